@@ -0,0 +1,55 @@
+// Package accesslog runs a receiver for Envoy's gRPC access log service. Point a LogService at it,
+// and it writes the access logs out as JSON.
+package accesslog
+
+import (
+	"context"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"github.com/datawire/dlib/dlog"
+	als "github.com/emissary-ingress/emissary/v3/pkg/accesslog"
+)
+
+func Main(ctx context.Context, version string, args ...string) error {
+	var address, output string
+	var maxSizeMB int64
+	var maxBackups int
+	var redact string
+	var opts als.Options
+
+	cmd := &cobra.Command{
+		Use:           "access-log-server",
+		Short:         "receive access logs from Envoy's gRPC access log service, and write them as JSON",
+		Args:          cobra.NoArgs,
+		SilenceErrors: true,
+		SilenceUsage:  true,
+	}
+	cmd.Flags().StringVar(&address, "address", ":8080", "address to listen on for access log streams")
+	cmd.Flags().StringVar(&output, "output", "stdout", `where to write entries: "stdout", "stderr", or a file`)
+	cmd.Flags().Int64Var(&maxSizeMB, "max-size", 100, "rotate the output file when it reaches this many megabytes (0 to never rotate)")
+	cmd.Flags().IntVar(&maxBackups, "max-backups", 5, "how many rotated output files to keep")
+	cmd.Flags().Float64Var(&opts.SampleRate, "sample-rate", 1, "fraction of entries to keep, from 0 to 1")
+	cmd.Flags().BoolVar(&opts.KeepErrors, "keep-errors", false, "keep every entry with a 5xx response, regardless of --sample-rate")
+	cmd.Flags().StringVar(&redact, "redact", "", "comma-separated fields to redact, e.g. request.request_headers.authorization")
+
+	cmd.RunE = func(cmd *cobra.Command, _ []string) error {
+		ctx := cmd.Context()
+		dlog.Infof(ctx, "access log server %s starting", version)
+
+		sink, err := als.OpenSink(output, maxSizeMB*1024*1024, maxBackups)
+		if err != nil {
+			return err
+		}
+		defer sink.Close()
+
+		if redact != "" {
+			opts.Redact = strings.Split(redact, ",")
+		}
+		return als.NewServer(sink, opts).ListenAndServe(ctx, address)
+	}
+
+	cmd.SetArgs(args)
+	return cmd.ExecuteContext(ctx)
+}
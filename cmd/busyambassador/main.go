@@ -17,9 +17,11 @@ import (
 	"github.com/emissary-ingress/emissary/v3/pkg/environment"
 
 	// commands
+	"github.com/emissary-ingress/emissary/v3/cmd/accesslog"
 	"github.com/emissary-ingress/emissary/v3/cmd/apiext"
 	"github.com/emissary-ingress/emissary/v3/cmd/entrypoint"
 	"github.com/emissary-ingress/emissary/v3/cmd/kubestatus"
+	"github.com/emissary-ingress/emissary/v3/cmd/metricssink"
 	"github.com/emissary-ingress/emissary/v3/cmd/reproducer"
 )
 
@@ -53,10 +55,12 @@ func main() {
 	}
 
 	busy.Main("busyambassador", "Ambassador", version, map[string]busy.Command{
-		"kubestatus": {Setup: environment.EnvironmentSetupEntrypoint, Run: kubestatus.Main},
-		"entrypoint": {Setup: noop, Run: entrypoint.Main},
-		"reproducer": {Setup: noop, Run: reproducer.Main},
-		"version":    {Setup: noop, Run: showVersion},
-		"apiext":     {Setup: noop, Run: apiext.Main},
+		"kubestatus":         {Setup: environment.EnvironmentSetupEntrypoint, Run: kubestatus.Main},
+		"entrypoint":         {Setup: noop, Run: entrypoint.Main},
+		"reproducer":         {Setup: noop, Run: reproducer.Main},
+		"version":            {Setup: noop, Run: showVersion},
+		"apiext":             {Setup: noop, Run: apiext.Main},
+		"envoy-metrics-sink": {Setup: noop, Run: metricssink.Main},
+		"access-log-server":  {Setup: noop, Run: accesslog.Main},
 	})
 }
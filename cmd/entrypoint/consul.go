@@ -23,21 +23,6 @@ func ReconcileConsul(ctx context.Context, consulWatcher *consulWatcher, s *snaps
 	envAmbID := GetAmbassadorID()
 
 	var mappings []consulMapping
-	for _, list := range s.Annotations {
-		for _, a := range list {
-			switch m := a.(type) {
-			case *amb.Mapping:
-				if m.Spec.AmbassadorID.Matches(envAmbID) {
-					mappings = append(mappings, consulMapping{Service: m.Spec.Service, Resolver: m.Spec.Resolver})
-				}
-			case *amb.TCPMapping:
-				if m.Spec.AmbassadorID.Matches(envAmbID) {
-					mappings = append(mappings, consulMapping{Service: m.Spec.Service, Resolver: m.Spec.Resolver})
-				}
-			}
-		}
-	}
-
 	var resolvers []*amb.ConsulResolver
 	for _, cr := range s.ConsulResolvers {
 		if cr.Spec.AmbassadorID.Matches(envAmbID) {
@@ -0,0 +1,307 @@
+package entrypoint
+
+import (
+	"context"
+	"errors"
+	"net"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/datawire/dlib/dlog"
+)
+
+// lookupHostFunc has the same signature as (*net.Resolver).LookupHost, so that the real resolver
+// can be swapped out for tests.
+type lookupHostFunc func(ctx context.Context, host string) ([]string, error)
+
+// hostResolver resolves hostnames (e.g. the addresses that Consul hands back for a service) to IP
+// addresses without ever blocking its caller on DNS.
+//
+// The resolve method is called from makeEndpoints while the SnapshotHolder mutex is held, so it
+// must never do a lookup itself. Instead, it answers from the cache (even if the cached answer is
+// stale) and queues a lookup for anything missing or expired. Lookups happen in the background,
+// and whenever one of them produces an answer that differs from what was cached, we signal on the
+// channel returned by changed so that the watcher can recompute endpoints as an event of its own.
+type hostResolver struct {
+	lookup      lookupHostFunc
+	ttl         time.Duration // How long a successful answer is considered fresh.
+	negativeTTL time.Duration // How long a failed lookup is remembered before we retry.
+	maxStale    time.Duration // How long the last good answer outlives it while lookups fail.
+	family      string        // One of "tcp", "tcp4", or "tcp6", as per getDNSIPNetworkFamily.
+	now         func() time.Time
+
+	// The changed method returns this channel. We write down this channel to signal that at least
+	// one cached answer has changed since the last time somebody read from it.
+	coalescedDirty chan struct{}
+	// The resolve method writes to this (without blocking) to wake up the run loop when it has
+	// queued new lookups.
+	wakeup chan struct{}
+	// Individual lookups write their results here. It is always being read by the run loop.
+	results chan hostLookupResult
+	// Bounds the number of lookups we have in flight at once.
+	semaphore chan struct{}
+
+	// The mutex protects access to cache and queue.
+	mutex sync.Mutex
+	cache map[string]*hostCacheEntry
+	queue []string
+}
+
+type hostCacheEntry struct {
+	addrs    []string
+	err      error
+	resolved bool      // Has this entry ever had a lookup complete?
+	pending  bool      // Is there a lookup queued or in flight for this entry?
+	expires  time.Time // When the current answer (positive or negative) goes stale.
+	lastGood time.Time // When a lookup last succeeded, so that we know how stale addrs is.
+	lastUsed time.Time // When resolve last asked about this entry, so we can prune it.
+}
+
+// errNoAddrs is what we remember for a lookup that succeeded without any (usable) addresses.
+var errNoAddrs = errors.New("no addresses found")
+
+type hostLookupResult struct {
+	host  string
+	addrs []string
+	err   error
+}
+
+func newHostResolver(lookup lookupHostFunc) *hostResolver {
+	return &hostResolver{
+		lookup:         lookup,
+		ttl:            GetDNSCacheTTL(),
+		negativeTTL:    GetDNSNegativeCacheTTL(),
+		maxStale:       GetDNSMaxStale(),
+		family:         getDNSIPNetworkFamily(),
+		now:            time.Now,
+		coalescedDirty: make(chan struct{}),
+		wakeup:         make(chan struct{}, 1),
+		results:        make(chan hostLookupResult),
+		semaphore:      make(chan struct{}, GetDNSMaxConcurrentLookups()),
+		cache:          make(map[string]*hostCacheEntry),
+	}
+}
+
+func (r *hostResolver) changed() chan struct{} {
+	return r.coalescedDirty
+}
+
+// resolve returns the best answer we currently have for host. IP literals (including bracketed
+// and zoned IPv6 literals) are answered immediately. For anything else the answer comes from the
+// cache, and a background lookup is queued if the cached answer is missing or stale. A nil result
+// means that we don't (yet) know of any address for host.
+func (r *hostResolver) resolve(host string) []string {
+	if ip := parseIPLiteral(host); ip != "" {
+		if !r.familyAllows(ip) {
+			return nil
+		}
+		return []string{ip}
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	now := r.now()
+	entry, ok := r.cache[host]
+	if !ok {
+		entry = &hostCacheEntry{}
+		r.cache[host] = entry
+	}
+	entry.lastUsed = now
+
+	if !entry.pending && (!entry.resolved || !now.Before(entry.expires)) {
+		entry.pending = true
+		r.queue = append(r.queue, host)
+		select {
+		case r.wakeup <- struct{}{}:
+		default:
+		}
+	}
+
+	return entry.addrs
+}
+
+func (r *hostResolver) run(ctx context.Context) error {
+	// Entries that nobody has asked about for a while get pruned, so that we don't keep
+	// refreshing names for Consul services that have gone away.
+	pruneTicker := time.NewTicker(r.ttl)
+	defer pruneTicker.Stop()
+
+	dirty := false
+	for {
+		var out chan struct{}
+		if dirty {
+			out = r.coalescedDirty
+		}
+		select {
+		case out <- struct{}{}:
+			dirty = false
+		case <-r.wakeup:
+			r.startLookups(ctx)
+		case result := <-r.results:
+			if r.store(ctx, result) {
+				dirty = true
+			}
+		case <-pruneTicker.C:
+			r.prune()
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+func (r *hostResolver) startLookups(ctx context.Context) {
+	r.mutex.Lock()
+	queue := r.queue
+	r.queue = nil
+	r.mutex.Unlock()
+
+	for _, host := range queue {
+		go func(host string) {
+			select {
+			case r.semaphore <- struct{}{}:
+			case <-ctx.Done():
+				return
+			}
+			addrs, err := r.lookup(ctx, host)
+			<-r.semaphore
+
+			select {
+			case r.results <- hostLookupResult{host: host, addrs: addrs, err: err}:
+			case <-ctx.Done():
+			}
+		}(host)
+	}
+}
+
+// store saves the result of a lookup, returning true if the answer for the host changed.
+func (r *hostResolver) store(ctx context.Context, result hostLookupResult) bool {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	entry, ok := r.cache[result.host]
+	if !ok {
+		// Pruned while the lookup was in flight; nobody cares anymore.
+		return false
+	}
+	entry.pending = false
+	entry.resolved = true
+
+	now := r.now()
+	var addrs []string
+	if result.err == nil {
+		addrs = r.filterAddrs(result.addrs)
+	}
+	if len(addrs) == 0 {
+		// A failed (or empty) lookup is retried after the negative TTL.
+		err := result.err
+		if err == nil {
+			err = errNoAddrs
+		}
+		// Only complain when the lookup starts failing, not every time the negative cache
+		// entry expires and we fail again.
+		if entry.err == nil {
+			dlog.Errorf(ctx, "error resolving %s: %+v", result.host, err)
+		}
+		entry.err = err
+		entry.expires = now.Add(r.negativeTTL)
+
+		// A transient failure doesn't throw away the last good answer, since one DNS hiccup
+		// shouldn't empty out the cluster; but once the name is definitely gone, or it has been
+		// failing for too long, so is the answer.
+		if isTransientDNSError(err) && now.Sub(entry.lastGood) < r.maxStale {
+			return false
+		}
+		if entry.addrs == nil {
+			return false
+		}
+		entry.addrs = nil
+		return true
+	}
+	entry.err = nil
+	entry.expires = now.Add(r.ttl)
+	entry.lastGood = now
+
+	if reflect.DeepEqual(addrs, entry.addrs) {
+		return false
+	}
+	entry.addrs = addrs
+	return true
+}
+
+// isTransientDNSError returns whether err might go away if we ask again: anything other than
+// the name not existing (NXDOMAIN) or not having any addresses.
+func isTransientDNSError(err error) bool {
+	if errors.Is(err, errNoAddrs) {
+		return false
+	}
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) && dnsErr.IsNotFound {
+		return false
+	}
+	return true
+}
+
+func (r *hostResolver) prune() {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	cutoff := r.now().Add(-2 * r.ttl)
+	for host, entry := range r.cache {
+		if !entry.pending && entry.lastUsed.Before(cutoff) {
+			delete(r.cache, host)
+		}
+	}
+}
+
+// filterAddrs drops any addresses of the wrong IP family, and sorts what is left (IPv4 first) so
+// that reordering in DNS answers doesn't look like a change.
+func (r *hostResolver) filterAddrs(addrs []string) []string {
+	var result []string
+	for _, addr := range addrs {
+		if r.familyAllows(addr) {
+			result = append(result, addr)
+		}
+	}
+	sort.SliceStable(result, func(i, j int) bool {
+		iv4 := net.ParseIP(result[i]).To4() != nil
+		jv4 := net.ParseIP(result[j]).To4() != nil
+		if iv4 != jv4 {
+			return iv4
+		}
+		return result[i] < result[j]
+	})
+	return result
+}
+
+func (r *hostResolver) familyAllows(addr string) bool {
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return false
+	}
+	switch r.family {
+	case "tcp4":
+		return ip.To4() != nil
+	case "tcp6":
+		return ip.To4() == nil
+	}
+	return true
+}
+
+// parseIPLiteral returns the canonical form of host if it is an IP address, or the empty string
+// if it is not. IPv6 addresses may be wrapped in brackets and may carry a zone (which Envoy has no
+// use for, so it gets dropped).
+func parseIPLiteral(host string) string {
+	host = strings.TrimSuffix(strings.TrimPrefix(host, "["), "]")
+	if i := strings.LastIndex(host, "%"); i >= 0 {
+		host = host[:i]
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return ""
+	}
+	return ip.String()
+}
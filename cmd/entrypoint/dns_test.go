@@ -0,0 +1,215 @@
+package entrypoint
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/datawire/dlib/dgroup"
+	"github.com/datawire/dlib/dlog"
+)
+
+type fakeDNS struct {
+	mutex   sync.Mutex
+	answers map[string][]string
+	errs    map[string]error
+	lookups map[string]int
+}
+
+func (f *fakeDNS) set(host string, addrs ...string) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.answers[host] = addrs
+}
+
+func (f *fakeDNS) unset(host string) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	delete(f.answers, host)
+}
+
+func (f *fakeDNS) fail(host string, err error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.errs[host] = err
+}
+
+func (f *fakeDNS) count(host string) int {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return f.lookups[host]
+}
+
+func (f *fakeDNS) LookupHost(_ context.Context, host string) ([]string, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.lookups[host]++
+	if err := f.errs[host]; err != nil {
+		return nil, err
+	}
+	addrs, ok := f.answers[host]
+	if !ok {
+		return nil, &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+	}
+	return addrs, nil
+}
+
+func setupHostResolver(t *testing.T) (*hostResolver, *fakeDNS, *time.Time) {
+	ctx, cancel := context.WithCancel(dlog.NewTestContext(t, false))
+	grp := dgroup.NewGroup(ctx, dgroup.GroupConfig{})
+	t.Cleanup(func() {
+		cancel()
+		assert.NoError(t, grp.Wait())
+	})
+
+	dns := &fakeDNS{answers: map[string][]string{}, errs: map[string]error{}, lookups: map[string]int{}}
+	now := time.Unix(0, 0)
+	r := newHostResolver(dns.LookupHost)
+	r.now = func() time.Time { return now }
+	grp.Go("dns", r.run)
+	return r, dns, &now
+}
+
+func waitChanged(t *testing.T, r *hostResolver) {
+	t.Helper()
+	select {
+	case <-r.changed():
+	case <-time.After(10 * time.Second):
+		t.Fatal("timed out waiting for the resolver to notice a change")
+	}
+}
+
+func TestHostResolverLiterals(t *testing.T) {
+	r, dns, _ := setupHostResolver(t)
+	assert.Equal(t, []string{"1.2.3.4"}, r.resolve("1.2.3.4"))
+	assert.Equal(t, []string{"fe80::1"}, r.resolve("[fe80::1%eth0]"))
+	assert.Equal(t, []string{"2001:db8::1"}, r.resolve("2001:db8::1"))
+	assert.Equal(t, 0, len(dns.lookups))
+
+	r.family = "tcp4"
+	assert.Nil(t, r.resolve("2001:db8::1"))
+}
+
+func TestHostResolverCaching(t *testing.T) {
+	r, dns, now := setupHostResolver(t)
+	dns.set("consul-node", "10.0.0.2", "2001:db8::2", "10.0.0.1")
+
+	// The first call never blocks, so there's no answer yet...
+	assert.Nil(t, r.resolve("consul-node"))
+	waitChanged(t, r)
+	// ...but once the lookup is done, the answer is cached and sorted IPv4 first.
+	assert.Equal(t, []string{"10.0.0.1", "10.0.0.2", "2001:db8::2"}, r.resolve("consul-node"))
+	assert.Equal(t, 1, dns.count("consul-node"))
+
+	// Once the answer is stale we keep handing it out while we refresh it.
+	dns.set("consul-node", "10.0.0.3")
+	*now = now.Add(r.ttl)
+	assert.Equal(t, []string{"10.0.0.1", "10.0.0.2", "2001:db8::2"}, r.resolve("consul-node"))
+	waitChanged(t, r)
+	assert.Equal(t, []string{"10.0.0.3"}, r.resolve("consul-node"))
+	assert.Equal(t, 2, dns.count("consul-node"))
+}
+
+func TestHostResolverNegativeCaching(t *testing.T) {
+	r, dns, now := setupHostResolver(t)
+
+	assert.Nil(t, r.resolve("missing"))
+	require.Eventually(t, func() bool {
+		r.mutex.Lock()
+		defer r.mutex.Unlock()
+		return r.cache["missing"].resolved
+	}, 10*time.Second, 10*time.Millisecond)
+
+	// While the failure is cached, we don't retry.
+	assert.Nil(t, r.resolve("missing"))
+	assert.Equal(t, 1, dns.count("missing"))
+
+	// Once it expires we do, and a success shows up as a change.
+	dns.set("missing", "10.1.1.1")
+	*now = now.Add(r.negativeTTL)
+	assert.Nil(t, r.resolve("missing"))
+	waitChanged(t, r)
+	assert.Equal(t, []string{"10.1.1.1"}, r.resolve("missing"))
+	assert.Equal(t, 2, dns.count("missing"))
+}
+
+func TestHostResolverKeepsLastGoodAnswer(t *testing.T) {
+	r, dns, now := setupHostResolver(t)
+	dns.set("consul-node", "10.0.0.1")
+
+	assert.Nil(t, r.resolve("consul-node"))
+	waitChanged(t, r)
+	assert.Equal(t, []string{"10.0.0.1"}, r.resolve("consul-node"))
+
+	settled := func(lookups int) func() bool {
+		return func() bool {
+			r.mutex.Lock()
+			defer r.mutex.Unlock()
+			return dns.count("consul-node") == lookups && !r.cache["consul-node"].pending
+		}
+	}
+
+	// A transient failure doesn't throw the answer away...
+	dns.fail("consul-node", &net.DNSError{Err: "server misbehaving", Name: "consul-node", IsTemporary: true})
+	*now = now.Add(r.ttl)
+	r.resolve("consul-node")
+	require.Eventually(t, settled(2), 10*time.Second, 10*time.Millisecond)
+	assert.Equal(t, []string{"10.0.0.1"}, r.resolve("consul-node"))
+
+	// ...and neither does a timeout...
+	dns.fail("consul-node", &net.DNSError{Err: "i/o timeout", Name: "consul-node", IsTimeout: true})
+	*now = now.Add(r.negativeTTL)
+	r.resolve("consul-node")
+	require.Eventually(t, settled(3), 10*time.Second, 10*time.Millisecond)
+	assert.Equal(t, []string{"10.0.0.1"}, r.resolve("consul-node"))
+
+	// ...but a new good answer replaces it...
+	dns.fail("consul-node", nil)
+	dns.set("consul-node", "10.0.0.2")
+	*now = now.Add(r.negativeTTL)
+	r.resolve("consul-node")
+	waitChanged(t, r)
+	assert.Equal(t, []string{"10.0.0.2"}, r.resolve("consul-node"))
+
+	// ...and once lookups have been failing for longer than maxStale, it's dropped.
+	dns.fail("consul-node", errors.New("connection refused"))
+	*now = now.Add(r.maxStale)
+	r.resolve("consul-node")
+	waitChanged(t, r)
+	assert.Nil(t, r.resolve("consul-node"))
+}
+
+func TestHostResolverForgetsMissingHosts(t *testing.T) {
+	r, dns, now := setupHostResolver(t)
+	dns.set("consul-node", "10.0.0.1")
+
+	assert.Nil(t, r.resolve("consul-node"))
+	waitChanged(t, r)
+	assert.Equal(t, []string{"10.0.0.1"}, r.resolve("consul-node"))
+
+	// NXDOMAIN means the name is gone, so its addresses are too...
+	dns.unset("consul-node")
+	*now = now.Add(r.ttl)
+	r.resolve("consul-node")
+	waitChanged(t, r)
+	assert.Nil(t, r.resolve("consul-node"))
+
+	// ...and so does an answer without any addresses.
+	dns.set("consul-node", "10.0.0.1")
+	*now = now.Add(r.negativeTTL)
+	r.resolve("consul-node")
+	waitChanged(t, r)
+	assert.Equal(t, []string{"10.0.0.1"}, r.resolve("consul-node"))
+
+	dns.set("consul-node")
+	*now = now.Add(r.ttl)
+	r.resolve("consul-node")
+	waitChanged(t, r)
+	assert.Nil(t, r.resolve("consul-node"))
+}
@@ -0,0 +1,306 @@
+package entrypoint
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/datawire/dlib/dlog"
+
+	amb "github.com/emissary-ingress/emissary/v3/pkg/api/getambassador.io/v3alpha1"
+	"github.com/emissary-ingress/emissary/v3/pkg/dnswatch"
+	snapshotTypes "github.com/emissary-ingress/emissary/v3/pkg/snapshot/v1"
+)
+
+const (
+	defaultDNSMinRefreshInterval = 5 * time.Second
+	defaultDNSMaxRefreshInterval = 5 * time.Minute
+)
+
+// ReconcileDNS starts and stops DNS watches to match the Mappings and TCPMappings that use a
+// DNSResolver. This works just like ReconcileConsul, and reuses consulMapping for the subset of
+// each Mapping that we need.
+func ReconcileDNS(ctx context.Context, dnsWatcher *dnsWatcher, s *snapshotTypes.KubernetesSnapshot) error {
+	envAmbID := GetAmbassadorID()
+
+	var mappings []consulMapping
+	var resolvers []*amb.DNSResolver
+	for _, dr := range s.DNSResolvers {
+		if dr.Spec.AmbassadorID.Matches(envAmbID) {
+			resolvers = append(resolvers, dr)
+		}
+	}
+
+	for _, m := range s.Mappings {
+		if m.Spec.AmbassadorID.Matches(envAmbID) {
+			mappings = append(mappings, consulMapping{Service: m.Spec.Service, Resolver: m.Spec.Resolver})
+		}
+	}
+
+	for _, tm := range s.TCPMappings {
+		if tm.Spec.AmbassadorID.Matches(envAmbID) {
+			mappings = append(mappings, consulMapping{Service: tm.Spec.Service, Resolver: tm.Spec.Resolver})
+		}
+	}
+
+	return dnsWatcher.reconcile(ctx, resolvers, mappings)
+}
+
+// dnsWatcher keeps track of the DNS watches for every (DNSResolver, service) pair in use, and of
+// the endpoints they have found.
+//
+// Unlike the consulWatcher, it doesn't take part in bootstrapping: clusters that use a
+// DNSResolver are always EDS clusters, so Envoy can be configured before any DNS answers arrive,
+// and the endpoints get filled in by the fastpath once they do.
+type dnsWatcher struct {
+	watchFunc watchDNSFunc
+	resolvers map[string]*dnsResolver
+
+	// The changed method returns this channel. We write down this channel to signal that new
+	// endpoints are available since the last time the update method was invoked.
+	coalescedDirty chan struct{}
+	// Individual watches write to this when new endpoint data is available. It is always being read
+	// by the implementation, so writing will never block.
+	endpointsCh chan dnswatch.Endpoints
+
+	// The mutex protects access to endpoints and wanted.
+	mutex     sync.Mutex
+	endpoints map[string]dnswatch.Endpoints
+	wanted    map[string]bool
+}
+
+func newDNSWatcher(watchFunc watchDNSFunc) *dnsWatcher {
+	return &dnsWatcher{
+		watchFunc:      watchFunc,
+		resolvers:      make(map[string]*dnsResolver),
+		coalescedDirty: make(chan struct{}),
+		endpointsCh:    make(chan dnswatch.Endpoints),
+		endpoints:      make(map[string]dnswatch.Endpoints),
+		wanted:         make(map[string]bool),
+	}
+}
+
+func (d *dnsWatcher) run(ctx context.Context) error {
+	dirty := false
+	for {
+		var out chan struct{}
+		if dirty {
+			out = d.coalescedDirty
+		}
+		select {
+		case out <- struct{}{}:
+			dirty = false
+		case ep := <-d.endpointsCh:
+			if d.updateEndpoints(ep) {
+				dirty = true
+			}
+		case <-ctx.Done():
+			return d.reconcile(ctx, nil, nil)
+		}
+	}
+}
+
+// updateEndpoints saves the endpoints from a watch, returning true if the endpoints are still
+// wanted. Endpoints from a watch that was stopped while it was mid-lookup are dropped.
+func (d *dnsWatcher) updateEndpoints(endpoints dnswatch.Endpoints) bool {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	key := dnsEndpointsKey(endpoints.Resolver, endpoints.Service)
+	if !d.wanted[key] {
+		return false
+	}
+	d.endpoints[key] = endpoints
+	return true
+}
+
+func (d *dnsWatcher) changed() chan struct{} {
+	return d.coalescedDirty
+}
+
+// update copies the current set of endpoints into snap, which is keyed the same way as
+// dnsEndpointsKey.
+func (d *dnsWatcher) update(snap map[string]dnswatch.Endpoints) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	for k := range snap {
+		delete(snap, k)
+	}
+	for k, v := range d.endpoints {
+		snap[k] = v
+	}
+}
+
+func dnsEndpointsKey(resolver, service string) string {
+	return resolver + "/" + service
+}
+
+// Start and stop DNS watches as needed in order to match the supplied set of resolvers and
+// mappings.
+func (d *dnsWatcher) reconcile(ctx context.Context, resolvers []*amb.DNSResolver, mappings []consulMapping) error {
+	resolversByName := make(map[string]*amb.DNSResolver)
+	for _, dr := range resolvers {
+		// As with ConsulResolvers, the namespace of a DNSResolver doesn't matter once it has
+		// been found.
+		resolversByName[dr.GetName()] = dr
+	}
+
+	mappingsByResolver := make(map[string][]consulMapping)
+	for _, m := range mappings {
+		if _, ok := resolversByName[m.Resolver]; !ok {
+			continue
+		}
+		mappingsByResolver[m.Resolver] = append(mappingsByResolver[m.Resolver], m)
+	}
+
+	// Decide which endpoints we want before touching any watches, so that a new watch can't
+	// deliver endpoints before we know we want them.
+	wanted := make(map[string]bool)
+	for rname, mappings := range mappingsByResolver {
+		for _, m := range mappings {
+			wanted[dnsEndpointsKey(rname, stripScheme(m.Service))] = true
+		}
+	}
+	func() {
+		d.mutex.Lock()
+		defer d.mutex.Unlock()
+		d.wanted = wanted
+		for key := range d.endpoints {
+			if !wanted[key] {
+				delete(d.endpoints, key)
+			}
+		}
+	}()
+
+	// (Re)create any resolvers that are new or have changed, and delete any that are no longer
+	// in use.
+	for name, dr := range resolversByName {
+		if _, ok := mappingsByResolver[name]; !ok {
+			continue
+		}
+		oldr, ok := d.resolvers[name]
+		if ok && reflect.DeepEqual(oldr.resolver.Spec, dr.Spec) {
+			continue
+		}
+		if ok {
+			oldr.deleted()
+		}
+		d.resolvers[name] = newDNSResolver(dr)
+	}
+	for name, r := range d.resolvers {
+		if _, ok := mappingsByResolver[name]; !ok {
+			r.deleted()
+			delete(d.resolvers, name)
+		}
+	}
+
+	for rname, mappings := range mappingsByResolver {
+		if err := d.resolvers[rname].reconcile(ctx, d.watchFunc, mappings, d.endpointsCh); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+type dnsResolver struct {
+	resolver *amb.DNSResolver
+	// Keyed by the service name as the watch reports it, i.e. without any scheme.
+	watches map[string]Stopper
+}
+
+func newDNSResolver(spec *amb.DNSResolver) *dnsResolver {
+	return &dnsResolver{resolver: spec, watches: make(map[string]Stopper)}
+}
+
+func (r *dnsResolver) deleted() {
+	for _, w := range r.watches {
+		w.Stop()
+	}
+}
+
+func (r *dnsResolver) reconcile(ctx context.Context, watchFunc watchDNSFunc, mappings []consulMapping, endpoints chan dnswatch.Endpoints) error {
+	servicesByName := make(map[string]bool)
+	for _, m := range mappings {
+		svc := stripScheme(m.Service)
+		servicesByName[svc] = true
+		if _, ok := r.watches[svc]; ok {
+			continue
+		}
+		w, err := watchFunc(ctx, r.resolver, m.Service, endpoints)
+		if err != nil {
+			// A bad service shouldn't stop every other Mapping from working, so just complain.
+			dlog.Errorf(ctx, "DNSResolver %s: unable to watch %s: %v", r.resolver.GetName(), m.Service, err)
+			continue
+		}
+		r.watches[svc] = w
+	}
+
+	for name, w := range r.watches {
+		if !servicesByName[name] {
+			w.Stop()
+			delete(r.watches, name)
+		}
+	}
+	return nil
+}
+
+func stripScheme(service string) string {
+	if i := strings.Index(service, "://"); i >= 0 {
+		return service[i+3:]
+	}
+	return service
+}
+
+type watchDNSFunc func(ctx context.Context, resolver *amb.DNSResolver, svc string, endpoints chan dnswatch.Endpoints) (Stopper, error)
+
+func watchDNS(
+	ctx context.Context,
+	resolver *amb.DNSResolver,
+	svc string,
+	endpointsCh chan dnswatch.Endpoints,
+) (Stopper, error) {
+	client, err := dnswatch.NewClient(resolver.Spec.Nameservers)
+	if err != nil {
+		return nil, err
+	}
+
+	recordType := string(resolver.Spec.RecordType)
+	if recordType == "" {
+		recordType = string(amb.SRVRecordType)
+	}
+	minInterval := defaultDNSMinRefreshInterval
+	if resolver.Spec.MinRefreshInterval != nil {
+		minInterval = resolver.Spec.MinRefreshInterval.Duration
+	}
+	maxInterval := defaultDNSMaxRefreshInterval
+	if resolver.Spec.MaxRefreshInterval != nil {
+		maxInterval = resolver.Spec.MaxRefreshInterval.Duration
+	}
+
+	w, err := dnswatch.New(client, resolver.GetName(), svc, recordType, int(resolver.Spec.DefaultPort), minInterval, maxInterval)
+	if err != nil {
+		return nil, err
+	}
+
+	w.Watch(func(endpoints dnswatch.Endpoints, err error) {
+		if err != nil {
+			// Keep whatever we last found; it's a better guess than nothing at all.
+			dlog.Errorf(ctx, "DNSResolver %s: error resolving %s: %v", resolver.GetName(), svc, err)
+			return
+		}
+		select {
+		case endpointsCh <- endpoints:
+		case <-ctx.Done():
+		}
+	})
+
+	go func() {
+		if err := w.Start(ctx); err != nil {
+			dlog.Errorf(ctx, "DNSResolver %s: stopped watching %s: %v", resolver.GetName(), svc, err)
+		}
+	}()
+
+	return w, nil
+}
@@ -3,16 +3,18 @@ package entrypoint
 import (
 	"context"
 	"fmt"
-	"net"
+	"sort"
+	"strings"
 
-	"github.com/datawire/dlib/dlog"
 	"github.com/emissary-ingress/emissary/v3/pkg/ambex"
+	amb "github.com/emissary-ingress/emissary/v3/pkg/api/getambassador.io/v3alpha1"
 	"github.com/emissary-ingress/emissary/v3/pkg/consulwatch"
+	"github.com/emissary-ingress/emissary/v3/pkg/dnswatch"
 	"github.com/emissary-ingress/emissary/v3/pkg/kates"
 	"github.com/emissary-ingress/emissary/v3/pkg/snapshot/v1"
 )
 
-func makeEndpoints(ctx context.Context, ksnap *snapshot.KubernetesSnapshot, consulEndpoints map[string]consulwatch.Endpoints) *ambex.Endpoints {
+func makeEndpoints(ctx context.Context, ksnap *snapshot.KubernetesSnapshot, consulEndpoints map[string]consulwatch.Endpoints, resolver *hostResolver, dnsEndpoints map[string]dnswatch.Endpoints) *ambex.Endpoints {
 	k8sServices := map[string]*kates.Service{}
 	for _, svc := range ksnap.Services {
 		k8sServices[key(svc)] = svc
@@ -31,7 +33,23 @@ func makeEndpoints(ctx context.Context, ksnap *snapshot.KubernetesSnapshot, cons
 	}
 
 	for _, consulEp := range consulEndpoints {
-		for _, ep := range consulEndpointsToAmbex(ctx, consulEp) {
+		for _, ep := range consulEndpointsToAmbex(ctx, consulEp, resolver) {
+			result[ep.ClusterName] = append(result[ep.ClusterName], ep)
+		}
+	}
+
+	envAmbID := GetAmbassadorID()
+	for _, r := range ksnap.StaticEndpointResolvers {
+		if !r.Spec.AmbassadorID.Matches(envAmbID) {
+			continue
+		}
+		for _, ep := range staticEndpointsToAmbex(r) {
+			result[ep.ClusterName] = append(result[ep.ClusterName], ep)
+		}
+	}
+
+	for _, dnsEp := range dnsEndpoints {
+		for _, ep := range dnsEndpointsToAmbex(dnsEp) {
 			result[ep.ClusterName] = append(result[ep.ClusterName], ep)
 		}
 	}
@@ -97,13 +115,12 @@ func k8sEndpointsToAmbex(ep *kates.Endpoints, svc *kates.Service) (result []*amb
 	return
 }
 
-func consulEndpointsToAmbex(ctx context.Context, endpoints consulwatch.Endpoints) (result []*ambex.Endpoint) {
+// consulEndpointsToAmbex converts Consul endpoints to ambex endpoints. Addresses that aren't IPs
+// are resolved through the hostResolver, which never blocks: an address that hasn't been resolved
+// yet simply contributes no endpoints until the resolver says that something changed.
+func consulEndpointsToAmbex(ctx context.Context, endpoints consulwatch.Endpoints, resolver *hostResolver) (result []*ambex.Endpoint) {
 	for _, ep := range endpoints.Endpoints {
-		addrs, err := net.LookupHost(ep.Address)
-		if err != nil {
-			dlog.Errorf(ctx, "error resolving consul address %s: %+v", ep.Address, err)
-			continue
-		}
+		addrs := resolver.resolve(ep.Address)
 		for _, addr := range addrs {
 			result = append(result, &ambex.Endpoint{
 				ClusterName: fmt.Sprintf("consul/%s/%s", endpoints.Id, endpoints.Service),
@@ -116,3 +133,67 @@ func consulEndpointsToAmbex(ctx context.Context, endpoints consulwatch.Endpoints
 
 	return
 }
+
+// dnsEndpointsToAmbex converts the endpoints found by a DNSResolver to ambex endpoints. These are
+// named after the resolver and the service as the Mapping wrote it (minus any scheme), which is
+// also how the Python side names the EDS cluster.
+//
+// SRV weights become load-balancing weights. SRV priorities can be any numbers, lowest first, but
+// Envoy wants its priorities to count up from 0 without gaps, so they're renumbered in order.
+func dnsEndpointsToAmbex(endpoints dnswatch.Endpoints) (result []*ambex.Endpoint) {
+	var srvPriorities []int
+	for _, ep := range endpoints.Endpoints {
+		srvPriorities = append(srvPriorities, ep.Priority)
+	}
+	sort.Ints(srvPriorities)
+	priorities := map[int]uint32{}
+	for _, p := range srvPriorities {
+		if _, ok := priorities[p]; !ok {
+			priorities[p] = uint32(len(priorities))
+		}
+	}
+
+	for _, ep := range endpoints.Endpoints {
+		result = append(result, &ambex.Endpoint{
+			ClusterName: fmt.Sprintf("dns/%s/%s", endpoints.Resolver, endpoints.Service),
+			Ip:          ep.Address,
+			Port:        uint32(ep.Port),
+			Protocol:    "TCP",
+			Weight:      uint32(ep.Weight),
+			Priority:    priorities[ep.Priority],
+		})
+	}
+
+	return
+}
+
+// staticEndpointHealth maps the health of a StaticEndpoint to the name of the envoy HealthStatus.
+var staticEndpointHealth = map[amb.StaticEndpointHealth]string{
+	amb.StaticEndpointHealthy:   "HEALTHY",
+	amb.StaticEndpointUnhealthy: "UNHEALTHY",
+	amb.StaticEndpointDraining:  "DRAINING",
+	amb.StaticEndpointUnknown:   "UNKNOWN",
+}
+
+// staticEndpointsToAmbex converts the services listed in a StaticEndpointResolver to ambex
+// endpoints, named after the resolver and the service. Service names are lowercased, as hostnames
+// are case-insensitive and the Python side lowercases the service of a Mapping when it names the
+// cluster's endpoints.
+func staticEndpointsToAmbex(resolver *amb.StaticEndpointResolver) (result []*ambex.Endpoint) {
+	for _, svc := range resolver.Spec.Services {
+		name := strings.ToLower(svc.Name)
+		for _, ep := range svc.Endpoints {
+			result = append(result, &ambex.Endpoint{
+				ClusterName:  fmt.Sprintf("static/%s/%s", resolver.GetName(), name),
+				Ip:           ep.Address,
+				Port:         uint32(ep.Port),
+				Protocol:     "TCP",
+				Weight:       uint32(ep.Weight),
+				Zone:         ep.Zone,
+				HealthStatus: staticEndpointHealth[ep.Health],
+			})
+		}
+	}
+
+	return
+}
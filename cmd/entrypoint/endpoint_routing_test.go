@@ -180,6 +180,126 @@ spec:
 	assert.Equal(t, "1.2.3.4", endpoints.Entries["k8s/default/foo/80"][0].Ip)
 }
 
+// Test that the endpoints listed in a StaticEndpointResolver get sent, and get resent when the
+// resolver changes.
+func TestEndpointRoutingStatic(t *testing.T) {
+	f := entrypoint.RunFake(t, entrypoint.FakeConfig{}, nil)
+	assert.NoError(t, f.UpsertYAML(`
+---
+apiVersion: getambassador.io/v3alpha1
+kind: StaticEndpointResolver
+metadata:
+  name: external
+  namespace: default
+spec:
+  services:
+  - name: db
+    endpoints:
+    - address: 10.0.0.1
+      port: 5432
+      weight: 3
+      zone: us-east-1a
+    - address: 10.0.0.2
+      port: 5432
+      zone: us-east-1b
+      health: Draining
+---
+apiVersion: getambassador.io/v3alpha1
+kind: TCPMapping
+metadata:
+  name: db
+  namespace: default
+spec:
+  port: 5432
+  resolver: external
+  service: db
+`))
+	f.Flush()
+
+	endpoints, err := f.GetEndpoints(HasEndpoints("static/external/db"))
+	require.NoError(t, err)
+	eps := endpoints.Entries["static/external/db"]
+	require.Len(t, eps, 2)
+	assert.Equal(t, &ambex.Endpoint{
+		ClusterName: "static/external/db",
+		Ip:          "10.0.0.1",
+		Port:        5432,
+		Protocol:    "TCP",
+		Weight:      3,
+		Zone:        "us-east-1a",
+	}, eps[0])
+	assert.Equal(t, &ambex.Endpoint{
+		ClusterName:  "static/external/db",
+		Ip:           "10.0.0.2",
+		Port:         5432,
+		Protocol:     "TCP",
+		Zone:         "us-east-1b",
+		HealthStatus: "DRAINING",
+	}, eps[1])
+
+	// Changing the resolver is an endpoint change, so it gets sent along too.
+	assert.NoError(t, f.UpsertYAML(`
+---
+apiVersion: getambassador.io/v3alpha1
+kind: StaticEndpointResolver
+metadata:
+  name: external
+  namespace: default
+spec:
+  services:
+  - name: db
+    endpoints:
+    - address: 10.0.0.3
+      port: 5432
+`))
+	f.Flush()
+
+	endpoints, err = f.GetEndpoints(func(endpoints *ambex.Endpoints) bool {
+		eps := endpoints.Entries["static/external/db"]
+		return len(eps) == 1 && eps[0].Ip == "10.0.0.3"
+	})
+	require.NoError(t, err)
+	assert.Equal(t, uint32(0), endpoints.Entries["static/external/db"][0].Weight)
+}
+
+// Test that service names in a StaticEndpointResolver are matched without regard to case, the way
+// the Python side matches the service of a Mapping.
+func TestEndpointRoutingStaticMixedCase(t *testing.T) {
+	f := entrypoint.RunFake(t, entrypoint.FakeConfig{}, nil)
+	assert.NoError(t, f.UpsertYAML(`
+---
+apiVersion: getambassador.io/v3alpha1
+kind: StaticEndpointResolver
+metadata:
+  name: external
+  namespace: default
+spec:
+  services:
+  - name: Billing-DB
+    endpoints:
+    - address: 10.0.0.1
+      port: 5432
+---
+apiVersion: getambassador.io/v3alpha1
+kind: TCPMapping
+metadata:
+  name: db
+  namespace: default
+spec:
+  port: 5432
+  resolver: external
+  service: Billing-DB
+`))
+	f.Flush()
+
+	endpoints, err := f.GetEndpoints(HasEndpoints("static/external/billing-db"))
+	require.NoError(t, err)
+	eps := endpoints.Entries["static/external/billing-db"]
+	require.Len(t, eps, 1)
+	assert.Equal(t, "static/external/billing-db", eps[0].ClusterName)
+	assert.NotContains(t, endpoints.Entries, "static/external/Billing-DB")
+}
+
 func ClusterNameContains(substring string) func(*v3cluster.Cluster) bool {
 	return func(c *v3cluster.Cluster) bool {
 		return strings.Contains(c.Name, substring)
@@ -22,6 +22,9 @@ type endpointRoutingInfo struct {
 	module          moduleResolver
 	endpointWatches map[string]bool // A set to track the subset of kubernetes endpoints we care about.
 	previousWatches map[string]bool
+	// A set of "resolver/service" keys for the StaticEndpointResolver services that are in use.
+	staticServices         map[string]bool
+	previousStaticServices map[string]bool
 }
 
 type ResolverType int
@@ -30,6 +33,8 @@ const (
 	KubernetesServiceResolver ResolverType = iota
 	KubernetesEndpointResolver
 	ConsulResolver
+	DNSResolver
+	StaticEndpointResolver
 )
 
 func (rt ResolverType) String() string {
@@ -40,6 +45,10 @@ func (rt ResolverType) String() string {
 		return "KubernetesEndpointResolver"
 	case ConsulResolver:
 		return "ConsulResolver"
+	case DNSResolver:
+		return "DNSResolver"
+	case StaticEndpointResolver:
+		return "StaticEndpointResolver"
 	default:
 		panic(fmt.Errorf("ResolverType.String: invalid enum value: %d", rt))
 	}
@@ -59,6 +68,7 @@ func newEndpointRoutingInfo() endpointRoutingInfo {
 		resolverTypes: make(map[string]ResolverType),
 		// Track which endpoints we actually want to watch.
 		endpointWatches: make(map[string]bool),
+		staticServices:  make(map[string]bool),
 	}
 }
 
@@ -71,46 +81,50 @@ func (eri *endpointRoutingInfo) reconcileEndpointWatches(ctx context.Context, s
 	eri.module = moduleResolver{}
 	eri.previousWatches = eri.endpointWatches
 	eri.endpointWatches = map[string]bool{}
-
-	// Phase one processes all the configuration stuff that Mappings depend on. Right now this
-	// includes Modules and Resolvers. When we are done with Phase one we have processed enough
-	// resources to correctly interpret Mappings.
-	for _, list := range s.Annotations {
-		for _, a := range list {
-			if _, isInvalid := a.(*kates.Unstructured); isInvalid {
-				continue
-			}
-			if GetAmbID(ctx, a).Matches(envAmbID) {
-				eri.checkResourcePhase1(ctx, a, "annotation")
-			}
-		}
-	}
-
-	// After that, walk all the other resources. We do this with separate loops
-	// for each type -- since we know a priori what type they are, there's no
-	// need to test every resource, and no need to walk over things we're not
+	eri.previousStaticServices = eri.staticServices
+	eri.staticServices = map[string]bool{}
+
+	// First process all the configuration stuff that Mappings depend on. Right now this includes
+	// Modules and Resolvers. Once that's done we have processed enough resources to correctly
+	// interpret Mappings. (Resources from annotations are in these same slices; see
+	// PopulateAnnotations.)
+	//
+	// We do this with separate loops for each type -- since we know a priori what type they are,
+	// there's no need to test every resource, and no need to walk over things we're not
 	// interested in.
 	for _, m := range s.Modules {
 		if m.Spec.AmbassadorID.Matches(envAmbID) {
-			eri.checkModule(ctx, m, "CRD")
+			eri.checkModule(ctx, m, resourceSource(m))
 		}
 	}
 
 	for _, r := range s.KubernetesServiceResolvers {
 		if r.Spec.AmbassadorID.Matches(envAmbID) {
-			eri.saveResolver(ctx, r.GetName(), KubernetesServiceResolver, "CRD")
+			eri.saveResolver(ctx, r.GetName(), KubernetesServiceResolver, resourceSource(r))
 		}
 	}
 
 	for _, r := range s.KubernetesEndpointResolvers {
 		if r.Spec.AmbassadorID.Matches(envAmbID) {
-			eri.saveResolver(ctx, r.GetName(), KubernetesEndpointResolver, "CRD")
+			eri.saveResolver(ctx, r.GetName(), KubernetesEndpointResolver, resourceSource(r))
 		}
 	}
 
 	for _, r := range s.ConsulResolvers {
 		if r.Spec.AmbassadorID.Matches(envAmbID) {
-			eri.saveResolver(ctx, r.GetName(), ConsulResolver, "CRD")
+			eri.saveResolver(ctx, r.GetName(), ConsulResolver, resourceSource(r))
+		}
+	}
+
+	for _, r := range s.DNSResolvers {
+		if r.Spec.AmbassadorID.Matches(envAmbID) {
+			eri.saveResolver(ctx, r.GetName(), DNSResolver, resourceSource(r))
+		}
+	}
+
+	for _, r := range s.StaticEndpointResolvers {
+		if r.Spec.AmbassadorID.Matches(envAmbID) {
+			eri.saveResolver(ctx, r.GetName(), StaticEndpointResolver, resourceSource(r))
 		}
 	}
 
@@ -125,56 +139,30 @@ func (eri *endpointRoutingInfo) reconcileEndpointWatches(ctx context.Context, s
 		}
 	}
 
-	for _, list := range s.Annotations {
-		for _, a := range list {
-			if _, isInvalid := a.(*kates.Unstructured); isInvalid {
-				continue
-			}
-			if GetAmbID(ctx, a).Matches(envAmbID) {
-				eri.checkResourcePhase2(ctx, a, "annotation")
-			}
-		}
-	}
-
 	for _, m := range s.Mappings {
 		if m.Spec.AmbassadorID.Matches(envAmbID) {
-			eri.checkMapping(ctx, m, "CRD")
+			eri.checkMapping(ctx, m, resourceSource(m))
 		}
 	}
 
 	for _, t := range s.TCPMappings {
 		if t.Spec.AmbassadorID.Matches(envAmbID) {
-			eri.checkTCPMapping(ctx, t, "CRD")
+			eri.checkTCPMapping(ctx, t, resourceSource(t))
 		}
 	}
 }
 
 func (eri *endpointRoutingInfo) watchesChanged() bool {
-	return !reflect.DeepEqual(eri.endpointWatches, eri.previousWatches)
-}
-
-// checkResourcePhase1 processes Modules and Resolvers and calls the correct type specific handler.
-func (eri *endpointRoutingInfo) checkResourcePhase1(ctx context.Context, obj kates.Object, source string) {
-	switch v := obj.(type) {
-	case *amb.Module:
-		eri.checkModule(ctx, v, source)
-	case *amb.KubernetesServiceResolver:
-		eri.saveResolver(ctx, v.GetName(), KubernetesServiceResolver, "CRD")
-	case *amb.KubernetesEndpointResolver:
-		eri.saveResolver(ctx, v.GetName(), KubernetesEndpointResolver, "CRD")
-	case *amb.ConsulResolver:
-		eri.saveResolver(ctx, v.GetName(), ConsulResolver, "CRD")
-	}
+	return !reflect.DeepEqual(eri.endpointWatches, eri.previousWatches) ||
+		!reflect.DeepEqual(eri.staticServices, eri.previousStaticServices)
 }
 
-// checkResourcePhase2 processes both regular and tcp Mappings and calls the correct type specific handler.
-func (eri *endpointRoutingInfo) checkResourcePhase2(ctx context.Context, obj kates.Object, source string) {
-	switch v := obj.(type) {
-	case *amb.Mapping:
-		eri.checkMapping(ctx, v, source)
-	case *amb.TCPMapping:
-		eri.checkTCPMapping(ctx, v, source)
+// resourceSource says where a resource came from, for logging.
+func resourceSource(obj kates.Object) string {
+	if snapshotTypes.AnnotationOrigin(obj) != "" {
+		return "annotation"
 	}
+	return "CRD"
 }
 
 type moduleResolver struct {
@@ -226,9 +214,12 @@ func (eri *endpointRoutingInfo) checkMapping(ctx context.Context, mapping *amb.M
 		dlog.Debugf(ctx, "WATCHER: Mapping %s uses the default resolver (%s)", name, source)
 	}
 
-	if eri.resolverTypes[resolver] == KubernetesEndpointResolver {
+	switch eri.resolverTypes[resolver] {
+	case KubernetesEndpointResolver:
 		svc, ns, _ := eri.module.parseService(ctx, mapping, service, mapping.GetNamespace())
 		eri.endpointWatches[fmt.Sprintf("%s:%s", ns, svc)] = true
+	case StaticEndpointResolver:
+		eri.staticServices[staticServiceKey(resolver, service)] = true
 	}
 }
 
@@ -245,10 +236,24 @@ func (eri *endpointRoutingInfo) checkTCPMapping(ctx context.Context, tcpmapping
 		resolver = eri.module.Resolver
 	}
 
-	if eri.resolverTypes[resolver] == KubernetesEndpointResolver {
+	switch eri.resolverTypes[resolver] {
+	case KubernetesEndpointResolver:
 		svc, ns, _ := eri.module.parseService(ctx, tcpmapping, service, tcpmapping.GetNamespace())
 		eri.endpointWatches[fmt.Sprintf("%s:%s", ns, svc)] = true
+	case StaticEndpointResolver:
+		eri.staticServices[staticServiceKey(resolver, service)] = true
+	}
+}
+
+// staticServiceKey returns the "resolver/service" key for a Mapping's service. The names in a
+// StaticEndpointResolver are just names, not hostnames, so unlike parseService we don't go looking
+// for a namespace in them; we only drop any scheme or port.
+func staticServiceKey(resolver, service string) string {
+	service = stripScheme(service)
+	if i := strings.LastIndex(service, ":"); i >= 0 {
+		service = service[:i]
 	}
+	return resolver + "/" + service
 }
 
 func (m *moduleResolver) parseService(ctx context.Context, resource kates.Object, svcName, svcNamespace string) (name string, namespace string, port string) {
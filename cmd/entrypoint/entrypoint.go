@@ -3,25 +3,34 @@ package entrypoint
 import (
 	"context"
 	"fmt"
-	"io/ioutil"
 	"os"
 	"os/signal"
 	"path"
+	"sort"
 	"strings"
 	"sync/atomic"
 	"syscall"
 	"time"
 
 	"github.com/google/uuid"
+	"github.com/prometheus/client_golang/prometheus"
 
+	"github.com/datawire/dlib/dcontext"
+	"github.com/datawire/dlib/dexec"
 	"github.com/datawire/dlib/dgroup"
 	"github.com/datawire/dlib/dlog"
+	"github.com/emissary-ingress/emissary/v3/pkg/accesslog"
 	"github.com/emissary-ingress/emissary/v3/pkg/acp"
 	"github.com/emissary-ingress/emissary/v3/pkg/ambex"
 	"github.com/emissary-ingress/emissary/v3/pkg/busy"
+	"github.com/emissary-ingress/emissary/v3/pkg/debug"
+	ecp_v3_cache "github.com/emissary-ingress/emissary/v3/pkg/envoy-control-plane/cache/v3"
+	ecp_v3_resource "github.com/emissary-ingress/emissary/v3/pkg/envoy-control-plane/resource/v3"
 	"github.com/emissary-ingress/emissary/v3/pkg/kates"
 	"github.com/emissary-ingress/emissary/v3/pkg/logutil"
 	"github.com/emissary-ingress/emissary/v3/pkg/memory"
+	"github.com/emissary-ingress/emissary/v3/pkg/snapshot/history"
+	"github.com/emissary-ingress/emissary/v3/pkg/tracing"
 )
 
 // This is the main ambassador entrypoint. It launches and manages two other
@@ -30,6 +39,9 @@ import (
 //  1. The diagd process.
 //  2. Envoy
 //
+// diagd, along with any sidecars, is run by a supervisor that restarts it
+// according to its restart policy (see supervisorConfig).
+//
 // The entrypoint process manages two other goroutines:
 //
 //  1. The watcher goroutine that watches for changes in ambassador inputs and
@@ -71,11 +83,11 @@ import (
 // passes the complete snapshot of inputs along to diagd along with a list of
 // deltas and invalid objects. This snapshot is fully detailed in snapshot.go
 //
-// The entrypoint goes to some trouble to ensure shared fate between all three
-// processes as well as all the goroutines it manages, i.e. if any one of them
-// dies for any reason, the whole process will shutdown and some larger process
-// manager (e.g. kubernetes) is expected to take note and restart if
-// appropriate.
+// The entrypoint supervises the diagd and envoy processes, restarting them
+// according to their restart policies. If a required process stops for good,
+// or any of the goroutines the entrypoint manages dies, the whole process will
+// shutdown and some larger process manager (e.g. kubernetes) is expected to
+// take note and restart if appropriate.
 
 func Main(ctx context.Context, Version string, args ...string) error {
 	// Setup logging according to AES_LOG_LEVEL
@@ -133,13 +145,47 @@ func Main(ctx context.Context, Version string, args ...string) error {
 	envoyHUP := make(chan os.Signal, 1)
 	signal.Notify(envoyHUP, syscall.SIGHUP)
 
+	shutdownTracing, err := tracing.Setup(ctx, GetTracingExporter(), "ambassador", Version)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := shutdownTracing(dcontext.WithoutCancel(ctx)); err != nil {
+			dlog.Errorf(ctx, "error shutting down tracing: %v", err)
+		}
+	}()
+	// The watcher hands each reconfiguration's trace to ambex with this.
+	ctx = tracing.WithBaton(ctx, &tracing.Baton{})
+
 	// Go ahead and create an AmbassadorWatcher now, since we'll need it later.
-	ambwatch := acp.NewAmbassadorWatcher(acp.NewEnvoyWatcher(), acp.NewDiagdWatcher())
+	diagdwatch := acp.NewDiagdWatcher()
+	diagdwatch.SetGracePeriod(GetDiagdGracePeriod())
+	ambwatch := acp.NewAmbassadorWatcher(acp.NewEnvoyWatcher(), diagdwatch)
+	ambwatch.SetEnvoyGracePeriod(GetEnvoyGracePeriod())
+	ambwatch.SetRequireWarm(envbool("AMBASSADOR_READY_REQUIRES_WARM"))
 
+	// We handle SIGINT and SIGTERM ourselves, rather than letting the group do it, so that we
+	// can drain Envoy and then stop things in order: first the watcher, so no new configuration
+	// comes in, then Envoy, then the rest of the control plane. The group's timeouts only come
+	// into play once all of that is done.
 	group := dgroup.NewGroup(ctx, dgroup.GroupConfig{
-		EnableSignalHandling: true,
-		SoftShutdownTimeout:  10 * time.Second,
-		HardShutdownTimeout:  10 * time.Second,
+		SoftShutdownTimeout: 10 * time.Second,
+		HardShutdownTimeout: 10 * time.Second,
+	})
+	shutdownSigs := make(chan os.Signal, 1)
+	signal.Notify(shutdownSigs, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(shutdownSigs)
+	shutdown := &shutdownSequence{
+		ambwatch:     ambwatch,
+		adminURL:     acp.GetEnvoyAdminURL(),
+		drainPeriod:  GetShutdownDrainPeriod(),
+		stageTimeout: 10 * time.Second,
+	}
+	watcherStage := shutdown.Stage("watcher")
+	envoyStage := shutdown.Stage("envoy")
+	controlPlaneStage := shutdown.Stage("control plane")
+	group.Go("shutdown", func(ctx context.Context) error {
+		return shutdown.Run(ctx, shutdownSigs)
 	})
 
 	// Demo mode: start the demo services. Starting the demo stuff first is
@@ -149,13 +195,33 @@ func Main(ctx context.Context, Version string, args ...string) error {
 		bootDemoMode(ctx, group, ambwatch)
 	}
 
-	group.Go("diagd", func(ctx context.Context) error {
-		cmd := subcommand(ctx, "diagd", GetDiagdArgs(ctx, demoMode)...)
-		if envbool("DEV_SHUTUP_DIAGD") {
-			cmd.Stdout = nil
-			cmd.Stderr = nil
-		}
-		return cmd.Run()
+	// The watcher tells diagd about every new snapshot, which the snapshot server serves.
+	snapshot := &atomic.Value{}
+	notifier := newDiagdNotifier(ambwatch, snapshot)
+
+	// diagd and the sidecars are supervised, so that one of them exiting doesn't take the
+	// whole pod down unless it's required. A restarted diagd has to be told about the current
+	// snapshot, since it won't hear about another one until something changes.
+	diagd := &supervisedChild{
+		name:     "diagd",
+		policy:   restartOnFailure,
+		required: true,
+		command: func(ctx context.Context) *dexec.Cmd {
+			cmd := subcommand(ctx, "diagd", GetDiagdArgs(ctx, demoMode)...)
+			if envbool("DEV_SHUTUP_DIAGD") {
+				cmd.Stdout = nil
+				cmd.Stderr = nil
+			}
+			return cmd
+		},
+		onRestart: notifier.resend,
+	}
+	children, err := loadSupervisedChildren(GetSupervisorConfigFile(), "/ambassador/sidecars", diagd)
+	if err != nil {
+		return err
+	}
+	controlPlaneStage.Go(group, "supervisor", func(ctx context.Context) error {
+		return newSupervisor(children, ambwatch).Run(ctx)
 	})
 
 	usage := memory.GetMemoryUsage(ctx)
@@ -166,53 +232,71 @@ func Main(ctx context.Context, Version string, args ...string) error {
 		})
 	}
 
+	// Everything the Go side of the house knows about goes into this registry, which is served
+	// at /metrics by the health check server.
+	metrics := prometheus.NewRegistry()
+	metrics.MustRegister(
+		prometheus.NewGoCollector(),
+		debug.FromContext(ctx),
+		ambwatch,
+		usage,
+	)
+	if err := ambex.RegisterMetrics(metrics); err != nil {
+		return err
+	}
+
 	fastpathCh := make(chan *ambex.FastpathSnapshot)
-	group.Go("ambex", func(ctx context.Context) error {
-		return ambex.Main(ctx, Version, usage.PercentUsed, fastpathCh, "--ads-listen-address",
+	explainer := &ambex.Explainer{}
+	hist := history.New(GetSnapshotHistoryCount())
+	observeSnapshot := func(version string, snapshot *ecp_v3_cache.Snapshot) {
+		ambwatch.NoteConfigAccepted(version,
+			resourceNames(snapshot, ecp_v3_resource.ClusterType),
+			resourceNames(snapshot, ecp_v3_resource.ListenerType))
+		explainer.Observe(version, snapshot)
+		hist.AddOutput(version, ambex.NewV3ExpandedSnapshot(snapshot))
+	}
+	controlPlaneStage.Go(group, "ambex", func(ctx context.Context) error {
+		return ambex.Main(ctx, Version, usage.ThrottlePercent, fastpathCh, observeSnapshot, "--ads-listen-address",
 			"127.0.0.1:8003", GetEnvoyDir())
 	})
 
-	group.Go("envoy", func(ctx context.Context) error {
-		return runEnvoy(ctx, envoyHUP)
+	envoyStage.Go(group, "envoy", func(ctx context.Context) error {
+		return runEnvoy(ctx, envoyHUP, ambwatch)
 	})
 
-	snapshot := &atomic.Value{}
-	group.Go("snapshot_server", func(ctx context.Context) error {
-		return snapshotServer(ctx, snapshot)
+	controlPlaneStage.Go(group, "snapshot_server", func(ctx context.Context) error {
+		return snapshotServer(ctx, snapshot, hist)
 	})
 	if !envbool("AMBASSADOR_DISABLE_SNAPSHOT_SERVER") {
-		group.Go("external_snapshot_server", func(ctx context.Context) error {
+		controlPlaneStage.Go(group, "external_snapshot_server", func(ctx context.Context) error {
 			return externalSnapshotServer(ctx, snapshot)
 		})
 	}
 
+	if address := GetAccessLogServerAddress(); address != "" {
+		sink, err := accesslog.OpenSink(GetAccessLogServerOutput(), 100*1024*1024, 5)
+		if err != nil {
+			return err
+		}
+		defer sink.Close()
+		controlPlaneStage.Go(group, "access_log_server", func(ctx context.Context) error {
+			return accesslog.NewServer(sink, GetAccessLogServerOptions()).ListenAndServe(ctx, address)
+		})
+	}
+
 	if !demoMode {
-		group.Go("watcher", func(ctx context.Context) error {
-			// We need to pass the AmbassadorWatcher to this (Kubernetes/Consul) watcher, so
-			// that it can tell the AmbassadorWatcher when snapshots are posted.
-			return WatchAllTheThings(ctx, ambwatch, snapshot, fastpathCh, clusterID, Version)
+		watcherStage.Go(group, "watcher", func(ctx context.Context) error {
+			// The notifier tells the AmbassadorWatcher when snapshots are posted, as well as
+			// telling diagd about them.
+			return WatchAllTheThings(ctx, notifier, snapshot, hist, fastpathCh, clusterID, Version)
 		})
 	}
 
 	// Finally, fire up the health check handler.
 	group.Go("healthchecks", func(ctx context.Context) error {
-		return healthCheckHandler(ctx, ambwatch)
+		return healthCheckHandler(ctx, ambwatch, explainer, metrics)
 	})
 
-	// Launch every file in the sidecar directory. Note that this is "bug compatible" with
-	// entrypoint.sh for now, e.g. we don't check execute bits or anything like that.
-	sidecarDir := "/ambassador/sidecars"
-	sidecars, err := ioutil.ReadDir(sidecarDir)
-	if err != nil && !os.IsNotExist(err) {
-		return err
-	}
-	for _, sidecar := range sidecars {
-		group.Go(sidecar.Name(), func(ctx context.Context) error {
-			cmd := subcommand(ctx, path.Join(sidecarDir, sidecar.Name()))
-			return cmd.Run()
-		})
-	}
-
 	return group.Wait()
 }
 
@@ -250,3 +334,14 @@ func GetClusterID(ctx context.Context) (clusterID string) {
 
 	return clusterIDFromRootID(rootID)
 }
+
+// resourceNames returns the sorted names of the resources of one type in an ambex snapshot.
+func resourceNames(snapshot *ecp_v3_cache.Snapshot, typeURL string) []string {
+	resources := snapshot.GetResources(typeURL)
+	names := make([]string, 0, len(resources))
+	for name := range resources {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
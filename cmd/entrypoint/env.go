@@ -7,10 +7,13 @@ import (
 	"os"
 	"path"
 	"path/filepath"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/datawire/dlib/dexec"
 	"github.com/datawire/dlib/dlog"
+	"github.com/emissary-ingress/emissary/v3/pkg/accesslog"
 )
 
 func GetAgentService() string {
@@ -43,6 +46,31 @@ func GetAmbassadorLabelSelector() string {
 	return env("AMBASSADOR_LABEL_SELECTOR", "")
 }
 
+// GetAmbassadorWatchNamespaces returns the namespaces to watch, from the comma-separated
+// AMBASSADOR_WATCH_NAMESPACES. If it is empty, we watch every namespace (or just our own, in
+// single-namespace mode).
+func GetAmbassadorWatchNamespaces() []string {
+	var namespaces []string
+	for _, ns := range strings.Split(env("AMBASSADOR_WATCH_NAMESPACES", ""), ",") {
+		if ns = strings.TrimSpace(ns); ns != "" {
+			namespaces = append(namespaces, ns)
+		}
+	}
+	return namespaces
+}
+
+// GetAmbassadorWatchNamespaceSelector returns the label selector for the namespaces to watch.
+// Namespaces are picked up (and dropped) as their labels change.
+func GetAmbassadorWatchNamespaceSelector() string {
+	return env("AMBASSADOR_WATCH_NAMESPACE_SELECTOR", "")
+}
+
+// GetAmbassadorServiceName returns the name of the Service in front of Ambassador, if it has been
+// set explicitly. If it hasn't, we find the Service by its labels instead.
+func GetAmbassadorServiceName() string {
+	return env("AMBASSADOR_SERVICE_NAME", "")
+}
+
 func GetAmbassadorRoot() string {
 	return env("ambassador_root", "/ambassador")
 }
@@ -298,6 +326,151 @@ func IsKnativeEnabled() bool {
 	return strings.ToLower(env("AMBASSADOR_KNATIVE_SUPPORT", "")) == "true"
 }
 
+// IsWorkloadAnnotationsEnabled returns whether we should look for getambassador.io/config
+// annotations on Deployments and Pods, as well as on Services and Ingresses.
+func IsWorkloadAnnotationsEnabled() bool {
+	return strings.ToLower(env("AMBASSADOR_WORKLOAD_ANNOTATIONS", "")) == "true"
+}
+
+// GetDNSCacheTTL returns how long a successful DNS lookup of a Consul endpoint address is cached
+// before it gets refreshed. Set AMBASSADOR_DNS_CACHE_TTL to a Go duration (e.g. "30s") to change it.
+func GetDNSCacheTTL() time.Duration {
+	return envDuration("AMBASSADOR_DNS_CACHE_TTL", 30*time.Second)
+}
+
+// GetDNSNegativeCacheTTL returns how long a failed DNS lookup is remembered before it gets
+// retried. Set AMBASSADOR_DNS_NEGATIVE_CACHE_TTL to a Go duration (e.g. "5s") to change it.
+func GetDNSNegativeCacheTTL() time.Duration {
+	return envDuration("AMBASSADOR_DNS_NEGATIVE_CACHE_TTL", 5*time.Second)
+}
+
+// GetDNSMaxStale returns how long the last good answer for a hostname is kept while lookups of it
+// fail with errors that may be transient (timeouts, SERVFAIL), counting from the last successful
+// lookup. Set AMBASSADOR_DNS_MAX_STALE to a Go duration (e.g. "10m") to change it.
+func GetDNSMaxStale() time.Duration {
+	return envDuration("AMBASSADOR_DNS_MAX_STALE", 5*time.Minute)
+}
+
+// GetDNSMaxConcurrentLookups returns the maximum number of DNS lookups that may be in flight at
+// once. Set AMBASSADOR_DNS_MAX_CONCURRENT_LOOKUPS to change it.
+func GetDNSMaxConcurrentLookups() int {
+	n, err := strconv.Atoi(env("AMBASSADOR_DNS_MAX_CONCURRENT_LOOKUPS", "8"))
+	if err != nil || n < 1 {
+		return 8
+	}
+	return n
+}
+
+// GetSnapshotHistoryCount returns how many input snapshots, and how many ambex output snapshots,
+// the snapshot server keeps in its history. The history is off (zero) unless
+// AMBASSADOR_SNAPSHOT_HISTORY_COUNT is set.
+func GetSnapshotHistoryCount() int {
+	n, err := strconv.Atoi(env("AMBASSADOR_SNAPSHOT_HISTORY_COUNT", "0"))
+	if err != nil || n < 0 {
+		return 0
+	}
+	return n
+}
+
+// GetTracingExporter returns where the spans for each reconfiguration go: "none" (the default) or
+// "otlp". Set AMBASSADOR_TRACING_EXPORTER to change it; the OTLP exporter itself is configured with
+// the standard OTEL_EXPORTER_OTLP_* environment variables.
+func GetTracingExporter() string {
+	return env("AMBASSADOR_TRACING_EXPORTER", "none")
+}
+
+// GetAccessLogServerAddress returns the address that the built-in gRPC access log service listens
+// on, for LogServices to point at. Set AMBASSADOR_ALS_ADDRESS (e.g. "127.0.0.1:8009") to turn it
+// on; it's off by default.
+func GetAccessLogServerAddress() string {
+	return env("AMBASSADOR_ALS_ADDRESS", "")
+}
+
+// GetAccessLogServerOutput returns where the built-in access log service writes entries:
+// "stdout" (the default), "stderr", or a file, which is rotated at 100MB. Set AMBASSADOR_ALS_OUTPUT
+// to change it.
+func GetAccessLogServerOutput() string {
+	return env("AMBASSADOR_ALS_OUTPUT", "stdout")
+}
+
+// GetAccessLogServerOptions returns how the built-in access log service samples and redacts
+// entries. Set AMBASSADOR_ALS_SAMPLE_RATE to the fraction of entries to keep,
+// AMBASSADOR_ALS_KEEP_ERRORS to keep every 5xx regardless, and AMBASSADOR_ALS_REDACT to a
+// comma-separated list of fields to redact (e.g. "request.request_headers.authorization").
+func GetAccessLogServerOptions() accesslog.Options {
+	opts := accesslog.Options{
+		KeepErrors: envbool("AMBASSADOR_ALS_KEEP_ERRORS"),
+	}
+	if rate, err := strconv.ParseFloat(env("AMBASSADOR_ALS_SAMPLE_RATE", "1"), 64); err == nil {
+		opts.SampleRate = rate
+	}
+	if redact := env("AMBASSADOR_ALS_REDACT", ""); redact != "" {
+		opts.Redact = strings.Split(redact, ",")
+	}
+	return opts
+}
+
+// GetEnvoyGracePeriod returns how long Envoy has to come up after diagd processes the first
+// snapshot, before the liveness check fails. Set AMBASSADOR_ENVOY_GRACE_PERIOD to a Go duration
+// (e.g. "2m") to change it; if you use a startupProbe on /ambassador/v0/check_started, this
+// matters much less.
+func GetEnvoyGracePeriod() time.Duration {
+	return envDuration("AMBASSADOR_ENVOY_GRACE_PERIOD", 30*time.Second)
+}
+
+// GetDiagdGracePeriod returns how long diagd has to process a snapshot before the liveness check
+// fails. Set AMBASSADOR_DIAGD_GRACE_PERIOD to a Go duration (e.g. "15m") to change it.
+func GetDiagdGracePeriod() time.Duration {
+	return envDuration("AMBASSADOR_DIAGD_GRACE_PERIOD", 10*time.Minute)
+}
+
+// GetShutdownDrainPeriod returns how long, on shutdown, to let Envoy drain after it's been told to
+// fail its health checks, before anything is stopped. It needs to be long enough for Kubernetes to
+// notice that we're not ready, and short enough to fit in the pod's terminationGracePeriodSeconds.
+// Set AMBASSADOR_SHUTDOWN_DRAIN_PERIOD to a Go duration (e.g. "15s") to change it.
+func GetShutdownDrainPeriod() time.Duration {
+	return envDuration("AMBASSADOR_SHUTDOWN_DRAIN_PERIOD", 5*time.Second)
+}
+
+// GetSupervisorConfigFile returns the file that declares the processes the entrypoint supervises
+// alongside diagd; see supervisorConfig. Set AMBASSADOR_SUPERVISOR_CONFIG to change it. If the file
+// doesn't exist, every file in /ambassador/sidecars is run instead.
+func GetSupervisorConfigFile() string {
+	return env("AMBASSADOR_SUPERVISOR_CONFIG", "/ambassador/supervisor.yaml")
+}
+
+// IsEnvoyHotRestartEnabled returns whether the entrypoint restarts Envoy itself, using Envoy's hot
+// restart, rather than letting an Envoy crash take the whole pod down. Set
+// AMBASSADOR_ENVOY_HOT_RESTART to turn it on. It needs /dev/shm to be writable.
+func IsEnvoyHotRestartEnabled() bool {
+	return envbool("AMBASSADOR_ENVOY_HOT_RESTART")
+}
+
+// GetEnvoyMaxRestarts returns how many times Envoy may exit unexpectedly within ten minutes before
+// the entrypoint gives up on restarting it. Set AMBASSADOR_ENVOY_MAX_RESTARTS to change it.
+func GetEnvoyMaxRestarts() int {
+	n, err := strconv.Atoi(env("AMBASSADOR_ENVOY_MAX_RESTARTS", "5"))
+	if err != nil || n < 0 {
+		return 5
+	}
+	return n
+}
+
+// getDNSIPNetworkFamily will return which IP families DNS answers are allowed to contribute to
+// endpoints. Set the AMBASSADOR_DNS_IP_FAMILY environment variable to "ANY", "IPV4_ONLY" or
+// "IPV6_ONLY"; the values map to networks the same way as for getHealthCheckIPNetworkFamily.
+func getDNSIPNetworkFamily() string {
+	ipFamily := strings.ToUpper(env("AMBASSADOR_DNS_IP_FAMILY", "ANY"))
+
+	switch ipFamily {
+	case "IPV4_ONLY":
+		return "tcp4"
+	case "IPV6_ONLY":
+		return "tcp6"
+	}
+	return "tcp"
+}
+
 // getHealthCheckHost will return address that the health check server will bind to.
 // If not provided it will default to all interfaces (`0.0.0.0`).
 func getHealthCheckHost() string {
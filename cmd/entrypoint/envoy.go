@@ -5,6 +5,8 @@ import (
 	"errors"
 	"fmt"
 	"os"
+	"os/signal"
+	"syscall"
 	"time"
 
 	"github.com/datawire/dlib/dcontext"
@@ -14,7 +16,7 @@ import (
 	"github.com/emissary-ingress/emissary/v3/pkg/envoytest"
 )
 
-func runEnvoy(ctx context.Context, envoyHUP chan os.Signal) error {
+func runEnvoy(ctx context.Context, envoyHUP chan os.Signal, ambwatch envoyRestartNotable) error {
 	// Wait until we get a SIGHUP to start envoy.
 	//var bootstrap string
 	select {
@@ -25,7 +27,28 @@ func runEnvoy(ctx context.Context, envoyHUP chan os.Signal) error {
 
 	// Try to run envoy directly, but fallback to running it inside docker if there is
 	// no envoy executable available.
-	if IsEnvoyAvailable() {
+	if IsEnvoyAvailable() && IsEnvoyHotRestartEnabled() {
+		restart := make(chan os.Signal, 1)
+		signal.Notify(restart, syscall.SIGUSR1)
+		defer signal.Stop(restart)
+
+		m := &envoyManager{
+			flags:         GetEnvoyFlags(),
+			bootstrapFile: GetEnvoyBootstrapFile(),
+			maxRestarts:   GetEnvoyMaxRestarts(),
+			restartWindow: 10 * time.Minute,
+			ambwatch:      ambwatch,
+			command: func(ctx context.Context, args ...string) *dexec.Cmd {
+				cmd := subcommand(ctx, "envoy", args...)
+				if envbool("DEV_SHUTUP_ENVOY") {
+					cmd.Stdout = nil
+					cmd.Stderr = nil
+				}
+				return cmd
+			},
+		}
+		return m.Run(ctx, envoyHUP, restart)
+	} else if IsEnvoyAvailable() {
 		cmd := subcommand(ctx, "envoy", GetEnvoyFlags()...)
 		if envbool("DEV_SHUTUP_ENVOY") {
 			cmd.Stdout = nil
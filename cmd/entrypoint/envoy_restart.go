@@ -0,0 +1,173 @@
+package entrypoint
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/datawire/dlib/dexec"
+	"github.com/datawire/dlib/dlog"
+)
+
+// envoyRestartNotable is the part of acp.AmbassadorWatcher that an envoyManager tells about
+// restarts.
+type envoyRestartNotable interface {
+	NoteEnvoyRestart(epoch int, reason string)
+}
+
+// An envoyManager runs Envoy and restarts it when need be, using Envoy's hot restart: the new
+// Envoy is started with the next --restart-epoch, takes the listen sockets over from the old one,
+// and tells it to drain and exit. Envoy gets hot restarted
+//
+//   - when we get a SIGUSR1, and
+//   - when diagd SIGHUPs us after changing the bootstrap file (e.g. the admin port).
+//
+// If the newest Envoy exits on its own, there's no Envoy left to hand anything over, so any
+// older Envoys that are still draining are stopped, and we start over at epoch 0. If that happens
+// more than maxRestarts times within restartWindow, we give up, and return an error.
+type envoyManager struct {
+	// The flags to run Envoy with, other than --restart-epoch.
+	flags []string
+
+	// The file that flags tells Envoy to bootstrap from.
+	bootstrapFile string
+
+	maxRestarts   int
+	restartWindow time.Duration
+
+	ambwatch envoyRestartNotable
+
+	// How to run Envoy. This is here for testing.
+	command func(ctx context.Context, args ...string) *dexec.Cmd
+}
+
+type envoyExit struct {
+	epoch int
+	err   error
+}
+
+// Run starts Envoy at epoch 0, and manages it until ctx is cancelled. Every signal on hup
+// means that the bootstrap file may have changed; every signal on restart asks for a hot
+// restart.
+func (m *envoyManager) Run(ctx context.Context, hup, restart <-chan os.Signal) error {
+	// The Envoys that are running, by epoch, with how to stop them.
+	running := make(map[int]context.CancelFunc)
+	exits := make(chan envoyExit)
+
+	start := func(epoch int) error {
+		envoyCtx, cancel := context.WithCancel(ctx)
+		args := append(append([]string(nil), m.flags...), "--restart-epoch", strconv.Itoa(epoch))
+		cmd := m.command(envoyCtx, args...)
+		if err := cmd.Start(); err != nil {
+			cancel()
+			return err
+		}
+		running[epoch] = cancel
+		go func() {
+			exits <- envoyExit{epoch: epoch, err: cmd.Wait()}
+		}()
+		return nil
+	}
+
+	// stopAll stops every Envoy that's running, and waits for them to exit.
+	stopAll := func() {
+		for _, cancel := range running {
+			cancel()
+		}
+		for len(running) > 0 {
+			exit := <-exits
+			running[exit.epoch]()
+			delete(running, exit.epoch)
+		}
+	}
+	defer stopAll()
+
+	epoch := 0
+	if err := start(epoch); err != nil {
+		return err
+	}
+	bootstrap := m.bootstrapChecksum(ctx)
+
+	hotRestart := func(reason string) error {
+		dlog.Infof(ctx, "hot restarting Envoy (epoch %d) because %s", epoch+1, reason)
+		if err := start(epoch + 1); err != nil {
+			return err
+		}
+		epoch++
+		m.ambwatch.NoteEnvoyRestart(epoch, reason)
+		return nil
+	}
+
+	var crashes []time.Time
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+
+		case <-hup:
+			checksum := m.bootstrapChecksum(ctx)
+			if checksum == bootstrap {
+				continue
+			}
+			bootstrap = checksum
+			if err := hotRestart("the bootstrap configuration changed"); err != nil {
+				return err
+			}
+
+		case <-restart:
+			if err := hotRestart("a restart was requested"); err != nil {
+				return err
+			}
+
+		case exit := <-exits:
+			running[exit.epoch]()
+			delete(running, exit.epoch)
+
+			if exit.epoch != epoch {
+				// This is an old Envoy that has finished handing over to a newer one.
+				dlog.Infof(ctx, "Envoy epoch %d has exited (%v)", exit.epoch, exit.err)
+				continue
+			}
+			if ctx.Err() != nil {
+				continue
+			}
+
+			reason := "Envoy exited"
+			if exit.err != nil {
+				reason = fmt.Sprintf("Envoy exited: %v", exit.err)
+			}
+			dlog.Errorf(ctx, "Envoy epoch %d: %s", exit.epoch, reason)
+
+			now := time.Now()
+			crashes = append(crashes, now)
+			for len(crashes) > 0 && now.Sub(crashes[0]) > m.restartWindow {
+				crashes = crashes[1:]
+			}
+			if len(crashes) > m.maxRestarts {
+				return fmt.Errorf("Envoy exited %d times within %v; giving up: %s", len(crashes), m.restartWindow, reason)
+			}
+
+			// A dead Envoy can't hand anything over, so the only way forward is to stop
+			// whatever's still draining and start afresh.
+			stopAll()
+			epoch = 0
+			if err := start(epoch); err != nil {
+				return err
+			}
+			m.ambwatch.NoteEnvoyRestart(epoch, reason)
+		}
+	}
+}
+
+// bootstrapChecksum returns a checksum of the bootstrap file, or "" if it can't be read.
+func (m *envoyManager) bootstrapChecksum(ctx context.Context) string {
+	bs, err := os.ReadFile(m.bootstrapFile)
+	if err != nil {
+		dlog.Warnf(ctx, "unable to read Envoy bootstrap file: %v", err)
+		return ""
+	}
+	return fmt.Sprintf("%x", sha256.Sum256(bs))
+}
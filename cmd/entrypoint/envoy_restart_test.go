@@ -0,0 +1,153 @@
+package entrypoint
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/datawire/dlib/dexec"
+	"github.com/datawire/dlib/dlog"
+	"github.com/emissary-ingress/emissary/v3/pkg/acp"
+)
+
+// fakeEnvoyScript logs its arguments as line N of $DIR/log, and then runs until $DIR/exit-N
+// exists, exiting with the status in that file.
+const fakeEnvoyScript = `
+echo "$@" >> "$DIR/log"
+n=$(wc -l < "$DIR/log")
+n=$((n+0))
+while [ ! -e "$DIR/exit-$n" ]; do sleep 0.01; done
+exit $(cat "$DIR/exit-$n")
+`
+
+func TestEnvoyManager(t *testing.T) {
+	dir := t.TempDir()
+	bootstrap := filepath.Join(dir, "bootstrap.json")
+	require.NoError(t, os.WriteFile(bootstrap, []byte(`{"admin": {}}`), 0644))
+
+	ambwatch := acp.NewAmbassadorWatcher(acp.NewEnvoyWatcher(), acp.NewDiagdWatcher())
+	m := &envoyManager{
+		flags:         []string{"-c", bootstrap, "--base-id", "0"},
+		bootstrapFile: bootstrap,
+		maxRestarts:   1,
+		restartWindow: time.Hour,
+		ambwatch:      ambwatch,
+		command: func(ctx context.Context, args ...string) *dexec.Cmd {
+			cmd := dexec.CommandContext(ctx, "sh", append([]string{"-c", fakeEnvoyScript, "envoy"}, args...)...)
+			cmd.Env = append(os.Environ(), "DIR="+dir)
+			cmd.DisableLogging = true
+			return cmd
+		},
+	}
+
+	invocations := func() []string {
+		bs, _ := os.ReadFile(filepath.Join(dir, "log"))
+		return strings.Split(strings.TrimSpace(string(bs)), "\n")
+	}
+	waitFor := func(n int) {
+		t.Helper()
+		require.Eventually(t, func() bool { return len(invocations()) >= n }, 10*time.Second, 10*time.Millisecond)
+		time.Sleep(50 * time.Millisecond)
+		require.Len(t, invocations(), n)
+	}
+	exit := func(n, status int) {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, fmt.Sprintf("exit-%d", n)), []byte(fmt.Sprint(status)), 0644))
+	}
+
+	ctx, cancel := context.WithCancel(dlog.NewTestContext(t, false))
+	defer cancel()
+	hup := make(chan os.Signal, 1)
+	restart := make(chan os.Signal, 1)
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- m.Run(ctx, hup, restart)
+	}()
+
+	waitFor(1)
+	assert.Equal(t, "-c "+bootstrap+" --base-id 0 --restart-epoch 0", invocations()[0])
+
+	// Asking for a restart starts the next epoch.
+	restart <- syscall.SIGUSR1
+	waitFor(2)
+	assert.Equal(t, "-c "+bootstrap+" --base-id 0 --restart-epoch 1", invocations()[1])
+	report := ambwatch.Report().EnvoyRestarts
+	assert.Equal(t, 1, report.Epoch)
+	assert.Equal(t, 1, report.Restarts)
+	assert.Equal(t, "a restart was requested", report.LastReason)
+
+	// The old Envoy exiting once it's handed over is just fine.
+	exit(1, 0)
+
+	// A SIGHUP only restarts Envoy if the bootstrap has changed.
+	hup <- syscall.SIGHUP
+	time.Sleep(100 * time.Millisecond)
+	waitFor(2)
+	require.NoError(t, os.WriteFile(bootstrap, []byte(`{"admin": {"address": "elsewhere"}}`), 0644))
+	hup <- syscall.SIGHUP
+	waitFor(3)
+	assert.Equal(t, "-c "+bootstrap+" --base-id 0 --restart-epoch 2", invocations()[2])
+
+	// If the newest Envoy dies, whatever's left is stopped, and we start over.
+	exit(3, 1)
+	waitFor(4)
+	assert.Equal(t, "-c "+bootstrap+" --base-id 0 --restart-epoch 0", invocations()[3])
+	report = ambwatch.Report().EnvoyRestarts
+	assert.Equal(t, 0, report.Epoch)
+	assert.Equal(t, 3, report.Restarts)
+	assert.Contains(t, report.LastReason, "Envoy exited: exit status 1")
+
+	// But not forever.
+	exit(4, 2)
+	select {
+	case err := <-errCh:
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "Envoy exited 2 times within 1h0m0s; giving up")
+	case <-time.After(10 * time.Second):
+		t.Fatal("envoyManager.Run did not give up")
+	}
+}
+
+func TestEnvoyManagerShutdown(t *testing.T) {
+	dir := t.TempDir()
+	m := &envoyManager{
+		bootstrapFile: filepath.Join(dir, "bootstrap.json"),
+		maxRestarts:   5,
+		restartWindow: time.Hour,
+		ambwatch:      acp.NewAmbassadorWatcher(acp.NewEnvoyWatcher(), acp.NewDiagdWatcher()),
+		command: func(ctx context.Context, args ...string) *dexec.Cmd {
+			cmd := dexec.CommandContext(ctx, "sh", append([]string{"-c", fakeEnvoyScript, "envoy"}, args...)...)
+			cmd.Env = append(os.Environ(), "DIR="+dir)
+			cmd.DisableLogging = true
+			return cmd
+		},
+	}
+
+	ctx, cancel := context.WithCancel(dlog.NewTestContext(t, false))
+	restart := make(chan os.Signal, 1)
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- m.Run(ctx, nil, restart)
+	}()
+	restart <- syscall.SIGUSR1
+	require.Eventually(t, func() bool {
+		bs, _ := os.ReadFile(filepath.Join(dir, "log"))
+		return strings.Count(string(bs), "\n") == 2
+	}, 10*time.Second, 10*time.Millisecond)
+
+	// Shutting down stops every Envoy, not just the newest one.
+	cancel()
+	select {
+	case err := <-errCh:
+		assert.NoError(t, err)
+	case <-time.After(10 * time.Second):
+		t.Fatal("envoyManager.Run did not return")
+	}
+}
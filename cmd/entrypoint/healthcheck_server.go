@@ -1,18 +1,26 @@
 package entrypoint
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
+	"fmt"
 	"net"
 	"net/http"
 	"net/http/httputil"
 	"net/http/pprof"
 	"net/url"
 
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
 	_ "k8s.io/client-go/plugin/pkg/client/auth"
 	_ "k8s.io/client-go/plugin/pkg/client/auth/gcp"
 
 	"github.com/datawire/dlib/dhttp"
+	"github.com/datawire/dlib/dlog"
 	"github.com/emissary-ingress/emissary/v3/pkg/acp"
+	"github.com/emissary-ingress/emissary/v3/pkg/ambex"
 	"github.com/emissary-ingress/emissary/v3/pkg/debug"
 )
 
@@ -38,6 +46,9 @@ func handleCheckReady(w http.ResponseWriter, r *http.Request, ambwatch *acp.Amba
 	// declared alive, and we'll never consider Ambassador ready.
 	ambwatch.FetchEnvoyReady(r.Context())
 
+	// Only readiness cares whether Envoy has warmed up.
+	ambwatch.FetchEnvoyWarm(r.Context())
+
 	ok := ambwatch.IsReady()
 
 	if ok {
@@ -47,13 +58,123 @@ func handleCheckReady(w http.ResponseWriter, r *http.Request, ambwatch *acp.Amba
 	}
 }
 
-func healthCheckHandler(ctx context.Context, ambwatch *acp.AmbassadorWatcher) error {
+func handleCheckStarted(w http.ResponseWriter, r *http.Request, ambwatch *acp.AmbassadorWatcher) {
+	// Once again, we have to talk to Envoy to find out if it's come up.
+	ambwatch.FetchEnvoyReady(r.Context())
+
+	ok := ambwatch.IsStarted()
+
+	if ok {
+		_, _ = w.Write([]byte("Ambassador has started\n"))
+	} else {
+		http.Error(w, "Ambassador has not started\n", http.StatusServiceUnavailable)
+	}
+}
+
+// handleHealthReport explains what the liveness and readiness checks are saying, and why, as
+// JSON. It always returns 200 if it can make a report at all: it's for people and tools trying to
+// figure out what's going on, not for Kubernetes.
+func handleHealthReport(w http.ResponseWriter, r *http.Request, ambwatch *acp.AmbassadorWatcher) {
+	// Like the checks themselves, make sure that what we say about Envoy is current.
+	ambwatch.FetchEnvoyReady(r.Context())
+	ambwatch.FetchEnvoyWarm(r.Context())
+
+	bs, err := json.MarshalIndent(ambwatch.Report(), "", "  ")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write(bs)
+}
+
+// handleExplain maps names in the Envoy configuration back to the Kubernetes resources that they
+// came from: ?name=<cluster, listener, route configuration, or virtual host> explains just the
+// things with that name (or that refer to it), and no name explains everything.
+func handleExplain(w http.ResponseWriter, r *http.Request, explainer *ambex.Explainer) {
+	version, explanations := explainer.Explain(r.URL.Query().Get("name"))
+	if explanations == nil {
+		explanations = []ambex.Explanation{}
+	}
+
+	bs, err := json.MarshalIndent(struct {
+		Version   string              `json:"version"`
+		Resources []ambex.Explanation `json:"resources"`
+	}{version, explanations}, "", "  ")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write(bs)
+}
+
+// handleMetrics serves the Go side's Prometheus metrics merged with diagd's (which include
+// Envoy's), so that a family that both of them have is only served once. Where they disagree
+// about a family, or both have the same series, ours win. If diagd can't be reached, we still
+// serve our own.
+func handleMetrics(w http.ResponseWriter, r *http.Request, gatherer prometheus.Gatherer, diagdMetricsURL string) {
+	diagd := prometheus.GathererFunc(func() ([]*dto.MetricFamily, error) {
+		return fetchMetrics(r.Context(), diagdMetricsURL)
+	})
+	families, err := prometheus.Gatherers{gatherer, diagd}.Gather()
+	if err != nil {
+		// Gather returns whatever it could gather along with the error, so keep going.
+		dlog.Warnf(r.Context(), "error gathering metrics: %v", err)
+	}
+
+	var buf bytes.Buffer
+	enc := expfmt.NewEncoder(&buf, expfmt.FmtText)
+	for _, family := range families {
+		if err := enc.Encode(family); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", string(expfmt.FmtText))
+	_, _ = w.Write(buf.Bytes())
+}
+
+// fetchMetrics fetches and parses the Prometheus text format metrics at url.
+func fetchMetrics(ctx context.Context, url string) ([]*dto.MetricFamily, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s: %s", url, resp.Status)
+	}
+
+	var parser expfmt.TextParser
+	byName, err := parser.TextToMetricFamilies(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", url, err)
+	}
+	families := make([]*dto.MetricFamily, 0, len(byName))
+	for _, family := range byName {
+		families = append(families, family)
+	}
+	return families, nil
+}
+
+func healthCheckHandler(ctx context.Context, ambwatch *acp.AmbassadorWatcher, explainer *ambex.Explainer, metrics prometheus.Gatherer) error {
 	dbg := debug.FromContext(ctx)
 
 	// We need to do some HTTP stuff by hand to catch the readiness and liveness
 	// checks here, but forward everything else to diagd.
 	sm := http.NewServeMux()
 
+	// diagdOrigin is where diagd is listening.
+	diagdOrigin, _ := url.Parse("http://127.0.0.1:8004/")
+
 	// Handle the liveness check and the readiness check directly, by handing them
 	// off to our functions.
 
@@ -69,9 +190,30 @@ func healthCheckHandler(ctx context.Context, ambwatch *acp.AmbassadorWatcher) er
 			handleCheckReady(w, r, ambwatch)
 		}))
 
+	startupTimer := dbg.Timer("check_started")
+	sm.HandleFunc("/ambassador/v0/check_started",
+		startupTimer.TimedHandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			handleCheckStarted(w, r, ambwatch)
+		}))
+
+	// Explain what the liveness and readiness checks are saying.
+	sm.HandleFunc("/ambassador/v0/health", func(w http.ResponseWriter, r *http.Request) {
+		handleHealthReport(w, r, ambwatch)
+	})
+
+	// Explain where the Envoy configuration came from.
+	sm.HandleFunc("/ambassador/v0/explain", func(w http.ResponseWriter, r *http.Request) {
+		handleExplain(w, r, explainer)
+	})
+
 	// Serve any debug info from the golang codebase.
 	sm.Handle("/debug", dbg)
 
+	// Serve Prometheus metrics from the golang codebase, merged with diagd's.
+	sm.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		handleMetrics(w, r, metrics, diagdOrigin.String()+"metrics")
+	})
+
 	// Serve pprof endpoints to aid in live debugging.
 	sm.HandleFunc("/debug/pprof/", pprof.Index)
 	sm.HandleFunc("/debug/pprof/profile", pprof.Profile)
@@ -80,9 +222,6 @@ func healthCheckHandler(ctx context.Context, ambwatch *acp.AmbassadorWatcher) er
 	sm.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
 
 	// For everything else, use a ReverseProxy to forward it to diagd.
-	//
-	// diagdOrigin is where diagd is listening.
-	diagdOrigin, _ := url.Parse("http://127.0.0.1:8004/")
 
 	// This reverseProxy is dirt simple: use a director function to
 	// swap the scheme and host of our request for the ones from the
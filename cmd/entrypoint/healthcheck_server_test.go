@@ -0,0 +1,110 @@
+package entrypoint
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/datawire/dlib/dlog"
+	"github.com/emissary-ingress/emissary/v3/pkg/acp"
+)
+
+func TestHandleMetrics(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	counter := prometheus.NewCounter(prometheus.CounterOpts{Name: "test_go_total", Help: "A Go metric."})
+	registry.MustRegister(counter)
+	counter.Inc()
+
+	diagd := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/metrics" {
+			http.NotFound(w, r)
+			return
+		}
+		_, _ = w.Write([]byte("# HELP test_go_total A Go metric.\n" +
+			"# TYPE test_go_total counter\n" +
+			"test_go_total 5\n" +
+			"test_go_total{source=\"diagd\"} 2\n" +
+			"# TYPE envoy_cluster_upstream_rq_total counter\n" +
+			"envoy_cluster_upstream_rq_total 3\n"))
+	}))
+	defer diagd.Close()
+
+	get := func(diagdMetricsURL string) string {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+		r = r.WithContext(dlog.NewTestContext(t, true))
+		handleMetrics(w, r, registry, diagdMetricsURL)
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Contains(t, w.Header().Get("Content-Type"), "text/plain")
+		return w.Body.String()
+	}
+
+	// The families are merged, rather than one of them being served twice, and where both of us
+	// have the same series, ours wins.
+	assert.Equal(t, "# TYPE envoy_cluster_upstream_rq_total counter\n"+
+		"envoy_cluster_upstream_rq_total 3\n"+
+		"# HELP test_go_total A Go metric.\n"+
+		"# TYPE test_go_total counter\n"+
+		"test_go_total 1\n"+
+		"test_go_total{source=\"diagd\"} 2\n", get(diagd.URL+"/metrics"))
+
+	// If diagd isn't answering, we still serve our own metrics.
+	assert.Equal(t, "# HELP test_go_total A Go metric.\n"+
+		"# TYPE test_go_total counter\n"+
+		"test_go_total 1\n", get(diagd.URL+"/nonexistent"))
+}
+
+func TestHandleHealthReport(t *testing.T) {
+	ew := acp.NewEnvoyWatcher()
+	ew.SetReadyCheck(func(context.Context) (*acp.EnvoyFetcherResponse, error) {
+		return &acp.EnvoyFetcherResponse{StatusCode: http.StatusServiceUnavailable, Text: []byte("DRAINING")}, nil
+	})
+	ambwatch := acp.NewAmbassadorWatcher(ew, acp.NewDiagdWatcher())
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "/ambassador/v0/health", nil)
+	r = r.WithContext(dlog.NewTestContext(t, true))
+	handleHealthReport(w, r, ambwatch)
+
+	// Not being ready is reported, not returned as an error.
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "application/json", w.Header().Get("Content-Type"))
+
+	var report acp.HealthReport
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &report))
+	assert.False(t, report.Ready)
+	assert.Equal(t, "not-ready", report.Envoy.State)
+	assert.Equal(t, "/ready returned 503: DRAINING", report.Envoy.Reason)
+}
+
+func TestHandleCheckStarted(t *testing.T) {
+	status := http.StatusServiceUnavailable
+	ew := acp.NewEnvoyWatcher()
+	ew.SetReadyCheck(func(context.Context) (*acp.EnvoyFetcherResponse, error) {
+		return &acp.EnvoyFetcherResponse{StatusCode: status}, nil
+	})
+	ambwatch := acp.NewAmbassadorWatcher(ew, acp.NewDiagdWatcher())
+
+	check := func() int {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, "/ambassador/v0/check_started", nil)
+		r = r.WithContext(dlog.NewTestContext(t, true))
+		handleCheckStarted(w, r, ambwatch)
+		return w.Code
+	}
+
+	assert.Equal(t, http.StatusServiceUnavailable, check())
+
+	ambwatch.NoteSnapshotSent()
+	ambwatch.NoteSnapshotProcessed()
+	assert.Equal(t, http.StatusServiceUnavailable, check())
+
+	status = http.StatusOK
+	assert.Equal(t, http.StatusOK, check())
+}
@@ -5,6 +5,7 @@ import (
 	"encoding/json"
 	"os"
 	"strings"
+	"time"
 
 	"github.com/datawire/dlib/dexec"
 )
@@ -22,6 +23,16 @@ func env(name, defaultValue string) string {
 	}
 }
 
+// envDuration parses the named environment variable as a Go duration, falling back to
+// defaultValue if it is unset, unparseable, or not positive.
+func envDuration(name string, defaultValue time.Duration) time.Duration {
+	value, err := time.ParseDuration(os.Getenv(name))
+	if err != nil || value <= 0 {
+		return defaultValue
+	}
+	return value
+}
+
 func ensureDir(dirname string) error {
 	err := os.MkdirAll(dirname, 0700)
 	if err != nil && os.IsExist(err) {
@@ -0,0 +1,113 @@
+package entrypoint
+
+import (
+	"context"
+	"reflect"
+	"strings"
+
+	"github.com/datawire/dlib/dlog"
+
+	"github.com/emissary-ingress/emissary/v3/pkg/kates"
+	snapshotTypes "github.com/emissary-ingress/emissary/v3/pkg/snapshot/v1"
+)
+
+// ingressController is the spec.controller of the IngressClasses that are ours.
+const ingressController = "getambassador.io/ingress-controller"
+
+// ReconcileIngressStatus copies the load balancer status of our own Service in to every Ingress
+// that we're handling, so that `kubectl get ingress` shows where to find it.
+func ReconcileIngressStatus(ctx context.Context, sh *SnapshotHolder) {
+	if len(sh.k8sSnapshot.Ingresses) == 0 || sh.ingressAPIVersion == "" {
+		return
+	}
+
+	svc := findAmbassadorService(sh.k8sSnapshot.Services, GetAmbassadorServiceName(), sh.podLabels)
+	if svc == nil {
+		dlog.Warnf(ctx, "unable to set the load balancer of Ingresses: could not find the Ambassador Service")
+		return
+	}
+
+	envAmbID := GetAmbassadorID()
+	classes := ambassadorIngressClasses(sh.k8sSnapshot.IngressClasses, envAmbID)
+
+	for _, ing := range sh.k8sSnapshot.Ingresses {
+		if !isIngressForUs(ctx, ing, classes, envAmbID) {
+			continue
+		}
+		if reflect.DeepEqual(ing.Status.LoadBalancer, svc.Status.LoadBalancer) {
+			continue
+		}
+
+		// The Ingresses in the snapshot have all been converted to extensions/v1beta1, which the
+		// apiserver may well not have any more, so we write the status back in the version that
+		// we're watching. Only the metadata and status matter to the status subresource; the
+		// apiserver keeps the spec it already has.
+		var lb map[string]interface{}
+		if err := convert(svc.Status.LoadBalancer, &lb); err != nil {
+			dlog.Errorf(ctx, "unable to set the load balancer of Ingress %s.%s: %v", ing.GetName(), ing.GetNamespace(), err)
+			continue
+		}
+		updated := &kates.Unstructured{Object: map[string]interface{}{
+			"apiVersion": sh.ingressAPIVersion,
+			"kind":       "Ingress",
+			"metadata": map[string]interface{}{
+				"name":            ing.GetName(),
+				"namespace":       ing.GetNamespace(),
+				"resourceVersion": ing.GetResourceVersion(),
+			},
+			"status": map[string]interface{}{
+				"loadBalancer": lb,
+			},
+		}}
+		sh.statusWriter.update("Ingress", ing.GetNamespace(), ing.GetName(), updated)
+	}
+}
+
+// ambassadorIngressClasses returns the names of the IngressClasses that belong to us.
+func ambassadorIngressClasses(ingressClasses []*snapshotTypes.IngressClass, envAmbID string) map[string]bool {
+	classes := make(map[string]bool)
+	for _, class := range ingressClasses {
+		if strings.ToLower(class.Spec.Controller) != ingressController {
+			continue
+		}
+		if !annotationAmbassadorID(class.GetAnnotations()).Matches(envAmbID) {
+			continue
+		}
+		classes[class.GetName()] = true
+	}
+	return classes
+}
+
+// isIngressForUs returns whether the Ingress uses one of our IngressClasses (or the old
+// `kubernetes.io/ingress.class: ambassador` annotation) and has our Ambassador ID. This has to
+// agree with the IngressProcessor in Python.
+func isIngressForUs(ctx context.Context, ing *snapshotTypes.Ingress, classes map[string]bool, envAmbID string) bool {
+	hasClass := ing.Spec.IngressClassName != nil && classes[*ing.Spec.IngressClassName]
+	hasAnnotation := strings.ToLower(ing.GetAnnotations()["kubernetes.io/ingress.class"]) == "ambassador"
+	if !hasClass && !hasAnnotation {
+		dlog.Debugf(ctx, "ignoring Ingress %s.%s without one of our IngressClasses", ing.GetName(), ing.GetNamespace())
+		return false
+	}
+	if id := annotationAmbassadorID(ing.GetAnnotations()); !id.Matches(envAmbID) {
+		dlog.Debugf(ctx, "ignoring Ingress %s.%s with ambassador-id %v", ing.GetName(), ing.GetNamespace(), id)
+		return false
+	}
+	return true
+}
+
+// ingressAPIVersion returns the apiVersion (e.g. "networking.k8s.io/v1") that we're watching
+// Ingresses in, or "" if we aren't watching them.
+func ingressAPIVersion(queries []kates.Query) string {
+	for _, q := range queries {
+		if q.Name != "Ingresses" {
+			continue
+		}
+		// The Kind is "${name}.${version}.${group}", as in interesting_types.go.
+		parts := strings.SplitN(q.Kind, ".", 3)
+		if len(parts) != 3 {
+			return ""
+		}
+		return parts[2] + "/" + parts[1]
+	}
+	return ""
+}
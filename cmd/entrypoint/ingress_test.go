@@ -0,0 +1,71 @@
+package entrypoint_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/emissary-ingress/emissary/v3/cmd/entrypoint"
+	"github.com/emissary-ingress/emissary/v3/pkg/snapshot/v1"
+)
+
+func TestFakeIngressStatus(t *testing.T) {
+	t.Setenv("AMBASSADOR_SERVICE_NAME", "ambassador")
+	f := entrypoint.RunFake(t, entrypoint.FakeConfig{}, nil)
+	// The status updates get written in the background, so let them through as soon as they
+	// happen.
+	f.AutoFlush(true)
+
+	assert.NoError(t, f.UpsertFile("testdata/FakeIngressStatus.yaml"))
+
+	lbIP := func(snap *snapshot.Snapshot, name string) string {
+		for _, ing := range snap.Kubernetes.Ingresses {
+			if ing.GetName() == name && len(ing.Status.LoadBalancer.Ingress) > 0 {
+				return ing.Status.LoadBalancer.Ingress[0].IP
+			}
+		}
+		return ""
+	}
+
+	snap, err := f.GetSnapshot(func(snap *snapshot.Snapshot) bool {
+		return lbIP(snap, "by-class") != "" && lbIP(snap, "by-annotation") != ""
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "203.0.113.10", lbIP(snap, "by-class"))
+	assert.Equal(t, "203.0.113.10", lbIP(snap, "by-annotation"))
+	assert.Equal(t, "", lbIP(snap, "not-ours"))
+
+	// When the load balancer moves, the Ingresses follow it.
+	assert.NoError(t, f.UpsertYAML(`
+---
+apiVersion: v1
+kind: Service
+metadata:
+  name: ambassador
+  namespace: default
+spec:
+  type: LoadBalancer
+  ports:
+  - name: http
+    port: 80
+    targetPort: 8080
+  selector:
+    app: ambassador
+status:
+  loadBalancer:
+    ingress:
+    - hostname: lb.example.com
+`))
+
+	snap, err = f.GetSnapshot(func(snap *snapshot.Snapshot) bool {
+		for _, ing := range snap.Kubernetes.Ingresses {
+			if ing.GetName() == "by-class" && len(ing.Status.LoadBalancer.Ingress) > 0 {
+				return ing.Status.LoadBalancer.Ingress[0].Hostname == "lb.example.com"
+			}
+		}
+		return false
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "", lbIP(snap, "not-ours"))
+}
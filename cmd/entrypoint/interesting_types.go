@@ -9,24 +9,32 @@ import (
 )
 
 // thingToWatch is... uh... a thing we're gonna watch. Specifically, it's a
-// K8s type name and an optional field selector.
+// K8s type name, an optional field selector, and whether we only need its
+// metadata.
 type thingToWatch struct {
 	typename      string
 	fieldselector string
+	metadataOnly  bool
 }
 
 type thingToMaybeWatch struct {
 	typename      string
 	fieldselector string
+	metadataOnly  bool
 	ignoreIf      bool
 }
 
 // GetQueries takes a set of interesting types, and returns a set of kates.Query to watch
 // for them.
 func GetQueries(ctx context.Context, interestingTypes map[string]thingToWatch) []kates.Query {
+	// Single-namespace mode wins over a list of namespaces, which wins over a namespace selector.
 	ns := kates.NamespaceAll
+	var namespaces []string
+	var namespaceSelector string
 	if IsAmbassadorSingleNamespace() {
 		ns = GetAmbassadorNamespace()
+	} else if namespaces = GetAmbassadorWatchNamespaces(); len(namespaces) == 0 {
+		namespaceSelector = GetAmbassadorWatchNamespaceSelector()
 	}
 
 	fs := GetAmbassadorFieldSelector()
@@ -35,11 +43,15 @@ func GetQueries(ctx context.Context, interestingTypes map[string]thingToWatch) [
 	var queries []kates.Query
 	for snapshotname, queryinfo := range interestingTypes {
 		query := kates.Query{
-			Namespace:     ns,
-			Name:          snapshotname,
-			Kind:          queryinfo.typename,
-			FieldSelector: queryinfo.fieldselector,
-			LabelSelector: ls,
+			Namespace:         ns,
+			Namespaces:        namespaces,
+			NamespaceSelector: namespaceSelector,
+			Name:              snapshotname,
+			Kind:              queryinfo.typename,
+			FieldSelector:     queryinfo.fieldselector,
+			LabelSelector:     ls,
+			MetadataOnly:      queryinfo.metadataOnly,
+			Transform:         trimFunc(snapshotname),
 		}
 		if query.FieldSelector == "" {
 			query.FieldSelector = fs
@@ -52,6 +64,37 @@ func GetQueries(ctx context.Context, interestingTypes map[string]thingToWatch) [
 	return queries
 }
 
+// keepStatusOf lists the snapshot fields whose status anything actually looks at. Everything else
+// has its status thrown away as soon as it arrives.
+var keepStatusOf = map[string]bool{
+	"Services":                true, // We copy the load balancer of our own Service to Ingresses.
+	"Ingresses":               true, // We check whether their status needs updating.
+	"KNativeIngresses":        true, // Likewise.
+	"KNativeClusterIngresses": true, // Likewise.
+	"Hosts":                   true, // Hosts keep their ACME state here.
+}
+
+// trimFunc returns the kates.Query Transform for a snapshot field. It throws away the parts of
+// resources that the apiserver adds for its own purposes (managedFields, and the copy of the whole
+// resource in the last-applied-configuration annotation), along with any status that we don't
+// need, so that they take up neither memory nor room in every snapshot we send to diagd.
+func trimFunc(snapshotname string) func(*kates.Unstructured) {
+	keepStatus := keepStatusOf[snapshotname]
+	return func(un *kates.Unstructured) {
+		un.SetManagedFields(nil)
+
+		annotations := un.GetAnnotations()
+		if _, ok := annotations["kubectl.kubernetes.io/last-applied-configuration"]; ok {
+			delete(annotations, "kubectl.kubernetes.io/last-applied-configuration")
+			un.SetAnnotations(annotations)
+		}
+
+		if !keepStatus {
+			delete(un.Object, "status")
+		}
+	}
+}
+
 // GetInterestingTypes takes a list of available server types, and returns the types we think
 // are interesting to watch.
 func GetInterestingTypes(ctx context.Context, serverTypeList []kates.APIResource) map[string]thingToWatch {
@@ -80,15 +123,24 @@ func GetInterestingTypes(ctx context.Context, serverTypeList []kates.APIResource
 		//
 		// Note that we pull `secrets.v1.` in to "K8sSecrets".  ReconcileSecrets will pull
 		// over the ones we need into "Secrets" and "Endpoints" respectively.
+		//
+		// Most Secrets in a cluster are of no interest to us (Helm releases, service account
+		// tokens, ...), so we only watch their metadata, and ReconcileSecrets fetches the
+		// contents of the ones that we actually use.
 		"Services":   {{typename: "services.v1."}},                             // New in Kubernetes 0.16.0 (2015-04-28) (v1beta{1..3} before that)
 		"Endpoints":  {{typename: "endpoints.v1.", fieldselector: endpointFs}}, // New in Kubernetes 0.16.0 (2015-04-28) (v1beta{1..3} before that)
-		"K8sSecrets": {{typename: "secrets.v1."}},                              // New in Kubernetes 0.16.0 (2015-04-28) (v1beta{1..3} before that)
+		"K8sSecrets": {{typename: "secrets.v1.", metadataOnly: true}},          // New in Kubernetes 0.16.0 (2015-04-28) (v1beta{1..3} before that)
 		"ConfigMaps": {{typename: "configmaps.v1.", fieldselector: configMapFs}},
 		"Ingresses": {
 			{typename: "ingresses.v1beta1.extensions"},        // New in Kubernetes 1.2.0 (2016-03-16), gone in Kubernetes 1.22.0 (2021-08-04)
 			{typename: "ingresses.v1beta1.networking.k8s.io"}, // New in Kubernetes 1.14.0 (2019-03-25), gone in Kubernetes 1.22.0 (2021-08-04)
 			{typename: "ingresses.v1.networking.k8s.io"},      // New in Kubernetes 1.19.0 (2020-08-26)
 		},
+		// Deployments and Pods are only of interest for their getambassador.io/config
+		// annotations, so (like Secrets) we only watch their metadata. There are a lot of them
+		// in a busy cluster, so this is opt-in.
+		"Deployments": {{typename: "deployments.v1.apps", metadataOnly: true, ignoreIf: !IsWorkloadAnnotationsEnabled()}}, // New in Kubernetes 1.9.0 (2017-12-15)
+		"Pods":        {{typename: "pods.v1.", metadataOnly: true, ignoreIf: !IsWorkloadAnnotationsEnabled()}},            // New in Kubernetes 0.16.0 (2015-04-28) (v1beta{1..3} before that)
 		"IngressClasses": {
 			{typename: "ingressclasses.v1beta1.networking.k8s.io", ignoreIf: IsAmbassadorSingleNamespace()}, // New in Kubernetes 1.18.0 (2020-03-25), gone in Kubernetes 1.22.0 (2021-08-04)
 			{typename: "ingressclasses.v1.networking.k8s.io", ignoreIf: IsAmbassadorSingleNamespace()},      // New in Kubernetes 1.19.0 (2020-08-26)
@@ -123,6 +175,7 @@ func GetInterestingTypes(ctx context.Context, serverTypeList []kates.APIResource
 		"AuthServices":                {{typename: "authservices.v3alpha1.getambassador.io"}},
 		"ConsulResolvers":             {{typename: "consulresolvers.v3alpha1.getambassador.io"}},
 		"DevPortals":                  {{typename: "devportals.v3alpha1.getambassador.io"}},
+		"DNSResolvers":                {{typename: "dnsresolvers.v3alpha1.getambassador.io"}},
 		"Hosts":                       {{typename: "hosts.v3alpha1.getambassador.io"}},
 		"KubernetesEndpointResolvers": {{typename: "kubernetesendpointresolvers.v3alpha1.getambassador.io"}},
 		"KubernetesServiceResolvers":  {{typename: "kubernetesserviceresolvers.v3alpha1.getambassador.io"}},
@@ -131,6 +184,7 @@ func GetInterestingTypes(ctx context.Context, serverTypeList []kates.APIResource
 		"Mappings":                    {{typename: "mappings.v3alpha1.getambassador.io"}},
 		"Modules":                     {{typename: "modules.v3alpha1.getambassador.io"}},
 		"RateLimitServices":           {{typename: "ratelimitservices.v3alpha1.getambassador.io"}},
+		"StaticEndpointResolvers":     {{typename: "staticendpointresolvers.v3alpha1.getambassador.io"}},
 		"TCPMappings":                 {{typename: "tcpmappings.v3alpha1.getambassador.io"}},
 		"TLSContexts":                 {{typename: "tlscontexts.v3alpha1.getambassador.io"}},
 		"TracingServices":             {{typename: "tracingservices.v3alpha1.getambassador.io"}},
@@ -151,7 +205,7 @@ func GetInterestingTypes(ctx context.Context, serverTypeList []kates.APIResource
 			if queryinfo.ignoreIf {
 				continue
 			}
-			last = thingToWatch{queryinfo.typename, queryinfo.fieldselector}
+			last = thingToWatch{queryinfo.typename, queryinfo.fieldselector, queryinfo.metadataOnly}
 			if _, haveType := serverTypes[queryinfo.typename]; haveType || serverTypes == nil {
 				ret[k] = last
 			}
@@ -0,0 +1,101 @@
+package entrypoint
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/emissary-ingress/emissary/v3/pkg/kates"
+	"github.com/emissary-ingress/emissary/v3/pkg/snapshot/v1"
+)
+
+func TestTrimFunc(t *testing.T) {
+	objs, err := kates.ParseManifests(`
+---
+apiVersion: getambassador.io/v3alpha1
+kind: Mapping
+metadata:
+  name: quote
+  namespace: default
+  annotations:
+    kubectl.kubernetes.io/last-applied-configuration: |
+      {"apiVersion":"getambassador.io/v3alpha1","kind":"Mapping","spec":{"prefix":"/quote/"}}
+    example.com/keep: "yes"
+  managedFields:
+  - manager: kubectl
+    operation: Update
+spec:
+  hostname: "*"
+  prefix: /quote/
+  service: quote
+status:
+  state: Running
+`)
+	require.NoError(t, err)
+	require.Len(t, objs, 1)
+
+	var mapping *kates.Unstructured
+	require.NoError(t, convert(objs[0], &mapping))
+	kept := mapping.DeepCopy()
+
+	trimFunc("Mappings")(mapping)
+	assert.Empty(t, mapping.GetManagedFields())
+	assert.Equal(t, map[string]string{"example.com/keep": "yes"}, mapping.GetAnnotations())
+	assert.NotContains(t, mapping.Object, "status")
+	assert.Equal(t, "/quote/", mapping.Object["spec"].(map[string]interface{})["prefix"])
+	assert.Equal(t, "quote", mapping.GetName())
+
+	// Some things keep their status.
+	trimFunc("Services")(kept)
+	assert.Empty(t, kept.GetManagedFields())
+	assert.Contains(t, kept.Object, "status")
+}
+
+func TestFilterWorkloadDeltas(t *testing.T) {
+	pod := func(name, config string, labels map[string]string) *kates.Pod {
+		p := &kates.Pod{
+			TypeMeta:   kates.TypeMeta{APIVersion: "v1", Kind: "Pod"},
+			ObjectMeta: kates.ObjectMeta{Name: name, Namespace: "default", Labels: labels},
+		}
+		if config != "" {
+			p.Annotations = map[string]string{snapshot.AnnotationConfigKey: config}
+		}
+		return p
+	}
+	delta := func(deltaType kates.DeltaType, kind, name string) *kates.Delta {
+		return &kates.Delta{
+			TypeMeta:   kates.TypeMeta{Kind: kind},
+			ObjectMeta: kates.ObjectMeta{Name: name, Namespace: "default"},
+			DeltaType:  deltaType,
+		}
+	}
+	sh := &SnapshotHolder{
+		k8sSnapshot:         NewKubernetesSnapshot(),
+		workloadAnnotations: map[objectKey]string{},
+	}
+
+	// A Pod with an annotation is news, and so is everything that isn't a Pod or Deployment...
+	sh.k8sSnapshot.Pods = []*kates.Pod{pod("web", "mapping", nil), pod("plain", "", nil)}
+	deltas := []*kates.Delta{
+		delta(kates.ObjectAdd, "Pod", "web"),
+		delta(kates.ObjectAdd, "Pod", "plain"),
+		delta(kates.ObjectAdd, "Mapping", "quote"),
+	}
+	assert.Equal(t, []*kates.Delta{deltas[0], deltas[2]}, sh.filterWorkloadDeltas(deltas))
+
+	// ...but any other change to it isn't...
+	sh.k8sSnapshot.Pods = []*kates.Pod{pod("web", "mapping", map[string]string{"ready": "true"}), pod("plain", "", nil)}
+	assert.Empty(t, sh.filterWorkloadDeltas([]*kates.Delta{delta(kates.ObjectUpdate, "Pod", "web")}))
+
+	// ...until the annotation changes, or the Pod goes away.
+	sh.k8sSnapshot.Pods = []*kates.Pod{pod("web", "other mapping", nil), pod("plain", "", nil)}
+	assert.Len(t, sh.filterWorkloadDeltas([]*kates.Delta{delta(kates.ObjectUpdate, "Pod", "web")}), 1)
+
+	sh.k8sSnapshot.Pods = []*kates.Pod{pod("plain", "", nil)}
+	assert.Len(t, sh.filterWorkloadDeltas([]*kates.Delta{
+		delta(kates.ObjectDelete, "Pod", "web"),
+		delta(kates.ObjectDelete, "Deployment", "plain"),
+	}), 1)
+	assert.Empty(t, sh.workloadAnnotations)
+}
@@ -0,0 +1,110 @@
+package entrypoint
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/datawire/dlib/dlog"
+
+	"github.com/emissary-ingress/emissary/v3/pkg/kates"
+	snapshotTypes "github.com/emissary-ingress/emissary/v3/pkg/snapshot/v1"
+)
+
+// ReconcileKnativeIngresses drops the Knative Ingresses that aren't meant for us from the
+// snapshot, and queues status updates for the ones that are, so that Knative Serving knows they're
+// ready and where to find them.
+func ReconcileKnativeIngresses(ctx context.Context, sh *SnapshotHolder) {
+	if len(sh.k8sSnapshot.KNativeIngresses) == 0 {
+		return
+	}
+
+	// Knative hands the load balancer domain to an ExternalName Service for in-cluster traffic.
+	// Without it, that traffic will go out through whatever DNS name the Knative Service was
+	// configured with, and back in through an out-of-cluster load balancer.
+	//
+	// TODO: It is technically possible to use a domain other than cluster.local (common-ish on
+	// bare metal clusters), but Python has the same problem, and it should probably get fixed
+	// everywhere at once.
+	lbDomain := ""
+	if svc := findAmbassadorService(sh.k8sSnapshot.Services, GetAmbassadorServiceName(), sh.podLabels); svc != nil {
+		lbDomain = fmt.Sprintf("%s.%s.svc.cluster.local", svc.GetName(), svc.GetNamespace())
+	} else {
+		dlog.Warnf(ctx, "unable to set the load balancer of Knative Ingresses: could not find the Ambassador Service")
+	}
+
+	envAmbID := GetAmbassadorID()
+	now := time.Now()
+
+	var ours []*snapshotTypes.KNativeIngress
+	for _, ing := range sh.k8sSnapshot.KNativeIngresses {
+		if !isKnativeIngressForUs(ctx, ing, envAmbID) {
+			continue
+		}
+		ours = append(ours, ing)
+
+		if status, changed := knativeIngressStatus(ing, lbDomain, now); changed {
+			updated := *ing
+			updated.Status = status
+			sh.statusWriter.update("Ingress.networking.internal.knative.dev", ing.GetNamespace(), ing.GetName(), &updated)
+		}
+	}
+	sh.k8sSnapshot.KNativeIngresses = ours
+}
+
+// isKnativeIngressForUs returns whether the Ingress has our ingress class (or none at all) and our
+// Ambassador ID.
+func isKnativeIngressForUs(ctx context.Context, ing *snapshotTypes.KNativeIngress, envAmbID string) bool {
+	if class := ing.IngressClass(); class != "" && strings.ToLower(class) != snapshotTypes.KNativeIngressClass {
+		dlog.Debugf(ctx, "ignoring Knative Ingress %s.%s with ingress class %q", ing.GetName(), ing.GetNamespace(), class)
+		return false
+	}
+
+	if id := annotationAmbassadorID(ing.GetAnnotations()); !id.Matches(envAmbID) {
+		dlog.Debugf(ctx, "ignoring Knative Ingress %s.%s with ambassador-id %v", ing.GetName(), ing.GetNamespace(), id)
+		return false
+	}
+
+	return true
+}
+
+// knativeIngressStatus returns the status that the Ingress should have, and whether that differs
+// from the status it has now. Only the generation and the load balancer are compared, so that the
+// transition times don't change every time we look.
+func knativeIngressStatus(ing *snapshotTypes.KNativeIngress, lbDomain string, now time.Time) (snapshotTypes.KNativeIngressStatus, bool) {
+	current := ing.Status
+
+	currentDomain := ""
+	if current.PrivateLoadBalancer != nil && len(current.PrivateLoadBalancer.Ingress) > 0 {
+		currentDomain = current.PrivateLoadBalancer.Ingress[0].DomainInternal
+	}
+	ready := false
+	for _, cond := range current.Conditions {
+		if cond.Type == "Ready" && cond.Status == string(kates.CoreConditionTrue) {
+			ready = true
+		}
+	}
+	if current.ObservedGeneration >= ing.GetGeneration() && currentDomain == lbDomain && ready {
+		return current, false
+	}
+
+	transition := kates.Time{Time: now}
+	status := snapshotTypes.KNativeIngressStatus{
+		ObservedGeneration: ing.GetGeneration(),
+		Conditions: []snapshotTypes.KNativeCondition{
+			{Type: "LoadBalancerReady", Status: "True", LastTransitionTime: transition},
+			{Type: "NetworkConfigured", Status: "True", LastTransitionTime: transition},
+			{Type: "Ready", Status: "True", LastTransitionTime: transition},
+		},
+	}
+	if lbDomain != "" {
+		lb := &snapshotTypes.KNativeLoadBalancerStatus{
+			Ingress: []snapshotTypes.KNativeLoadBalancerIngressStatus{{DomainInternal: lbDomain}},
+		}
+		status.LoadBalancer = lb
+		status.PublicLoadBalancer = lb
+		status.PrivateLoadBalancer = lb
+	}
+	return status, true
+}
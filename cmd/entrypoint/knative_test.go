@@ -0,0 +1,143 @@
+package entrypoint
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/datawire/dlib/dgroup"
+	"github.com/datawire/dlib/dlog"
+
+	"github.com/emissary-ingress/emissary/v3/pkg/kates"
+	snapshotTypes "github.com/emissary-ingress/emissary/v3/pkg/snapshot/v1"
+)
+
+type recordingStatusUpdater struct {
+	updates chan interface{}
+}
+
+func (r *recordingStatusUpdater) UpdateStatus(_ context.Context, resource interface{}, _ interface{}) error {
+	r.updates <- resource
+	return nil
+}
+
+func setupStatusWriter(t *testing.T) (*statusWriter, chan interface{}) {
+	ctx, cancel := context.WithCancel(dlog.NewTestContext(t, false))
+	grp := dgroup.NewGroup(ctx, dgroup.GroupConfig{})
+	t.Cleanup(func() {
+		cancel()
+		assert.NoError(t, grp.Wait())
+	})
+
+	updater := &recordingStatusUpdater{updates: make(chan interface{}, 10)}
+	w := newStatusWriter(updater)
+	grp.Go("status", w.run)
+	return w, updater.updates
+}
+
+func knativeIngress(name string, generation int64, annotations map[string]string) *snapshotTypes.KNativeIngress {
+	return &snapshotTypes.KNativeIngress{
+		TypeMeta: kates.TypeMeta{
+			APIVersion: "networking.internal.knative.dev/v1alpha1",
+			Kind:       "Ingress",
+		},
+		ObjectMeta: kates.ObjectMeta{
+			Name:        name,
+			Namespace:   "default",
+			Generation:  generation,
+			Annotations: annotations,
+		},
+	}
+}
+
+func TestReconcileKnativeIngresses(t *testing.T) {
+	w, updates := setupStatusWriter(t)
+
+	upToDate := knativeIngress("up-to-date", 3, nil)
+	upToDate.Status = snapshotTypes.KNativeIngressStatus{
+		ObservedGeneration: 3,
+		Conditions:         []snapshotTypes.KNativeCondition{{Type: "Ready", Status: "True"}},
+		PrivateLoadBalancer: &snapshotTypes.KNativeLoadBalancerStatus{
+			Ingress: []snapshotTypes.KNativeLoadBalancerIngressStatus{{DomainInternal: "ambassador.default.svc.cluster.local"}},
+		},
+	}
+
+	sh := &SnapshotHolder{
+		k8sSnapshot:  NewKubernetesSnapshot(),
+		statusWriter: w,
+		podLabels:    map[string]string{"app": "ambassador", "pod-template-hash": "abc123"},
+	}
+	sh.k8sSnapshot.Services = []*kates.Service{
+		{
+			ObjectMeta: kates.ObjectMeta{Name: "not-ours", Namespace: "default"},
+			Spec:       kates.ServiceSpec{Selector: map[string]string{"app": "ambassador"}},
+		},
+		{
+			ObjectMeta: kates.ObjectMeta{
+				Name:      "ambassador",
+				Namespace: "default",
+				Labels:    map[string]string{"app.kubernetes.io/component": "ambassador-service"},
+			},
+			Spec: kates.ServiceSpec{Selector: map[string]string{"app": "ambassador"}},
+		},
+	}
+	sh.k8sSnapshot.KNativeIngresses = []*snapshotTypes.KNativeIngress{
+		knativeIngress("no-class", 1, nil),
+		knativeIngress("our-class", 2, map[string]string{
+			snapshotTypes.KNativeIngressClassAnnotationAlt: "Ambassador.Ingress.Networking.Knative.Dev",
+		}),
+		knativeIngress("other-class", 1, map[string]string{
+			snapshotTypes.KNativeIngressClassAnnotation: "kourier.ingress.networking.knative.dev",
+		}),
+		knativeIngress("other-id", 1, map[string]string{
+			"getambassador.io/ambassador-id": "other",
+		}),
+		upToDate,
+	}
+
+	ReconcileKnativeIngresses(dlog.NewTestContext(t, false), sh)
+
+	var names []string
+	for _, ing := range sh.k8sSnapshot.KNativeIngresses {
+		names = append(names, ing.GetName())
+	}
+	assert.Equal(t, []string{"no-class", "our-class", "up-to-date"}, names)
+
+	written := map[string]*snapshotTypes.KNativeIngress{}
+	for len(written) < 2 {
+		select {
+		case resource := <-updates:
+			ing, ok := resource.(*snapshotTypes.KNativeIngress)
+			require.True(t, ok)
+			written[ing.GetName()] = ing
+		case <-time.After(10 * time.Second):
+			t.Fatal("timed out waiting for status updates")
+		}
+	}
+	select {
+	case resource := <-updates:
+		t.Fatalf("unexpected status update: %v", resource)
+	case <-time.After(10 * time.Millisecond):
+	}
+
+	require.Contains(t, written, "our-class")
+	status := written["our-class"].Status
+	assert.Equal(t, int64(2), status.ObservedGeneration)
+	assert.Len(t, status.Conditions, 3)
+	for _, lb := range []*snapshotTypes.KNativeLoadBalancerStatus{status.LoadBalancer, status.PublicLoadBalancer, status.PrivateLoadBalancer} {
+		require.NotNil(t, lb)
+		assert.Equal(t, "ambassador.default.svc.cluster.local", lb.Ingress[0].DomainInternal)
+	}
+
+	// The snapshot itself is left alone; the new status only shows up once the apiserver has it.
+	assert.Empty(t, sh.k8sSnapshot.KNativeIngresses[1].Status.Conditions)
+
+	// Once the status has been written, there's nothing more to do.
+	_, changed := knativeIngressStatus(written["no-class"], "ambassador.default.svc.cluster.local", time.Now())
+	assert.False(t, changed)
+	_, changed = knativeIngressStatus(written["no-class"], "other.default.svc.cluster.local", time.Now())
+	assert.True(t, changed)
+}
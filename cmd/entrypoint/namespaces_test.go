@@ -0,0 +1,121 @@
+package entrypoint_test
+
+import (
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/emissary-ingress/emissary/v3/cmd/entrypoint"
+	"github.com/emissary-ingress/emissary/v3/pkg/snapshot/v1"
+)
+
+const namespacedMappings = `
+---
+apiVersion: getambassador.io/v3alpha1
+kind: Mapping
+metadata:
+  name: quote
+  namespace: team-a
+spec:
+  hostname: "*"
+  prefix: /team-a/
+  service: quote.team-a
+---
+apiVersion: getambassador.io/v3alpha1
+kind: Mapping
+metadata:
+  name: quote
+  namespace: team-b
+spec:
+  hostname: "*"
+  prefix: /team-b/
+  service: quote.team-b
+---
+apiVersion: getambassador.io/v3alpha1
+kind: Mapping
+metadata:
+  name: quote
+  namespace: team-c
+spec:
+  hostname: "*"
+  prefix: /team-c/
+  service: quote.team-c
+`
+
+func mappingNamespaces(snap *snapshot.Snapshot) []string {
+	var namespaces []string
+	for _, m := range snap.Kubernetes.Mappings {
+		namespaces = append(namespaces, m.GetNamespace())
+	}
+	sort.Strings(namespaces)
+	return namespaces
+}
+
+func TestFakeWatchNamespaces(t *testing.T) {
+	t.Setenv("AMBASSADOR_WATCH_NAMESPACES", "team-a, team-b")
+	f := entrypoint.RunFake(t, entrypoint.FakeConfig{}, nil)
+
+	assert.NoError(t, f.UpsertYAML(namespacedMappings))
+	f.Flush()
+
+	snap, err := f.GetSnapshot(func(snap *snapshot.Snapshot) bool {
+		return len(snap.Kubernetes.Mappings) > 0
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"team-a", "team-b"}, mappingNamespaces(snap))
+}
+
+func TestFakeWatchNamespaceSelector(t *testing.T) {
+	t.Setenv("AMBASSADOR_WATCH_NAMESPACE_SELECTOR", "emissary=yes")
+	f := entrypoint.RunFake(t, entrypoint.FakeConfig{}, nil)
+
+	assert.NoError(t, f.UpsertYAML(`
+---
+apiVersion: v1
+kind: Namespace
+metadata:
+  name: team-a
+  labels:
+    emissary: "yes"
+---
+apiVersion: v1
+kind: Namespace
+metadata:
+  name: team-b
+---
+apiVersion: v1
+kind: Namespace
+metadata:
+  name: team-c
+  labels:
+    emissary: "no"
+`))
+	assert.NoError(t, f.UpsertYAML(namespacedMappings))
+	f.Flush()
+
+	snap, err := f.GetSnapshot(func(snap *snapshot.Snapshot) bool {
+		return len(snap.Kubernetes.Mappings) > 0
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"team-a"}, mappingNamespaces(snap))
+
+	// Labeling a namespace brings in its resources.
+	assert.NoError(t, f.UpsertYAML(`
+---
+apiVersion: v1
+kind: Namespace
+metadata:
+  name: team-b
+  labels:
+    emissary: "yes"
+`))
+	f.Flush()
+
+	snap, err = f.GetSnapshot(func(snap *snapshot.Snapshot) bool {
+		return len(snap.Kubernetes.Mappings) == 2
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"team-a", "team-b"}, mappingNamespaces(snap))
+}
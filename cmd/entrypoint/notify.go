@@ -4,14 +4,21 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"net/http"
 	"net/url"
+	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+
 	"github.com/emissary-ingress/emissary/v3/pkg/acp"
 	"github.com/emissary-ingress/emissary/v3/pkg/debug"
+	"github.com/emissary-ingress/emissary/v3/pkg/tracing"
 
 	"github.com/datawire/dlib/dlog"
 )
@@ -26,6 +33,48 @@ type noopNotable struct{}
 func (_ *noopNotable) NoteSnapshotSent()      {}
 func (_ *noopNotable) NoteSnapshotProcessed() {}
 
+// A diagdNotifier tells diagd (and the Edge Stack sidecar) about snapshots, one at a time. The
+// watcher does this whenever there's a new snapshot, but a diagd that has just been restarted
+// knows nothing, so it has to be told about the current snapshot again, too.
+type diagdNotifier struct {
+	mu       sync.Mutex
+	ambwatch notable
+
+	// The snapshot that the snapshot server is serving, if there is one yet.
+	encoded *atomic.Value
+
+	// How to notify diagd; this is notifyReconfigWebhooks, except for testing.
+	send func(ctx context.Context, ambwatch notable) error
+}
+
+func newDiagdNotifier(ambwatch notable, encoded *atomic.Value) *diagdNotifier {
+	return &diagdNotifier{
+		ambwatch: ambwatch,
+		encoded:  encoded,
+		send:     notifyReconfigWebhooks,
+	}
+}
+
+// notify tells diagd that there's a new snapshot.
+func (n *diagdNotifier) notify(ctx context.Context) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	return n.send(ctx, n.ambwatch)
+}
+
+// resend tells diagd about the current snapshot again, if there is one. If there isn't, the
+// watcher will tell diagd about the first one when it's ready.
+func (n *diagdNotifier) resend(ctx context.Context) {
+	if n.encoded.Load() == nil {
+		return
+	}
+	dlog.Infof(ctx, "sending the current snapshot to diagd again")
+	if err := n.notify(ctx); err != nil {
+		dlog.Errorf(ctx, "error sending the current snapshot to diagd again: %v", err)
+	}
+}
+
 func notifyReconfigWebhooks(ctx context.Context, ambwatch notable) error {
 	isEdgeStack, err := IsEdgeStack()
 	if err != nil {
@@ -90,6 +139,8 @@ func notifyReconfigWebhooksFunc(ctx context.Context, ambwatch notable, edgestack
 // posts to a webhook style url, logging any errors, and returning false if a retry is needed
 func notifyWebhookUrl(ctx context.Context, name, xurl string) (bool, error) {
 	defer debug.FromContext(ctx).Timer(fmt.Sprintf("notifyWebhook:%s", name)).Start()()
+	ctx, span := tracing.Start(ctx, "notifyWebhook", trace.WithAttributes(attribute.String("ambassador.webhook", name)))
+	defer span.End()
 
 	req, err := http.NewRequestWithContext(ctx, http.MethodPost, xurl, nil)
 	if err != nil {
@@ -116,6 +167,8 @@ func notifyWebhookUrl(ctx context.Context, name, xurl string) (bool, error) {
 	}
 
 	req.Header.Set("content-type", "application/json")
+	// Pass the trace along, so that whatever the webhook does is part of this reconfiguration.
+	tracing.InjectHTTP(ctx, req.Header)
 	resp, err := http.DefaultClient.Do(req)
 	if err != nil {
 		if errors.Is(err, syscall.ECONNREFUSED) {
@@ -123,11 +176,14 @@ func notifyWebhookUrl(ctx context.Context, name, xurl string) (bool, error) {
 			// started up yet, so we log the error and return false to signal retry.
 			dlog.Error(ctx, err.Error())
 			return false, nil
+		} else if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, syscall.ECONNRESET) {
+			// The sidecar went away in the middle of the request. The known case for this is the
+			// diagd gunicorn worker getting OOMKilled. The supervisor restarts the sidecars (and
+			// gives up on the whole pod if a required one keeps dying), so all we need to do is
+			// retry, just as if it hadn't started up yet.
+			dlog.Error(ctx, err.Error())
+			return false, nil
 		} else {
-			// If either of the sidecars cannot successfully handle a webhook request, we
-			// deliberately consider it a fatal error so that we can ensure shared fate between all
-			// ambassador processes. The only known case where this occurs so far is when the diagd
-			// gunicorn worker gets OOMKilled. This results in an EOF and we end up here.
 			return false, err
 		}
 	}
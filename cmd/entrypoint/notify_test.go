@@ -1,13 +1,19 @@
 package entrypoint
 
 import (
+	"context"
 	"net/http"
 	"net/http/httptest"
+	"sync/atomic"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
 
 	"github.com/datawire/dlib/dlog"
+	"github.com/emissary-ingress/emissary/v3/pkg/tracing"
 )
 
 // Check if we return false when we get a connection refused.
@@ -19,7 +25,8 @@ func TestNotifyWebhookUrlConnectionRefused(t *testing.T) {
 	assert.False(t, finished)
 }
 
-// Check that we panic if we do not get a properly formed http response of some kind such as an EOF.
+// Check that we return false, so as to retry, if the webhook drops the connection in the middle of
+// the request, as diagd does when its worker gets OOMKilled.
 func TestNotifyWebhookUrlEOF(t *testing.T) {
 	ctx := dlog.NewTestContext(t, false)
 
@@ -28,7 +35,79 @@ func TestNotifyWebhookUrlEOF(t *testing.T) {
 		// We want to generate an EOF for the connected client. This seems to do that.
 		srv.CloseClientConnections()
 	}))
+	defer srv.Close()
 
-	_, err := notifyWebhookUrl(ctx, "test", srv.URL)
-	assert.Error(t, err)
+	finished, err := notifyWebhookUrl(ctx, "test", srv.URL)
+	assert.NoError(t, err)
+	assert.False(t, finished)
+}
+
+// Check that a reconfiguration survives diagd dropping the connection, and gets through once it's
+// back.
+func TestNotifyReconfigWebhooksDiagdRestart(t *testing.T) {
+	ctx := dlog.NewTestContext(t, false)
+
+	var requests int32
+	var srv *httptest.Server
+	srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&requests, 1) == 1 {
+			srv.CloseClientConnections()
+		}
+	}))
+	defer srv.Close()
+	t.Setenv("DEV_AMBASSADOR_EVENT_HOST", srv.URL)
+
+	require.NoError(t, notifyReconfigWebhooksFunc(ctx, &noopNotable{}, false))
+	assert.Equal(t, int32(2), atomic.LoadInt32(&requests))
+}
+
+// Check that the webhook gets the trace context, so that it can continue the trace.
+func TestNotifyWebhookUrlTraceContext(t *testing.T) {
+	ctx := dlog.NewTestContext(t, false)
+
+	prev := otel.GetTracerProvider()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider())
+	defer otel.SetTracerProvider(prev)
+	_, err := tracing.Setup(ctx, "none", "test", "v0")
+	require.NoError(t, err)
+
+	var traceparent string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		traceparent = r.Header.Get("traceparent")
+	}))
+	defer srv.Close()
+
+	ctx, span := tracing.Start(ctx, "test")
+	defer span.End()
+	finished, err := notifyWebhookUrl(ctx, "test", srv.URL)
+	require.NoError(t, err)
+	assert.True(t, finished)
+	assert.Contains(t, traceparent, span.SpanContext().TraceID().String())
+
+}
+
+func TestDiagdNotifierResend(t *testing.T) {
+	ctx := dlog.NewTestContext(t, false)
+
+	sent := 0
+	encoded := &atomic.Value{}
+	notifier := newDiagdNotifier(&noopNotable{}, encoded)
+	notifier.send = func(ctx context.Context, ambwatch notable) error {
+		sent++
+		return nil
+	}
+
+	// Before there's a snapshot, there's nothing to send again.
+	notifier.resend(ctx)
+	assert.Equal(t, 0, sent)
+
+	enc, err := encodeSnapshot(makeLargeSnapshot(1), 1, 0)
+	require.NoError(t, err)
+	encoded.Store(enc)
+	require.NoError(t, notifier.notify(ctx))
+	assert.Equal(t, 1, sent)
+
+	// After that, the current snapshot is sent again.
+	notifier.resend(ctx)
+	assert.Equal(t, 2, sent)
 }
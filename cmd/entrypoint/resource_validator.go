@@ -2,14 +2,20 @@ package entrypoint
 
 import (
 	"context"
+	"fmt"
 
 	"github.com/datawire/dlib/dlog"
 	getambassadorio "github.com/emissary-ingress/emissary/v3/pkg/api/getambassador.io"
 	"github.com/emissary-ingress/emissary/v3/pkg/kates"
+	snapshotTypes "github.com/emissary-ingress/emissary/v3/pkg/snapshot/v1"
 )
 
 type resourceValidator struct {
-	invalid        map[string]*kates.Unstructured
+	invalid map[string]*kates.Unstructured
+	// The last valid version of each resource, by kind, namespace and name, so that CEL
+	// transition rules (the ones that refer to oldSelf) can be checked against it, as the API
+	// server would.
+	valid          map[string]*kates.Unstructured
 	katesValidator *kates.Validator
 }
 
@@ -17,11 +23,23 @@ func newResourceValidator() (*resourceValidator, error) {
 	return &resourceValidator{
 		katesValidator: getambassadorio.NewValidator(),
 		invalid:        map[string]*kates.Unstructured{},
+		valid:          map[string]*kates.Unstructured{},
 	}, nil
 }
 
 func (v *resourceValidator) isValid(ctx context.Context, un *kates.Unstructured) bool {
-	err := v.katesValidator.Validate(ctx, un)
+	key := validKey(un.GetKind(), un.GetNamespace(), un.GetName())
+
+	var err error
+	if un.GroupVersionKind().Group == "getambassador.io" && snapshotTypes.AnnotationOrigin(un) != "" {
+		// The watcher uses this annotation to tell the resources that it unfolded from
+		// getambassador.io/config annotations from the real ones, so a real one mustn't have it.
+		err = fmt.Errorf("the %s annotation is reserved for resources from getambassador.io/config annotations", snapshotTypes.AnnotationOriginKey)
+	} else if old, ok := v.valid[key]; ok {
+		err = v.katesValidator.ValidateUpdate(ctx, old, un)
+	} else {
+		err = v.katesValidator.Validate(ctx, un)
+	}
 
 	if err != nil {
 		dlog.Errorf(ctx, "validation error: %s %s/%s -- %s", un.GetKind(), un.GetNamespace(), un.GetName(), err.Error())
@@ -29,10 +47,21 @@ func (v *resourceValidator) isValid(ctx context.Context, un *kates.Unstructured)
 		return false
 	} else {
 		v.removeInvalid(ctx, un)
+		v.valid[key] = un
 		return true
 	}
 }
 
+// The forget method drops the last valid version of a resource that has
+// been deleted, so that it starts over if it's created again.
+func (v *resourceValidator) forget(ctx context.Context, delta *kates.Delta) {
+	delete(v.valid, validKey(delta.Kind, delta.GetNamespace(), delta.GetName()))
+}
+
+func validKey(kind, namespace, name string) string {
+	return kind + ":" + namespace + "/" + name
+}
+
 func (v *resourceValidator) getInvalid() []*kates.Unstructured {
 	var result []*kates.Unstructured
 	for _, inv := range v.invalid {
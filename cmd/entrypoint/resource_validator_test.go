@@ -0,0 +1,117 @@
+package entrypoint
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/datawire/dlib/dlog"
+	"github.com/emissary-ingress/emissary/v3/pkg/kates"
+	snapshotTypes "github.com/emissary-ingress/emissary/v3/pkg/snapshot/v1"
+)
+
+const immutableModeCRD = `
+apiVersion: apiextensions.k8s.io/v1
+kind: CustomResourceDefinition
+metadata:
+  name: widgets.test.io
+spec:
+  group: test.io
+  names:
+    kind: Widget
+    plural: widgets
+    singular: widget
+  scope: Namespaced
+  versions:
+  - name: v1
+    served: true
+    storage: true
+    schema:
+      openAPIV3Schema:
+        type: object
+        properties:
+          apiVersion:
+            type: string
+          kind:
+            type: string
+          metadata:
+            type: object
+          spec:
+            type: object
+            properties:
+              mode:
+                type: string
+                x-kubernetes-validations:
+                - rule: self == oldSelf
+                  message: mode is immutable
+`
+
+func TestResourceValidatorTransitionRules(t *testing.T) {
+	ctx := dlog.NewTestContext(t, false)
+
+	crds, err := kates.ParseManifestsToUnstructured(immutableModeCRD)
+	require.NoError(t, err)
+	katesValidator, err := kates.NewValidator(nil, crds)
+	require.NoError(t, err)
+	v := &resourceValidator{
+		katesValidator: katesValidator,
+		invalid:        map[string]*kates.Unstructured{},
+		valid:          map[string]*kates.Unstructured{},
+	}
+
+	widget := func(mode string) *kates.Unstructured {
+		return &kates.Unstructured{Object: map[string]interface{}{
+			"apiVersion": "test.io/v1",
+			"kind":       "Widget",
+			"metadata":   map[string]interface{}{"name": "w", "namespace": "default", "uid": "1234"},
+			"spec":       map[string]interface{}{"mode": mode},
+		}}
+	}
+
+	// The first version of a resource has nothing to compare against...
+	assert.True(t, v.isValid(ctx, widget("fixed")))
+	assert.True(t, v.isValid(ctx, widget("fixed")))
+
+	// ...but later versions are checked against the last valid one.
+	assert.False(t, v.isValid(ctx, widget("auto")))
+	require.Len(t, v.getInvalid(), 1)
+	assert.Contains(t, v.getInvalid()[0].Object["errors"], "mode is immutable")
+	assert.False(t, v.isValid(ctx, widget("auto")))
+	assert.True(t, v.isValid(ctx, widget("fixed")))
+	assert.Empty(t, v.getInvalid())
+
+	// Once it's deleted, it can come back as anything.
+	v.forget(ctx, kates.NewDelta(kates.ObjectDelete, widget("fixed")))
+	assert.True(t, v.isValid(ctx, widget("auto")))
+}
+
+func TestResourceValidatorReservedOrigin(t *testing.T) {
+	ctx := dlog.NewTestContext(t, false)
+
+	v, err := newResourceValidator()
+	require.NoError(t, err)
+
+	mapping := func(annotations map[string]interface{}) *kates.Unstructured {
+		return &kates.Unstructured{Object: map[string]interface{}{
+			"apiVersion": "getambassador.io/v3alpha1",
+			"kind":       "Mapping",
+			"metadata": map[string]interface{}{
+				"name":        "quote",
+				"namespace":   "default",
+				"uid":         "1234",
+				"annotations": annotations,
+			},
+			"spec": map[string]interface{}{"prefix": "/quote/", "service": "quote"},
+		}}
+	}
+
+	assert.True(t, v.isValid(ctx, mapping(nil)))
+
+	// Only resources unfolded from annotations may claim to have come from one.
+	assert.False(t, v.isValid(ctx, mapping(map[string]interface{}{
+		snapshotTypes.AnnotationOriginKey: "Service/quote.default",
+	})))
+	require.Len(t, v.getInvalid(), 1)
+	assert.Contains(t, v.getInvalid()[0].Object["errors"], "is reserved")
+}
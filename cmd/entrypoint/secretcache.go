@@ -0,0 +1,224 @@
+package entrypoint
+
+import (
+	"context"
+	"sync"
+
+	"github.com/datawire/dlib/dlog"
+
+	"github.com/emissary-ingress/emissary/v3/pkg/kates"
+	snapshotTypes "github.com/emissary-ingress/emissary/v3/pkg/snapshot/v1"
+)
+
+// secretCache holds the contents of the Secrets that we're using.
+//
+// There can be a huge number of Secrets in a cluster (every Helm release has one, for starters),
+// and we use very few of them, so we only watch their metadata. When ReconcileSecrets finds a
+// Secret that it needs, it gets the whole thing from here, and we only go to the apiserver if we
+// haven't fetched that Secret before or its resourceVersion has changed since we did.
+//
+// ReconcileSecrets runs with the SnapshotHolder mutex held, so it never waits for the apiserver:
+// fetches happen in the background (a few at a time), and the changed channel fires when they
+// finish so that the watcher can reconcile again, much like the hostResolver does for DNS.
+type secretCache struct {
+	getter SecretGetter
+
+	// The changed method returns this channel. We write down this channel to signal that at least
+	// one fetch has finished since the last time somebody read from it.
+	coalescedDirty chan struct{}
+	// The get method writes to this (without blocking) to wake up the run loop when it has queued
+	// new fetches.
+	wakeup chan struct{}
+	// Individual fetches write their results here. It is always being read by the run loop.
+	results chan secretFetchResult
+	// Bounds the number of fetches we have in flight at once.
+	semaphore chan struct{}
+
+	// The mutex protects everything below.
+	mutex   sync.Mutex
+	secrets map[snapshotTypes.SecretRef]cachedSecret
+	// The resourceVersion that we're fetching (or are about to fetch) for each Secret.
+	pending map[snapshotTypes.SecretRef]string
+	// Secrets that we've already failed to fetch, so that failing again isn't news.
+	failed map[snapshotTypes.SecretRef]bool
+	queue  []snapshotTypes.SecretRef
+}
+
+type cachedSecret struct {
+	secret *kates.Secret
+	// The resourceVersion that the watch had when we fetched the Secret. We compare against this,
+	// not the resourceVersion of the Secret itself, since the watch can lag behind a Get.
+	version string
+}
+
+type secretFetchResult struct {
+	ref     snapshotTypes.SecretRef
+	version string
+	secret  *kates.Secret
+	err     error
+}
+
+func newSecretCache(getter SecretGetter) *secretCache {
+	return &secretCache{
+		getter:         getter,
+		coalescedDirty: make(chan struct{}),
+		wakeup:         make(chan struct{}, 1),
+		results:        make(chan secretFetchResult),
+		semaphore:      make(chan struct{}, 8),
+		secrets:        make(map[snapshotTypes.SecretRef]cachedSecret),
+		pending:        make(map[snapshotTypes.SecretRef]string),
+		failed:         make(map[snapshotTypes.SecretRef]bool),
+	}
+}
+
+func (c *secretCache) changed() chan struct{} {
+	return c.coalescedDirty
+}
+
+// get returns the whole Secret for the (metadata-only) Secret from the watch, queueing a fetch if
+// we don't have its current resourceVersion. Until that fetch finishes, the previous version of
+// the Secret is returned if we have one, and nil if we don't. The second return value says whether
+// the result is nil only because we're still waiting for the first fetch.
+func (c *secretCache) get(watched *kates.Secret) (*kates.Secret, bool) {
+	ref := snapshotTypes.SecretRef{Namespace: watched.GetNamespace(), Name: watched.GetName()}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	cached, ok := c.secrets[ref]
+	if ok && cached.version == watched.GetResourceVersion() {
+		return cached.secret, false
+	}
+
+	if _, ok := c.pending[ref]; !ok {
+		c.queue = append(c.queue, ref)
+		select {
+		case c.wakeup <- struct{}{}:
+		default:
+		}
+	}
+	c.pending[ref] = watched.GetResourceVersion()
+
+	return cached.secret, !ok && !c.failed[ref]
+}
+
+func (c *secretCache) run(ctx context.Context) error {
+	dirty := false
+	for {
+		var out chan struct{}
+		if dirty {
+			out = c.coalescedDirty
+		}
+		select {
+		case out <- struct{}{}:
+			dirty = false
+		case <-c.wakeup:
+			c.startFetches(ctx)
+		case result := <-c.results:
+			if c.store(ctx, result) {
+				dirty = true
+			}
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+func (c *secretCache) startFetches(ctx context.Context) {
+	c.mutex.Lock()
+	versions := make(map[snapshotTypes.SecretRef]string, len(c.queue))
+	for _, ref := range c.queue {
+		if version, ok := c.pending[ref]; ok {
+			versions[ref] = version
+		}
+	}
+	c.queue = nil
+	c.mutex.Unlock()
+
+	for ref, version := range versions {
+		go func(ref snapshotTypes.SecretRef, version string) {
+			select {
+			case c.semaphore <- struct{}{}:
+			case <-ctx.Done():
+				return
+			}
+			secret := &kates.Secret{
+				TypeMeta: kates.TypeMeta{
+					APIVersion: "v1",
+					Kind:       "Secret",
+				},
+				ObjectMeta: kates.ObjectMeta{
+					Name:      ref.Name,
+					Namespace: ref.Namespace,
+				},
+			}
+			err := c.getter.Get(ctx, secret, &secret)
+			<-c.semaphore
+
+			select {
+			case c.results <- secretFetchResult{ref: ref, version: version, secret: secret, err: err}:
+			case <-ctx.Done():
+			}
+		}(ref, version)
+	}
+}
+
+// store saves the result of a fetch, returning true if it's worth reconciling again.
+func (c *secretCache) store(ctx context.Context, result secretFetchResult) bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	ref := result.ref
+	want, ok := c.pending[ref]
+	if !ok {
+		// Pruned while the fetch was in flight; nobody cares anymore.
+		return false
+	}
+	delete(c.pending, ref)
+
+	if result.err != nil {
+		// If the Secret has gone away, the watch will tell us soon enough; if not, we'll try again
+		// on the next reconcile. Either way, we only need to reconcile now if this was the first
+		// try, since somebody may be waiting for it.
+		dlog.Errorf(ctx, "unable to fetch secret %s.%s: %v", ref.Name, ref.Namespace, result.err)
+		delete(c.secrets, ref)
+		news := !c.failed[ref]
+		c.failed[ref] = true
+		return news
+	}
+	dlog.Debugf(ctx, "fetched secret %s.%s at resourceVersion %s", ref.Name, ref.Namespace, result.secret.GetResourceVersion())
+	c.secrets[ref] = cachedSecret{secret: result.secret, version: result.version}
+	delete(c.failed, ref)
+	if want != result.version {
+		// The Secret changed again while we were fetching it, so go around again.
+		c.pending[ref] = want
+		c.queue = append(c.queue, ref)
+		select {
+		case c.wakeup <- struct{}{}:
+		default:
+		}
+	}
+	return true
+}
+
+// prune forgets every Secret that isn't in keep.
+func (c *secretCache) prune(keep map[snapshotTypes.SecretRef]bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	for ref := range c.secrets {
+		if !keep[ref] {
+			delete(c.secrets, ref)
+		}
+	}
+	for ref := range c.pending {
+		if !keep[ref] {
+			delete(c.pending, ref)
+		}
+	}
+	for ref := range c.failed {
+		if !keep[ref] {
+			delete(c.failed, ref)
+		}
+	}
+}
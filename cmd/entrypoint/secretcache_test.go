@@ -0,0 +1,198 @@
+package entrypoint
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/datawire/dlib/dgroup"
+	"github.com/datawire/dlib/dlog"
+
+	"github.com/emissary-ingress/emissary/v3/pkg/kates"
+	"github.com/emissary-ingress/emissary/v3/pkg/snapshot/v1"
+	snapshotTypes "github.com/emissary-ingress/emissary/v3/pkg/snapshot/v1"
+)
+
+// countingSecretGetter hands out Secrets with whatever resourceVersion it's been told, and counts
+// how often it gets asked. If gate is set, each Get waits for it.
+type countingSecretGetter struct {
+	mutex           sync.Mutex
+	resourceVersion string
+	fetches         int
+	gate            chan struct{}
+}
+
+func (g *countingSecretGetter) Get(_ context.Context, resource interface{}, target interface{}) error {
+	g.mutex.Lock()
+	gate := g.gate
+	g.mutex.Unlock()
+	if gate != nil {
+		<-gate
+	}
+
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	g.fetches++
+	if g.resourceVersion == "" {
+		return fmt.Errorf("not found")
+	}
+	secret := resource.(*kates.Secret).DeepCopy()
+	secret.SetResourceVersion(g.resourceVersion)
+	secret.Data = map[string][]byte{"tls.crt": []byte(g.resourceVersion)}
+	*(target.(**kates.Secret)) = secret
+	return nil
+}
+
+func (g *countingSecretGetter) set(resourceVersion string) {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	g.resourceVersion = resourceVersion
+}
+
+func (g *countingSecretGetter) count() int {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	return g.fetches
+}
+
+func TestSecretCache(t *testing.T) {
+	ctx, cancel := context.WithCancel(dlog.NewTestContext(t, false))
+	grp := dgroup.NewGroup(ctx, dgroup.GroupConfig{})
+	t.Cleanup(func() {
+		cancel()
+		assert.NoError(t, grp.Wait())
+	})
+
+	getter := &countingSecretGetter{resourceVersion: "1", gate: make(chan struct{})}
+	cache := newSecretCache(getter)
+	grp.Go("secrets", cache.run)
+
+	watched := func(resourceVersion string) *kates.Secret {
+		return &kates.Secret{ObjectMeta: kates.ObjectMeta{
+			Name:            "tls-cert",
+			Namespace:       "default",
+			ResourceVersion: resourceVersion,
+		}}
+	}
+	waitChanged := func() {
+		t.Helper()
+		select {
+		case <-cache.changed():
+		case <-time.After(10 * time.Second):
+			t.Fatal("timed out waiting for the secretCache to fetch")
+		}
+	}
+
+	// The first time we see a Secret, we have to fetch it, and we don't wait for the apiserver
+	// to do that...
+	secret, pending := cache.get(watched("1"))
+	assert.Nil(t, secret)
+	assert.True(t, pending)
+	getter.mutex.Lock()
+	close(getter.gate)
+	getter.gate = nil
+	getter.mutex.Unlock()
+	waitChanged()
+
+	secret, pending = cache.get(watched("1"))
+	require.NotNil(t, secret)
+	assert.False(t, pending)
+	assert.Equal(t, []byte("1"), secret.Data["tls.crt"])
+	assert.Equal(t, 1, getter.count())
+
+	// ...but not again until it changes. Until the new version arrives, we get the old one.
+	getter.set("2")
+	secret, pending = cache.get(watched("2"))
+	require.NotNil(t, secret)
+	assert.False(t, pending)
+	assert.Equal(t, []byte("1"), secret.Data["tls.crt"])
+	waitChanged()
+
+	secret, _ = cache.get(watched("2"))
+	require.NotNil(t, secret)
+	assert.Equal(t, []byte("2"), secret.Data["tls.crt"])
+	assert.Equal(t, 2, getter.count())
+
+	// Pruning forgets it, so it has to be fetched again.
+	cache.prune(map[snapshotTypes.SecretRef]bool{})
+	secret, pending = cache.get(watched("2"))
+	assert.Nil(t, secret)
+	assert.True(t, pending)
+	waitChanged()
+	secret, _ = cache.get(watched("2"))
+	require.NotNil(t, secret)
+	assert.Equal(t, 3, getter.count())
+
+	// A Secret that can't be fetched isn't cached either, and once we know that, we aren't
+	// waiting for it anymore.
+	getter.set("")
+	_, _ = cache.get(watched("3"))
+	waitChanged()
+	secret, pending = cache.get(watched("3"))
+	assert.Nil(t, secret)
+	assert.False(t, pending)
+}
+
+func TestFakeSecretsFetchedOnDemand(t *testing.T) {
+	f := RunFake(t, FakeConfig{}, nil)
+
+	secretYAML := func(name, token string) string {
+		return fmt.Sprintf(`
+---
+apiVersion: v1
+kind: Secret
+metadata:
+  name: %s
+  namespace: default
+type: Opaque
+data:
+  token: %s
+`, name, base64.StdEncoding.EncodeToString([]byte(token)))
+	}
+
+	assert.NoError(t, f.UpsertYAML(secretYAML("used", "one")+secretYAML("unused", "two")+`
+---
+apiVersion: getambassador.io/v3alpha1
+kind: Host
+metadata:
+  name: example
+  namespace: default
+spec:
+  hostname: example.com
+  tlsSecret:
+    name: used
+`))
+	f.Flush()
+
+	token := func(snap *snapshot.Snapshot) string {
+		for _, secret := range snap.Kubernetes.Secrets {
+			if secret.GetName() == "used" {
+				return string(secret.Data["token"])
+			}
+		}
+		return ""
+	}
+
+	snap, err := f.GetSnapshot(func(snap *snapshot.Snapshot) bool {
+		return token(snap) != ""
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "one", token(snap))
+	assert.Len(t, snap.Kubernetes.Secrets, 1)
+
+	// A change to the Secret gets fetched again.
+	assert.NoError(t, f.UpsertYAML(secretYAML("used", "three")))
+	f.Flush()
+
+	snap, err = f.GetSnapshot(func(snap *snapshot.Snapshot) bool {
+		return token(snap) == "three"
+	})
+	require.NoError(t, err)
+	assert.Len(t, snap.Kubernetes.Secrets, 1)
+}
@@ -183,22 +183,6 @@ func ReconcileSecrets(ctx context.Context, sh *SnapshotHolder) error {
 	// for all of these before putting them on the list.
 	var resources []kates.Object
 
-	// Annotations are straightforward, although honestly we should
-	// be filtering annotations by type here (or, even better, unfold
-	// them earlier so that we can treat them like any other resource
-	// here).
-
-	for _, list := range sh.k8sSnapshot.Annotations {
-		for _, a := range list {
-			if _, isInvalid := a.(*kates.Unstructured); isInvalid {
-				continue
-			}
-			if GetAmbID(ctx, a).Matches(envAmbID) {
-				resources = append(resources, a)
-			}
-		}
-	}
-
 	// Hosts are a little weird, because we have two ways to find the
 	// ambassador_id. Sorry about that.
 	for _, h := range sh.k8sSnapshot.Hosts {
@@ -310,6 +294,13 @@ func ReconcileSecrets(ctx context.Context, sh *SnapshotHolder) error {
 		}
 	}
 
+	//
+	// We only watch the metadata of K8sSecrets, so the secretCache has to go get
+	// the rest of the ones that we use. It does that in the background, so some
+	// of them may not be here yet; Notify holds the snapshot back until they are.
+	fetched := map[snapshotTypes.SecretRef]bool{}
+	sh.secretsPending = false
+
 	for _, secret := range sh.k8sSnapshot.K8sSecrets {
 		ref := snapshotTypes.SecretRef{Namespace: secret.GetNamespace(), Name: secret.GetName()}
 
@@ -320,9 +311,17 @@ func ReconcileSecrets(ctx context.Context, sh *SnapshotHolder) error {
 		}
 
 		if refs[ref] {
-			checkSecret(ctx, sh, "K8sSecret", ref, secret)
+			fetched[ref] = true
+			full, pending := sh.secretCache.get(secret)
+			if full != nil {
+				checkSecret(ctx, sh, "K8sSecret", ref, full)
+			}
+			if pending {
+				sh.secretsPending = true
+			}
 		}
 	}
+	sh.secretCache.prune(fetched)
 	return nil
 }
 
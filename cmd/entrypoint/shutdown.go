@@ -0,0 +1,180 @@
+package entrypoint
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/datawire/dlib/dgroup"
+	"github.com/datawire/dlib/dlog"
+	"github.com/emissary-ingress/emissary/v3/pkg/acp"
+)
+
+// A shutdownSequence takes Ambassador down without dropping requests on the floor. When it gets a
+// SIGTERM (or SIGINT), it:
+//
+//  1. tells the AmbassadorWatcher, so that the readiness check fails right away;
+//  2. tells Envoy to fail its health checks, and to drain its listeners gracefully;
+//  3. waits for the drain period, while Kubernetes notices that we're not ready and stops
+//     sending us traffic; and then
+//  4. stops the goroutines in each of its stages, one stage at a time, in the order that the
+//     stages were added.
+//
+// Anything that isn't in a stage is stopped after the last stage, by the dgroup shutting down.
+// A second signal skips whatever's left of the sequence.
+type shutdownSequence struct {
+	ambwatch *acp.AmbassadorWatcher
+
+	// Where Envoy's admin interface is.
+	adminURL string
+
+	// How long to wait between telling Envoy to drain and stopping anything.
+	drainPeriod time.Duration
+
+	// How long each stage gets to stop before we move on to the next one anyway.
+	stageTimeout time.Duration
+
+	stages []*shutdownStage
+}
+
+// A shutdownStage is a set of goroutines that are stopped together.
+type shutdownStage struct {
+	name    string
+	stop    chan struct{}
+	running sync.WaitGroup
+}
+
+// Stage adds a stage to the end of the sequence.
+func (s *shutdownSequence) Stage(name string) *shutdownStage {
+	stage := &shutdownStage{name: name, stop: make(chan struct{})}
+	s.stages = append(s.stages, stage)
+	return stage
+}
+
+// Go runs fn in the group, with a context that's cancelled (softly) when the stage is stopped.
+// Once the stage has been stopped, any error from fn is expected, so it's logged rather than
+// returned: returning it would make the group shut everything else down at once.
+func (stage *shutdownStage) Go(group *dgroup.Group, name string, fn func(ctx context.Context) error) {
+	stage.running.Add(1)
+	group.Go(name, func(ctx context.Context) error {
+		defer stage.running.Done()
+
+		ctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+		go func() {
+			select {
+			case <-stage.stop:
+				cancel()
+			case <-ctx.Done():
+			}
+		}()
+
+		err := fn(ctx)
+		select {
+		case <-stage.stop:
+			if err != nil {
+				dlog.Infof(ctx, "stopped: %v", err)
+			}
+			return nil
+		default:
+			return err
+		}
+	})
+}
+
+// Run waits for a signal on sigs, then runs the sequence. It returns nil if the context is
+// cancelled first; otherwise, it returns an error to make the group shut down the rest.
+func (s *shutdownSequence) Run(ctx context.Context, sigs <-chan os.Signal) error {
+	var sig os.Signal
+	select {
+	case <-ctx.Done():
+		return nil
+	case sig = <-sigs:
+	}
+	dlog.Infof(ctx, "received signal %v, shutting down gracefully", sig)
+
+	if again := s.drain(ctx, sigs); again != nil {
+		return fmt.Errorf("received signal %v (graceful shutdown already underway; stopping everything now)", again)
+	}
+	if again := s.stop(ctx, sigs); again != nil {
+		return fmt.Errorf("received signal %v (graceful shutdown already underway; stopping everything now)", again)
+	}
+
+	return fmt.Errorf("received signal %v (graceful shutdown complete)", sig)
+}
+
+// drain takes Ambassador out of service and waits for the drain period. It returns the signal
+// that cut it short, if one did.
+func (s *shutdownSequence) drain(ctx context.Context, sigs <-chan os.Signal) os.Signal {
+	s.ambwatch.NoteShuttingDown()
+
+	// Envoy may not be running (if we're shutting down before it ever started, say), so
+	// failing to talk to it isn't worth stopping for.
+	for _, path := range []string{"/healthcheck/fail", "/drain_listeners?graceful"} {
+		if err := s.postAdmin(ctx, path); err != nil {
+			dlog.Warnf(ctx, "shutdown: unable to tell Envoy %s: %v", path, err)
+		}
+	}
+
+	dlog.Infof(ctx, "shutdown: draining for %v", s.drainPeriod)
+	timer := time.NewTimer(s.drainPeriod)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+	case sig := <-sigs:
+		return sig
+	case <-ctx.Done():
+	}
+	return nil
+}
+
+// stop stops each stage in turn. It returns the signal that cut it short, if one did.
+func (s *shutdownSequence) stop(ctx context.Context, sigs <-chan os.Signal) os.Signal {
+	for _, stage := range s.stages {
+		dlog.Infof(ctx, "shutdown: stopping %s", stage.name)
+		close(stage.stop)
+
+		stopped := make(chan struct{})
+		go func(stage *shutdownStage) {
+			stage.running.Wait()
+			close(stopped)
+		}(stage)
+
+		timer := time.NewTimer(s.stageTimeout)
+		select {
+		case <-stopped:
+		case <-timer.C:
+			dlog.Warnf(ctx, "shutdown: %s did not stop within %v, moving on", stage.name, s.stageTimeout)
+		case sig := <-sigs:
+			timer.Stop()
+			return sig
+		case <-ctx.Done():
+			timer.Stop()
+			return nil
+		}
+		timer.Stop()
+	}
+	return nil
+}
+
+func (s *shutdownSequence) postAdmin(ctx context.Context, path string) error {
+	ctx, cancel := context.WithTimeout(ctx, 2*time.Second)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.adminURL+path, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s", resp.Status)
+	}
+	return nil
+}
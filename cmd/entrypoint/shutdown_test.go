@@ -0,0 +1,169 @@
+package entrypoint
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/datawire/dlib/dgroup"
+	"github.com/datawire/dlib/dlog"
+	"github.com/emissary-ingress/emissary/v3/pkg/acp"
+)
+
+type shutdownFixture struct {
+	ambwatch *acp.AmbassadorWatcher
+	admin    *httptest.Server
+
+	mu     sync.Mutex
+	events []string
+}
+
+func newShutdownFixture(t *testing.T) *shutdownFixture {
+	f := &shutdownFixture{}
+
+	ew := acp.NewEnvoyWatcher()
+	ew.SetReadyCheck(func(context.Context) (*acp.EnvoyFetcherResponse, error) {
+		return &acp.EnvoyFetcherResponse{StatusCode: http.StatusOK}, nil
+	})
+	f.ambwatch = acp.NewAmbassadorWatcher(ew, acp.NewDiagdWatcher())
+	f.ambwatch.NoteSnapshotSent()
+	time.Sleep(time.Millisecond)
+	f.ambwatch.NoteSnapshotProcessed()
+	f.ambwatch.FetchEnvoyReady(dlog.NewTestContext(t, false))
+	require.True(t, f.ambwatch.IsReady())
+
+	f.admin = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// By the time Envoy hears about it, we must already be failing the readiness check.
+		f.note(fmt.Sprintf("%s %s ready=%t", r.Method, r.URL.RequestURI(), f.ambwatch.IsReady()))
+	}))
+	t.Cleanup(f.admin.Close)
+
+	return f
+}
+
+func (f *shutdownFixture) note(event string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.events = append(f.events, event)
+}
+
+func (f *shutdownFixture) getEvents() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]string(nil), f.events...)
+}
+
+// until returns a goroutine body that runs until it's told to stop, noting when it is.
+func (f *shutdownFixture) until(name string, err error) func(ctx context.Context) error {
+	return func(ctx context.Context) error {
+		<-ctx.Done()
+		f.note("stopped " + name)
+		return err
+	}
+}
+
+func TestShutdownSequence(t *testing.T) {
+	f := newShutdownFixture(t)
+	ctx := dlog.NewTestContext(t, false)
+
+	group := dgroup.NewGroup(ctx, dgroup.GroupConfig{
+		SoftShutdownTimeout: 10 * time.Second,
+		HardShutdownTimeout: 10 * time.Second,
+	})
+	sigs := make(chan os.Signal, 1)
+	shutdown := &shutdownSequence{
+		ambwatch:     f.ambwatch,
+		adminURL:     f.admin.URL,
+		drainPeriod:  50 * time.Millisecond,
+		stageTimeout: 10 * time.Second,
+	}
+	first := shutdown.Stage("first")
+	second := shutdown.Stage("second")
+	group.Go("shutdown", func(ctx context.Context) error {
+		return shutdown.Run(ctx, sigs)
+	})
+	// Being stopped makes a subprocess return an error, which mustn't stop everything else.
+	second.Go(group, "envoy", f.until("envoy", errors.New("signal: interrupt")))
+	first.Go(group, "watcher", f.until("watcher", nil))
+	group.Go("healthchecks", f.until("healthchecks", nil))
+
+	sigs <- syscall.SIGTERM
+	err := group.Wait()
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "graceful shutdown complete")
+
+	assert.Equal(t, []string{
+		"POST /healthcheck/fail ready=false",
+		"POST /drain_listeners?graceful ready=false",
+		"stopped watcher",
+		"stopped envoy",
+		"stopped healthchecks",
+	}, f.getEvents())
+	assert.True(t, f.ambwatch.IsAlive())
+}
+
+func TestShutdownSequenceImpatient(t *testing.T) {
+	f := newShutdownFixture(t)
+	ctx := dlog.NewTestContext(t, false)
+
+	group := dgroup.NewGroup(ctx, dgroup.GroupConfig{
+		SoftShutdownTimeout: 10 * time.Second,
+		HardShutdownTimeout: 10 * time.Second,
+	})
+	sigs := make(chan os.Signal, 1)
+	shutdown := &shutdownSequence{
+		ambwatch:     f.ambwatch,
+		adminURL:     f.admin.URL,
+		drainPeriod:  time.Hour,
+		stageTimeout: time.Hour,
+	}
+	stage := shutdown.Stage("envoy")
+	group.Go("shutdown", func(ctx context.Context) error {
+		return shutdown.Run(ctx, sigs)
+	})
+	stage.Go(group, "envoy", f.until("envoy", nil))
+
+	// A second signal during the drain period stops everything right away.
+	sigs <- syscall.SIGTERM
+	require.Eventually(t, func() bool { return len(f.getEvents()) == 2 }, 10*time.Second, 10*time.Millisecond)
+	sigs <- syscall.SIGINT
+
+	err := group.Wait()
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "already underway")
+	assert.Contains(t, f.getEvents(), "stopped envoy")
+}
+
+func TestShutdownSequenceWithoutEnvoy(t *testing.T) {
+	f := newShutdownFixture(t)
+	ctx := dlog.NewTestContext(t, false)
+
+	group := dgroup.NewGroup(ctx, dgroup.GroupConfig{})
+	sigs := make(chan os.Signal, 1)
+	shutdown := &shutdownSequence{
+		ambwatch:     f.ambwatch,
+		adminURL:     "http://127.0.0.1:1", // nothing's listening
+		drainPeriod:  time.Millisecond,
+		stageTimeout: time.Second,
+	}
+	group.Go("shutdown", func(ctx context.Context) error {
+		return shutdown.Run(ctx, sigs)
+	})
+
+	// Not being able to reach Envoy doesn't stop the shutdown.
+	sigs <- syscall.SIGTERM
+	err := group.Wait()
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "graceful shutdown complete")
+	assert.False(t, f.ambwatch.IsReady())
+}
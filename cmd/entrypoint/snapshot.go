@@ -51,6 +51,10 @@ func GetAmbID(ctx context.Context, resource kates.Object) amb.AmbassadorID {
 		return r.Spec.AmbassadorID
 	case *amb.ConsulResolver:
 		return r.Spec.AmbassadorID
+	case *amb.DNSResolver:
+		return r.Spec.AmbassadorID
+	case *amb.StaticEndpointResolver:
+		return r.Spec.AmbassadorID
 	case *amb.KubernetesEndpointResolver:
 		return r.Spec.AmbassadorID
 	case *amb.KubernetesServiceResolver:
@@ -0,0 +1,242 @@
+package entrypoint
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/emissary-ingress/emissary/v3/pkg/kates"
+	snapshotTypes "github.com/emissary-ingress/emissary/v3/pkg/snapshot/v1"
+)
+
+// An encodedSnapshot is a snapshot that's ready to be served to diagd (and anyone else who asks).
+// The watcher builds one per reconfiguration and stores it in the shared *atomic.Value.
+//
+// With 10k Services in the cluster, the snapshot is tens of megabytes of JSON, so we're careful
+// not to make more copies of it than we need: it's marshalled once, compactly, and it's gzipped
+// at most once, the first time a client that accepts gzip asks for it. The snapshotDeltas are
+// likewise only built the first time somebody asks for them.
+type encodedSnapshot struct {
+	json []byte // the whole snapshot
+
+	// The generation numbers for the snapshotDeltas.
+	generation int
+	previous   int
+
+	gzipOnce sync.Once
+	gzipped  []byte
+
+	deltasOnce sync.Once
+	deltas     []byte
+	deltasErr  error
+}
+
+// loadEncodedSnapshot returns the current snapshot, or nil if there isn't one yet.
+func loadEncodedSnapshot(value *atomic.Value) *encodedSnapshot {
+	enc, _ := value.Load().(*encodedSnapshot)
+	return enc
+}
+
+// JSON returns the whole snapshot, as compact JSON.
+func (e *encodedSnapshot) JSON() []byte {
+	if e == nil {
+		return nil
+	}
+	return e.json
+}
+
+func (e *encodedSnapshot) gzip() []byte {
+	e.gzipOnce.Do(func() {
+		var buf bytes.Buffer
+		// BestSpeed gets almost all of the size win for a fraction of the CPU of the default
+		// level, which matters since we do this on every reconfiguration.
+		zw, _ := gzip.NewWriterLevel(&buf, gzip.BestSpeed)
+		_, _ = zw.Write(e.json)
+		_ = zw.Close()
+		e.gzipped = buf.Bytes()
+	})
+	return e.gzipped
+}
+
+// deltasJSON returns the snapshotDeltas since the previous encodedSnapshot.
+//
+// The watcher goes on changing the objects in the snapshot as soon as it has been encoded, so we
+// can't hang on to them until somebody asks for the deltas. Instead we build the deltas from the
+// snapshot's own JSON, which costs more than building them up front, but only when they're used.
+func (e *encodedSnapshot) deltasJSON() ([]byte, error) {
+	e.deltasOnce.Do(func() {
+		var sn snapshotTypes.Snapshot
+		if e.deltasErr = json.Unmarshal(e.json, &sn); e.deltasErr != nil {
+			return
+		}
+		e.deltas, e.deltasErr = json.Marshal(snapshotDeltas{
+			Generation: e.generation,
+			Previous:   e.previous,
+			Deltas:     sn.Deltas,
+			Objects:    changedObjects(sn.Kubernetes, sn.Deltas),
+			Derived:    derivedObjects(sn.Kubernetes),
+			Consul:     sn.Consul,
+			Invalid:    sn.Invalid,
+		})
+	})
+	return e.deltas, e.deltasErr
+}
+
+// serve writes either the whole snapshot or just its deltas, gzipped if the client accepts gzip.
+// The deltas are usually small, so they never get compressed.
+func (e *encodedSnapshot) serve(w http.ResponseWriter, r *http.Request, deltasOnly bool) {
+	if e == nil {
+		http.Error(w, "no snapshot yet", http.StatusServiceUnavailable)
+		return
+	}
+	var deltas []byte
+	if deltasOnly {
+		var err error
+		if deltas, err = e.deltasJSON(); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+	w.Header().Set("Content-Type", snapshotTypes.ContentTypeJSON)
+	w.Header().Add("Vary", "Accept-Encoding")
+	switch {
+	case deltasOnly:
+		_, _ = w.Write(deltas)
+	case acceptsGzip(r):
+		w.Header().Set("Content-Encoding", "gzip")
+		_, _ = w.Write(e.gzip())
+	default:
+		_, _ = w.Write(e.json)
+	}
+}
+
+func acceptsGzip(r *http.Request) bool {
+	for _, header := range r.Header.Values("Accept-Encoding") {
+		for _, coding := range strings.Split(header, ",") {
+			coding = strings.TrimSpace(coding)
+			name, params, _ := strings.Cut(coding, ";")
+			if strings.TrimSpace(name) != "gzip" {
+				continue
+			}
+			// "gzip;q=0" means "anything but gzip".
+			return strings.ReplaceAll(strings.TrimSpace(params), " ", "") != "q=0"
+		}
+	}
+	return false
+}
+
+// A snapshotDeltas is the delta-only form of a snapshot: the kates.Deltas since the previous
+// snapshot, along with the current state of every Kubernetes object those deltas added or
+// updated. A client that already has the snapshot with generation Previous can bring it up to
+// date without fetching the whole thing again.
+//
+// Some things don't have deltas of their own, so they're always sent in full: Consul endpoints,
+// invalid resources, and the Derived objects, which are the ones that the watcher makes up rather
+// than getting from Kubernetes (the resources unfolded from getambassador.io/config annotations,
+// and the synthetic AuthService and RateLimitService). A client should replace all of the derived
+// objects it has with these; they're never in Objects.
+//
+// A change to nothing but Endpoints goes straight to Envoy, and doesn't make a new snapshot; its
+// deltas are sent along with the next snapshot that there is.
+type snapshotDeltas struct {
+	Generation int
+	Previous   int // zero if there is no previous snapshot
+	Deltas     []*kates.Delta
+	Objects    []kates.Object
+	Derived    []kates.Object
+	Consul     *snapshotTypes.ConsulSnapshot
+	Invalid    []*kates.Unstructured
+}
+
+// encodeSnapshot builds the encodedSnapshot for sn. The generation numbers are the
+// SnapshotHolder's change counts for sn and for the previous snapshot that was sent.
+func encodeSnapshot(sn *snapshotTypes.Snapshot, generation, previous int) (*encodedSnapshot, error) {
+	snapshotJSON, err := json.Marshal(sn)
+	if err != nil {
+		return nil, err
+	}
+	return &encodedSnapshot{json: snapshotJSON, generation: generation, previous: previous}, nil
+}
+
+type objectKey struct {
+	kind      string
+	namespace string
+	name      string
+}
+
+// changedObjects returns the objects in k8s that were added or updated by deltas, in the order
+// that they appear in the snapshot. Derived objects are left out.
+func changedObjects(k8s *snapshotTypes.KubernetesSnapshot, deltas []*kates.Delta) []kates.Object {
+	if len(deltas) == 0 {
+		return nil
+	}
+
+	// A later delta for the same object wins, so a deleted object that came back is wanted and a
+	// new object that was deleted again isn't.
+	wanted := make(map[objectKey]bool, len(deltas))
+	for _, delta := range deltas {
+		key := objectKey{delta.Kind, delta.Namespace, delta.Name}
+		wanted[key] = delta.DeltaType != kates.ObjectDelete
+	}
+
+	var ret []kates.Object
+	eachSnapshotObject(k8s, func(obj kates.Object) {
+		key := objectKey{obj.GetObjectKind().GroupVersionKind().Kind, obj.GetNamespace(), obj.GetName()}
+		if wanted[key] && !isDerived(obj) {
+			ret = append(ret, obj)
+		}
+	})
+	return ret
+}
+
+// derivedObjects returns every derived object in k8s, in the order that they appear in the
+// snapshot.
+func derivedObjects(k8s *snapshotTypes.KubernetesSnapshot) []kates.Object {
+	var ret []kates.Object
+	eachSnapshotObject(k8s, func(obj kates.Object) {
+		if isDerived(obj) {
+			ret = append(ret, obj)
+		}
+	})
+	return ret
+}
+
+// isDerived returns whether obj was made up by the watcher, rather than coming from Kubernetes.
+func isDerived(obj kates.Object) bool {
+	if snapshotTypes.AnnotationOrigin(obj) != "" {
+		return true
+	}
+	switch obj.GetName() {
+	case syntheticAuthServiceName, syntheticRateLimitServiceName:
+		return true
+	}
+	return false
+}
+
+// eachSnapshotObject calls f for every object in the slices of k8s.
+func eachSnapshotObject(k8s *snapshotTypes.KubernetesSnapshot, f func(kates.Object)) {
+	if k8s == nil {
+		return
+	}
+	fields := reflect.ValueOf(k8s).Elem()
+	for i := 0; i < fields.NumField(); i++ {
+		field := fields.Field(i)
+		if field.Kind() != reflect.Slice || fields.Type().Field(i).Tag.Get("json") == "-" {
+			// Fields that aren't in the snapshot (such as the metadata-only K8sSecrets) aren't
+			// in its deltas either.
+			continue
+		}
+		for j := 0; j < field.Len(); j++ {
+			obj, ok := field.Index(j).Interface().(kates.Object)
+			if !ok || reflect.ValueOf(obj).IsNil() {
+				continue
+			}
+			f(obj)
+		}
+	}
+}
@@ -0,0 +1,311 @@
+package entrypoint
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	amb "github.com/emissary-ingress/emissary/v3/pkg/api/getambassador.io/v3alpha1"
+	"github.com/emissary-ingress/emissary/v3/pkg/kates"
+	snapshotTypes "github.com/emissary-ingress/emissary/v3/pkg/snapshot/v1"
+)
+
+func makeTestService(namespace, name string) *kates.Service {
+	return &kates.Service{
+		TypeMeta:   kates.TypeMeta{APIVersion: "v1", Kind: "Service"},
+		ObjectMeta: kates.ObjectMeta{Namespace: namespace, Name: name},
+		Spec: kates.ServiceSpec{
+			Ports: []kates.ServicePort{{Name: "http", Port: 80}},
+		},
+	}
+}
+
+// makeLargeSnapshot returns a snapshot with the given number of Services (and Endpoints for each
+// of them), one of which has just changed.
+func makeLargeSnapshot(services int) *snapshotTypes.Snapshot {
+	k8s := &snapshotTypes.KubernetesSnapshot{}
+	for i := 0; i < services; i++ {
+		svc := makeTestService("default", fmt.Sprintf("svc-%d", i))
+		k8s.Services = append(k8s.Services, svc)
+		k8s.Endpoints = append(k8s.Endpoints, &kates.Endpoints{
+			TypeMeta:   kates.TypeMeta{APIVersion: "v1", Kind: "Endpoints"},
+			ObjectMeta: svc.ObjectMeta,
+			Subsets: []kates.EndpointSubset{{
+				Addresses: []kates.EndpointAddress{{IP: fmt.Sprintf("10.%d.%d.%d", i/65536, (i/256)%256, i%256)}},
+				Ports:     []kates.EndpointPort{{Name: "http", Port: 8080}},
+			}},
+		})
+	}
+	delta, err := kates.NewDeltaFromObject(kates.ObjectUpdate, k8s.Services[0])
+	if err != nil {
+		panic(err)
+	}
+	return &snapshotTypes.Snapshot{
+		Kubernetes: k8s,
+		Consul:     &snapshotTypes.ConsulSnapshot{},
+		Deltas:     []*kates.Delta{delta},
+	}
+}
+
+func TestEncodeSnapshotDeltas(t *testing.T) {
+	quote := makeTestService("default", "quote")
+	other := makeTestService("default", "other")
+	gone := makeTestService("default", "gone")
+
+	deltas := []*kates.Delta{}
+	for _, item := range []struct {
+		deltaType kates.DeltaType
+		obj       kates.Object
+	}{
+		{kates.ObjectAdd, quote},
+		{kates.ObjectAdd, gone},
+		{kates.ObjectDelete, gone},
+	} {
+		delta, err := kates.NewDeltaFromObject(item.deltaType, item.obj)
+		require.NoError(t, err)
+		deltas = append(deltas, delta)
+	}
+
+	sn := &snapshotTypes.Snapshot{
+		Kubernetes: &snapshotTypes.KubernetesSnapshot{Services: []*kates.Service{other, quote}},
+		Deltas:     deltas,
+	}
+	enc, err := encodeSnapshot(sn, 7, 5)
+	require.NoError(t, err)
+
+	// The whole snapshot is compact JSON.
+	assert.NotContains(t, string(enc.JSON()), "\n")
+	var decoded snapshotTypes.Snapshot
+	require.NoError(t, json.Unmarshal(enc.JSON(), &decoded))
+	assert.Len(t, decoded.Kubernetes.Services, 2)
+
+	// The deltas only include the object that's still there.
+	var payload struct {
+		Generation int
+		Previous   int
+		Deltas     []*kates.Delta
+		Objects    []*kates.Unstructured
+	}
+	deltasJSON, err := enc.deltasJSON()
+	require.NoError(t, err)
+	require.NoError(t, json.Unmarshal(deltasJSON, &payload))
+	assert.Equal(t, 7, payload.Generation)
+	assert.Equal(t, 5, payload.Previous)
+	assert.Len(t, payload.Deltas, 3)
+	require.Len(t, payload.Objects, 1)
+	assert.Equal(t, "quote", payload.Objects[0].GetName())
+}
+
+func TestEncodeSnapshotDeltasEndpoints(t *testing.T) {
+	// An Endpoints-only change doesn't make a snapshot of its own, but its deltas go out with the
+	// next one, along with the Endpoints that they changed.
+	sn := makeLargeSnapshot(3)
+	delta, err := kates.NewDeltaFromObject(kates.ObjectUpdate, sn.Kubernetes.Endpoints[1])
+	require.NoError(t, err)
+	sn.Deltas = []*kates.Delta{delta}
+
+	enc, err := encodeSnapshot(sn, 2, 1)
+	require.NoError(t, err)
+
+	// The deltas are built later on, but changes made after encoding mustn't show up in them.
+	sn.Kubernetes.Endpoints[1].Name = "renamed"
+
+	var payload struct {
+		Deltas  []*kates.Delta
+		Objects []*kates.Unstructured
+	}
+	deltasJSON, err := enc.deltasJSON()
+	require.NoError(t, err)
+	require.NoError(t, json.Unmarshal(deltasJSON, &payload))
+	require.Len(t, payload.Deltas, 1)
+	assert.Equal(t, "Endpoints", payload.Deltas[0].Kind)
+	require.Len(t, payload.Objects, 1)
+	assert.Equal(t, "Endpoints", payload.Objects[0].GetKind())
+	assert.Equal(t, "svc-1", payload.Objects[0].GetName())
+}
+
+func TestEncodeSnapshotDeltasDerived(t *testing.T) {
+	svc := makeTestService("default", "quote")
+	unfolded := &amb.Mapping{
+		TypeMeta: kates.TypeMeta{APIVersion: "getambassador.io/v3alpha1", Kind: "Mapping"},
+		ObjectMeta: kates.ObjectMeta{
+			Namespace:   "default",
+			Name:        "quote-mapping",
+			Annotations: map[string]string{snapshotTypes.AnnotationOriginKey: "Service/quote.default"},
+		},
+	}
+	synthetic := &amb.AuthService{
+		TypeMeta:   kates.TypeMeta{APIVersion: "getambassador.io/v3alpha1", Kind: "AuthService"},
+		ObjectMeta: kates.ObjectMeta{Namespace: "default", Name: syntheticAuthServiceName},
+	}
+
+	deltas := []*kates.Delta{}
+	for _, obj := range []kates.Object{svc, synthetic} {
+		delta, err := kates.NewDeltaFromObject(kates.ObjectAdd, obj)
+		require.NoError(t, err)
+		deltas = append(deltas, delta)
+	}
+
+	sn := &snapshotTypes.Snapshot{
+		Kubernetes: &snapshotTypes.KubernetesSnapshot{
+			Services:     []*kates.Service{svc},
+			Mappings:     []*amb.Mapping{unfolded},
+			AuthServices: []*amb.AuthService{synthetic},
+		},
+		Deltas: deltas,
+	}
+	enc, err := encodeSnapshot(sn, 2, 1)
+	require.NoError(t, err)
+
+	// The derived objects are always sent, whether they have deltas or not, and only in Derived.
+	var payload struct {
+		Objects []*kates.Unstructured
+		Derived []*kates.Unstructured
+	}
+	deltasJSON, err := enc.deltasJSON()
+	require.NoError(t, err)
+	require.NoError(t, json.Unmarshal(deltasJSON, &payload))
+	require.Len(t, payload.Objects, 1)
+	assert.Equal(t, "quote", payload.Objects[0].GetName())
+	require.Len(t, payload.Derived, 2)
+	assert.Equal(t, "quote-mapping", payload.Derived[0].GetName())
+	assert.Equal(t, syntheticAuthServiceName, payload.Derived[1].GetName())
+}
+
+func TestServeEncodedSnapshot(t *testing.T) {
+	value := &atomic.Value{}
+	get := func(path, acceptEncoding string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, path, nil)
+		if acceptEncoding != "" {
+			r.Header.Set("Accept-Encoding", acceptEncoding)
+		}
+		w := httptest.NewRecorder()
+		loadEncodedSnapshot(value).serve(w, r, path == "/snapshot-deltas")
+		return w
+	}
+
+	// Nothing to serve yet.
+	assert.Equal(t, http.StatusServiceUnavailable, get("/snapshot", "").Code)
+
+	enc, err := encodeSnapshot(makeLargeSnapshot(10), 1, 0)
+	require.NoError(t, err)
+	value.Store(enc)
+
+	w := get("/snapshot", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "", w.Header().Get("Content-Encoding"))
+	assert.Equal(t, enc.JSON(), w.Body.Bytes())
+
+	for _, acceptEncoding := range []string{"gzip", "gzip, deflate", "br;q=1.0, gzip;q=0.8"} {
+		w = get("/snapshot", acceptEncoding)
+		assert.Equal(t, "gzip", w.Header().Get("Content-Encoding"), acceptEncoding)
+		zr, err := gzip.NewReader(bytes.NewReader(w.Body.Bytes()))
+		require.NoError(t, err)
+		body, err := ioutil.ReadAll(zr)
+		require.NoError(t, err)
+		assert.Equal(t, enc.JSON(), body)
+	}
+
+	w = get("/snapshot", "deflate, gzip;q=0")
+	assert.Equal(t, "", w.Header().Get("Content-Encoding"))
+
+	w = get("/snapshot-deltas", "gzip")
+	assert.Equal(t, "", w.Header().Get("Content-Encoding"))
+	deltas, err := enc.deltasJSON()
+	require.NoError(t, err)
+	assert.Equal(t, deltas, w.Body.Bytes())
+}
+
+// The benchmarks below show what a reconfiguration costs the watcher and the snapshot server with
+// a lot of Services. Run them with:
+//
+//	go test ./cmd/entrypoint -run '^$' -bench Snapshot -benchmem
+
+const benchmarkServices = 10000
+
+func BenchmarkSnapshotEncoding(b *testing.B) {
+	sn := makeLargeSnapshot(benchmarkServices)
+
+	// This is what we used to do on every reconfiguration.
+	b.Run("MarshalIndent", func(b *testing.B) {
+		b.ReportAllocs()
+		var size int
+		for i := 0; i < b.N; i++ {
+			bs, err := json.MarshalIndent(sn, "", "  ")
+			if err != nil {
+				b.Fatal(err)
+			}
+			size = len(bs)
+		}
+		b.ReportMetric(float64(size), "bytes/snapshot")
+	})
+
+	b.Run("Compact", func(b *testing.B) {
+		b.ReportAllocs()
+		var size int
+		for i := 0; i < b.N; i++ {
+			enc, err := encodeSnapshot(sn, 2, 1)
+			if err != nil {
+				b.Fatal(err)
+			}
+			size = len(enc.JSON())
+		}
+		b.ReportMetric(float64(size), "bytes/snapshot")
+	})
+
+	b.Run("CompactGzip", func(b *testing.B) {
+		b.ReportAllocs()
+		var size int
+		for i := 0; i < b.N; i++ {
+			enc, err := encodeSnapshot(sn, 2, 1)
+			if err != nil {
+				b.Fatal(err)
+			}
+			size = len(enc.gzip())
+		}
+		b.ReportMetric(float64(size), "bytes/snapshot")
+	})
+}
+
+func BenchmarkSnapshotServe(b *testing.B) {
+	enc, err := encodeSnapshot(makeLargeSnapshot(benchmarkServices), 2, 1)
+	if err != nil {
+		b.Fatal(err)
+	}
+	value := &atomic.Value{}
+	value.Store(enc)
+
+	for _, bm := range []struct {
+		name           string
+		acceptEncoding string
+		deltasOnly     bool
+	}{
+		{"Identity", "", false},
+		{"Gzip", "gzip", false},
+		{"Deltas", "gzip", true},
+	} {
+		b.Run(bm.name, func(b *testing.B) {
+			b.ReportAllocs()
+			r := httptest.NewRequest(http.MethodGet, "/snapshot", nil)
+			if bm.acceptEncoding != "" {
+				r.Header.Set("Accept-Encoding", bm.acceptEncoding)
+			}
+			var size int
+			for i := 0; i < b.N; i++ {
+				w := httptest.NewRecorder()
+				loadEncodedSnapshot(value).serve(w, r, bm.deltasOnly)
+				size = w.Body.Len()
+			}
+			b.ReportMetric(float64(size), "bytes/response")
+		})
+	}
+}
@@ -10,6 +10,7 @@ import (
 
 	"github.com/datawire/dlib/dhttp"
 	"github.com/datawire/dlib/dlog"
+	"github.com/emissary-ingress/emissary/v3/pkg/snapshot/history"
 	snapshotTypes "github.com/emissary-ingress/emissary/v3/pkg/snapshot/v1"
 )
 
@@ -20,7 +21,7 @@ const ExternalSnapshotPort = 8005
 func externalSnapshotServer(ctx context.Context, snapshot *atomic.Value) error {
 	mux := http.NewServeMux()
 	mux.HandleFunc("/snapshot-external", func(w http.ResponseWriter, r *http.Request) {
-		sanitizedSnap, err := sanitizeExternalSnapshot(ctx, snapshot.Load().([]byte), http.DefaultClient)
+		sanitizedSnap, err := sanitizeExternalSnapshot(ctx, loadEncodedSnapshot(snapshot).JSON(), http.DefaultClient)
 		if err != nil {
 			w.WriteHeader(http.StatusInternalServerError)
 			return
@@ -36,11 +37,16 @@ func externalSnapshotServer(ctx context.Context, snapshot *atomic.Value) error {
 	return s.ListenAndServe(ctx, fmt.Sprintf(":%d", ExternalSnapshotPort))
 }
 
-func snapshotServer(ctx context.Context, snapshot *atomic.Value) error {
+func snapshotServer(ctx context.Context, snapshot *atomic.Value, hist *history.History) error {
 	mux := http.NewServeMux()
 	mux.HandleFunc("/snapshot", func(w http.ResponseWriter, r *http.Request) {
-		_, _ = w.Write(snapshot.Load().([]byte))
+		loadEncodedSnapshot(snapshot).serve(w, r, false)
 	})
+	mux.HandleFunc("/snapshot-deltas", func(w http.ResponseWriter, r *http.Request) {
+		loadEncodedSnapshot(snapshot).serve(w, r, true)
+	})
+	mux.Handle("/snapshots", hist)
+	mux.Handle("/snapshots/", hist)
 
 	s := &dhttp.ServerConfig{
 		Handler: mux,
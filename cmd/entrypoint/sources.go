@@ -22,3 +22,15 @@ type IstioCertSource interface {
 type IstioCertWatcher interface {
 	Changed() <-chan IstioCertUpdate
 }
+
+// StatusUpdater writes the status subresource of a Kubernetes resource; *kates.Client implements
+// it.
+type StatusUpdater interface {
+	UpdateStatus(ctx context.Context, resource interface{}, target interface{}) error
+}
+
+// SecretGetter fetches whole Secrets, since we only watch their metadata; *kates.Client implements
+// it.
+type SecretGetter interface {
+	Get(ctx context.Context, resource interface{}, target interface{}) error
+}
@@ -0,0 +1,177 @@
+package entrypoint
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/datawire/dlib/dlog"
+
+	amb "github.com/emissary-ingress/emissary/v3/pkg/api/getambassador.io/v3alpha1"
+	"github.com/emissary-ingress/emissary/v3/pkg/kates"
+)
+
+// podLabelsFile is where the downward API puts the labels of the pod we're running in. (Python
+// reads the same file, so don't move it without moving that too.)
+var podLabelsFile = "/tmp/ambassador-pod-info/labels"
+
+// statusWriter writes the status of Kubernetes resources in the background, so that a slow or
+// unhappy apiserver can never hold up the watcher loop.
+//
+// The update method is called from the watcher loop while the SnapshotHolder mutex is held, so it
+// only records what the status should be. The run loop does the writes, and if a resource gets
+// more than one update before the run loop gets to it, only the last one is written.
+//
+// We don't retry failed writes: the usual reason for one to fail is that the resource changed out
+// from under us, and that change will come back through the watcher and queue a fresh update.
+type statusWriter struct {
+	updater StatusUpdater
+
+	// The update method writes to this (without blocking) to wake up the run loop.
+	wakeup chan struct{}
+
+	// The mutex protects access to pending.
+	mutex   sync.Mutex
+	pending map[string]interface{}
+}
+
+func newStatusWriter(updater StatusUpdater) *statusWriter {
+	return &statusWriter{
+		updater: updater,
+		wakeup:  make(chan struct{}, 1),
+		pending: make(map[string]interface{}),
+	}
+}
+
+// update queues a write of the status of the named resource. The resource must be a complete
+// Kubernetes resource (typed or not) with its status already set to what we want.
+func (w *statusWriter) update(kind, namespace, name string, resource interface{}) {
+	key := fmt.Sprintf("%s %s.%s", kind, name, namespace)
+
+	w.mutex.Lock()
+	w.pending[key] = resource
+	w.mutex.Unlock()
+
+	select {
+	case w.wakeup <- struct{}{}:
+	default:
+	}
+}
+
+func (w *statusWriter) run(ctx context.Context) error {
+	for {
+		select {
+		case <-w.wakeup:
+			w.mutex.Lock()
+			pending := w.pending
+			w.pending = make(map[string]interface{})
+			w.mutex.Unlock()
+
+			for key, resource := range pending {
+				if err := w.updater.UpdateStatus(ctx, resource, nil); err != nil {
+					dlog.Errorf(ctx, "unable to update status of %s: %v", key, err)
+				} else {
+					dlog.Debugf(ctx, "updated status of %s", key)
+				}
+			}
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+// readPodLabels reads the labels of our own pod from a file in the format that the downward API
+// writes, i.e. one `key="value"` line per label.
+func readPodLabels(ctx context.Context, path string) map[string]string {
+	labels := make(map[string]string)
+
+	f, err := os.Open(path)
+	if err != nil {
+		dlog.Warnf(ctx, "pod labels are not mounted at %s; Ambassador will be unable to find its own Service", path)
+		return labels
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		parts := strings.SplitN(line, "=", 2)
+		if len(parts) != 2 {
+			dlog.Warnf(ctx, "dropping pod label %q", line)
+			continue
+		}
+		value, err := strconv.Unquote(parts[1])
+		if err != nil {
+			dlog.Warnf(ctx, "dropping pod label %q: %v", line, err)
+			continue
+		}
+		labels[parts[0]] = value
+	}
+	if err := scanner.Err(); err != nil {
+		dlog.Warnf(ctx, "error reading pod labels from %s: %v", path, err)
+	}
+	return labels
+}
+
+// findAmbassadorService returns the Service that routes to this very Ambassador pod, or nil if
+// there isn't one.
+//
+// If name is set, the Service is just the one with that name in our namespace. Otherwise we use
+// the same test that Python uses: the Service must be labeled as an ambassador-service, live in
+// our namespace, and have a selector that matches our pod's labels.
+func findAmbassadorService(services []*kates.Service, name string, podLabels map[string]string) *kates.Service {
+	if name != "" {
+		for _, svc := range services {
+			if svc.GetName() == name && svc.GetNamespace() == GetAmbassadorNamespace() {
+				return svc
+			}
+		}
+		return nil
+	}
+
+	for _, svc := range services {
+		if strings.ToLower(svc.GetLabels()["app.kubernetes.io/component"]) != "ambassador-service" {
+			continue
+		}
+		if svc.GetNamespace() != GetAmbassadorNamespace() {
+			continue
+		}
+		if len(svc.Spec.Selector) == 0 {
+			continue
+		}
+		matches := true
+		for key, value := range svc.Spec.Selector {
+			if podValue, ok := podLabels[key]; !ok || podValue != value {
+				matches = false
+				break
+			}
+		}
+		if matches {
+			return svc
+		}
+	}
+	return nil
+}
+
+// annotationAmbassadorID returns the Ambassador ID from the getambassador.io/ambassador-id
+// annotation of a resource that isn't one of ours (an Ingress, say). Unlike on our own resources,
+// the annotation there is usually just a bare string, but we'll take a JSON list too.
+func annotationAmbassadorID(annotations map[string]string) amb.AmbassadorID {
+	idstr, ok := annotations["getambassador.io/ambassador-id"]
+	if !ok {
+		return nil
+	}
+	var id amb.AmbassadorID
+	if err := json.Unmarshal([]byte(idstr), &id); err != nil {
+		return amb.AmbassadorID{idstr}
+	}
+	return id
+}
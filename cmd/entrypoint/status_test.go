@@ -0,0 +1,31 @@
+package entrypoint
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/datawire/dlib/dlog"
+)
+
+func TestReadPodLabels(t *testing.T) {
+	ctx := dlog.NewTestContext(t, false)
+
+	path := filepath.Join(t.TempDir(), "labels")
+	require.NoError(t, os.WriteFile(path, []byte(`app.kubernetes.io/name="emissary-ingress"
+pod-template-hash="5d9c7b8f6"
+bogus
+product="aes \"edge\""
+`), 0644))
+
+	assert.Equal(t, map[string]string{
+		"app.kubernetes.io/name": "emissary-ingress",
+		"pod-template-hash":      "5d9c7b8f6",
+		"product":                `aes "edge"`,
+	}, readPodLabels(ctx, path))
+
+	assert.Empty(t, readPodLabels(ctx, filepath.Join(t.TempDir(), "missing")))
+}
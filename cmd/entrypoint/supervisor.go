@@ -0,0 +1,388 @@
+package entrypoint
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"sync"
+	"time"
+
+	"sigs.k8s.io/yaml"
+
+	"github.com/datawire/dlib/dexec"
+	"github.com/datawire/dlib/dlog"
+	"github.com/emissary-ingress/emissary/v3/pkg/acp"
+)
+
+// A restartPolicy says what the supervisor does when a child exits.
+type restartPolicy string
+
+const (
+	// restartAlways restarts the child whenever it exits.
+	restartAlways restartPolicy = "always"
+	// restartOnFailure restarts the child if it exits with an error, but not if it exits
+	// successfully.
+	restartOnFailure restartPolicy = "on-failure"
+	// restartNever leaves the child alone once it has exited.
+	restartNever restartPolicy = "never"
+)
+
+// A supervisorConfig is the contents of the supervisor config file, which declares the processes
+// that the entrypoint runs alongside diagd:
+//
+//	children:
+//	- name: my-sidecar
+//	  command: ["/ambassador/sidecars/my-sidecar", "--verbose"]
+//	  restart: on-failure
+//	- name: diagd
+//	  restart: always
+//
+// diagd is always run. It can be listed (without a command) to change its restart policy, or to
+// make it not required.
+type supervisorConfig struct {
+	Children []childConfig `json:"children"`
+}
+
+type childConfig struct {
+	Name    string   `json:"name"`
+	Command []string `json:"command,omitempty"`
+
+	// How to restart the child; the default is "on-failure".
+	Restart restartPolicy `json:"restart,omitempty"`
+
+	// Whether Ambassador can run without the child. If a required child exits for good, or
+	// starts crash looping, the entrypoint shuts down.
+	Required *bool `json:"required,omitempty"`
+}
+
+// A supervisedChild is a process that the supervisor runs.
+type supervisedChild struct {
+	name     string
+	policy   restartPolicy
+	required bool
+
+	// How to run the child.
+	command func(ctx context.Context) *dexec.Cmd
+
+	// What to do, if anything, each time the child is started again after it has exited. It's
+	// called in a goroutine of its own, once the new process is running.
+	onRestart func(ctx context.Context)
+}
+
+// loadSupervisedChildren returns diagd and the children declared in configFile. If there's no
+// configFile, every file in sidecarDir is a child instead, restarted if it fails, as it would be
+// if it were declared with just a command.
+func loadSupervisedChildren(configFile, sidecarDir string, diagd *supervisedChild) ([]*supervisedChild, error) {
+	var config supervisorConfig
+	bs, err := os.ReadFile(configFile)
+	switch {
+	case err == nil:
+		if err := yaml.UnmarshalStrict(bs, &config); err != nil {
+			return nil, fmt.Errorf("%s: %w", configFile, err)
+		}
+	case os.IsNotExist(err):
+		sidecars, err := os.ReadDir(sidecarDir)
+		if err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+		for _, sidecar := range sidecars {
+			config.Children = append(config.Children, childConfig{
+				Name:    sidecar.Name(),
+				Command: []string{path.Join(sidecarDir, sidecar.Name())},
+			})
+		}
+	default:
+		return nil, err
+	}
+
+	children := []*supervisedChild{diagd}
+	seen := map[string]bool{}
+	for _, cfg := range config.Children {
+		if cfg.Name == "" {
+			return nil, fmt.Errorf("%s: a child has no name", configFile)
+		}
+		if seen[cfg.Name] {
+			return nil, fmt.Errorf("%s: child %q is declared more than once", configFile, cfg.Name)
+		}
+		seen[cfg.Name] = true
+
+		switch cfg.Restart {
+		case "":
+		case restartAlways, restartOnFailure, restartNever:
+		default:
+			return nil, fmt.Errorf("%s: child %q: invalid restart policy %q (must be %q, %q or %q)",
+				configFile, cfg.Name, cfg.Restart, restartAlways, restartOnFailure, restartNever)
+		}
+
+		if cfg.Name == diagd.name {
+			if len(cfg.Command) > 0 {
+				return nil, fmt.Errorf("%s: the command for %s cannot be changed", configFile, diagd.name)
+			}
+			if cfg.Restart != "" {
+				diagd.policy = cfg.Restart
+			}
+			if cfg.Required != nil {
+				diagd.required = *cfg.Required
+			}
+			continue
+		}
+
+		if len(cfg.Command) == 0 {
+			return nil, fmt.Errorf("%s: child %q has no command", configFile, cfg.Name)
+		}
+		command := cfg.Command
+		child := &supervisedChild{
+			name:   cfg.Name,
+			policy: restartOnFailure,
+			command: func(ctx context.Context) *dexec.Cmd {
+				return subcommand(ctx, command[0], command[1:]...)
+			},
+		}
+		if cfg.Restart != "" {
+			child.policy = cfg.Restart
+		}
+		if cfg.Required != nil {
+			child.required = *cfg.Required
+		}
+		children = append(children, child)
+	}
+
+	return children, nil
+}
+
+// childStatusNotable is the part of acp.AmbassadorWatcher that a supervisor tells about its
+// children.
+type childStatusNotable interface {
+	NoteChildStatus(status acp.ChildReport)
+}
+
+// A supervisor runs its children, restarting them according to their restart policies, so that
+// one of them exiting doesn't take the rest of Ambassador down with it. Restarts back off
+// exponentially from minBackoff to maxBackoff; a child that has run for stableAfter is considered
+// healthy again, and starts over at minBackoff. A child that exits crashLoopCount times within
+// crashLoopWindow is crash looping: if it's required, the supervisor gives up, and returns an
+// error, otherwise it keeps restarting it.
+//
+// Each line that a child writes is prefixed with its name.
+type supervisor struct {
+	children []*supervisedChild
+	notable  childStatusNotable
+
+	minBackoff  time.Duration
+	maxBackoff  time.Duration
+	stableAfter time.Duration
+
+	crashLoopCount  int
+	crashLoopWindow time.Duration
+
+	// Where the children's output goes.
+	stdout io.Writer
+	stderr io.Writer
+}
+
+// newSupervisor returns a supervisor with the default backoff and crash loop settings.
+func newSupervisor(children []*supervisedChild, notable childStatusNotable) *supervisor {
+	return &supervisor{
+		children:        children,
+		notable:         notable,
+		minBackoff:      time.Second,
+		maxBackoff:      time.Minute,
+		stableAfter:     2 * time.Minute,
+		crashLoopCount:  5,
+		crashLoopWindow: 5 * time.Minute,
+		stdout:          os.Stdout,
+		stderr:          os.Stderr,
+	}
+}
+
+// Run runs every child until ctx is cancelled, or until a required child fails for good.
+func (s *supervisor) Run(ctx context.Context) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	errs := make(chan error, len(s.children))
+	for _, child := range s.children {
+		go func(child *supervisedChild) {
+			errs <- s.supervise(ctx, child)
+		}(child)
+	}
+
+	var firstErr error
+	for range s.children {
+		if err := <-errs; err != nil && firstErr == nil {
+			firstErr = err
+			// Stop everything else.
+			cancel()
+		}
+	}
+	return firstErr
+}
+
+// supervise runs a single child, restarting it as need be.
+func (s *supervisor) supervise(ctx context.Context, child *supervisedChild) error {
+	ctx = dlog.WithField(ctx, "child", child.name)
+	status := acp.ChildReport{
+		Name:     child.name,
+		Policy:   string(child.policy),
+		Required: child.required,
+	}
+	note := func(state string) {
+		status.State = state
+		s.notable.NoteChildStatus(status)
+	}
+
+	backoff := s.minBackoff
+	var exits []time.Time
+	for {
+		started := time.Now()
+		status.LastStart = &started
+		status.NextStart = nil
+		note("running")
+
+		err := s.runOnce(ctx, child, status.Restarts > 0)
+		if ctx.Err() != nil {
+			note("stopped")
+			return nil
+		}
+
+		exited := time.Now()
+		reason := "exited successfully"
+		if err != nil {
+			reason = err.Error()
+		}
+		status.LastExit = &exited
+		status.LastExitReason = reason
+
+		if child.policy == restartNever || (child.policy == restartOnFailure && err == nil) {
+			note("exited")
+			// A required child has to keep running, so it stopping for good is fatal, even if
+			// it thinks it succeeded.
+			if child.required {
+				if err != nil {
+					return fmt.Errorf("%s: %w", child.name, err)
+				}
+				return fmt.Errorf("%s: %s, but it is required", child.name, reason)
+			}
+			dlog.Infof(ctx, "%s: %s; not restarting it", child.name, reason)
+			return nil
+		}
+
+		if exited.Sub(started) >= s.stableAfter {
+			backoff = s.minBackoff
+		}
+		exits = append(exits, exited)
+		for len(exits) > 0 && exited.Sub(exits[0]) > s.crashLoopWindow {
+			exits = exits[1:]
+		}
+
+		state := "backoff"
+		if len(exits) >= s.crashLoopCount {
+			state = "crash-loop"
+			if child.required {
+				note(state)
+				return fmt.Errorf("%s exited %d times within %v; giving up: %s",
+					child.name, len(exits), s.crashLoopWindow, reason)
+			}
+		}
+
+		next := exited.Add(backoff)
+		status.NextStart = &next
+		note(state)
+		dlog.Errorf(ctx, "%s: %s; restarting it in %v", child.name, reason, backoff)
+
+		timer := time.NewTimer(backoff)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			status.NextStart = nil
+			note("stopped")
+			return nil
+		case <-timer.C:
+		}
+
+		backoff *= 2
+		if backoff > s.maxBackoff {
+			backoff = s.maxBackoff
+		}
+		status.Restarts++
+	}
+}
+
+// runOnce runs the child until it exits, with its output prefixed with its name.
+func (s *supervisor) runOnce(ctx context.Context, child *supervisedChild, restart bool) error {
+	cmd := child.command(ctx)
+	// dexec would log everything the child writes, on top of us passing it along.
+	cmd.DisableLogging = true
+
+	if cmd.Stdout != nil {
+		stdout := newPrefixWriter(s.stdout, child.name)
+		defer stdout.Flush()
+		cmd.Stdout = stdout
+	}
+	if cmd.Stderr != nil {
+		stderr := newPrefixWriter(s.stderr, child.name)
+		defer stderr.Flush()
+		cmd.Stderr = stderr
+	}
+
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	dlog.Infof(ctx, "started %s (pid %d)", child.name, cmd.Process.Pid)
+	if restart && child.onRestart != nil {
+		go child.onRestart(ctx)
+	}
+	return cmd.Wait()
+}
+
+// A prefixWriter writes each line written to it to w, prefixed with "[name] ". A line that
+// hasn't been finished yet is held back until it is, or until Flush is called.
+type prefixWriter struct {
+	mu     sync.Mutex
+	w      io.Writer
+	prefix []byte
+	buf    []byte
+}
+
+func newPrefixWriter(w io.Writer, name string) *prefixWriter {
+	return &prefixWriter{w: w, prefix: []byte("[" + name + "] ")}
+}
+
+func (pw *prefixWriter) Write(p []byte) (int, error) {
+	pw.mu.Lock()
+	defer pw.mu.Unlock()
+
+	pw.buf = append(pw.buf, p...)
+	var out []byte
+	for {
+		i := bytes.IndexByte(pw.buf, '\n')
+		if i < 0 {
+			break
+		}
+		out = append(out, pw.prefix...)
+		out = append(out, pw.buf[:i+1]...)
+		pw.buf = pw.buf[i+1:]
+	}
+	if len(out) > 0 {
+		if _, err := pw.w.Write(out); err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+// Flush writes out whatever is left of an unfinished line.
+func (pw *prefixWriter) Flush() {
+	pw.mu.Lock()
+	defer pw.mu.Unlock()
+
+	if len(pw.buf) == 0 {
+		return
+	}
+	out := append(append(append([]byte(nil), pw.prefix...), pw.buf...), '\n')
+	_, _ = pw.w.Write(out)
+	pw.buf = nil
+}
@@ -0,0 +1,313 @@
+package entrypoint
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/datawire/dlib/dexec"
+	"github.com/datawire/dlib/dlog"
+	"github.com/emissary-ingress/emissary/v3/pkg/acp"
+)
+
+func fakeDiagd() *supervisedChild {
+	return &supervisedChild{name: "diagd", policy: restartOnFailure, required: true}
+}
+
+func TestLoadSupervisedChildren(t *testing.T) {
+	dir := t.TempDir()
+	sidecarDir := filepath.Join(dir, "sidecars")
+	require.NoError(t, os.Mkdir(sidecarDir, 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(sidecarDir, "a"), nil, 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(sidecarDir, "b"), nil, 0755))
+	configFile := filepath.Join(dir, "supervisor.yaml")
+
+	// Without a config file, every sidecar is run.
+	children, err := loadSupervisedChildren(configFile, sidecarDir, fakeDiagd())
+	require.NoError(t, err)
+	require.Len(t, children, 3)
+	assert.Equal(t, "diagd", children[0].name)
+	assert.Equal(t, "a", children[1].name)
+	assert.Equal(t, restartOnFailure, children[1].policy)
+	assert.False(t, children[1].required)
+	assert.Equal(t, "b", children[2].name)
+
+	// Nor does it matter if there's no sidecar directory.
+	children, err = loadSupervisedChildren(configFile, filepath.Join(dir, "nonexistent"), fakeDiagd())
+	require.NoError(t, err)
+	require.Len(t, children, 1)
+
+	// With one, only what's declared is run.
+	require.NoError(t, os.WriteFile(configFile, []byte(`
+children:
+- name: diagd
+  restart: always
+  required: false
+- name: logger
+  command: ["/bin/logger", "--verbose"]
+  restart: never
+  required: true
+`), 0644))
+	children, err = loadSupervisedChildren(configFile, sidecarDir, fakeDiagd())
+	require.NoError(t, err)
+	require.Len(t, children, 2)
+	assert.Equal(t, "diagd", children[0].name)
+	assert.Equal(t, restartAlways, children[0].policy)
+	assert.False(t, children[0].required)
+	assert.Equal(t, "logger", children[1].name)
+	assert.Equal(t, restartNever, children[1].policy)
+	assert.True(t, children[1].required)
+	assert.Equal(t, []string{"/bin/logger", "--verbose"}, children[1].command(context.Background()).Args)
+
+	for name, tc := range map[string]struct {
+		config string
+		err    string
+	}{
+		"unknown-field": {
+			config: "children:\n- name: x\n  command: [x]\n  restrat: always\n",
+			err:    `unknown field "restrat"`,
+		},
+		"bad-policy": {
+			config: "children:\n- name: x\n  command: [x]\n  restart: sometimes\n",
+			err:    `child "x": invalid restart policy "sometimes"`,
+		},
+		"no-name": {
+			config: "children:\n- command: [x]\n",
+			err:    "a child has no name",
+		},
+		"no-command": {
+			config: "children:\n- name: x\n",
+			err:    `child "x" has no command`,
+		},
+		"duplicate": {
+			config: "children:\n- name: x\n  command: [x]\n- name: x\n  command: [y]\n",
+			err:    `child "x" is declared more than once`,
+		},
+		"diagd-command": {
+			config: "children:\n- name: diagd\n  command: [x]\n",
+			err:    "the command for diagd cannot be changed",
+		},
+	} {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			require.NoError(t, os.WriteFile(configFile, []byte(tc.config), 0644))
+			_, err := loadSupervisedChildren(configFile, sidecarDir, fakeDiagd())
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tc.err)
+		})
+	}
+}
+
+type fakeChildStatuses struct {
+	mu       sync.Mutex
+	statuses map[string]acp.ChildReport
+}
+
+func (f *fakeChildStatuses) NoteChildStatus(status acp.ChildReport) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.statuses == nil {
+		f.statuses = make(map[string]acp.ChildReport)
+	}
+	f.statuses[status.Name] = status
+}
+
+func (f *fakeChildStatuses) get(name string) acp.ChildReport {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.statuses[name]
+}
+
+type lockedBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *lockedBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *lockedBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func shChild(name string, policy restartPolicy, required bool, script string) *supervisedChild {
+	return &supervisedChild{
+		name:     name,
+		policy:   policy,
+		required: required,
+		command: func(ctx context.Context) *dexec.Cmd {
+			return subcommand(ctx, "sh", "-c", script)
+		},
+	}
+}
+
+func newTestSupervisor(children []*supervisedChild, statuses *fakeChildStatuses, out *lockedBuffer) *supervisor {
+	s := newSupervisor(children, statuses)
+	s.minBackoff = 10 * time.Millisecond
+	s.maxBackoff = 40 * time.Millisecond
+	s.crashLoopCount = 3
+	s.crashLoopWindow = time.Hour
+	s.stdout = out
+	s.stderr = out
+	return s
+}
+
+func TestSupervisor(t *testing.T) {
+	statuses := &fakeChildStatuses{}
+	out := &lockedBuffer{}
+	s := newTestSupervisor([]*supervisedChild{
+		shChild("daemon", restartOnFailure, true, "echo up; while true; do sleep 0.01; done"),
+		shChild("flaky", restartOnFailure, false, "echo oops >&2; printf partial; exit 3"),
+		shChild("oneshot", restartOnFailure, false, "echo done"),
+		shChild("forever", restartAlways, false, "exit 0"),
+	}, statuses, out)
+
+	ctx, cancel := context.WithCancel(dlog.NewTestContext(t, false))
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- s.Run(ctx)
+	}()
+
+	// A child that isn't required can crash loop without stopping anything else...
+	require.Eventually(t, func() bool {
+		return statuses.get("flaky").State == "crash-loop" && statuses.get("forever").State == "crash-loop"
+	}, 10*time.Second, 10*time.Millisecond)
+	assert.Equal(t, "running", statuses.get("daemon").State)
+	flaky := statuses.get("flaky")
+	assert.GreaterOrEqual(t, flaky.Restarts, 2)
+	assert.Equal(t, "exit status 3", flaky.LastExitReason)
+	assert.NotNil(t, flaky.NextStart)
+
+	// ...and a child that exits successfully isn't restarted unless its policy says so.
+	oneshot := statuses.get("oneshot")
+	assert.Equal(t, "exited", oneshot.State)
+	assert.Equal(t, 0, oneshot.Restarts)
+	assert.Equal(t, "exited successfully", oneshot.LastExitReason)
+	assert.Equal(t, "always", statuses.get("forever").Policy)
+
+	cancel()
+	select {
+	case err := <-errCh:
+		assert.NoError(t, err)
+	case <-time.After(10 * time.Second):
+		t.Fatal("supervisor did not stop")
+	}
+	assert.Equal(t, "stopped", statuses.get("daemon").State)
+	assert.Equal(t, "stopped", statuses.get("flaky").State)
+	assert.Equal(t, "exited", statuses.get("oneshot").State)
+
+	// Output is prefixed with the child's name, a line at a time.
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	assert.Contains(t, lines, "[daemon] up")
+	assert.Contains(t, lines, "[oneshot] done")
+	assert.Contains(t, lines, "[flaky] oops")
+	assert.Contains(t, lines, "[flaky] partial")
+}
+
+func TestSupervisorRequired(t *testing.T) {
+	for name, tc := range map[string]struct {
+		policy restartPolicy
+		script string
+		err    string
+	}{
+		"never":              {policy: restartNever, script: "exit 1", err: "diagd: exit status 1"},
+		"on-failure":         {policy: restartOnFailure, script: "exit 1", err: "diagd exited 3 times within 1h0m0s; giving up: exit status 1"},
+		"never-success":      {policy: restartNever, script: "exit 0", err: "diagd: exited successfully, but it is required"},
+		"on-failure-success": {policy: restartOnFailure, script: "exit 0", err: "diagd: exited successfully, but it is required"},
+	} {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			statuses := &fakeChildStatuses{}
+			s := newTestSupervisor([]*supervisedChild{
+				shChild("diagd", tc.policy, true, tc.script),
+				shChild("sidecar", restartOnFailure, false, "while true; do sleep 0.01; done"),
+			}, statuses, &lockedBuffer{})
+
+			// A required child stopping for good, successfully or not, stops everything else.
+			err := s.Run(dlog.NewTestContext(t, false))
+			require.Error(t, err)
+			assert.Equal(t, tc.err, err.Error())
+			assert.Equal(t, "stopped", statuses.get("sidecar").State)
+		})
+	}
+}
+
+func TestSupervisorRestartResendsSnapshot(t *testing.T) {
+	ctx, cancel := context.WithCancel(dlog.NewTestContext(t, false))
+	defer cancel()
+
+	// There's already a snapshot when diagd dies.
+	encoded := &atomic.Value{}
+	enc, err := encodeSnapshot(makeLargeSnapshot(1), 1, 0)
+	require.NoError(t, err)
+	encoded.Store(enc)
+
+	var sent int32
+	notifier := newDiagdNotifier(&noopNotable{}, encoded)
+	notifier.send = func(ctx context.Context, ambwatch notable) error {
+		atomic.AddInt32(&sent, 1)
+		return nil
+	}
+
+	// The first diagd exits with an error; the second one stays up.
+	marker := filepath.Join(t.TempDir(), "started")
+	diagd := shChild("diagd", restartOnFailure, true,
+		"if [ -e "+marker+" ]; then while true; do sleep 0.01; done; fi; touch "+marker+"; exit 1")
+	diagd.onRestart = notifier.resend
+
+	statuses := &fakeChildStatuses{}
+	s := newTestSupervisor([]*supervisedChild{diagd}, statuses, &lockedBuffer{})
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- s.Run(ctx)
+	}()
+
+	// The restarted diagd is sent the current snapshot, once.
+	require.Eventually(t, func() bool {
+		return atomic.LoadInt32(&sent) == 1
+	}, 10*time.Second, 10*time.Millisecond)
+	status := statuses.get("diagd")
+	assert.Equal(t, "running", status.State)
+	assert.Equal(t, 1, status.Restarts)
+
+	cancel()
+	select {
+	case err := <-errCh:
+		assert.NoError(t, err)
+	case <-time.After(10 * time.Second):
+		t.Fatal("supervisor did not stop")
+	}
+	assert.Equal(t, int32(1), atomic.LoadInt32(&sent))
+}
+
+func TestPrefixWriter(t *testing.T) {
+	var buf bytes.Buffer
+	pw := newPrefixWriter(&buf, "child")
+
+	_, err := pw.Write([]byte("one\ntw"))
+	require.NoError(t, err)
+	assert.Equal(t, "[child] one\n", buf.String())
+
+	_, err = pw.Write([]byte("o\nthree\nfo"))
+	require.NoError(t, err)
+	assert.Equal(t, "[child] one\n[child] two\n[child] three\n", buf.String())
+
+	pw.Flush()
+	assert.Equal(t, "[child] one\n[child] two\n[child] three\n[child] fo\n", buf.String())
+	pw.Flush()
+	assert.Equal(t, "[child] one\n[child] two\n[child] three\n[child] fo\n", buf.String())
+}
@@ -8,6 +8,7 @@ import (
 	"github.com/emissary-ingress/emissary/v3/pkg/api/getambassador.io/v3alpha1"
 	"github.com/emissary-ingress/emissary/v3/pkg/emissaryutil"
 	"github.com/emissary-ingress/emissary/v3/pkg/kates"
+	snapshotTypes "github.com/emissary-ingress/emissary/v3/pkg/snapshot/v1"
 )
 
 // Checks if the provided string is a loopback IP address with port 8500
@@ -18,28 +19,26 @@ func IsLocalhost8500(svcStr string) bool {
 
 func iterateOverAuthServices(sh *SnapshotHolder, cb func(
 	authService *v3alpha1.AuthService, // duh
-	name string, // name to unambiguously refer to the authService by; might be more complex than "name.namespace" if it's an annotation
-	parentName string, // name of the thing that the annotation is on (or empty if not an annotation)
-	idx int, // index of the authService; either in sh.k8sSnapshot.AuthServices or in sh.k8sSnapshot.Annotations[parentName]
+	name string, // name to unambiguously refer to the authService by; says where it came from if it's from an annotation
+	idx int, // index of the authService in sh.k8sSnapshot.AuthServices
 )) {
 	envAmbID := GetAmbassadorID()
 
 	for i, authService := range sh.k8sSnapshot.AuthServices {
 		if authService.Spec.AmbassadorID.Matches(envAmbID) {
 			name := authService.TypeMeta.Kind + "/" + authService.ObjectMeta.Name + "." + authService.ObjectMeta.Namespace
-			cb(authService, name, "", i)
-		}
-	}
-	for parentName, list := range sh.k8sSnapshot.Annotations {
-		for i, obj := range list {
-			if authService, ok := obj.(*v3alpha1.AuthService); ok && authService.Spec.AmbassadorID.Matches(envAmbID) {
-				name := fmt.Sprintf("%s#%d", parentName, i)
-				cb(authService, name, parentName, i)
+			if origin := snapshotTypes.AnnotationOrigin(authService); origin != "" {
+				name += " (from " + origin + ")"
 			}
+			cb(authService, name, i)
 		}
 	}
 }
 
+// The synthetic AuthService gets a name with underscores, which prevents it from colliding with
+// anything real in the cluster--Kubernetes resources can't have underscores in their name.
+const syntheticAuthServiceName = "synthetic_edge_stack_auth"
+
 // This is a gross hack to remove all AuthServices using protocol_version: v2 only when running Edge-Stack and then inject an
 // AuthService with protocol_version: v3 if needed. The purpose of this hack is to prevent Edge-Stack 2.3 from
 // using any other AuthService than the default one running as part of amb-sidecar and force the protocol version to v3.
@@ -51,19 +50,15 @@ func ReconcileAuthServices(ctx context.Context, sh *SnapshotHolder, deltas *[]*k
 		return nil
 	}
 
-	// using a name with underscores prevents it from colliding with anything real in the
-	// cluster--Kubernetes resources can't have underscores in their name.
-	const syntheticAuthServiceName = "synthetic_edge_stack_auth"
-
 	var (
 		numAuthServices  uint64
 		syntheticAuth    *v3alpha1.AuthService
 		syntheticAuthIdx int
 	)
-	iterateOverAuthServices(sh, func(authService *v3alpha1.AuthService, name, parentName string, i int) {
+	iterateOverAuthServices(sh, func(authService *v3alpha1.AuthService, name string, i int) {
 		numAuthServices++
 		if IsLocalhost8500(authService.Spec.AuthService) {
-			if parentName == "" && authService.ObjectMeta.Name == syntheticAuthServiceName {
+			if snapshotTypes.AnnotationOrigin(authService) == "" && authService.ObjectMeta.Name == syntheticAuthServiceName {
 				syntheticAuth = authService
 				syntheticAuthIdx = i
 			}
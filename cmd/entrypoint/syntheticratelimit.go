@@ -7,33 +7,31 @@ import (
 	"github.com/datawire/dlib/dlog"
 	"github.com/emissary-ingress/emissary/v3/pkg/api/getambassador.io/v3alpha1"
 	"github.com/emissary-ingress/emissary/v3/pkg/kates"
+	snapshotTypes "github.com/emissary-ingress/emissary/v3/pkg/snapshot/v1"
 )
 
 func iterateOverRateLimitServices(sh *SnapshotHolder, cb func(
-	rateLimitService *v3alpha1.RateLimitService, // rateLimitService
-	name string, // name to unambiguously refer to the rateLimitServices by; might be more complex than "name.namespace" if it's an annotation
-	parentName string, // name of the thing that the annotation is on (or empty if not an annotation)
-	idx int, // index of the rateLimitService; either in sh.k8sSnapshot.RateLimitServices or in sh.k8sSnapshot.Annotations[parentName]
+	rateLimitService *v3alpha1.RateLimitService, // duh
+	name string, // name to unambiguously refer to the rateLimitService by; says where it came from if it's from an annotation
+	idx int, // index of the rateLimitService in sh.k8sSnapshot.RateLimitServices
 )) {
 	envAmbID := GetAmbassadorID()
 
 	for i, rateLimitService := range sh.k8sSnapshot.RateLimitServices {
 		if rateLimitService.Spec.AmbassadorID.Matches(envAmbID) {
 			name := rateLimitService.TypeMeta.Kind + "/" + rateLimitService.ObjectMeta.Name + "." + rateLimitService.ObjectMeta.Namespace
-			cb(rateLimitService, name, "", i)
-		}
-	}
-
-	for parentName, list := range sh.k8sSnapshot.Annotations {
-		for i, obj := range list {
-			if rateLimitService, ok := obj.(*v3alpha1.RateLimitService); ok && rateLimitService.Spec.AmbassadorID.Matches(envAmbID) {
-				name := fmt.Sprintf("%s#%d", parentName, i)
-				cb(rateLimitService, name, parentName, i)
+			if origin := snapshotTypes.AnnotationOrigin(rateLimitService); origin != "" {
+				name += " (from " + origin + ")"
 			}
+			cb(rateLimitService, name, i)
 		}
 	}
 }
 
+// The synthetic RateLimitService gets a name with underscores, which prevents it from colliding with
+// anything real in the cluster--Kubernetes resources can't have underscores in their name.
+const syntheticRateLimitServiceName = "synthetic_edge_stack_rate_limit"
+
 // ReconcileRateLimit is a hack to remove all RateLimitService using protocol_version: v2 only when running Edge-Stack and then inject an
 // RateLimitService with protocol_version: v3 if needed. The purpose of this hack is to prevent Edge-Stack 2.3 from
 // using any other RateLimitService than the default one running as part of amb-sidecar and force the protocol version to v3.
@@ -45,20 +43,16 @@ func ReconcileRateLimit(ctx context.Context, sh *SnapshotHolder, deltas *[]*kate
 		return nil
 	}
 
-	// using a name with underscores prevents it from colliding with anything real in the
-	// cluster--Kubernetes resources can't have underscores in their name.
-	const syntheticRateLimitServiceName = "synthetic_edge_stack_rate_limit"
-
 	var (
 		numRateLimitServices  uint64
 		syntheticRateLimit    *v3alpha1.RateLimitService
 		syntheticRateLimitIdx int
 	)
 
-	iterateOverRateLimitServices(sh, func(rateLimitService *v3alpha1.RateLimitService, name, parentName string, i int) {
+	iterateOverRateLimitServices(sh, func(rateLimitService *v3alpha1.RateLimitService, name string, i int) {
 		numRateLimitServices++
 		if IsLocalhost8500(rateLimitService.Spec.Service) {
-			if parentName == "" && rateLimitService.ObjectMeta.Name == syntheticRateLimitServiceName {
+			if snapshotTypes.AnnotationOrigin(rateLimitService) == "" && rateLimitService.ObjectMeta.Name == syntheticRateLimitServiceName {
 				syntheticRateLimit = rateLimitService
 				syntheticRateLimitIdx = i
 			}
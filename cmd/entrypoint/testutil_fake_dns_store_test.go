@@ -0,0 +1,44 @@
+package entrypoint
+
+import (
+	"sync"
+
+	"github.com/emissary-ingress/emissary/v3/pkg/dnswatch"
+)
+
+type DNSStore struct {
+	mutex     sync.Mutex
+	endpoints map[DNSKey]dnswatch.Endpoints
+}
+
+type DNSKey struct {
+	resolver string
+	service  string
+}
+
+func NewDNSStore() *DNSStore {
+	return &DNSStore{endpoints: map[DNSKey]dnswatch.Endpoints{}}
+}
+
+func (d *DNSStore) DNSEndpoint(resolver, service string, endpoint dnswatch.Endpoint) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	key := DNSKey{resolver, service}
+	ep, ok := d.endpoints[key]
+	if !ok {
+		ep = dnswatch.Endpoints{
+			Resolver: resolver,
+			Service:  service,
+		}
+	}
+	ep.Endpoints = append(ep.Endpoints, endpoint)
+	d.endpoints[key] = ep
+}
+
+func (d *DNSStore) Get(resolver, service string) (dnswatch.Endpoints, bool) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	ep, ok := d.endpoints[DNSKey{resolver, service}]
+	return ep, ok
+}
@@ -525,3 +525,64 @@ spec:
 	// ...and one ConsulResolver.
 	assert.Equal(t, "consul-1:9999", snap.Kubernetes.ConsulResolvers[0].Spec.Address)
 }
+
+// By default the Fake struct only invokes the first part of the pipeline, so TestFakeHelloDNS
+// checks that endpoints found by a DNSResolver make it to ambex, and that we don't wait for them
+// before sending the first snapshot.
+func TestFakeHelloDNS(t *testing.T) {
+	f := entrypoint.RunFake(t, entrypoint.FakeConfig{}, nil)
+
+	assert.NoError(t, f.UpsertFile("testdata/FakeHelloDNS.yaml"))
+	f.Flush()
+
+	// Unlike consul, there's no need for DNS answers before the snapshot is ready, since the
+	// Python side only needs to know the name of the EDS cluster.
+	snap, err := f.GetSnapshot(func(snap *snapshot.Snapshot) bool {
+		return len(snap.Kubernetes.Mappings) == 2 && len(snap.Kubernetes.DNSResolvers) > 0
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "dns-srv", snap.Kubernetes.DNSResolvers[0].Name)
+
+	// Now supply some DNS answers for the service referenced by our hello mapping.
+	f.DNSEndpoint("dns-srv", "_http._tcp.hello.example.com", "1.2.3.4", 8080)
+	f.DNSEndpoint("dns-srv", "_http._tcp.hello.example.com", "1.2.3.5", 8081)
+	f.Flush()
+
+	endpoints, err := f.GetEndpoints(func(endpoints *ambex.Endpoints) bool {
+		_, ok := endpoints.Entries["dns/dns-srv/_http._tcp.hello.example.com"]
+		return ok
+	})
+	require.NoError(t, err)
+	eps := endpoints.Entries["dns/dns-srv/_http._tcp.hello.example.com"]
+	require.Len(t, eps, 2)
+	assert.Equal(t, "1.2.3.4", eps[0].Ip)
+	assert.Equal(t, uint32(8080), eps[0].Port)
+	assert.Equal(t, "1.2.3.5", eps[1].Ip)
+	assert.Equal(t, uint32(8081), eps[1].Port)
+}
+
+// TestFakeHelloDNSPriorities checks that SRV priorities and weights make it to ambex, with the
+// priorities renumbered from 0 the way Envoy wants them.
+func TestFakeHelloDNSPriorities(t *testing.T) {
+	f := entrypoint.RunFake(t, entrypoint.FakeConfig{}, nil)
+
+	assert.NoError(t, f.UpsertFile("testdata/FakeHelloDNS.yaml"))
+	f.Flush()
+
+	f.DNSSRVEndpoint("dns-srv", "_http._tcp.hello.example.com", "1.2.3.4", 8080, 10, 60)
+	f.DNSSRVEndpoint("dns-srv", "_http._tcp.hello.example.com", "1.2.3.5", 8080, 10, 20)
+	f.DNSSRVEndpoint("dns-srv", "_http._tcp.hello.example.com", "1.2.3.6", 8080, 20, 0)
+	f.Flush()
+
+	endpoints, err := f.GetEndpoints(func(endpoints *ambex.Endpoints) bool {
+		return len(endpoints.Entries["dns/dns-srv/_http._tcp.hello.example.com"]) == 3
+	})
+	require.NoError(t, err)
+	eps := endpoints.Entries["dns/dns-srv/_http._tcp.hello.example.com"]
+	assert.Equal(t, uint32(0), eps[0].Priority)
+	assert.Equal(t, uint32(60), eps[0].Weight)
+	assert.Equal(t, uint32(0), eps[1].Priority)
+	assert.Equal(t, uint32(20), eps[1].Weight)
+	assert.Equal(t, uint32(1), eps[2].Priority)
+	assert.Equal(t, uint32(0), eps[2].Weight)
+}
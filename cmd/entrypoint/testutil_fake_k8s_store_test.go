@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"io/ioutil"
 	"sort"
+	"strconv"
 	"strings"
 	"sync"
 
@@ -23,6 +24,8 @@ type K8sStore struct {
 	// This tracks every delta forever. That's ok because we only use this for tests, so we want to
 	// favor simplicity over efficiency. Also tests don't run that long, so it's not a big deal.
 	deltas []*kates.Delta
+	// Every change bumps the resourceVersion, just like in kubernetes.
+	resourceVersion int
 }
 
 type K8sKey struct {
@@ -71,6 +74,9 @@ func (k *K8sStore) Upsert(resource kates.Object) error {
 	k.mutex.Lock()
 	defer k.mutex.Unlock()
 
+	k.resourceVersion++
+	un.SetResourceVersion(strconv.Itoa(k.resourceVersion))
+
 	key := K8sKey{un.GetKind(), un.GetNamespace(), un.GetName()}
 	_, ok := k.resources[key]
 	if ok {
@@ -82,6 +88,48 @@ func (k *K8sStore) Upsert(resource kates.Object) error {
 	return nil
 }
 
+// UpdateStatus will replace the status of an existing resource with the status of the given
+// resource, leaving everything else about it alone, just like the status subresource in
+// kubernetes does.
+func (k *K8sStore) UpdateStatus(resource *kates.Unstructured) error {
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+
+	canonKind, err := canon(resource.GetKind())
+	if err != nil {
+		return err
+	}
+	namespace := resource.GetNamespace()
+	if namespace == "" {
+		namespace = "default"
+	}
+	key := K8sKey{canonKind, namespace, resource.GetName()}
+	old, ok := k.resources[key]
+	if !ok {
+		return fmt.Errorf("%s %s.%s not found", canonKind, resource.GetName(), namespace)
+	}
+
+	un := old.(*kates.Unstructured).DeepCopy()
+	un.Object["status"] = resource.Object["status"]
+	k.resourceVersion++
+	un.SetResourceVersion(strconv.Itoa(k.resourceVersion))
+	k.deltas = append(k.deltas, kates.NewDelta(kates.ObjectUpdate, un))
+	k.resources[key] = un
+	return nil
+}
+
+// Get returns the identified resource, or nil if there isn't one.
+func (k *K8sStore) Get(kind, namespace, name string) (kates.Object, error) {
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+
+	canonKind, err := canon(kind)
+	if err != nil {
+		return nil, err
+	}
+	return k.resources[K8sKey{canonKind, namespace, name}], nil
+}
+
 // Delete will remove the identified resource from the store.
 func (k *K8sStore) Delete(kind, namespace, name string) error {
 	k.mutex.Lock()
@@ -207,6 +255,8 @@ func canonGVK(rawString string) (canonKind string, canonGroupVersion string, err
 	// Each case should be `case "singular", "plural":`
 	switch strings.ToLower(rawKind) {
 	// Native Kubernetes types
+	case "namespace", "namespaces":
+		return "Namespace", "v1", nil
 	case "service", "services":
 		return "Service", "v1", nil
 	case "endpoints":
@@ -239,6 +289,8 @@ func canonGVK(rawString string) (canonKind string, canonGroupVersion string, err
 		return "ConsulResolver", "getambassador.io/v3alpha1", nil
 	case "devportal", "devportals":
 		return "DevPortal", "getambassador.io/v3alpha1", nil
+	case "dnsresolver", "dnsresolvers":
+		return "DNSResolver", "getambassador.io/v3alpha1", nil
 	case "host", "hosts":
 		return "Host", "getambassador.io/v3alpha1", nil
 	case "kubernetesendpointresolver", "kubernetesendpointresolvers":
@@ -255,6 +307,8 @@ func canonGVK(rawString string) (canonKind string, canonGroupVersion string, err
 		return "Module", "getambassador.io/v3alpha1", nil
 	case "ratelimitservice", "ratelimitservices":
 		return "RateLimitService", "getambassador.io/v3alpha1", nil
+	case "staticendpointresolver", "staticendpointresolvers":
+		return "StaticEndpointResolver", "getambassador.io/v3alpha1", nil
 	case "tcpmapping", "tcpmappings":
 		return "TCPMapping", "getambassador.io/v3alpha1", nil
 	case "tlscontext", "tlscontexts":
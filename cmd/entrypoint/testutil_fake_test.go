@@ -21,6 +21,7 @@ import (
 	v3bootstrap "github.com/emissary-ingress/emissary/v3/pkg/api/envoy/config/bootstrap/v3"
 	amb "github.com/emissary-ingress/emissary/v3/pkg/api/getambassador.io/v3alpha1"
 	"github.com/emissary-ingress/emissary/v3/pkg/consulwatch"
+	"github.com/emissary-ingress/emissary/v3/pkg/dnswatch"
 	"github.com/emissary-ingress/emissary/v3/pkg/kates"
 	"github.com/emissary-ingress/emissary/v3/pkg/snapshot/v1"
 )
@@ -61,13 +62,18 @@ type Fake struct {
 
 	k8sSource       *fakeK8sSource
 	watcher         *fakeWatcher
+	dnsWatcher      *fakeDNSWatcher
 	istioCertSource *fakeIstioCertSource
+	statusUpdater   *fakeStatusUpdater
+	secretGetter    *fakeSecretGetter
 	// This group of fields are used to store kubernetes resources and consul endpoint data and
 	// provide explicit control over when changes to that data are sent to the control plane.
 	k8sStore       *K8sStore
 	consulStore    *ConsulStore
+	dnsStore       *DNSStore
 	k8sNotifier    *Notifier
 	consulNotifier *Notifier
+	dnsNotifier    *Notifier
 
 	// This holds the current snapshot.
 	currentSnapshot *atomic.Value
@@ -104,6 +110,7 @@ func NewFake(t *testing.T, config FakeConfig) *Fake {
 	ctx, cancel := context.WithCancel(dlog.NewTestContext(t, false))
 	k8sStore := NewK8sStore()
 	consulStore := NewConsulStore()
+	dnsStore := NewDNSStore()
 
 	fake := &Fake{
 		config: config,
@@ -113,8 +120,10 @@ func NewFake(t *testing.T, config FakeConfig) *Fake {
 
 		k8sStore:       k8sStore,
 		consulStore:    consulStore,
+		dnsStore:       dnsStore,
 		k8sNotifier:    NewNotifier(),
 		consulNotifier: NewNotifier(),
+		dnsNotifier:    NewNotifier(),
 
 		currentSnapshot: &atomic.Value{},
 
@@ -125,7 +134,10 @@ func NewFake(t *testing.T, config FakeConfig) *Fake {
 
 	fake.k8sSource = &fakeK8sSource{fake: fake, store: k8sStore}
 	fake.watcher = &fakeWatcher{fake: fake, store: consulStore}
+	fake.dnsWatcher = &fakeDNSWatcher{fake: fake, store: dnsStore}
 	fake.istioCertSource = &fakeIstioCertSource{}
+	fake.statusUpdater = &fakeStatusUpdater{fake: fake}
+	fake.secretGetter = &fakeSecretGetter{fake: fake}
 
 	return fake
 }
@@ -151,7 +163,7 @@ func (f *Fake) Setup() {
 		}
 
 		f.group.Go("snapshot_server", func(ctx context.Context) error {
-			return snapshotServer(ctx, f.currentSnapshot)
+			return snapshotServer(ctx, f.currentSnapshot, nil)
 		})
 
 		f.DiagdBindPort = GetDiagdBindPort()
@@ -269,8 +281,11 @@ func (f *Fake) runWatcher(ctx context.Context) error {
 		f.currentSnapshot, // encoded
 		f.k8sSource,
 		queries,
-		f.watcher.Watch, // watchConsulFunc
+		f.watcher.Watch,    // watchConsulFunc
+		f.dnsWatcher.Watch, // watchDNSFunc
 		f.istioCertSource,
+		f.statusUpdater,
+		f.secretGetter,
 		f.notifySnapshot,
 		f.notifyFastpath,
 		f.ambassadorMeta,
@@ -380,12 +395,14 @@ func (f *Fake) GetEnvoyConfig(predicate func(*v3bootstrap.Bootstrap) bool) (*v3b
 func (f *Fake) AutoFlush(enabled bool) {
 	f.k8sNotifier.AutoNotify(enabled)
 	f.consulNotifier.AutoNotify(enabled)
+	f.dnsNotifier.AutoNotify(enabled)
 }
 
 // Feed will cause inputs from all datasources to be delivered to the control plane.
 func (f *Fake) Flush() {
 	f.k8sNotifier.Notify()
 	f.consulNotifier.Notify()
+	f.dnsNotifier.Notify()
 }
 
 // sets the ambassador meta info that should get sent in each snapshot
@@ -437,6 +454,19 @@ func (f *Fake) ConsulEndpoint(datacenter, service, address string, port int, tag
 	f.consulNotifier.Changed()
 }
 
+// DNSEndpoint stores the supplied endpoint data as if it had been found by the named DNSResolver.
+func (f *Fake) DNSEndpoint(resolver, service, address string, port int) {
+	f.dnsStore.DNSEndpoint(resolver, service, dnswatch.Endpoint{Address: address, Port: port})
+	f.dnsNotifier.Changed()
+}
+
+// DNSSRVEndpoint is like DNSEndpoint, but for an endpoint found from an SRV record with the given
+// priority and weight.
+func (f *Fake) DNSSRVEndpoint(resolver, service, address string, port, priority, weight int) {
+	f.dnsStore.DNSEndpoint(resolver, service, dnswatch.Endpoint{Address: address, Port: port, Priority: priority, Weight: weight})
+	f.dnsNotifier.Changed()
+}
+
 // SendIstioCertUpdate sends the supplied Istio certificate update.
 func (f *Fake) SendIstioCertUpdate(update IstioCertUpdate) {
 	f.istioCertSource.updateChannel <- update
@@ -473,6 +503,12 @@ func (f *fakeK8sWatcher) FilteredUpdate(_ context.Context, target interface{}, d
 	if err != nil {
 		return false, err
 	}
+	namespaceLabels := map[string]map[string]string{}
+	for key, obj := range resources {
+		if key.Kind == "Namespace" {
+			namespaceLabels[obj.GetName()] = obj.GetLabels()
+		}
+	}
 	for _, obj := range resources {
 		for _, q := range f.queries {
 			var un *kates.Unstructured
@@ -480,12 +516,27 @@ func (f *fakeK8sWatcher) FilteredUpdate(_ context.Context, target interface{}, d
 			if err != nil {
 				return false, err
 			}
-			doesMatch, err := matches(q, obj)
+			doesMatch, err := matches(q, obj, namespaceLabels)
 			if err != nil {
 				return false, err
 			}
-			if doesMatch && predicate(un) {
-				byname[q.Name] = append(byname[q.Name], obj)
+			if !doesMatch {
+				continue
+			}
+			// Just like the real thing, leave out everything but the metadata if that's all
+			// that was asked for, and run the transform before anything else sees it.
+			if q.MetadataOnly {
+				un = &kates.Unstructured{Object: map[string]interface{}{
+					"apiVersion": un.GetAPIVersion(),
+					"kind":       un.GetKind(),
+					"metadata":   un.Object["metadata"],
+				}}
+			}
+			if q.Transform != nil {
+				q.Transform(un)
+			}
+			if predicate(un) {
+				byname[q.Name] = append(byname[q.Name], un)
 			}
 		}
 	}
@@ -513,7 +564,16 @@ func (f *fakeK8sWatcher) FilteredUpdate(_ context.Context, target interface{}, d
 	return len(newDeltas) > 0, nil
 }
 
-func matches(query kates.Query, obj kates.Object) (bool, error) {
+// fakeClusterScopedKinds are the kinds that the namespaces of a query don't apply to. (The fake
+// store puts everything in a namespace, even these.)
+var fakeClusterScopedKinds = map[string]bool{
+	"ClusterIngress": true,
+	"GatewayClass":   true,
+	"IngressClass":   true,
+	"Namespace":      true,
+}
+
+func matches(query kates.Query, obj kates.Object, namespaceLabels map[string]map[string]string) (bool, error) {
 	queryKind, err := canon(query.Kind)
 	if err != nil {
 		return false, err
@@ -522,7 +582,66 @@ func matches(query kates.Query, obj kates.Object) (bool, error) {
 	if err != nil {
 		return false, err
 	}
-	return queryKind == objKind, nil
+	if queryKind != objKind || fakeClusterScopedKinds[objKind] {
+		return queryKind == objKind, nil
+	}
+
+	switch {
+	case query.NamespaceSelector != "":
+		sel, err := kates.ParseSelector(query.NamespaceSelector)
+		if err != nil {
+			return false, err
+		}
+		labels, ok := namespaceLabels[obj.GetNamespace()]
+		return ok && sel.Matches(kates.LabelSet(labels)), nil
+	case len(query.Namespaces) > 0:
+		for _, ns := range query.Namespaces {
+			if ns == obj.GetNamespace() {
+				return true, nil
+			}
+		}
+		return false, nil
+	default:
+		return true, nil
+	}
+}
+
+// fakeSecretGetter gets Secrets straight out of the fake k8s datastore.
+type fakeSecretGetter struct {
+	fake *Fake
+}
+
+func (f *fakeSecretGetter) Get(_ context.Context, resource interface{}, target interface{}) error {
+	var un *kates.Unstructured
+	if err := convert(resource, &un); err != nil {
+		return err
+	}
+	obj, err := f.fake.k8sStore.Get(un.GetKind(), un.GetNamespace(), un.GetName())
+	if err != nil {
+		return err
+	}
+	if obj == nil {
+		return fmt.Errorf("%s %s.%s not found", un.GetKind(), un.GetName(), un.GetNamespace())
+	}
+	return convert(obj, target)
+}
+
+// fakeStatusUpdater writes statuses straight in to the fake k8s datastore, much as the apiserver
+// would.
+type fakeStatusUpdater struct {
+	fake *Fake
+}
+
+func (f *fakeStatusUpdater) UpdateStatus(_ context.Context, resource interface{}, _ interface{}) error {
+	var un *kates.Unstructured
+	if err := convert(resource, &un); err != nil {
+		return err
+	}
+	if err := f.fake.k8sStore.UpdateStatus(un); err != nil {
+		return err
+	}
+	f.fake.k8sNotifier.Changed()
+	return nil
 }
 
 type fakeWatcher struct {
@@ -542,6 +661,23 @@ func (f *fakeWatcher) Watch(ctx context.Context, resolver *amb.ConsulResolver, s
 	return &fakeStopper{stop}, nil
 }
 
+type fakeDNSWatcher struct {
+	fake  *Fake
+	store *DNSStore
+}
+
+func (f *fakeDNSWatcher) Watch(ctx context.Context, resolver *amb.DNSResolver, svc string, endpoints chan dnswatch.Endpoints) (Stopper, error) {
+	var sent dnswatch.Endpoints
+	stop := f.fake.dnsNotifier.Listen(func() {
+		ep, ok := f.store.Get(resolver.GetName(), stripScheme(svc))
+		if ok && !reflect.DeepEqual(ep, sent) {
+			endpoints <- ep
+			sent = ep
+		}
+	})
+	return &fakeStopper{stop}, nil
+}
+
 type fakeStopper struct {
 	stop StopFunc
 }
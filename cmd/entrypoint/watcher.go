@@ -4,29 +4,46 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"net"
 	"os"
 	"strconv"
 	"sync"
 	"sync/atomic"
 	"time"
 
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 	gw "sigs.k8s.io/gateway-api/apis/v1alpha1"
 
 	"github.com/datawire/dlib/dgroup"
 	"github.com/datawire/dlib/dlog"
-	"github.com/emissary-ingress/emissary/v3/pkg/acp"
 	"github.com/emissary-ingress/emissary/v3/pkg/ambex"
 	"github.com/emissary-ingress/emissary/v3/pkg/debug"
+	"github.com/emissary-ingress/emissary/v3/pkg/dnswatch"
 	ecp_v3_cache "github.com/emissary-ingress/emissary/v3/pkg/envoy-control-plane/cache/v3"
 	"github.com/emissary-ingress/emissary/v3/pkg/gateway"
 	"github.com/emissary-ingress/emissary/v3/pkg/kates"
+	"github.com/emissary-ingress/emissary/v3/pkg/snapshot/history"
 	"github.com/emissary-ingress/emissary/v3/pkg/snapshot/v1"
+	"github.com/emissary-ingress/emissary/v3/pkg/tracing"
 )
 
+// watchStats renders the current kates.WatchStats of a client whenever it is marshalled, so that
+// /debug always shows the live numbers.
+type watchStats struct {
+	client *kates.Client
+}
+
+func (w watchStats) MarshalJSON() ([]byte, error) {
+	return json.Marshal(w.client.WatchStats())
+}
+
 func WatchAllTheThings(
 	ctx context.Context,
-	ambwatch *acp.AmbassadorWatcher,
+	notifier *diagdNotifier,
 	encoded *atomic.Value,
+	hist *history.History,
 	fastpathCh chan<- *ambex.FastpathSnapshot,
 	clusterID string,
 	version string,
@@ -46,6 +63,9 @@ func WatchAllTheThings(
 	}
 	dlog.Infof(ctx, "AMBASSADOR_RECONFIG_MAX_DELAY set to %d", intv)
 
+	// Show the health of our Kubernetes watches (relists, forbidden lists, errors) in /debug.
+	debug.FromContext(ctx).Value("kubernetesWatches").Store(watchStats{client})
+
 	serverTypeList, err := client.ServerResources()
 	if err != nil {
 		// It's possible that an error prevented listing some apigroups, but not all; so
@@ -60,9 +80,10 @@ func WatchAllTheThings(
 
 	// **** SETUP DONE for the Kubernetes Watcher
 
-	notify := func(ctx context.Context, disposition SnapshotDisposition, _ []byte) error {
+	notify := func(ctx context.Context, disposition SnapshotDisposition, snapshotJSON []byte) error {
 		if disposition == SnapshotReady {
-			return notifyReconfigWebhooks(ctx, ambwatch)
+			hist.AddInput(snapshotJSON)
+			return notifier.notify(ctx)
 		}
 		return nil
 	}
@@ -73,6 +94,7 @@ func WatchAllTheThings(
 
 	k8sSrc := newK8sSource(client)
 	consulSrc := watchConsul
+	dnsSrc := watchDNS
 	istioCertSrc := newIstioCertSource()
 
 	return watchAllTheThingsInternal(
@@ -81,7 +103,10 @@ func WatchAllTheThings(
 		k8sSrc,
 		queries,
 		consulSrc, // watchConsulFunc
+		dnsSrc,    // watchDNSFunc
 		istioCertSrc,
+		client,         // statusUpdater
+		client,         // secretGetter
 		notify,         // snapshotProcessor
 		fastpathUpdate, // fastpathProcessor
 		ambassadorMeta,
@@ -186,7 +211,10 @@ func watchAllTheThingsInternal(
 	k8sSrc K8sSource,
 	queries []kates.Query,
 	watchConsulFunc watchConsulFunc,
+	watchDNSFunc watchDNSFunc,
 	istioCertSrc IstioCertSource,
+	statusUpdater StatusUpdater,
+	secretGetter SecretGetter,
 	snapshotProcessor SnapshotProcessor,
 	fastpathProcessor FastpathProcessor,
 	ambassadorMeta *snapshot.AmbassadorMetaInfo,
@@ -211,6 +239,10 @@ func watchAllTheThingsInternal(
 	// consul resolver. We use the ConsulResolver that a given Mapping is configured with to find
 	// the datacenter to query.
 	//
+	// DNSResolvers work the same way: we poll DNS for the services named by Mappings that use a
+	// DNSResolver. Unlike consul, DNS is never waited on before the first snapshot goes out, since
+	// the endpoints it finds only ever reach Envoy via the fastpath.
+	//
 	// The filesystem datasource is for istio secrets. XXX fill in more
 
 	grp := dgroup.NewGroup(ctx, dgroup.GroupConfig{})
@@ -229,20 +261,41 @@ func watchAllTheThingsInternal(
 	}
 	consulWatcher := newConsulWatcher(watchConsulFunc)
 	grp.Go("consul", consulWatcher.run)
+	dnsWatcher := newDNSWatcher(watchDNSFunc)
+	grp.Go("dnsresolver", dnsWatcher.run)
 	istioCertWatcher, err := istioCertSrc.Watch(ctx)
 	if err != nil {
 		return err
 	}
 	istio := newIstioCertWatchManager(ctx, istioCertWatcher)
 
+	// Consul hands us addresses that may need DNS resolution. We never want to do that
+	// synchronously while building endpoints, so the hostResolver does lookups in the background
+	// and tells us when an answer changes.
+	hostResolver := newHostResolver(net.DefaultResolver.LookupHost)
+	grp.Go("dns", hostResolver.run)
+
+	// Some resources (e.g. Knative Ingresses) need us to write their status. That happens in the
+	// background too, since the apiserver can be slow.
+	statusWriter := newStatusWriter(statusUpdater)
+	grp.Go("status", statusWriter.run)
+
 	// SnapshotHolder tracks all the data structures that get updated by the various sources of
 	// information. It also holds the business logic that converts the data as received to a more
 	// amenable form for processing. It not only serves to group these together, but it also
 	// provides a mutex to protect access to the data.
-	snapshots, err := NewSnapshotHolder(ambassadorMeta)
+	snapshots, err := NewSnapshotHolder(
+		ambassadorMeta,
+		hostResolver,
+		statusWriter,
+		secretGetter,
+		readPodLabels(ctx, podLabelsFile),
+		ingressAPIVersion(queries),
+	)
 	if err != nil {
 		return err
 	}
+	grp.Go("secrets", snapshots.secretCache.run)
 
 	// This points to notifyCh when we have updated information to send and nil when we have no new
 	// information. This is deliberately nil to begin with as we have nothing to send yet.
@@ -275,7 +328,7 @@ func watchAllTheThingsInternal(
 			select {
 			case <-k8sWatcher.Changed():
 				// Kubernetes has some changes, so we need to handle them.
-				changed, err := snapshots.K8sUpdate(ctx, k8sWatcher, consulWatcher, fastpathProcessor)
+				changed, err := snapshots.K8sUpdate(ctx, k8sWatcher, consulWatcher, dnsWatcher, fastpathProcessor)
 				if err != nil {
 					return err
 				}
@@ -287,6 +340,20 @@ func watchAllTheThingsInternal(
 				dlog.Debugf(ctx, "WATCHER: Consul fired")
 				snapshots.ConsulUpdate(ctx, consulWatcher, fastpathProcessor)
 				out = notifyCh
+			case <-snapshots.secretCache.changed():
+				dlog.Debugf(ctx, "WATCHER: Secrets fetched")
+				if err := snapshots.SecretsUpdate(ctx); err != nil {
+					return err
+				}
+				out = notifyCh
+			case <-hostResolver.changed():
+				// DNS answers only ever feed endpoints, so there's no snapshot to send.
+				dlog.Debugf(ctx, "WATCHER: DNS fired")
+				snapshots.EndpointsUpdate(ctx, fastpathProcessor)
+			case <-dnsWatcher.changed():
+				// Likewise for DNSResolvers.
+				dlog.Debugf(ctx, "WATCHER: DNSResolver fired")
+				snapshots.DNSUpdate(ctx, dnsWatcher, fastpathProcessor)
 			case icertUpdate := <-istio.Changed():
 				// The Istio cert has some changes, so we need to handle them.
 				if _, err := snapshots.IstioUpdate(ctx, istio, icertUpdate); err != nil {
@@ -329,10 +396,35 @@ type SnapshotHolder struct {
 	// kubernetes snapshot. This is a passthrough of the full stream of deltas reported by kates
 	// which is in turn a facade fo the deltas reported by client-go.
 	unsentDeltas []*kates.Delta
+	// The spans of the K8sUpdates that produced the unsentDeltas, so that the Notify that sends
+	// them can be traced back to them.
+	unsentSpans []trace.SpanContext
 
 	endpointRoutingInfo endpointRoutingInfo
 	dispatcher          *gateway.Dispatcher
 
+	// Resolves the hostnames in consul endpoints without blocking while we hold the mutex.
+	hostResolver *hostResolver
+
+	// The endpoints found by DNSResolvers. These never appear in the snapshot, since the Python
+	// side only needs to know the names of their EDS clusters.
+	dnsEndpoints map[string]dnswatch.Endpoints
+
+	// Writes the status of resources that we're responsible for.
+	statusWriter *statusWriter
+	// The contents of the Secrets we're using, since we only watch their metadata.
+	secretCache *secretCache
+	// Set by ReconcileSecrets when we're using a Secret that the secretCache hasn't fetched yet.
+	secretsPending bool
+	// The getambassador.io/config annotation of every Pod and Deployment that has one, so that
+	// we can tell when a change to one of them actually matters.
+	workloadAnnotations map[objectKey]string
+	// The labels of our own pod, which we need in order to find our own Service.
+	podLabels map[string]string
+	// The apiVersion that we're watching Ingresses in, which is what we have to write their
+	// status in.
+	ingressAPIVersion string
+
 	// Serial number that tracks if we need to send snapshot changes or not. This is incremented
 	// when a change worth sending is made, and we copy it over to snapshotNotifiedCount when the
 	// change is sent.
@@ -343,7 +435,14 @@ type SnapshotHolder struct {
 	firstReconfig bool
 }
 
-func NewSnapshotHolder(ambassadorMeta *snapshot.AmbassadorMetaInfo) (*SnapshotHolder, error) {
+func NewSnapshotHolder(
+	ambassadorMeta *snapshot.AmbassadorMetaInfo,
+	hostResolver *hostResolver,
+	statusWriter *statusWriter,
+	secretGetter SecretGetter,
+	podLabels map[string]string,
+	ingressAPIVersion string,
+) (*SnapshotHolder, error) {
 	disp := gateway.NewDispatcher()
 	err := disp.Register("Gateway", func(untyped kates.Object) (*gateway.CompiledConfig, error) {
 		return gateway.Compile_Gateway(untyped.(*gw.Gateway))
@@ -368,6 +467,13 @@ func NewSnapshotHolder(ambassadorMeta *snapshot.AmbassadorMetaInfo) (*SnapshotHo
 		consulSnapshot:      &snapshot.ConsulSnapshot{},
 		endpointRoutingInfo: newEndpointRoutingInfo(),
 		dispatcher:          disp,
+		hostResolver:        hostResolver,
+		dnsEndpoints:        make(map[string]dnswatch.Endpoints),
+		statusWriter:        statusWriter,
+		secretCache:         newSecretCache(secretGetter),
+		workloadAnnotations: make(map[objectKey]string),
+		podLabels:           podLabels,
+		ingressAPIVersion:   ingressAPIVersion,
 		firstReconfig:       true,
 	}, nil
 }
@@ -377,14 +483,21 @@ func (sh *SnapshotHolder) K8sUpdate(
 	ctx context.Context,
 	watcher K8sWatcher,
 	consulWatcher *consulWatcher,
+	dnsWatcher *dnsWatcher,
 	fastpathProcessor FastpathProcessor,
 ) (bool, error) {
+	ctx, span := tracing.Start(ctx, "SnapshotHolder.K8sUpdate")
+	defer span.End()
+
 	dbg := debug.FromContext(ctx)
 
 	katesUpdateTimer := dbg.Timer("katesUpdate")
 	parseAnnotationsTimer := dbg.Timer("parseAnnotations")
+	reconcileKnativeTimer := dbg.Timer("reconcileKnative")
+	reconcileIngressStatusTimer := dbg.Timer("reconcileIngressStatus")
 	reconcileSecretsTimer := dbg.Timer("reconcileSecrets")
 	reconcileConsulTimer := dbg.Timer("reconcileConsul")
+	reconcileDNSTimer := dbg.Timer("reconcileDNS")
 	reconcileAuthServicesTimer := dbg.Timer("reconcileAuthServices")
 	reconcileRateLimitServicesTimer := dbg.Timer("reconcileRateLimitServices")
 
@@ -412,6 +525,15 @@ func (sh *SnapshotHolder) K8sUpdate(
 			dlog.Errorf(ctx, "[WATCHER]: ERROR calculating changes in an update to the cluster config: %v", err)
 			return false, err
 		}
+		for _, delta := range deltas {
+			if delta.DeltaType == kates.ObjectDelete {
+				sh.validator.forget(ctx, delta)
+			}
+		}
+		if changed && len(deltas) > 0 {
+			deltas = sh.filterWorkloadDeltas(deltas)
+			changed = len(deltas) > 0
+		}
 		if !changed {
 			dlog.Debugf(ctx, "[WATCHER]: K8sUpdate did not detected any change to the resources relevant to this instance of Ambassador")
 			return false, err
@@ -450,6 +572,13 @@ func (sh *SnapshotHolder) K8sUpdate(
 			}
 		})
 
+		reconcileKnativeTimer.Time(func() {
+			ReconcileKnativeIngresses(ctx, sh)
+		})
+		reconcileIngressStatusTimer.Time(func() {
+			ReconcileIngressStatus(ctx, sh)
+		})
+
 		reconcileSecretsTimer.Time(func() {
 			err = ReconcileSecrets(ctx, sh)
 		})
@@ -464,6 +593,13 @@ func (sh *SnapshotHolder) K8sUpdate(
 			dlog.Errorf(ctx, "[WATCHER]: ERROR reconciling Consul resources: %v", err)
 			return false, err
 		}
+		reconcileDNSTimer.Time(func() {
+			err = ReconcileDNS(ctx, dnsWatcher, sh.k8sSnapshot)
+		})
+		if err != nil {
+			dlog.Errorf(ctx, "[WATCHER]: ERROR reconciling DNSResolver resources: %v", err)
+			return false, err
+		}
 		reconcileAuthServicesTimer.Time(func() {
 			err = ReconcileAuthServices(ctx, sh, &deltas)
 		})
@@ -487,6 +623,11 @@ func (sh *SnapshotHolder) K8sUpdate(
 			endpointsChanged = true
 		}
 
+		span.SetAttributes(tracing.DeltaAttributes(deltas)...)
+		if sc := span.SpanContext(); sc.IsValid() {
+			sh.unsentSpans = append(sh.unsentSpans, sc)
+		}
+
 		endpointsOnly := true
 		for _, delta := range deltas {
 			sh.unsentDeltas = append(sh.unsentDeltas, delta)
@@ -500,6 +641,11 @@ func (sh *SnapshotHolder) K8sUpdate(
 				endpointsOnly = false
 			}
 
+			// A StaticEndpointResolver is both configuration and an endpoint source.
+			if delta.Kind == "StaticEndpointResolver" {
+				endpointsChanged = true
+			}
+
 			if sh.dispatcher.IsRegistered(delta.Kind) {
 				dispatcherChanged = true
 				if delta.DeltaType == kates.ObjectDelete {
@@ -512,7 +658,7 @@ func (sh *SnapshotHolder) K8sUpdate(
 		}
 
 		if endpointsChanged || dispatcherChanged {
-			endpoints = makeEndpoints(ctx, sh.k8sSnapshot, sh.consulSnapshot.Endpoints)
+			endpoints = makeEndpoints(ctx, sh.k8sSnapshot, sh.consulSnapshot.Endpoints, sh.hostResolver, sh.dnsEndpoints)
 			for _, gwc := range sh.k8sSnapshot.GatewayClasses {
 				if err := sh.dispatcher.Upsert(gwc); err != nil {
 					// TODO: Should this be more severe?
@@ -544,6 +690,8 @@ func (sh *SnapshotHolder) K8sUpdate(
 	}()
 	if err != nil {
 		dlog.Errorf(ctx, "[WATCHER]: ERROR checking changes from a cluster config update: %v", err)
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
 		return changed, err
 	}
 
@@ -557,6 +705,54 @@ func (sh *SnapshotHolder) K8sUpdate(
 	return changed, nil
 }
 
+// filterWorkloadDeltas drops the deltas for Pods and Deployments whose getambassador.io/config
+// annotation hasn't changed. That annotation is all we watch them for, and they change all the
+// time (every rollout, every Pod that gets scheduled or becomes ready), so nothing else about them
+// is worth reconfiguring for.
+func (sh *SnapshotHolder) filterWorkloadDeltas(deltas []*kates.Delta) []*kates.Delta {
+	var current map[objectKey]string
+	ret := deltas[:0:0]
+	for _, delta := range deltas {
+		if delta.Kind != "Pod" && delta.Kind != "Deployment" {
+			ret = append(ret, delta)
+			continue
+		}
+		if current == nil {
+			current = workloadAnnotations(sh.k8sSnapshot)
+		}
+		key := objectKey{delta.Kind, delta.Namespace, delta.Name}
+		annotation := current[key]
+		if annotation == sh.workloadAnnotations[key] {
+			continue
+		}
+		if annotation == "" {
+			delete(sh.workloadAnnotations, key)
+		} else {
+			sh.workloadAnnotations[key] = annotation
+		}
+		ret = append(ret, delta)
+	}
+	return ret
+}
+
+// workloadAnnotations returns the getambassador.io/config annotation of every Pod and Deployment
+// in k8s that has one.
+func workloadAnnotations(k8s *snapshot.KubernetesSnapshot) map[objectKey]string {
+	ret := make(map[objectKey]string)
+	add := func(kind string, obj kates.Object) {
+		if annotation := obj.GetAnnotations()[snapshot.AnnotationConfigKey]; annotation != "" {
+			ret[objectKey{kind, obj.GetNamespace(), obj.GetName()}] = annotation
+		}
+	}
+	for _, pod := range k8s.Pods {
+		add("Pod", pod)
+	}
+	for _, deployment := range k8s.Deployments {
+		add("Deployment", deployment)
+	}
+	return ret
+}
+
 func (sh *SnapshotHolder) ConsulUpdate(ctx context.Context, consulWatcher *consulWatcher, fastpathProcessor FastpathProcessor) bool {
 	var endpoints *ambex.Endpoints
 	var dispSnapshot *ecp_v3_cache.Snapshot
@@ -564,7 +760,7 @@ func (sh *SnapshotHolder) ConsulUpdate(ctx context.Context, consulWatcher *consu
 		sh.mutex.Lock()
 		defer sh.mutex.Unlock()
 		consulWatcher.update(sh.consulSnapshot)
-		endpoints = makeEndpoints(ctx, sh.k8sSnapshot, sh.consulSnapshot.Endpoints)
+		endpoints = makeEndpoints(ctx, sh.k8sSnapshot, sh.consulSnapshot.Endpoints, sh.hostResolver, sh.dnsEndpoints)
 		_, dispSnapshot = sh.dispatcher.GetSnapshot(ctx)
 	}()
 	fastpathProcessor(ctx, &ambex.FastpathSnapshot{
@@ -574,6 +770,40 @@ func (sh *SnapshotHolder) ConsulUpdate(ctx context.Context, consulWatcher *consu
 	return true
 }
 
+// DNSUpdate picks up new endpoints from DNSResolvers and sends them along via the fastpath.
+func (sh *SnapshotHolder) DNSUpdate(ctx context.Context, dnsWatcher *dnsWatcher, fastpathProcessor FastpathProcessor) {
+	var endpoints *ambex.Endpoints
+	var dispSnapshot *ecp_v3_cache.Snapshot
+	func() {
+		sh.mutex.Lock()
+		defer sh.mutex.Unlock()
+		dnsWatcher.update(sh.dnsEndpoints)
+		endpoints = makeEndpoints(ctx, sh.k8sSnapshot, sh.consulSnapshot.Endpoints, sh.hostResolver, sh.dnsEndpoints)
+		_, dispSnapshot = sh.dispatcher.GetSnapshot(ctx)
+	}()
+	fastpathProcessor(ctx, &ambex.FastpathSnapshot{
+		Endpoints: endpoints,
+		Snapshot:  dispSnapshot,
+	})
+}
+
+// EndpointsUpdate recomputes endpoints without any new input from kubernetes or consul. This is
+// what happens when the hostResolver finishes a DNS lookup that changed an answer.
+func (sh *SnapshotHolder) EndpointsUpdate(ctx context.Context, fastpathProcessor FastpathProcessor) {
+	var endpoints *ambex.Endpoints
+	var dispSnapshot *ecp_v3_cache.Snapshot
+	func() {
+		sh.mutex.Lock()
+		defer sh.mutex.Unlock()
+		endpoints = makeEndpoints(ctx, sh.k8sSnapshot, sh.consulSnapshot.Endpoints, sh.hostResolver, sh.dnsEndpoints)
+		_, dispSnapshot = sh.dispatcher.GetSnapshot(ctx)
+	}()
+	fastpathProcessor(ctx, &ambex.FastpathSnapshot{
+		Endpoints: endpoints,
+		Snapshot:  dispSnapshot,
+	})
+}
+
 func (sh *SnapshotHolder) IstioUpdate(ctx context.Context, istio *istioCertWatchManager,
 	icertUpdate IstioCertUpdate) (bool, error) {
 	dbg := debug.FromContext(ctx)
@@ -600,6 +830,19 @@ func (sh *SnapshotHolder) IstioUpdate(ctx context.Context, istio *istioCertWatch
 	return true, nil
 }
 
+// SecretsUpdate picks up the Secrets that the secretCache has fetched since we last reconciled.
+func (sh *SnapshotHolder) SecretsUpdate(ctx context.Context) error {
+	sh.mutex.Lock()
+	defer sh.mutex.Unlock()
+
+	if err := ReconcileSecrets(ctx, sh); err != nil {
+		dlog.Errorf(ctx, "[WATCHER]: ERROR reconciling Secrets: %v", err)
+		return err
+	}
+	sh.snapshotChangeCount += 1
+	return nil
+}
+
 func (sh *SnapshotHolder) Notify(
 	ctx context.Context,
 	encoded *atomic.Value,
@@ -611,8 +854,11 @@ func (sh *SnapshotHolder) Notify(
 	notifyWebhooksTimer := dbg.Timer("notifyWebhooks")
 
 	// If the change is solely endpoints we don't bother making a snapshot.
+	var enc *encodedSnapshot
 	var snapshotJSON []byte
 	var bootstrapped bool
+	var span trace.Span
+	var deltaAttrs []attribute.KeyValue
 	changed := true
 
 	err := func() error {
@@ -624,23 +870,33 @@ func (sh *SnapshotHolder) Notify(
 			return nil
 		}
 
+		// This is where the changes from however many K8sUpdates come together, so this is
+		// where their traces come together too.
+		deltaAttrs = tracing.DeltaAttributes(sh.unsentDeltas)
+		ctx, span = tracing.StartLinked(ctx, "SnapshotHolder.Notify", sh.unsentSpans,
+			trace.WithAttributes(deltaAttrs...))
+
 		sn := &snapshot.Snapshot{
 			Kubernetes:     sh.k8sSnapshot,
 			Consul:         sh.consulSnapshot,
-			Invalid:        sh.validator.getInvalid(),
+			Invalid:        append(sh.validator.getInvalid(), sh.k8sSnapshot.InvalidAnnotations...),
 			Deltas:         sh.unsentDeltas,
 			AmbassadorMeta: sh.ambassadorMeta,
 		}
 
 		var err error
-		snapshotJSON, err = json.MarshalIndent(sn, "", "  ")
+		enc, err = encodeSnapshot(sn, sh.snapshotChangeCount, sh.snapshotChangeNotified)
 		if err != nil {
 			return err
 		}
+		snapshotJSON = enc.JSON()
 
-		bootstrapped = consulWatcher.isBootstrapped()
+		// A snapshot that's missing Secrets only because we haven't fetched them yet would
+		// configure Envoy without them, so it has to wait for them just like it waits for Consul.
+		bootstrapped = consulWatcher.isBootstrapped() && !sh.secretsPending
 		if bootstrapped {
 			sh.unsentDeltas = nil
+			sh.unsentSpans = nil
 			if sh.firstReconfig {
 				dlog.Debugf(ctx, "WATCHER: Bootstrapped! Computing initial configuration...")
 				sh.firstReconfig = false
@@ -649,6 +905,9 @@ func (sh *SnapshotHolder) Notify(
 		}
 		return nil
 	}()
+	if span != nil {
+		defer span.End()
+	}
 	if err != nil {
 		return err
 	}
@@ -658,7 +917,11 @@ func (sh *SnapshotHolder) Notify(
 
 	if bootstrapped {
 		// ...then stash this snapshot and fire off webhooks.
-		encoded.Store(snapshotJSON)
+		encoded.Store(enc)
+
+		// diagd will turn this snapshot into Envoy configuration and hand that to ambex, which
+		// picks up the trace from here.
+		tracing.BatonFromContext(ctx).Pass(ctx, deltaAttrs...)
 
 		// Finally, use the reconfigure webhooks to let the rest of Ambassador
 		// know about the new configuration.
@@ -0,0 +1,97 @@
+// Package metricssink runs a receiver for Envoy's metrics service. Point Envoys at it with
+// AMBASSADOR_GRPC_METRICS_SINK, and then scrape (or push) all of their stats from one place.
+package metricssink
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/spf13/cobra"
+	"google.golang.org/grpc"
+
+	"github.com/datawire/dlib/dgroup"
+	"github.com/datawire/dlib/dhttp"
+	"github.com/datawire/dlib/dlog"
+	v3metrics "github.com/emissary-ingress/emissary/v3/pkg/api/envoy/service/metrics/v3"
+	sink "github.com/emissary-ingress/emissary/v3/pkg/metricssink"
+)
+
+func Main(ctx context.Context, version string, args ...string) error {
+	var opts sink.Options
+	var grpcAddress, httpAddress string
+	var otlp sink.OTLPExporter
+	var otlpHeaders []string
+
+	cmd := &cobra.Command{
+		Use:           "envoy-metrics-sink",
+		Short:         "collect stats from Envoy's metrics service, and re-export them",
+		Args:          cobra.NoArgs,
+		SilenceErrors: true,
+		SilenceUsage:  true,
+	}
+	cmd.Flags().StringVar(&grpcAddress, "grpc-address", ":8080", "address to listen on for Envoy metrics service streams")
+	cmd.Flags().StringVar(&httpAddress, "http-address", ":8081", "address to serve Prometheus /metrics on")
+	cmd.Flags().DurationVar(&opts.Retention, "retention", sink.DefaultRetention, "how long to keep series that are no longer being reported")
+	cmd.Flags().BoolVar(&opts.CountersAsDeltas, "counters-as-deltas", false, "set this if the Envoys have report_counters_as_deltas set")
+	cmd.Flags().StringVar(&otlp.Endpoint, "otlp-endpoint", "", "OTLP/HTTP metrics URL to push to, e.g. http://otel-collector:4318/v1/metrics (default: don't push)")
+	cmd.Flags().DurationVar(&otlp.Interval, "otlp-interval", 30*time.Second, "how often to push to the OTLP endpoint")
+	cmd.Flags().StringArrayVar(&otlpHeaders, "otlp-header", nil, "`NAME=VALUE` header to send to the OTLP endpoint (may be repeated)")
+
+	cmd.RunE = func(cmd *cobra.Command, _ []string) error {
+		ctx := cmd.Context()
+		dlog.Infof(ctx, "Envoy metrics sink %s starting", version)
+
+		otlp.Headers = http.Header{}
+		for _, header := range otlpHeaders {
+			name, value, ok := strings.Cut(header, "=")
+			if !ok {
+				return fmt.Errorf("invalid --otlp-header %q: expected NAME=VALUE", header)
+			}
+			otlp.Headers.Add(strings.TrimSpace(name), strings.TrimSpace(value))
+		}
+
+		metrics := sink.New(opts)
+		registry := prometheus.NewRegistry()
+		if err := registry.Register(prometheus.NewGoCollector()); err != nil {
+			return err
+		}
+
+		grp := dgroup.NewGroup(ctx, dgroup.GroupConfig{
+			EnableSignalHandling: true,
+		})
+
+		grp.Go("grpc", func(ctx context.Context) error {
+			grpcMux := grpc.NewServer()
+			v3metrics.RegisterMetricsServiceServer(grpcMux, metrics)
+			sc := &dhttp.ServerConfig{
+				Handler: grpcMux,
+			}
+			return sc.ListenAndServe(ctx, grpcAddress)
+		})
+
+		grp.Go("http", func(ctx context.Context) error {
+			mux := http.NewServeMux()
+			mux.Handle("/metrics", promhttp.HandlerFor(prometheus.Gatherers{registry, metrics}, promhttp.HandlerOpts{}))
+			sc := &dhttp.ServerConfig{
+				Handler: mux,
+			}
+			return sc.ListenAndServe(ctx, httpAddress)
+		})
+
+		if otlp.Endpoint != "" {
+			grp.Go("otlp", func(ctx context.Context) error {
+				return otlp.Run(ctx, metrics)
+			})
+		}
+
+		return grp.Wait()
+	}
+
+	cmd.SetArgs(args)
+	return cmd.ExecuteContext(ctx)
+}
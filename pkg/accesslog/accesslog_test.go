@@ -0,0 +1,214 @@
+package accesslog
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/types/known/anypb"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+
+	"github.com/datawire/dlib/dlog"
+	v3core "github.com/emissary-ingress/emissary/v3/pkg/api/envoy/config/core/v3"
+	logdatav3 "github.com/emissary-ingress/emissary/v3/pkg/api/envoy/data/accesslog/v3"
+	alsv3 "github.com/emissary-ingress/emissary/v3/pkg/api/envoy/service/accesslog/v3"
+)
+
+type fakeStream struct {
+	grpc.ServerStream
+	ctx      context.Context
+	messages []*alsv3.StreamAccessLogsMessage
+}
+
+func (f *fakeStream) Context() context.Context { return f.ctx }
+
+func (f *fakeStream) Recv() (*alsv3.StreamAccessLogsMessage, error) {
+	if len(f.messages) == 0 {
+		return nil, io.EOF
+	}
+	msg := f.messages[0]
+	f.messages = f.messages[1:]
+	return msg, nil
+}
+
+func (f *fakeStream) SendAndClose(*alsv3.StreamAccessLogsResponse) error { return nil }
+
+type memorySink struct {
+	entries []Entry
+}
+
+func (m *memorySink) WriteEntry(_ context.Context, entry Entry) error {
+	m.entries = append(m.entries, entry)
+	return nil
+}
+
+func httpEntry(path string, code uint32) *logdatav3.HTTPAccessLogEntry {
+	return &logdatav3.HTTPAccessLogEntry{
+		CommonProperties: &logdatav3.AccessLogCommon{UpstreamCluster: "cluster_quote"},
+		Request: &logdatav3.HTTPRequestProperties{
+			Path:           path,
+			RequestHeaders: map[string]string{"authorization": "Bearer xyzzy", "x-ok": "fine"},
+		},
+		Response: &logdatav3.HTTPResponseProperties{ResponseCode: wrapperspb.UInt32(code)},
+	}
+}
+
+func stream(t *testing.T, messages ...*alsv3.StreamAccessLogsMessage) *fakeStream {
+	return &fakeStream{ctx: dlog.NewTestContext(t, false), messages: messages}
+}
+
+func TestStreamAccessLogs(t *testing.T) {
+	sink := &memorySink{}
+	srv := NewServer(sink, Options{Redact: []string{"request.request_headers.authorization", "no.such.field"}})
+
+	err := srv.StreamAccessLogs(stream(t,
+		&alsv3.StreamAccessLogsMessage{
+			Identifier: &alsv3.StreamAccessLogsMessage_Identifier{
+				Node:    &v3core.Node{Id: "ambassador-a"},
+				LogName: "als",
+			},
+			LogEntries: &alsv3.StreamAccessLogsMessage_HttpLogs{HttpLogs: &alsv3.StreamAccessLogsMessage_HTTPAccessLogEntries{
+				LogEntry: []*logdatav3.HTTPAccessLogEntry{httpEntry("/quote/", 200)},
+			}},
+		},
+		&alsv3.StreamAccessLogsMessage{
+			LogEntries: &alsv3.StreamAccessLogsMessage_TcpLogs{TcpLogs: &alsv3.StreamAccessLogsMessage_TCPAccessLogEntries{
+				LogEntry: []*logdatav3.TCPAccessLogEntry{{
+					ConnectionProperties: &logdatav3.ConnectionProperties{ReceivedBytes: 10, SentBytes: 20},
+				}},
+			}},
+		},
+	))
+	require.NoError(t, err)
+	require.Len(t, sink.entries, 2)
+
+	http := sink.entries[0]
+	assert.Equal(t, "http", http["type"])
+	assert.Equal(t, "als", http["log_name"])
+	assert.Equal(t, "ambassador-a", http["node_id"])
+	assert.Equal(t, "cluster_quote", http["common_properties"].(map[string]interface{})["upstream_cluster"])
+	request := http["request"].(map[string]interface{})
+	assert.Equal(t, "/quote/", request["path"])
+	assert.Equal(t, map[string]interface{}{"authorization": Redacted, "x-ok": "fine"}, request["request_headers"])
+	assert.Equal(t, 200.0, http["response"].(map[string]interface{})["response_code"])
+
+	// The identifier carries over to later messages on the stream.
+	tcp := sink.entries[1]
+	assert.Equal(t, "tcp", tcp["type"])
+	assert.Equal(t, "ambassador-a", tcp["node_id"])
+	assert.Equal(t, "20", tcp["connection_properties"].(map[string]interface{})["sent_bytes"])
+}
+
+func TestSampling(t *testing.T) {
+	sink := &memorySink{}
+	srv := NewServer(sink, Options{SampleRate: 0.5, KeepErrors: true})
+	rolls := []float64{0.1, 0.9, 0.9, 0.4}
+	srv.random = func() float64 {
+		roll := rolls[0]
+		rolls = rolls[1:]
+		return roll
+	}
+
+	err := srv.StreamAccessLogs(stream(t, &alsv3.StreamAccessLogsMessage{
+		LogEntries: &alsv3.StreamAccessLogsMessage_HttpLogs{HttpLogs: &alsv3.StreamAccessLogsMessage_HTTPAccessLogEntries{
+			LogEntry: []*logdatav3.HTTPAccessLogEntry{
+				httpEntry("/kept/", 200),
+				httpEntry("/dropped/", 200),
+				httpEntry("/error/", 503),
+				httpEntry("/dropped/", 404),
+				httpEntry("/kept-too/", 200),
+			},
+		}},
+	}))
+	require.NoError(t, err)
+
+	var paths []string
+	for _, entry := range sink.entries {
+		paths = append(paths, entry["request"].(map[string]interface{})["path"].(string))
+	}
+	// The 503 doesn't use up a roll.
+	assert.Equal(t, []string{"/kept/", "/error/", "/kept-too/"}, paths)
+}
+
+func TestUnknownFilterState(t *testing.T) {
+	entry := httpEntry("/", 200)
+	entry.CommonProperties.FilterStateObjects = map[string]*anypb.Any{
+		"mystery": {TypeUrl: "type.googleapis.com/no.such.Type", Value: []byte("x")},
+	}
+	decoded, err := toEntry(entry)
+	require.NoError(t, err)
+	assert.NotContains(t, decoded["common_properties"], "filter_state_objects")
+
+	// The original is left alone.
+	assert.Len(t, entry.CommonProperties.FilterStateObjects, 1)
+}
+
+func TestJSONSink(t *testing.T) {
+	var buf bytes.Buffer
+	sink := NewJSONSink(&buf)
+	require.NoError(t, sink.WriteEntry(context.Background(), Entry{"type": "tcp"}))
+	require.NoError(t, sink.WriteEntry(context.Background(), Entry{"type": "http"}))
+	assert.Equal(t, "{\"type\":\"tcp\"}\n{\"type\":\"http\"}\n", buf.String())
+	assert.NoError(t, sink.Close())
+}
+
+func TestRotatingFile(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "access.log")
+
+	f, err := OpenRotatingFile(path, 10, 2)
+	require.NoError(t, err)
+	for _, line := range []string{"aaaaaaa\n", "bbbbbbb\n", "ccccccc\n", "ddddddd\n"} {
+		_, err := f.Write([]byte(line))
+		require.NoError(t, err)
+	}
+	require.NoError(t, f.Close())
+
+	read := func(name string) string {
+		bs, err := os.ReadFile(filepath.Join(dir, name))
+		require.NoError(t, err)
+		return string(bs)
+	}
+	assert.Equal(t, "ddddddd\n", read("access.log"))
+	assert.Equal(t, "ccccccc\n", read("access.log.1"))
+	assert.Equal(t, "bbbbbbb\n", read("access.log.2"))
+	_, err = os.Stat(filepath.Join(dir, "access.log.3"))
+	assert.True(t, os.IsNotExist(err))
+
+	// Reopening picks up where it left off.
+	f, err = OpenRotatingFile(path, 10, 0)
+	require.NoError(t, err)
+	_, err = f.Write([]byte("e\n"))
+	require.NoError(t, err)
+	_, err = f.Write([]byte("fffffff\n"))
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+	assert.Equal(t, "fffffff\n", read("access.log"))
+}
+
+func TestOpenSink(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "access.log")
+	sink, err := OpenSink(path, 0, 0)
+	require.NoError(t, err)
+	require.NoError(t, sink.WriteEntry(context.Background(), Entry{"type": "http"}))
+	require.NoError(t, sink.Close())
+
+	bs, err := os.ReadFile(path)
+	require.NoError(t, err)
+	var entry Entry
+	require.NoError(t, json.Unmarshal(bs, &entry))
+	assert.Equal(t, Entry{"type": "http"}, entry)
+
+	sink, err = OpenSink("stdout", 0, 0)
+	require.NoError(t, err)
+	assert.NoError(t, sink.Close())
+	_, err = os.Stdout.Stat()
+	assert.NoError(t, err, "closing the sink mustn't close stdout")
+}
@@ -0,0 +1,189 @@
+// Package accesslog is a receiver for Envoy's gRPC access log service (envoy.service.accesslog.v3),
+// which is what a LogService resource with `service:` pointed at it talks to. Each HTTP or TCP
+// access log entry is turned into a flat, structured Entry (the entry's JSON form, with Envoy's
+// snake_case field names), sampled, redacted, and handed to a Sink.
+package accesslog
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"math/rand"
+	"strings"
+
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/datawire/dlib/dhttp"
+	"github.com/datawire/dlib/dlog"
+	logdatav3 "github.com/emissary-ingress/emissary/v3/pkg/api/envoy/data/accesslog/v3"
+	alsv3 "github.com/emissary-ingress/emissary/v3/pkg/api/envoy/service/accesslog/v3"
+)
+
+// An Entry is one access log entry, as it will be written out. Along with the fields of the
+// Envoy entry, it has:
+//
+//	"type"      "http" or "tcp"
+//	"log_name"  the log_name that the Envoy's access log config has
+//	"node_id"   the ID of the Envoy that sent it
+type Entry map[string]interface{}
+
+// A Sink is somewhere for entries to go. WriteEntry may be called from several streams at once.
+type Sink interface {
+	WriteEntry(ctx context.Context, entry Entry) error
+}
+
+// Redacted is what redacted fields are replaced with.
+const Redacted = "[REDACTED]"
+
+// Options configures a Server.
+type Options struct {
+	// SampleRate is the fraction of entries to keep, from 0 to 1. Zero means 1: keep everything.
+	SampleRate float64
+
+	// KeepErrors keeps every HTTP entry with a 5xx response, regardless of SampleRate.
+	KeepErrors bool
+
+	// Redact lists the fields to replace with Redacted, as dotted paths into the Entry, e.g.
+	// "request.request_headers.authorization" or "common_properties.downstream_remote_address".
+	// Header names are lowercase.
+	Redact []string
+}
+
+// A Server implements alsv3.AccessLogServiceServer by sending entries to a Sink.
+type Server struct {
+	alsv3.UnimplementedAccessLogServiceServer
+
+	sink   Sink
+	opts   Options
+	redact [][]string
+	random func() float64
+}
+
+var _ alsv3.AccessLogServiceServer = (*Server)(nil)
+
+// NewServer returns a Server that sends entries to sink.
+func NewServer(sink Sink, opts Options) *Server {
+	if opts.SampleRate <= 0 || opts.SampleRate > 1 {
+		opts.SampleRate = 1
+	}
+	srv := &Server{
+		sink:   sink,
+		opts:   opts,
+		random: rand.Float64,
+	}
+	for _, path := range opts.Redact {
+		if path = strings.TrimSpace(path); path != "" {
+			srv.redact = append(srv.redact, strings.Split(path, "."))
+		}
+	}
+	return srv
+}
+
+// ListenAndServe serves the access log service on address until ctx is cancelled.
+func (s *Server) ListenAndServe(ctx context.Context, address string) error {
+	grpcMux := grpc.NewServer()
+	alsv3.RegisterAccessLogServiceServer(grpcMux, s)
+	sc := &dhttp.ServerConfig{
+		Handler: grpcMux,
+	}
+	dlog.Infof(ctx, "access log service listening on %s", address)
+	return sc.ListenAndServe(ctx, address)
+}
+
+// StreamAccessLogs implements alsv3.AccessLogServiceServer. Envoy only identifies itself in the
+// first message on a stream, so that's remembered for the rest of them.
+func (s *Server) StreamAccessLogs(stream alsv3.AccessLogService_StreamAccessLogsServer) error {
+	ctx := stream.Context()
+	var nodeID, logName string
+	for {
+		msg, err := stream.Recv()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return stream.SendAndClose(&alsv3.StreamAccessLogsResponse{})
+			}
+			return err
+		}
+		if id := msg.GetIdentifier(); id != nil {
+			nodeID = id.GetNode().GetId()
+			logName = id.GetLogName()
+		}
+
+		for _, entry := range msg.GetHttpLogs().GetLogEntry() {
+			s.handle(ctx, "http", nodeID, logName, entry, s.opts.KeepErrors && entry.GetResponse().GetResponseCode().GetValue() >= 500)
+		}
+		for _, entry := range msg.GetTcpLogs().GetLogEntry() {
+			s.handle(ctx, "tcp", nodeID, logName, entry, false)
+		}
+	}
+}
+
+func (s *Server) handle(ctx context.Context, typ, nodeID, logName string, msg proto.Message, keep bool) {
+	if !keep && s.opts.SampleRate < 1 && s.random() >= s.opts.SampleRate {
+		return
+	}
+	entry, err := toEntry(msg)
+	if err != nil {
+		dlog.Errorf(ctx, "access log: unable to decode %s entry: %v", typ, err)
+		return
+	}
+	entry["type"] = typ
+	entry["log_name"] = logName
+	entry["node_id"] = nodeID
+	for _, path := range s.redact {
+		redact(entry, path)
+	}
+	if err := s.sink.WriteEntry(ctx, entry); err != nil {
+		dlog.Errorf(ctx, "access log: unable to write %s entry: %v", typ, err)
+	}
+}
+
+var marshalOptions = protojson.MarshalOptions{UseProtoNames: true}
+
+func toEntry(msg proto.Message) (Entry, error) {
+	bs, err := marshalOptions.Marshal(msg)
+	if err != nil {
+		// The usual reason for this is a filter state object of a type that we don't know
+		// about, so try again without them.
+		common := commonProperties(msg)
+		if common == nil || len(common.GetFilterStateObjects()) == 0 {
+			return nil, err
+		}
+		msg = proto.Clone(msg)
+		commonProperties(msg).FilterStateObjects = nil
+		if bs, err = marshalOptions.Marshal(msg); err != nil {
+			return nil, err
+		}
+	}
+	var entry Entry
+	if err := json.Unmarshal(bs, &entry); err != nil {
+		return nil, err
+	}
+	return entry, nil
+}
+
+func commonProperties(msg proto.Message) *logdatav3.AccessLogCommon {
+	switch msg := msg.(type) {
+	case *logdatav3.HTTPAccessLogEntry:
+		return msg.GetCommonProperties()
+	case *logdatav3.TCPAccessLogEntry:
+		return msg.GetCommonProperties()
+	}
+	return nil
+}
+
+// redact replaces the field at path, if there is one.
+func redact(entry map[string]interface{}, path []string) {
+	for _, key := range path[:len(path)-1] {
+		child, ok := entry[key].(map[string]interface{})
+		if !ok {
+			return
+		}
+		entry = child
+	}
+	if _, ok := entry[path[len(path)-1]]; ok {
+		entry[path[len(path)-1]] = Redacted
+	}
+}
@@ -0,0 +1,139 @@
+package accesslog
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+)
+
+// A JSONSink writes each entry as a line of JSON.
+type JSONSink struct {
+	mu  sync.Mutex
+	w   io.Writer
+	enc *json.Encoder
+}
+
+var _ Sink = (*JSONSink)(nil)
+
+// NewJSONSink returns a Sink that writes JSON lines to w. Each entry is a single Write, so w can be
+// a RotatingFile.
+func NewJSONSink(w io.Writer) *JSONSink {
+	return &JSONSink{w: w, enc: json.NewEncoder(w)}
+}
+
+// WriteEntry implements Sink.
+func (s *JSONSink) WriteEntry(_ context.Context, entry Entry) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.enc.Encode(entry)
+}
+
+// Close closes the underlying writer, if it can be closed.
+func (s *JSONSink) Close() error {
+	if closer, ok := s.w.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+// A RotatingFile is a file that gets rotated when it gets too big: "access.log" is renamed to
+// "access.log.1", the old "access.log.1" to "access.log.2", and so on, keeping MaxBackups of
+// them. Writes are never split across files.
+type RotatingFile struct {
+	path       string
+	maxSize    int64
+	maxBackups int
+
+	mu   sync.Mutex
+	file *os.File
+	size int64
+}
+
+// OpenRotatingFile opens (or creates) the file at path for appending. A maxSize of zero means
+// the file is never rotated.
+func OpenRotatingFile(path string, maxSize int64, maxBackups int) (*RotatingFile, error) {
+	f := &RotatingFile{path: path, maxSize: maxSize, maxBackups: maxBackups}
+	if err := f.open(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (f *RotatingFile) open() error {
+	file, err := os.OpenFile(f.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	f.file = file
+	f.size = info.Size()
+	return nil
+}
+
+func (f *RotatingFile) rotate() error {
+	if err := f.file.Close(); err != nil {
+		return err
+	}
+	if f.maxBackups > 0 {
+		for i := f.maxBackups - 1; i > 0; i-- {
+			if err := os.Rename(fmt.Sprintf("%s.%d", f.path, i), fmt.Sprintf("%s.%d", f.path, i+1)); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+		}
+		if err := os.Rename(f.path, f.path+".1"); err != nil {
+			return err
+		}
+	} else if err := os.Remove(f.path); err != nil {
+		return err
+	}
+	return f.open()
+}
+
+// Write implements io.Writer.
+func (f *RotatingFile) Write(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.maxSize > 0 && f.size > 0 && f.size+int64(len(p)) > f.maxSize {
+		if err := f.rotate(); err != nil {
+			return 0, fmt.Errorf("rotating %s: %w", f.path, err)
+		}
+	}
+	n, err := f.file.Write(p)
+	f.size += int64(n)
+	return n, err
+}
+
+// Close implements io.Closer.
+func (f *RotatingFile) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.file.Close()
+}
+
+// OpenSink returns a JSONSink for output, which is "stdout", "stderr", or the path of a file to be
+// rotated according to maxSize and maxBackups. Close it when done.
+func OpenSink(output string, maxSize int64, maxBackups int) (*JSONSink, error) {
+	switch output {
+	case "", "stdout", "-":
+		return NewJSONSink(nopCloser{os.Stdout}), nil
+	case "stderr":
+		return NewJSONSink(nopCloser{os.Stderr}), nil
+	}
+	file, err := OpenRotatingFile(output, maxSize, maxBackups)
+	if err != nil {
+		return nil, err
+	}
+	return NewJSONSink(file), nil
+}
+
+// nopCloser keeps JSONSink.Close from closing stdout.
+type nopCloser struct {
+	io.Writer
+}
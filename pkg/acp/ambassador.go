@@ -21,16 +21,44 @@
 //                                                |
 //                                                | (first snapshot is processed)
 //                                                V
-//                                          envoyStarting
-//                                                |
-//                                                | (we got stats from Envoy)
-//                                                V
-//                                          envoyRunning
+//                                          envoyStarting <----------+
+//                                                |                    |
+//                                                | (we got stats      | (Envoy was
+//                                                |  from Envoy)       |  restarted)
+//                                                V                    |
+//                                          envoyRunning --------------+
 //
-// Envoy is currently given 30 seconds to come up after getting its initial
+// Envoy is given 30 seconds by default to come up after getting its initial
 // configuration. This may be the wrong compromise: in practice, Envoy should come
 // up _much_ faster than that, but the idea this code is more about providing a
-// conservative failsafe than providing a finely-tuned hair trigger.
+// conservative failsafe than providing a finely-tuned hair trigger. Big
+// configurations can take Envoy longer to warm up, so SetEnvoyGracePeriod can
+// change it.
+//
+// Whenever Envoy is restarted (hot or otherwise) after that, we go back to
+// envoyStarting with a fresh grace period, since an Envoy that crashed and was
+// started again needs just as long to come up as the first one did.
+//
+// Ambassador has "started" once we first reach envoyRunning, and it stays started
+// after that, even across Envoy restarts: this is what a Kubernetes startupProbe
+// wants to know. With a startupProbe, the liveness probe doesn't start until Envoy
+// is up, so the grace period matters much less.
+//
+// SHUTTING DOWN:
+// Once NoteShuttingDown is called, Ambassador is never ready again, so that Kubernetes
+// stops sending it traffic while Envoy drains. It's still alive, though: killing it
+// would defeat the purpose. That holds even though Envoy's /ready starts failing
+// once we tell Envoy to fail its health checks, and even if diagd goes away first.
+//
+// WARMING:
+// Envoy's /ready says that Envoy is up, not that it has warmed the clusters and
+// listeners that ambex gave it. If SetRequireWarm is on, Ambassador isn't ready
+// until Envoy has at least as many clusters and listeners active as there are in
+// the last configuration that ambex accepted, and isn't warming any. This only
+// gates the initial readiness: once Envoy has warmed up, we stop asking, since
+// later configurations warm alongside the active ones without taking anything
+// away. Only the readiness check (and the health report) asks Envoy about warming,
+// using FetchEnvoyWarm; liveness never depends on it.
 //
 // TESTING HOOKS:
 // Since time plays a role, you can use AmbassadorWatcher.SetFetchTime to change the
@@ -67,8 +95,9 @@ type AmbassadorWatcher struct {
 	// How shall we fetch the current time?
 	fetchTime timeFetcher
 
-	// What's the current Envoy state?
-	state awState
+	// What's the current Envoy state? And has Envoy ever been running?
+	state   awState
+	started bool
 
 	// We encapsulate an EnvoyWatcher and a DiagdWatcher.
 	ew *EnvoyWatcher
@@ -78,6 +107,34 @@ type AmbassadorWatcher struct {
 	// snapshot, we have to hand the snapshot to Envoy and allow Envoy to start
 	// up. This takes finite time, so we have to allow for that.
 	GraceEnd time.Time
+
+	// How long is that grace period?
+	envoyGracePeriod time.Duration
+
+	// Must Envoy have warmed everything in the current configuration before
+	// we're ready? And has it, at least once?
+	requireWarm bool
+	warmed      bool
+
+	// Are we shutting down?
+	shuttingDown bool
+
+	// Which hot restart epoch is Envoy on, how many times has it been restarted,
+	// and when and why was the last time?
+	envoyEpoch             int
+	envoyRestarts          int
+	envoyLastRestart       time.Time
+	envoyLastRestartReason string
+
+	// The latest status of each process that the entrypoint supervises, by name.
+	children map[string]ChildReport
+
+	// The last configuration that ambex accepted, when it did, and the clusters
+	// and listeners that are in it.
+	ambexVersion      string
+	ambexAccepted     time.Time
+	expectedClusters  []string
+	expectedListeners []string
 }
 
 // NewAmbassadorWatcher creates a new AmbassadorWatcher, given a fetcher.
@@ -87,10 +144,11 @@ type AmbassadorWatcher struct {
 func NewAmbassadorWatcher(ew *EnvoyWatcher, dw *DiagdWatcher) *AmbassadorWatcher {
 	return &AmbassadorWatcher{
 		// Default to using time.Now for time. This can be reset later.
-		fetchTime: time.Now,
-		state:     envoyNotStarted,
-		ew:        ew,
-		dw:        dw,
+		fetchTime:        time.Now,
+		state:            envoyNotStarted,
+		ew:               ew,
+		dw:               dw,
+		envoyGracePeriod: 30 * time.Second,
 	}
 }
 
@@ -99,6 +157,18 @@ func (w *AmbassadorWatcher) SetFetchTime(fetchTime timeFetcher) {
 	w.fetchTime = fetchTime
 }
 
+// SetEnvoyGracePeriod will change how long Envoy has to come up after the first
+// snapshot is processed. Set it at instantiation.
+func (w *AmbassadorWatcher) SetEnvoyGracePeriod(dur time.Duration) {
+	w.envoyGracePeriod = dur
+}
+
+// SetRequireWarm will change whether readiness waits for Envoy to warm every cluster
+// and listener in the current configuration. Set it at instantiation.
+func (w *AmbassadorWatcher) SetRequireWarm(requireWarm bool) {
+	w.requireWarm = requireWarm
+}
+
 // FetchEnvoyReady will check whether Envoy's statistics are fetchable.
 func (w *AmbassadorWatcher) FetchEnvoyReady(ctx context.Context) {
 	w.mutex.Lock()
@@ -107,6 +177,17 @@ func (w *AmbassadorWatcher) FetchEnvoyReady(ctx context.Context) {
 	w.ew.FetchEnvoyReady(ctx)
 }
 
+// FetchEnvoyWarm will check what Envoy has warmed, if we care and we don't already
+// know that it has warmed up.
+func (w *AmbassadorWatcher) FetchEnvoyWarm(ctx context.Context) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	if w.requireWarm && !w.warmed && !w.ambexAccepted.IsZero() {
+		w.ew.FetchEnvoyWarm(ctx)
+	}
+}
+
 // NoteSnapshotSent will note that a snapshot has been sent.
 func (w *AmbassadorWatcher) NoteSnapshotSent() {
 	w.mutex.Lock()
@@ -127,18 +208,102 @@ func (w *AmbassadorWatcher) NoteSnapshotProcessed() {
 		// Yes, it is. Note that we're now waiting for Envoy to start...
 		w.state = envoyStarting
 
-		// ...and give Envoy 30 seconds to come up.
-		w.GraceEnd = w.fetchTime().Add(30 * time.Second)
+		// ...and give Envoy time to come up.
+		w.GraceEnd = w.fetchTime().Add(w.envoyGracePeriod)
+	}
+}
+
+// NoteConfigAccepted will note that ambex has accepted a configuration, with the given
+// clusters and listeners, and handed it to Envoy.
+func (w *AmbassadorWatcher) NoteConfigAccepted(version string, clusters, listeners []string) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	w.ambexVersion = version
+	w.ambexAccepted = w.fetchTime()
+	w.expectedClusters = clusters
+	w.expectedListeners = listeners
+}
+
+// NoteShuttingDown will note that Ambassador is shutting down, so it must no longer be
+// considered ready.
+func (w *AmbassadorWatcher) NoteShuttingDown() {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	w.shuttingDown = true
+}
+
+// NoteEnvoyRestart will note that Envoy has been restarted, now at the given hot
+// restart epoch.
+func (w *AmbassadorWatcher) NoteEnvoyRestart(epoch int, reason string) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	w.envoyEpoch = epoch
+	w.envoyRestarts++
+	w.envoyLastRestart = w.fetchTime()
+	w.envoyLastRestartReason = reason
+
+	// Give the new Envoy the same time to come up as the first one had. (If we
+	// haven't processed the first snapshot yet, that'll start the clock.)
+	if w.state != envoyNotStarted {
+		w.state = envoyStarting
+		w.GraceEnd = w.envoyLastRestart.Add(w.envoyGracePeriod)
 	}
 }
 
+// NoteChildStatus will note the latest status of a supervised process.
+func (w *AmbassadorWatcher) NoteChildStatus(status ChildReport) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	if w.children == nil {
+		w.children = make(map[string]ChildReport)
+	}
+	w.children[status.Name] = status
+}
+
+// IsStarted returns true IFF the Ambassador as a whole has started up: diagd has
+// processed the first snapshot, and Envoy has come up with it. Once Ambassador has
+// started, it stays started.
+func (w *AmbassadorWatcher) IsStarted() bool {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	return w.isStarted()
+}
+
+// isStarted is IsStarted for callers that already hold the mutex.
+func (w *AmbassadorWatcher) isStarted() bool {
+	if w.state == envoyStarting && w.ew.IsAlive() {
+		w.state = envoyRunning
+	}
+	if w.state == envoyRunning {
+		w.started = true
+	}
+
+	return w.started
+}
+
 // IsAlive returns true IFF the Ambassador as a whole can be considered alive.
 func (w *AmbassadorWatcher) IsAlive() bool {
 	w.mutex.Lock()
 	defer w.mutex.Unlock()
 
-	// First things first: if diagd isn't alive, Ambassador as a whole is
-	// clearly not alive.
+	return w.isAlive()
+}
+
+// isAlive is IsAlive for callers that already hold the mutex.
+func (w *AmbassadorWatcher) isAlive() bool {
+	// While we're shutting down, we're taking things down on purpose, so failing
+	// the liveness check would only get us killed before Envoy finishes draining.
+	if w.shuttingDown {
+		return true
+	}
+
+	// Otherwise, if diagd isn't alive, Ambassador as a whole is clearly not
+	// alive.
 
 	if !w.dw.IsAlive() {
 		return false
@@ -158,6 +323,7 @@ func (w *AmbassadorWatcher) IsAlive() bool {
 		if w.ew.IsAlive() {
 			// Yes. Remember that it's running...
 			w.state = envoyRunning
+			w.started = true
 
 			// ...and then we're good to go.
 			return true
@@ -184,8 +350,52 @@ func (w *AmbassadorWatcher) IsReady() bool {
 	w.mutex.Lock()
 	defer w.mutex.Unlock()
 
-	// This is much simpler that IsAlive. Ambassador is ready IFF both diagd and
-	// Envoy are ready; that's all there is to it.
+	return w.isReady()
+}
+
+// isReady is IsReady for callers that already hold the mutex.
+func (w *AmbassadorWatcher) isReady() bool {
+	// This is much simpler that IsAlive. Ambassador is ready IFF we're not shutting
+	// down, and both diagd and Envoy are ready (and, if we care, Envoy has warmed
+	// up); that's all there is to it.
+
+	return !w.shuttingDown && w.dw.IsReady() && w.ew.IsReady() && w.isWarm()
+}
+
+// isWarm returns true IFF Envoy has warmed up, or we don't care.
+func (w *AmbassadorWatcher) isWarm() bool {
+	if !w.requireWarm || w.warmed {
+		return true
+	}
 
-	return w.dw.IsReady() && w.ew.IsReady()
+	clusters, listeners, ok := w.cold()
+	if ok && clusters == 0 && listeners == 0 {
+		w.warmed = true
+	}
+	return w.warmed
+}
+
+// cold returns how many clusters and listeners Envoy has yet to warm, as far as we
+// can tell, and false if we can't tell: ambex hasn't accepted a configuration, or we
+// couldn't ask Envoy.
+func (w *AmbassadorWatcher) cold() (clusters, listeners int, ok bool) {
+	if w.ambexAccepted.IsZero() {
+		return 0, 0, false
+	}
+	stats, ok := w.ew.WarmingStats()
+	if !ok {
+		return 0, 0, false
+	}
+
+	// Until Envoy has received the configuration, it has nothing to warm, so
+	// make sure that it has as many active as ambex expects, too.
+	clusters = stats.WarmingClusters
+	if missing := len(w.expectedClusters) - stats.ActiveClusters; missing > clusters {
+		clusters = missing
+	}
+	listeners = stats.WarmingListeners
+	if missing := len(w.expectedListeners) - stats.ActiveListeners; missing > listeners {
+		listeners = missing
+	}
+	return clusters, listeners, true
 }
@@ -1,9 +1,13 @@
 package acp_test
 
 import (
+	"fmt"
+	"strings"
 	"testing"
 	"time"
 
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
 	"github.com/datawire/dlib/dlog"
 	"github.com/datawire/dlib/dtime"
 	"github.com/emissary-ingress/emissary/v3/pkg/acp"
@@ -12,6 +16,7 @@ import (
 type awMetadata struct {
 	t  *testing.T
 	ft *dtime.FakeTime
+	f  *fakeReady
 	aw *acp.AmbassadorWatcher
 }
 
@@ -54,7 +59,7 @@ func newAWMetadata(t *testing.T) *awMetadata {
 	aw := acp.NewAmbassadorWatcher(ew, dw)
 	aw.SetFetchTime(ft.Now)
 
-	return &awMetadata{t: t, ft: ft, aw: aw}
+	return &awMetadata{t: t, ft: ft, f: f, aw: aw}
 }
 
 func TestAmbassadorHappyPath(t *testing.T) {
@@ -130,3 +135,75 @@ func TestAmbassadorNoSnapshots(t *testing.T) {
 	m.stepSec(60)
 	m.check(4, 660, false, false)
 }
+
+func TestAmbassadorMetrics(t *testing.T) {
+	m := newAWMetadata(t)
+
+	expect := func(alive, ready int) {
+		t.Helper()
+		err := testutil.CollectAndCompare(m.aw, strings.NewReader(fmt.Sprintf(`
+# HELP ambassador_alive Whether Ambassador as a whole is alive (1) or not (0), as the liveness probe sees it.
+# TYPE ambassador_alive gauge
+ambassador_alive %d
+# HELP ambassador_ready Whether Ambassador as a whole is ready (1) or not (0), as the readiness probe sees it.
+# TYPE ambassador_ready gauge
+ambassador_ready %d
+`, alive, ready)))
+		if err != nil {
+			t.Error(err)
+		}
+	}
+
+	expect(1, 0)
+
+	m.aw.NoteSnapshotSent()
+	m.aw.NoteSnapshotProcessed()
+	m.aw.FetchEnvoyReady(dlog.NewTestContext(t, false))
+	expect(1, 1)
+}
+
+func TestAmbassadorShuttingDown(t *testing.T) {
+	m := newAWMetadata(t)
+
+	m.aw.NoteSnapshotSent()
+	m.stepSec(10)
+	m.aw.NoteSnapshotProcessed()
+	m.aw.FetchEnvoyReady(dlog.NewTestContext(t, false))
+	m.check(0, 10, true, true)
+
+	// Shutting down makes us unready, but not dead.
+	m.aw.NoteShuttingDown()
+	m.check(1, 10, true, false)
+
+	if report := m.aw.Report(); !report.ShuttingDown || report.Ambassador.Reason != "shutting down" {
+		t.Errorf("Report says shutting_down %t, reason %q", report.ShuttingDown, report.Ambassador.Reason)
+	}
+}
+
+func TestAmbassadorDrainWindow(t *testing.T) {
+	m := newAWMetadata(t)
+	ctx := dlog.NewTestContext(t, false)
+
+	m.aw.NoteSnapshotSent()
+	m.stepSec(10)
+	m.aw.NoteSnapshotProcessed()
+	m.aw.FetchEnvoyReady(ctx)
+	m.check(0, 10, true, true)
+
+	// The shutdown sequence tells Envoy to fail its health checks, so from then on Envoy's
+	// /ready says 503, and Envoy isn't alive as far as its own watcher is concerned...
+	m.aw.NoteShuttingDown()
+	m.f.setMode(Failure)
+	m.aw.FetchEnvoyReady(ctx)
+	m.check(1, 10, true, false)
+
+	// ...but we stay alive for as long as it takes Envoy to drain.
+	for i := 0; i < 10; i++ {
+		m.stepSec(60)
+		m.aw.FetchEnvoyReady(ctx)
+		m.check(2+i, 70+60*i, true, false)
+	}
+	if report := m.aw.Report(); !report.Alive || report.Envoy.Alive {
+		t.Errorf("Report says alive %t, Envoy alive %t", report.Alive, report.Envoy.Alive)
+	}
+}
@@ -9,7 +9,8 @@
 // THE GRACE PERIOD:
 // Much of DiagdWatcher is concerned with feeding a snapshot to diagd for processing,
 // and then noting that processing is done. This can take awhile. Currently, we give
-// diagd _ten minutes_ to get its act together, with the ideas that:
+// diagd _ten minutes_ (by default: see DiagdWatcher.SetGracePeriod) to get its act
+// together, with the ideas that:
 //
 // a. We really don't want to start summarily killing pods when, say, configuration
 //    times go from 30 seconds to 31 seconds, but
@@ -51,15 +52,24 @@ type DiagdWatcher struct {
 	// When did we last hear that diagd had processed a snapshot?
 	LastProcessed time.Time
 
-	// When does our grace period end? The grace period is ten minutes after
-	// the most recent event (boot, or the last time a snapshot was sent).
+	// How many snapshots have we sent since diagd last finished processing one? Since
+	// processing a snapshot makes any older ones moot, this is really just "is diagd behind,
+	// and by how much".
+	Pending int
+
+	// When does our grace period end? The grace period is ten minutes (or
+	// gracePeriod) after the most recent event (boot, or the last time a snapshot
+	// was sent).
 	GraceEnd time.Time
+
+	// How long is the grace period?
+	gracePeriod time.Duration
 }
 
 // NewDiagdWatcher creates a new DiagdWatcher.
 func NewDiagdWatcher() *DiagdWatcher {
-	w := &DiagdWatcher{fetchTime: time.Now}
-	w.setGraceEnd(w.fetchTime(), 10*time.Minute) // initial boot grace period
+	w := &DiagdWatcher{fetchTime: time.Now, gracePeriod: 10 * time.Minute}
+	w.setGraceEnd(w.fetchTime(), w.gracePeriod) // initial boot grace period
 
 	return w
 }
@@ -82,7 +92,15 @@ func (w *DiagdWatcher) SetFetchTime(fetchTime timeFetcher) {
 	w.fetchTime = fetchTime
 
 	// See comment above for why it's OK to reset the boot grace period here.
-	w.setGraceEnd(w.fetchTime(), 10*time.Minute) // RESET boot grace period, see above.
+	w.setGraceEnd(w.fetchTime(), w.gracePeriod) // RESET boot grace period, see above.
+}
+
+// SetGracePeriod will change how long diagd has to process a snapshot _AND RESETS THE
+// BOOT GRACE PERIOD_. Like SetFetchTime, call it at instantiation.
+func (w *DiagdWatcher) SetGracePeriod(dur time.Duration) {
+	w.gracePeriod = dur
+
+	w.setGraceEnd(w.fetchTime(), w.gracePeriod) // RESET boot grace period, see above.
 }
 
 // NoteSnapshotSent marks the time at which we have sent a snapshot.
@@ -92,6 +110,7 @@ func (w *DiagdWatcher) NoteSnapshotSent() {
 
 	// Remember that we've sent a snapshot...
 	w.LastSent = w.fetchTime()
+	w.Pending++
 
 	// ...and reset the grace period IFF we've processed something.
 	//
@@ -101,7 +120,7 @@ func (w *DiagdWatcher) NoteSnapshotSent() {
 	// paranoia, but that's OK.)
 
 	if !w.LastProcessed.IsZero() {
-		w.setGraceEnd(w.LastSent, 10*time.Minute) // Update grace period
+		w.setGraceEnd(w.LastSent, w.gracePeriod) // Update grace period
 	}
 }
 
@@ -110,6 +129,7 @@ func (w *DiagdWatcher) NoteSnapshotProcessed() {
 	w.mutex.Lock()
 	defer w.mutex.Unlock()
 	w.LastProcessed = w.fetchTime()
+	w.Pending = 0
 }
 
 // IsAlive returns true IFF diagd should be considered alive.
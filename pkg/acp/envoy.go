@@ -16,19 +16,27 @@
 // check readiness. The default is EnvoyWatcher.defaultFetcher, which tries to pull
 // readiness from http://localhost:8001/ready.
 //
-// This hook is NOT meant for you to change the fetcher on the fly in a running
-// EnvoyWatcher. Set it at instantiation, then leave it alone. See envoy_test.go
+// Likewise, EnvoyWatcher.SetWarmCheck changes the function that EnvoyWatcher uses
+// to find out what Envoy has warmed. The default reads the cluster_manager and
+// listener_manager stats from the admin interface at http://localhost:8001/stats,
+// which is much cheaper for Envoy than listing every cluster.
+//
+// These hooks are NOT meant for you to change the fetcher on the fly in a running
+// EnvoyWatcher. Set them at instantiation, then leave them alone. See envoy_test.go
 // for more.
 
 package acp
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"net/http"
+	"net/url"
 	"os"
 	"strconv"
+	"strings"
 	"sync"
 	"time"
 
@@ -45,19 +53,41 @@ type EnvoyWatcher struct {
 	// How shall we determine Envoy's readiness?
 	readyCheck envoyFetcher
 
+	// How shall we determine what Envoy has warmed?
+	warmCheck envoyWarmFetcher
+
 	// For default fetcher, the port for /ready endpoint listener
 	defaultReadyURL string
 
+	// For the default warm fetcher, where Envoy's admin interface is
+	defaultAdminURL string
+
+	// How shall we fetch the current time?
+	fetchTime timeFetcher
+
 	// Did the last ready check succeed?
 	LastSucceeded bool
+
+	// When did a ready check last succeed, and last fail? And why did it fail?
+	LastSuccess       time.Time
+	LastFailure       time.Time
+	LastFailureReason string
+
+	// What Envoy had active and warming as of the last warm check (nil if we
+	// don't know), and why that check failed, if it did.
+	warmingStats  *EnvoyWarmingStats
+	LastWarmError string
 }
 
 // NewEnvoyWatcher creates a new EnvoyWatcher, given a fetcher.
 func NewEnvoyWatcher() *EnvoyWatcher {
 	w := &EnvoyWatcher{
 		defaultReadyURL: getDefaultReadyURL(),
+		defaultAdminURL: GetEnvoyAdminURL(),
+		fetchTime:       time.Now,
 	}
 	w.SetReadyCheck(w.defaultFetcher)
+	w.SetWarmCheck(w.defaultWarmFetcher)
 
 	return w
 }
@@ -106,6 +136,73 @@ func (w *EnvoyWatcher) defaultFetcher(ctx context.Context) (*EnvoyFetcherRespons
 	return &EnvoyFetcherResponse{StatusCode: statusCode, Text: text}, nil
 }
 
+// warmingStatsFilter picks out the stats that defaultWarmFetcher needs.
+const warmingStatsFilter = `^(cluster_manager\.(active|warming)_clusters|listener_manager\.total_listeners_(active|warming))$`
+
+// This is the default warm fetcher for the EnvoyWatcher -- it asks Envoy's admin
+// interface how many clusters and listeners are active, and how many are warming.
+func (w *EnvoyWatcher) defaultWarmFetcher(ctx context.Context) (*EnvoyWarmingStats, error) {
+	// Same deal with the timeout as for /ready.
+	tctx, tcancel := context.WithTimeout(ctx, 2*time.Second)
+	defer tcancel()
+
+	var stats struct {
+		Stats []struct {
+			Name  string `json:"name"`
+			Value int    `json:"value"`
+		} `json:"stats"`
+	}
+	path := "/stats?format=json&filter=" + url.QueryEscape(warmingStatsFilter)
+	if err := w.fetchAdminJSON(tctx, path, &stats); err != nil {
+		return nil, err
+	}
+
+	ret := &EnvoyWarmingStats{}
+	found := 0
+	for _, stat := range stats.Stats {
+		switch stat.Name {
+		case "cluster_manager.active_clusters":
+			ret.ActiveClusters = stat.Value
+		case "cluster_manager.warming_clusters":
+			ret.WarmingClusters = stat.Value
+		case "listener_manager.total_listeners_active":
+			ret.ActiveListeners = stat.Value
+		case "listener_manager.total_listeners_warming":
+			ret.WarmingListeners = stat.Value
+		default:
+			continue
+		}
+		found++
+	}
+	if found != 4 {
+		return nil, fmt.Errorf("error fetching /stats: found %d of the 4 warming stats", found)
+	}
+	return ret, nil
+}
+
+// fetchAdminJSON GETs a path from Envoy's admin interface, and decodes the JSON that
+// comes back into dest.
+func (w *EnvoyWatcher) fetchAdminJSON(ctx context.Context, path string, dest interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, w.defaultAdminURL+path, nil)
+	if err != nil {
+		return fmt.Errorf("error creating request: %v", err)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("error fetching %s: %v", req.URL.Path, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("error fetching %s: %s", req.URL.Path, resp.Status)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(dest); err != nil {
+		return fmt.Errorf("error decoding %s: %v", req.URL.Path, err)
+	}
+	return nil
+}
+
 // SetReadyCheck will change the function we use to get check if Envoy is ready. This is
 // here for testing; the assumption is that you'll call it at instantiation if you need
 // to, then leave it alone.
@@ -113,9 +210,21 @@ func (w *EnvoyWatcher) SetReadyCheck(readyCheck envoyFetcher) {
 	w.readyCheck = readyCheck
 }
 
+// SetWarmCheck will change the function we use to find out what Envoy has warmed. Like
+// SetReadyCheck, this is here for testing.
+func (w *EnvoyWatcher) SetWarmCheck(warmCheck envoyWarmFetcher) {
+	w.warmCheck = warmCheck
+}
+
+// SetFetchTime will change the function we use to get the current time.
+func (w *EnvoyWatcher) SetFetchTime(fetchTime timeFetcher) {
+	w.fetchTime = fetchTime
+}
+
 // FetchEnvoyReady will check whether Envoy's ready endpoint is fetchable.
 func (w *EnvoyWatcher) FetchEnvoyReady(ctx context.Context) {
 	succeeded := false
+	reason := ""
 
 	// Actually check if ready...
 	readyResponse, err := w.readyCheck(ctx)
@@ -126,14 +235,58 @@ func (w *EnvoyWatcher) FetchEnvoyReady(ctx context.Context) {
 		// moment, we don't care about the text.)
 		if readyResponse.StatusCode == 200 {
 			succeeded = true
+		} else {
+			reason = fmt.Sprintf("/ready returned %d: %s", readyResponse.StatusCode,
+				strings.TrimSpace(string(readyResponse.Text)))
 		}
 	} else {
 		dlog.Debugf(ctx, "could not fetch Envoy status: %v", err)
+		reason = err.Error()
 	}
 
 	w.mutex.Lock()
 	defer w.mutex.Unlock()
 	w.LastSucceeded = succeeded
+	if succeeded {
+		w.LastSuccess = w.fetchTime()
+	} else {
+		w.LastFailure = w.fetchTime()
+		w.LastFailureReason = reason
+	}
+}
+
+// FetchEnvoyWarm will find out what Envoy has active and warming. If it can't, we
+// forget what we knew: it's better to say that Envoy is cold than to trust an old
+// answer.
+func (w *EnvoyWatcher) FetchEnvoyWarm(ctx context.Context) {
+	stats, err := w.warmCheck(ctx)
+	if err != nil {
+		dlog.Debugf(ctx, "could not fetch Envoy's warming stats: %v", err)
+	}
+
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	w.warmingStats = nil
+	w.LastWarmError = ""
+
+	if err != nil {
+		w.LastWarmError = err.Error()
+		return
+	}
+	w.warmingStats = stats
+}
+
+// WarmingStats returns what Envoy had active and warming as of the last
+// FetchEnvoyWarm, and false if we don't know.
+func (w *EnvoyWatcher) WarmingStats() (EnvoyWarmingStats, bool) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	if w.warmingStats == nil {
+		return EnvoyWarmingStats{}, false
+	}
+	return *w.warmingStats, true
 }
 
 // IsAlive returns true IFF Envoy should be considered alive.
@@ -167,3 +320,21 @@ func getDefaultReadyURL() string {
 	}
 	return fmt.Sprintf("http://localhost:%d/ready", readyPort)
 }
+
+// GetEnvoyAdminURL returns where Envoy's admin interface is: http://localhost:8001, unless
+// AMBASSADOR_ADMIN_PORT says otherwise (which it needs to if the Module's admin_port does).
+func GetEnvoyAdminURL() string {
+	var adminPort uint64
+	var err error
+	strAdminPort := os.Getenv("AMBASSADOR_ADMIN_PORT")
+	if strAdminPort != "" {
+		adminPort, err = strconv.ParseUint(strAdminPort, 10, 16)
+		if err != nil {
+			dlog.Infof(context.Background(), "Unable to parse AMBASSADOR_ADMIN_PORT or port is out of bounds: %s", err)
+		}
+	}
+	if adminPort < 1 {
+		adminPort = 8001
+	}
+	return fmt.Sprintf("http://localhost:%d", adminPort)
+}
@@ -3,6 +3,11 @@ package acp_test
 import (
 	"context"
 	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"regexp"
 	"testing"
 
 	"github.com/datawire/dlib/dlog"
@@ -122,3 +127,51 @@ func TestEnvoySadToHappy(t *testing.T) {
 	m.ew.FetchEnvoyReady(dlog.NewTestContext(t, false))
 	m.check(2, true)
 }
+
+func TestEnvoyDefaultWarmCheck(t *testing.T) {
+	var filter string
+	admin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/stats" {
+			http.NotFound(w, r)
+			return
+		}
+		filter = r.URL.Query().Get("filter")
+		_, _ = w.Write([]byte(`{"stats": [
+			{"name": "cluster_manager.active_clusters", "value": 12},
+			{"name": "cluster_manager.warming_clusters", "value": 2},
+			{"name": "listener_manager.total_listeners_active", "value": 3},
+			{"name": "listener_manager.total_listeners_warming", "value": 0}
+		]}`))
+	}))
+	defer admin.Close()
+	u, err := url.Parse(admin.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("AMBASSADOR_ADMIN_PORT", u.Port())
+
+	ew := acp.NewEnvoyWatcher()
+	ew.FetchEnvoyWarm(dlog.NewTestContext(t, false))
+
+	// We only ask for the stats that we need.
+	for name, wanted := range map[string]bool{
+		"cluster_manager.active_clusters":          true,
+		"cluster_manager.warming_clusters":         true,
+		"listener_manager.total_listeners_active":  true,
+		"listener_manager.total_listeners_warming": true,
+		"cluster_manager.cluster_added":            false,
+		"cluster.foo.warming_clusters":             false,
+	} {
+		if matched, _ := regexp.MatchString(filter, name); matched != wanted {
+			t.Errorf("the warm check's filter %q matched %s: %v", filter, name, matched)
+		}
+	}
+	stats, ok := ew.WarmingStats()
+	expected := acp.EnvoyWarmingStats{ActiveClusters: 12, WarmingClusters: 2, ActiveListeners: 3}
+	if !ok || !reflect.DeepEqual(stats, expected) {
+		t.Errorf("EnvoyWatcher.WarmingStats returned %+v and %v", stats, ok)
+	}
+	if ew.LastWarmError != "" {
+		t.Errorf("EnvoyWatcher.LastWarmError is %q", ew.LastWarmError)
+	}
+}
@@ -0,0 +1,31 @@
+package acp
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	aliveDesc = prometheus.NewDesc("ambassador_alive",
+		"Whether Ambassador as a whole is alive (1) or not (0), as the liveness probe sees it.", nil, nil)
+	readyDesc = prometheus.NewDesc("ambassador_ready",
+		"Whether Ambassador as a whole is ready (1) or not (0), as the readiness probe sees it.", nil, nil)
+)
+
+// Describe implements prometheus.Collector.
+func (w *AmbassadorWatcher) Describe(ch chan<- *prometheus.Desc) {
+	ch <- aliveDesc
+	ch <- readyDesc
+}
+
+// Collect implements prometheus.Collector, reporting IsAlive and IsReady.
+func (w *AmbassadorWatcher) Collect(ch chan<- prometheus.Metric) {
+	ch <- prometheus.MustNewConstMetric(aliveDesc, prometheus.GaugeValue, boolMetric(w.IsAlive()))
+	ch <- prometheus.MustNewConstMetric(readyDesc, prometheus.GaugeValue, boolMetric(w.IsReady()))
+}
+
+func boolMetric(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,294 @@
+package acp
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+// A HealthReport explains what IsAlive and IsReady are saying, and why: it's what you want to
+// look at when a pod is flapping. It's meant to be served as JSON.
+type HealthReport struct {
+	// When the report was made.
+	Time time.Time `json:"time"`
+
+	// What the startup, liveness and readiness probes would say right now.
+	Started bool `json:"started"`
+	Alive   bool `json:"alive"`
+	Ready   bool `json:"ready"`
+
+	// Whether Ambassador is shutting down, which makes it not ready.
+	ShuttingDown bool `json:"shutting_down"`
+
+	// How many snapshots diagd has been sent and hasn't finished processing.
+	PendingSnapshots int `json:"pending_snapshots"`
+
+	Ambassador ComponentReport `json:"ambassador"`
+	Diagd      ComponentReport `json:"diagd"`
+	Envoy      ComponentReport `json:"envoy"`
+	Ambex      AmbexReport     `json:"ambex"`
+
+	// How Envoy has been restarted.
+	EnvoyRestarts EnvoyRestartReport `json:"envoy_restarts"`
+
+	// What Envoy has yet to warm, if readiness waits for it.
+	Warming *WarmingReport `json:"warming,omitempty"`
+
+	// The processes that the entrypoint supervises (diagd and the sidecars), by name.
+	Children []ChildReport `json:"children,omitempty"`
+}
+
+// A ComponentReport is the health of one part of Ambassador. Times that haven't happened yet
+// are left out.
+type ComponentReport struct {
+	Alive bool   `json:"alive"`
+	Ready bool   `json:"ready"`
+	State string `json:"state"`
+
+	// Why the component isn't alive or ready, if it isn't.
+	Reason string `json:"reason,omitempty"`
+
+	LastSuccess *time.Time `json:"last_success,omitempty"`
+	LastFailure *time.Time `json:"last_failure,omitempty"`
+
+	// When the component's current grace period ends, if it has one.
+	GraceEnd *time.Time `json:"grace_end,omitempty"`
+}
+
+// An AmbexReport says whether ambex has accepted a configuration to hand to Envoy.
+type AmbexReport struct {
+	Accepted     bool       `json:"accepted"`
+	Version      string     `json:"version,omitempty"`
+	LastAccepted *time.Time `json:"last_accepted,omitempty"`
+}
+
+// An EnvoyRestartReport says how many times the entrypoint has restarted Envoy, and which hot
+// restart epoch it's on now.
+type EnvoyRestartReport struct {
+	Epoch       int        `json:"epoch"`
+	Restarts    int        `json:"restarts"`
+	LastRestart *time.Time `json:"last_restart,omitempty"`
+	LastReason  string     `json:"last_reason,omitempty"`
+}
+
+// A ChildReport is the status of one supervised process. A child that isn't required can fail
+// without making Ambassador unhealthy, so this is the only place its failures show up.
+type ChildReport struct {
+	Name string `json:"name"`
+
+	// The child's restart policy: "always", "on-failure" or "never".
+	Policy   string `json:"policy"`
+	Required bool   `json:"required"`
+
+	// One of "running", "backoff", "crash-loop", "exited" or "stopped".
+	State    string `json:"state"`
+	Restarts int    `json:"restarts"`
+
+	LastStart      *time.Time `json:"last_start,omitempty"`
+	LastExit       *time.Time `json:"last_exit,omitempty"`
+	LastExitReason string     `json:"last_exit_reason,omitempty"`
+
+	// When the child will be started again, if it's waiting to be.
+	NextStart *time.Time `json:"next_start,omitempty"`
+}
+
+// A WarmingReport says how many clusters and listeners Envoy has yet to warm, before it's warmed
+// up for the first time. After that, we stop asking.
+type WarmingReport struct {
+	Warm          bool `json:"warm"`
+	ColdClusters  int  `json:"cold_clusters,omitempty"`
+	ColdListeners int  `json:"cold_listeners,omitempty"`
+
+	// Why we couldn't ask Envoy, if we couldn't.
+	Error string `json:"error,omitempty"`
+}
+
+// Report returns a HealthReport for the Ambassador as a whole. Like IsAlive, it doesn't talk to
+// Envoy itself: call FetchEnvoyReady first to get Envoy's current state.
+func (w *AmbassadorWatcher) Report() HealthReport {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	now := w.fetchTime()
+	alive := w.isAlive()
+	ready := w.isReady()
+
+	report := HealthReport{
+		Time:    now,
+		Started: w.isStarted(),
+		Alive:   alive,
+		Ready:   ready,
+		Diagd:   w.dw.report(),
+		Envoy:   w.ew.report(),
+		Ambex: AmbexReport{
+			Accepted:     !w.ambexAccepted.IsZero(),
+			Version:      w.ambexVersion,
+			LastAccepted: timePtr(w.ambexAccepted),
+		},
+		EnvoyRestarts: EnvoyRestartReport{
+			Epoch:       w.envoyEpoch,
+			Restarts:    w.envoyRestarts,
+			LastRestart: timePtr(w.envoyLastRestart),
+			LastReason:  w.envoyLastRestartReason,
+		},
+	}
+	report.PendingSnapshots = w.dw.pending()
+	report.ShuttingDown = w.shuttingDown
+
+	for _, child := range w.children {
+		report.Children = append(report.Children, child)
+	}
+	sort.Slice(report.Children, func(i, j int) bool {
+		return report.Children[i].Name < report.Children[j].Name
+	})
+
+	if w.requireWarm {
+		warming := &WarmingReport{Warm: w.isWarm()}
+		if !warming.Warm {
+			warming.ColdClusters, warming.ColdListeners, _ = w.cold()
+			warming.Error = w.ew.lastWarmError()
+		}
+		report.Warming = warming
+	}
+
+	ambassador := ComponentReport{Alive: alive, Ready: ready}
+	switch w.state {
+	case envoyNotStarted:
+		ambassador.State = "envoy-not-started"
+	case envoyStarting:
+		ambassador.State = "envoy-starting"
+		ambassador.GraceEnd = timePtr(w.GraceEnd)
+	case envoyRunning:
+		ambassador.State = "envoy-running"
+	}
+
+	switch {
+	case !report.Diagd.Alive:
+		ambassador.Reason = "diagd is not alive"
+	case !alive:
+		if w.state == envoyStarting {
+			ambassador.Reason = fmt.Sprintf("Envoy did not come up by %s", w.GraceEnd.Format(time.RFC3339))
+		} else {
+			ambassador.Reason = "Envoy is not alive"
+		}
+	case w.shuttingDown:
+		ambassador.Reason = "shutting down"
+	case !report.Diagd.Ready:
+		ambassador.Reason = "diagd is not ready"
+	case !report.Envoy.Ready:
+		if w.state == envoyStarting {
+			ambassador.Reason = fmt.Sprintf("waiting for Envoy to come up (until %s)", w.GraceEnd.Format(time.RFC3339))
+		} else {
+			ambassador.Reason = "Envoy is not ready"
+		}
+	case report.Warming != nil && !report.Warming.Warm:
+		if w.ambexAccepted.IsZero() {
+			ambassador.Reason = "ambex has not accepted a configuration yet"
+		} else {
+			ambassador.Reason = fmt.Sprintf("Envoy has not warmed %d clusters and %d listeners",
+				report.Warming.ColdClusters, report.Warming.ColdListeners)
+		}
+	}
+	report.Ambassador = ambassador
+
+	return report
+}
+
+// report returns a ComponentReport for diagd.
+func (w *DiagdWatcher) report() ComponentReport {
+	alive := w.IsAlive()
+	ready := w.IsReady()
+
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	report := ComponentReport{
+		Alive:       alive,
+		Ready:       ready,
+		LastSuccess: timePtr(w.LastProcessed),
+	}
+
+	switch {
+	case w.LastSent.IsZero():
+		report.State = "waiting-for-snapshot"
+	case w.LastProcessed.IsZero() || !w.LastSent.Before(w.LastProcessed):
+		report.State = "processing"
+	default:
+		report.State = "idle"
+	}
+
+	if report.State != "idle" {
+		report.GraceEnd = timePtr(w.GraceEnd)
+		if !w.withinGracePeriod() {
+			// Falling out of the grace period is the failure.
+			report.LastFailure = timePtr(w.GraceEnd)
+		}
+	}
+
+	switch {
+	case !alive && w.LastSent.IsZero():
+		report.Reason = fmt.Sprintf("no snapshot was sent by %s", w.GraceEnd.Format(time.RFC3339))
+	case !alive:
+		report.Reason = fmt.Sprintf("the snapshot sent at %s was not processed by %s",
+			w.LastSent.Format(time.RFC3339), w.GraceEnd.Format(time.RFC3339))
+	case !ready && w.LastSent.IsZero():
+		report.Reason = "no snapshot has been sent yet"
+	case !ready && w.LastProcessed.IsZero():
+		report.Reason = "the first snapshot has not been processed yet"
+	}
+
+	return report
+}
+
+// pending returns the number of snapshots that diagd has yet to process.
+func (w *DiagdWatcher) pending() int {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	return w.Pending
+}
+
+// report returns a ComponentReport for Envoy.
+func (w *EnvoyWatcher) report() ComponentReport {
+	alive := w.IsAlive()
+	ready := w.IsReady()
+
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	report := ComponentReport{
+		Alive:       alive,
+		Ready:       ready,
+		LastSuccess: timePtr(w.LastSuccess),
+		LastFailure: timePtr(w.LastFailure),
+	}
+
+	switch {
+	case w.LastSuccess.IsZero() && w.LastFailure.IsZero():
+		report.State = "unchecked"
+		report.Reason = "Envoy has not been checked yet"
+	case w.LastSucceeded:
+		report.State = "ready"
+	default:
+		report.State = "not-ready"
+		report.Reason = w.LastFailureReason
+	}
+
+	return report
+}
+
+// lastWarmError returns why the last warm check failed, if it did.
+func (w *EnvoyWatcher) lastWarmError() string {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	return w.LastWarmError
+}
+
+// timePtr returns nil for a zero time, so that it's left out of the JSON.
+func timePtr(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
+}
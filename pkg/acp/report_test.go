@@ -0,0 +1,366 @@
+package acp_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/datawire/dlib/dlog"
+	"github.com/datawire/dlib/dtime"
+	"github.com/emissary-ingress/emissary/v3/pkg/acp"
+)
+
+func TestAmbassadorReport(t *testing.T) {
+	ctx := dlog.NewTestContext(t, false)
+	ft := dtime.NewFakeTime()
+	f := &fakeReady{mode: Failure}
+
+	dw := acp.NewDiagdWatcher()
+	dw.SetFetchTime(ft.Now)
+	ew := acp.NewEnvoyWatcher()
+	ew.SetReadyCheck(f.readyCheck)
+	ew.SetFetchTime(ft.Now)
+	aw := acp.NewAmbassadorWatcher(ew, dw)
+	aw.SetFetchTime(ft.Now)
+	boot := ft.Now()
+
+	// At boot, nothing has happened yet.
+	report := aw.Report()
+	assert.True(t, report.Alive)
+	assert.False(t, report.Ready)
+	assert.Equal(t, "envoy-not-started", report.Ambassador.State)
+	assert.Equal(t, "diagd is not ready", report.Ambassador.Reason)
+	assert.Equal(t, "waiting-for-snapshot", report.Diagd.State)
+	assert.Equal(t, "no snapshot has been sent yet", report.Diagd.Reason)
+	assert.Equal(t, boot.Add(10*time.Minute), *report.Diagd.GraceEnd)
+	assert.Equal(t, "unchecked", report.Envoy.State)
+	assert.False(t, report.Ambex.Accepted)
+
+	bs, err := json.Marshal(report)
+	require.NoError(t, err)
+	assert.NotContains(t, string(bs), "last_success")
+
+	// A snapshot goes out, and diagd is working on it.
+	ft.StepSec(10)
+	aw.NoteSnapshotSent()
+	report = aw.Report()
+	assert.Equal(t, 1, report.PendingSnapshots)
+	assert.Equal(t, "processing", report.Diagd.State)
+	assert.Equal(t, "the first snapshot has not been processed yet", report.Diagd.Reason)
+
+	// diagd's done, so Envoy is starting, but it isn't ready yet.
+	ft.StepSec(10)
+	aw.NoteSnapshotProcessed()
+	aw.FetchEnvoyReady(ctx)
+	report = aw.Report()
+	assert.True(t, report.Alive)
+	assert.False(t, report.Ready)
+	assert.Equal(t, 0, report.PendingSnapshots)
+	assert.Equal(t, "idle", report.Diagd.State)
+	assert.Empty(t, report.Diagd.Reason)
+	assert.Nil(t, report.Diagd.GraceEnd)
+	assert.Equal(t, boot.Add(20*time.Second), *report.Diagd.LastSuccess)
+	assert.Equal(t, "envoy-starting", report.Ambassador.State)
+	assert.Equal(t, boot.Add(50*time.Second), *report.Ambassador.GraceEnd)
+	assert.Contains(t, report.Ambassador.Reason, "waiting for Envoy to come up")
+	assert.Equal(t, "not-ready", report.Envoy.State)
+	assert.Equal(t, "/ready returned 503: Not ready", report.Envoy.Reason)
+	assert.Equal(t, boot.Add(20*time.Second), *report.Envoy.LastFailure)
+
+	// If Envoy never comes up, Ambassador as a whole is dead.
+	ft.StepSec(60)
+	aw.FetchEnvoyReady(ctx)
+	report = aw.Report()
+	assert.False(t, report.Alive)
+	assert.Contains(t, report.Ambassador.Reason, "Envoy did not come up by")
+
+	// But if it does, everything's fine.
+	f.setMode(Happy)
+	aw.NoteConfigAccepted("v1", nil, nil)
+	aw.FetchEnvoyReady(ctx)
+	report = aw.Report()
+	assert.True(t, report.Alive)
+	assert.True(t, report.Ready)
+	assert.Equal(t, "envoy-running", report.Ambassador.State)
+	assert.Empty(t, report.Ambassador.Reason)
+	assert.Equal(t, "ready", report.Envoy.State)
+	assert.Equal(t, boot.Add(80*time.Second), *report.Envoy.LastSuccess)
+	assert.Equal(t, boot.Add(80*time.Second), *report.Envoy.LastFailure)
+	assert.Equal(t, acp.AmbexReport{Accepted: true, Version: "v1", LastAccepted: report.Ambex.LastAccepted}, report.Ambex)
+	assert.Equal(t, boot.Add(80*time.Second), *report.Ambex.LastAccepted)
+}
+
+func TestDiagdReportStuck(t *testing.T) {
+	ft := dtime.NewFakeTime()
+	dw := acp.NewDiagdWatcher()
+	dw.SetFetchTime(ft.Now)
+	ew := acp.NewEnvoyWatcher()
+	ew.SetReadyCheck((&fakeReady{mode: Happy}).readyCheck)
+	aw := acp.NewAmbassadorWatcher(ew, dw)
+	aw.SetFetchTime(ft.Now)
+	boot := ft.Now()
+
+	aw.NoteSnapshotSent()
+	aw.NoteSnapshotProcessed()
+	ft.StepSec(60)
+	aw.NoteSnapshotSent()
+	ft.StepSec(10)
+	aw.NoteSnapshotSent()
+
+	// diagd has had ten minutes since the last of those.
+	ft.StepSec(600)
+	report := aw.Report()
+	assert.False(t, report.Alive)
+	assert.Equal(t, 2, report.PendingSnapshots)
+	assert.Equal(t, "diagd is not alive", report.Ambassador.Reason)
+	assert.Equal(t, "processing", report.Diagd.State)
+	assert.Equal(t, boot.Add(670*time.Second), *report.Diagd.LastFailure)
+	assert.Contains(t, report.Diagd.Reason, "was not processed by")
+}
+
+func TestAmbassadorWarming(t *testing.T) {
+	ctx := dlog.NewTestContext(t, false)
+	ft := dtime.NewFakeTime()
+
+	stats := &acp.EnvoyWarmingStats{}
+	var warmErr error
+	fetches := 0
+	dw := acp.NewDiagdWatcher()
+	dw.SetFetchTime(ft.Now)
+	ew := acp.NewEnvoyWatcher()
+	ew.SetReadyCheck((&fakeReady{mode: Happy}).readyCheck)
+	ew.SetWarmCheck(func(context.Context) (*acp.EnvoyWarmingStats, error) {
+		fetches++
+		if warmErr != nil {
+			return nil, warmErr
+		}
+		ret := *stats
+		return &ret, nil
+	})
+	aw := acp.NewAmbassadorWatcher(ew, dw)
+	aw.SetFetchTime(ft.Now)
+	aw.SetRequireWarm(true)
+
+	aw.NoteSnapshotSent()
+	ft.StepSec(1)
+	aw.NoteSnapshotProcessed()
+	aw.FetchEnvoyReady(ctx)
+	aw.FetchEnvoyWarm(ctx)
+
+	// Envoy is up, but ambex hasn't given it anything yet, so there's nothing to ask about.
+	assert.True(t, aw.IsStarted())
+	assert.False(t, aw.IsReady())
+	assert.Equal(t, "ambex has not accepted a configuration yet", aw.Report().Ambassador.Reason)
+	assert.Equal(t, 0, fetches)
+
+	// Now it has, but Envoy hasn't picked it up yet...
+	aw.NoteConfigAccepted("v1", []string{"cluster_a", "cluster_b"}, []string{"ambassador-listener-8080"})
+	aw.FetchEnvoyWarm(ctx)
+	assert.False(t, aw.IsReady())
+	assert.Equal(t, "Envoy has not warmed 2 clusters and 1 listeners", aw.Report().Ambassador.Reason)
+
+	// ...and then it's still warming some of it.
+	*stats = acp.EnvoyWarmingStats{ActiveClusters: 1, WarmingClusters: 1, WarmingListeners: 1}
+	aw.FetchEnvoyWarm(ctx)
+	assert.False(t, aw.IsReady())
+	report := aw.Report()
+	assert.Equal(t, "Envoy has not warmed 1 clusters and 1 listeners", report.Ambassador.Reason)
+	assert.Equal(t, &acp.WarmingReport{ColdClusters: 1, ColdListeners: 1}, report.Warming)
+
+	// If we can't ask Envoy, we don't assume that nothing's changed.
+	warmErr = errors.New("connection refused")
+	aw.FetchEnvoyWarm(ctx)
+	assert.False(t, aw.IsReady())
+	assert.Equal(t, "connection refused", aw.Report().Warming.Error)
+
+	// Once it's done, we're ready.
+	warmErr = nil
+	*stats = acp.EnvoyWarmingStats{ActiveClusters: 3, ActiveListeners: 1}
+	aw.FetchEnvoyWarm(ctx)
+	assert.True(t, aw.IsReady())
+	assert.Equal(t, &acp.WarmingReport{Warm: true}, aw.Report().Warming)
+
+	// After that, we stop asking: Envoy warms new configurations alongside the old ones.
+	fetched := fetches
+	aw.NoteConfigAccepted("v2", []string{"cluster_a", "cluster_b", "cluster_c", "cluster_d"}, nil)
+	*stats = acp.EnvoyWarmingStats{WarmingClusters: 1}
+	aw.FetchEnvoyWarm(ctx)
+	assert.True(t, aw.IsReady())
+	assert.Equal(t, fetched, fetches)
+
+	// Liveness doesn't care about any of this, and never asks.
+	aw.FetchEnvoyReady(ctx)
+	assert.True(t, aw.IsAlive())
+	assert.Equal(t, fetched, fetches)
+}
+
+func TestAmbassadorWarmingOffLivenessPath(t *testing.T) {
+	ctx := dlog.NewTestContext(t, false)
+	ft := dtime.NewFakeTime()
+
+	fetches := 0
+	dw := acp.NewDiagdWatcher()
+	dw.SetFetchTime(ft.Now)
+	ew := acp.NewEnvoyWatcher()
+	ew.SetReadyCheck((&fakeReady{mode: Happy}).readyCheck)
+	ew.SetWarmCheck(func(context.Context) (*acp.EnvoyWarmingStats, error) {
+		fetches++
+		return &acp.EnvoyWarmingStats{WarmingClusters: 1}, nil
+	})
+	aw := acp.NewAmbassadorWatcher(ew, dw)
+	aw.SetFetchTime(ft.Now)
+	aw.SetRequireWarm(true)
+
+	aw.NoteSnapshotSent()
+	ft.StepSec(1)
+	aw.NoteSnapshotProcessed()
+	aw.NoteConfigAccepted("v1", []string{"cluster_a"}, nil)
+
+	// However long Envoy takes to warm up, the liveness check neither asks about it nor fails
+	// because of it.
+	for i := 0; i < 10; i++ {
+		ft.StepSec(60)
+		aw.FetchEnvoyReady(ctx)
+		assert.True(t, aw.IsAlive())
+		assert.False(t, aw.IsReady())
+	}
+	assert.Equal(t, 0, fetches)
+}
+
+func TestAmbassadorStarted(t *testing.T) {
+	ctx := dlog.NewTestContext(t, false)
+	ft := dtime.NewFakeTime()
+	f := &fakeReady{mode: Failure}
+
+	dw := acp.NewDiagdWatcher()
+	dw.SetFetchTime(ft.Now)
+	dw.SetGracePeriod(time.Minute)
+	ew := acp.NewEnvoyWatcher()
+	ew.SetReadyCheck(f.readyCheck)
+	aw := acp.NewAmbassadorWatcher(ew, dw)
+	aw.SetFetchTime(ft.Now)
+	aw.SetEnvoyGracePeriod(5 * time.Minute)
+
+	assert.False(t, aw.IsStarted())
+
+	aw.NoteSnapshotSent()
+	ft.StepSec(30)
+	aw.NoteSnapshotProcessed()
+	aw.FetchEnvoyReady(ctx)
+	assert.False(t, aw.IsStarted())
+
+	// Envoy gets the longer grace period that it was given...
+	ft.StepSec(240)
+	aw.FetchEnvoyReady(ctx)
+	assert.True(t, aw.IsAlive())
+	assert.False(t, aw.IsStarted())
+
+	// ...and once it's up, we've started for good.
+	f.setMode(Happy)
+	aw.FetchEnvoyReady(ctx)
+	assert.True(t, aw.IsStarted())
+	f.setMode(Failure)
+	aw.FetchEnvoyReady(ctx)
+	assert.True(t, aw.IsStarted())
+	assert.False(t, aw.IsAlive())
+
+	// diagd, meanwhile, has a shorter grace period than usual.
+	aw.NoteSnapshotSent()
+	ft.StepSec(61)
+	assert.False(t, dw.IsAlive())
+}
+
+func TestAmbassadorEnvoyRestarts(t *testing.T) {
+	ft := dtime.NewFakeTime()
+	aw := acp.NewAmbassadorWatcher(acp.NewEnvoyWatcher(), acp.NewDiagdWatcher())
+	aw.SetFetchTime(ft.Now)
+
+	report := aw.Report().EnvoyRestarts
+	assert.Equal(t, acp.EnvoyRestartReport{}, report)
+
+	ft.StepSec(60)
+	aw.NoteEnvoyRestart(1, "a restart was requested")
+	report = aw.Report().EnvoyRestarts
+	assert.Equal(t, 1, report.Epoch)
+	assert.Equal(t, 1, report.Restarts)
+	assert.Equal(t, ft.Now(), *report.LastRestart)
+	assert.Equal(t, "a restart was requested", report.LastReason)
+
+	// Starting over at epoch 0 still counts as a restart.
+	ft.StepSec(60)
+	aw.NoteEnvoyRestart(0, "Envoy exited: exit status 1")
+	report = aw.Report().EnvoyRestarts
+	assert.Equal(t, 0, report.Epoch)
+	assert.Equal(t, 2, report.Restarts)
+	assert.Equal(t, ft.Now(), *report.LastRestart)
+	assert.Equal(t, "Envoy exited: exit status 1", report.LastReason)
+}
+
+func TestAmbassadorEnvoyRestartGracePeriod(t *testing.T) {
+	ctx := dlog.NewTestContext(t, false)
+	ft := dtime.NewFakeTime()
+	f := &fakeReady{mode: Happy}
+	dw := acp.NewDiagdWatcher()
+	dw.SetFetchTime(ft.Now)
+	ew := acp.NewEnvoyWatcher()
+	ew.SetReadyCheck(f.readyCheck)
+	aw := acp.NewAmbassadorWatcher(ew, dw)
+	aw.SetFetchTime(ft.Now)
+
+	aw.NoteSnapshotSent()
+	ft.StepSec(1)
+	aw.NoteSnapshotProcessed()
+	aw.FetchEnvoyReady(ctx)
+	assert.True(t, aw.IsStarted())
+	assert.True(t, aw.IsAlive())
+
+	// An hour later, Envoy crashes, and it takes a while for the new one to come up. That's fine
+	// for as long as the grace period lasts...
+	ft.StepSec(3600)
+	f.setMode(Error)
+	aw.NoteEnvoyRestart(0, "Envoy exited: signal: segmentation fault")
+	ft.StepSec(29)
+	aw.FetchEnvoyReady(ctx)
+	assert.True(t, aw.IsAlive())
+	assert.False(t, aw.IsReady())
+	report := aw.Report()
+	assert.Equal(t, "envoy-starting", report.Ambassador.State)
+	assert.Equal(t, ft.Now().Add(1*time.Second), *report.Ambassador.GraceEnd)
+
+	// ...but no longer. We stay started, though.
+	ft.StepSec(1)
+	aw.FetchEnvoyReady(ctx)
+	assert.False(t, aw.IsAlive())
+	assert.True(t, aw.IsStarted())
+
+	// Every restart gets a grace period of its own.
+	aw.NoteEnvoyRestart(0, "Envoy exited: signal: segmentation fault")
+	assert.True(t, aw.IsAlive())
+	f.setMode(Happy)
+	ft.StepSec(10)
+	aw.FetchEnvoyReady(ctx)
+	assert.True(t, aw.IsAlive())
+	assert.True(t, aw.IsReady())
+	assert.Equal(t, "envoy-running", aw.Report().Ambassador.State)
+}
+
+func TestAmbassadorChildren(t *testing.T) {
+	aw := acp.NewAmbassadorWatcher(acp.NewEnvoyWatcher(), acp.NewDiagdWatcher())
+	assert.Empty(t, aw.Report().Children)
+
+	aw.NoteChildStatus(acp.ChildReport{Name: "sidecar", Policy: "on-failure", State: "running"})
+	aw.NoteChildStatus(acp.ChildReport{Name: "diagd", Policy: "on-failure", Required: true, State: "running"})
+	aw.NoteChildStatus(acp.ChildReport{Name: "sidecar", Policy: "on-failure", State: "backoff", Restarts: 1})
+
+	children := aw.Report().Children
+	require.Len(t, children, 2)
+	assert.Equal(t, "diagd", children[0].Name)
+	assert.Equal(t, "sidecar", children[1].Name)
+	assert.Equal(t, "backoff", children[1].State)
+	assert.Equal(t, 1, children[1].Restarts)
+}
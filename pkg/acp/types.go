@@ -15,6 +15,15 @@ type EnvoyFetcherResponse struct {
 	Text       []byte
 }
 
+// EnvoyWarmingStats is the response from an envoyWarmFetcher: how many clusters and
+// listeners Envoy has active, and how many it's still warming.
+type EnvoyWarmingStats struct {
+	ActiveClusters   int
+	WarmingClusters  int
+	ActiveListeners  int
+	WarmingListeners int
+}
+
 // timeFetcher is a function that returns the current time. We use time.Now
 // unless overridden for testing.
 type timeFetcher func() time.Time
@@ -22,3 +31,7 @@ type timeFetcher func() time.Time
 // envoyFetcher is a function that returns Envoy's stats. We supply a default
 // envoyFetcher, but it can be overridden (usually for testing).
 type envoyFetcher func(ctx context.Context) (*EnvoyFetcherResponse, error)
+
+// envoyWarmFetcher is a function that returns what Envoy has warmed. We supply a
+// default envoyWarmFetcher, but it can be overridden (usually for testing).
+type envoyWarmFetcher func(ctx context.Context) (*EnvoyWarmingStats, error)
@@ -7,6 +7,7 @@ import (
 
 	v3core "github.com/emissary-ingress/emissary/v3/pkg/api/envoy/config/core/v3"
 	v3endpoint "github.com/emissary-ingress/emissary/v3/pkg/api/envoy/config/endpoint/v3"
+	"google.golang.org/protobuf/types/known/wrapperspb"
 )
 
 // The Endpoints struct is how Endpoint data gets communicated to ambex. This is a bit simpler than
@@ -31,17 +32,37 @@ func (e *Endpoints) RoutesString() string {
 	return strings.Join(routes, "\n")
 }
 
-// ToMap_v3 produces a map with the envoy v3 friendly forms of all the endpoint data.
+// ToMap_v3 produces a map with the envoy v3 friendly forms of all the endpoint data. Endpoints
+// are grouped into a locality for each Zone and Priority; endpoints without either all share a
+// single locality-less group at priority 0, which is all there is unless something sets them.
 func (e *Endpoints) ToMap_v3() map[string]*v3endpoint.ClusterLoadAssignment {
+	type localityKey struct {
+		zone     string
+		priority uint32
+	}
 	result := map[string]*v3endpoint.ClusterLoadAssignment{}
 	for name, eps := range e.Entries {
-		var endpoints []*v3endpoint.LbEndpoint
+		var localities []*v3endpoint.LocalityLbEndpoints
+		byKey := map[localityKey]*v3endpoint.LocalityLbEndpoints{}
 		for _, ep := range eps {
-			endpoints = append(endpoints, ep.ToLbEndpoint_v3())
+			key := localityKey{zone: ep.Zone, priority: ep.Priority}
+			locality, ok := byKey[key]
+			if !ok {
+				locality = &v3endpoint.LocalityLbEndpoints{Priority: ep.Priority}
+				if ep.Zone != "" {
+					locality.Locality = &v3core.Locality{Zone: ep.Zone}
+				}
+				byKey[key] = locality
+				localities = append(localities, locality)
+			}
+			locality.LbEndpoints = append(locality.LbEndpoints, ep.ToLbEndpoint_v3())
+		}
+		if len(localities) == 0 {
+			localities = []*v3endpoint.LocalityLbEndpoints{{}}
 		}
 		loadAssignment := &v3endpoint.ClusterLoadAssignment{
 			ClusterName: name,
-			Endpoints:   []*v3endpoint.LocalityLbEndpoints{{LbEndpoints: endpoints}},
+			Endpoints:   localities,
 		}
 		result[name] = loadAssignment
 	}
@@ -54,11 +75,23 @@ type Endpoint struct {
 	Ip          string
 	Port        uint32
 	Protocol    string
+
+	// The rest are optional, and are left empty by sources that have no notion of them.
+
+	// Weight is the load-balancing weight; zero means unweighted.
+	Weight uint32 `json:",omitempty"`
+	// Zone is the locality zone of the endpoint.
+	Zone string `json:",omitempty"`
+	// Priority is the Envoy priority of the endpoint: 0 is the highest, and Envoy expects the
+	// priorities in a cluster to be contiguous.
+	Priority uint32 `json:",omitempty"`
+	// HealthStatus is the name of an envoy HealthStatus, e.g. "UNHEALTHY" or "DRAINING".
+	HealthStatus string `json:",omitempty"`
 }
 
 // ToLBEndpoint_v3 translates to envoy v3 frinedly form of the Endpoint data.
 func (e *Endpoint) ToLbEndpoint_v3() *v3endpoint.LbEndpoint {
-	return &v3endpoint.LbEndpoint{
+	lbEndpoint := &v3endpoint.LbEndpoint{
 		HostIdentifier: &v3endpoint.LbEndpoint_Endpoint{
 			Endpoint: &v3endpoint.Endpoint{
 				Address: &v3core.Address{
@@ -76,4 +109,11 @@ func (e *Endpoint) ToLbEndpoint_v3() *v3endpoint.LbEndpoint {
 			},
 		},
 	}
+	if e.Weight > 0 {
+		lbEndpoint.LoadBalancingWeight = &wrapperspb.UInt32Value{Value: e.Weight}
+	}
+	if e.HealthStatus != "" {
+		lbEndpoint.HealthStatus = v3core.HealthStatus(v3core.HealthStatus_value[e.HealthStatus])
+	}
+	return lbEndpoint
 }
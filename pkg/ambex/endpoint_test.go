@@ -0,0 +1,59 @@
+package ambex
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	v3core "github.com/emissary-ingress/emissary/v3/pkg/api/envoy/config/core/v3"
+)
+
+func TestEndpointsToMap(t *testing.T) {
+	endpoints := &Endpoints{Entries: map[string][]*Endpoint{
+		"k8s/default/foo": {
+			{ClusterName: "k8s/default/foo", Ip: "1.2.3.4", Port: 8080, Protocol: "TCP"},
+			{ClusterName: "k8s/default/foo", Ip: "1.2.3.5", Port: 8080, Protocol: "TCP"},
+		},
+		"dns/srv/db": {
+			{ClusterName: "dns/srv/db", Ip: "10.0.1.1", Port: 5432, Protocol: "TCP", Weight: 60},
+			{ClusterName: "dns/srv/db", Ip: "10.0.1.2", Port: 5432, Protocol: "TCP", Priority: 1},
+			{ClusterName: "dns/srv/db", Ip: "10.0.1.3", Port: 5432, Protocol: "TCP", Weight: 40},
+		},
+		"static/external/db": {
+			{ClusterName: "static/external/db", Ip: "10.0.0.1", Port: 5432, Protocol: "TCP", Weight: 3, Zone: "a"},
+			{ClusterName: "static/external/db", Ip: "10.0.0.2", Port: 5432, Protocol: "TCP", Zone: "b", HealthStatus: "DRAINING"},
+			{ClusterName: "static/external/db", Ip: "10.0.0.3", Port: 5432, Protocol: "TCP", Zone: "a"},
+		},
+	}}
+
+	result := endpoints.ToMap_v3()
+
+	// Without zones, everything goes into one group with no locality, just like always.
+	foo := result["k8s/default/foo"]
+	require.Len(t, foo.Endpoints, 1)
+	assert.Nil(t, foo.Endpoints[0].Locality)
+	require.Len(t, foo.Endpoints[0].LbEndpoints, 2)
+	assert.Nil(t, foo.Endpoints[0].LbEndpoints[0].LoadBalancingWeight)
+	assert.Equal(t, v3core.HealthStatus_UNKNOWN, foo.Endpoints[0].LbEndpoints[0].HealthStatus)
+
+	// With zones, endpoints are grouped by zone in the order the zones first appear.
+	db := result["static/external/db"]
+	require.Len(t, db.Endpoints, 2)
+	assert.Equal(t, "a", db.Endpoints[0].Locality.Zone)
+	require.Len(t, db.Endpoints[0].LbEndpoints, 2)
+	assert.Equal(t, uint32(3), db.Endpoints[0].LbEndpoints[0].LoadBalancingWeight.GetValue())
+	assert.Equal(t, "10.0.0.3", db.Endpoints[0].LbEndpoints[1].GetEndpoint().Address.GetSocketAddress().Address)
+	assert.Equal(t, "b", db.Endpoints[1].Locality.Zone)
+	require.Len(t, db.Endpoints[1].LbEndpoints, 1)
+	assert.Equal(t, v3core.HealthStatus_DRAINING, db.Endpoints[1].LbEndpoints[0].HealthStatus)
+
+	// Endpoints with different priorities go in different groups, too.
+	srv := result["dns/srv/db"]
+	require.Len(t, srv.Endpoints, 2)
+	assert.Equal(t, uint32(0), srv.Endpoints[0].Priority)
+	require.Len(t, srv.Endpoints[0].LbEndpoints, 2)
+	assert.Equal(t, uint32(60), srv.Endpoints[0].LbEndpoints[0].LoadBalancingWeight.GetValue())
+	assert.Equal(t, uint32(1), srv.Endpoints[1].Priority)
+	require.Len(t, srv.Endpoints[1].LbEndpoints, 1)
+}
@@ -53,9 +53,13 @@ import (
 	"strconv"
 	"strings"
 	"syscall"
+	"time"
 
 	// third-party libraries
 	"github.com/fsnotify/fsnotify"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 	"google.golang.org/grpc"
 	"google.golang.org/protobuf/encoding/protojson"
 	"google.golang.org/protobuf/encoding/prototext"
@@ -109,6 +113,7 @@ import (
 	"github.com/datawire/dlib/dgroup"
 	"github.com/datawire/dlib/dhttp"
 	"github.com/datawire/dlib/dlog"
+	"github.com/emissary-ingress/emissary/v3/pkg/tracing"
 )
 
 type Args struct {
@@ -366,6 +371,10 @@ func csDump(ctx context.Context, snapdirPath string, numsnaps int, generation in
 	}
 }
 
+// A SnapshotObserver is told about every snapshot that ambex hands to Envoy, after Envoy has been
+// given it.
+type SnapshotObserver func(version string, snapshot *ecp_v3_cache.Snapshot)
+
 // Get an updated snapshot going.
 func update(
 	ctx context.Context,
@@ -378,6 +387,7 @@ func update(
 	edsEndpointsV3 map[string]*v3endpointconfig.ClusterLoadAssignment,
 	fastpathSnapshot *FastpathSnapshot,
 	updates chan<- Update,
+	observer SnapshotObserver,
 ) error {
 
 	clustersv3 := []ecp_cache_types.Resource{}  // v3.Cluster
@@ -494,6 +504,10 @@ func update(
 	*generation++
 
 	version := fmt.Sprintf("v%d", curgen)
+	trace.SpanFromContext(ctx).SetAttributes(
+		tracing.AmbexGenerationKey.Int(curgen),
+		tracing.AmbexVersionKey.String(version),
+	)
 
 	snapshotResources := map[ecp_v3_resource.Type][]ecp_cache_types.Resource{
 		ecp_v3_resource.EndpointType: endpointsv3,
@@ -522,13 +536,26 @@ func update(
 	dlog.Debugf(ctx, "Created snapshot %s", version)
 	csDump(ctx, snapdirPath, numsnaps, curgen, snapshot)
 
+	queued := time.Now()
 	update := Update{version, func() error {
 		dlog.Debugf(ctx, "Accepting snapshot %s", version)
 
+		// The time that the snapshot spent waiting for the Updater, which is where any
+		// throttling happens.
+		_, waitSpan := tracing.Start(ctx, "ambex.Updater", trace.WithTimestamp(queued))
+		waitSpan.End()
+
+		ctx, span := tracing.Start(ctx, "ambex.SetSnapshot")
+		defer span.End()
 		err = configv3.SetSnapshot(ctx, "test-id", snapshot)
 		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
 			return fmt.Errorf("v3 Snapshot error %q for %+v", err, snapshot)
 		}
+		if observer != nil {
+			observer(version, snapshot)
+		}
 
 		return nil
 	}}
@@ -576,18 +603,21 @@ func (l logAdapterBase) Errorf(format string, args ...interface{}) {
 // OnStreamOpen implements ecp_v3_server.Callbacks.
 func (l logAdapterBase) OnStreamOpen(ctx context.Context, sid int64, stype string) error {
 	dlog.Debugf(ctx, "%v Stream open[%v]: %v", l.prefix, sid, stype)
+	noteStreamOpen("sotw")
 	return nil
 }
 
 // OnStreamClosed implements ecp_v3_server.Callbacks.
 func (l logAdapterBase) OnStreamClosed(sid int64, node *v3core.Node) {
 	dlog.Debugf(context.TODO(), "%v Stream closed[%v]", l.prefix, sid)
+	noteStreamClosed("sotw")
 }
 
 // OnStreamRequest implements ecp_v3_server.Callbacks.
 func (l logAdapterV3) OnStreamRequest(sid int64, req *v3discovery.DiscoveryRequest) error {
 	dlog.Debugf(context.TODO(), "V3 Stream request[%v] for type %s: requesting %d resources", sid, req.TypeUrl, len(req.ResourceNames))
 	dlog.Debugf(context.TODO(), "V3 Stream request[%v] dump: %v", sid, req)
+	noteStreamRequest(req)
 	return nil
 }
 
@@ -600,18 +630,21 @@ func (l logAdapterV3) OnStreamResponse(ctx context.Context, sid int64, req *v3di
 // OnDeltaStreamOpen implements ecp_v3_server.Callbacks.
 func (l logAdapterV3) OnDeltaStreamOpen(ctx context.Context, sid int64, stype string) error {
 	dlog.Debugf(ctx, "%v DeltaStream open[%v]: %v", l.prefix, sid, stype)
+	noteStreamOpen("delta")
 	return nil
 }
 
 // OnDeltaStreamClosed implements ecp_v3_server.Callbacks.
 func (l logAdapterV3) OnDeltaStreamClosed(sid int64, node *v3core.Node) {
 	dlog.Debugf(context.TODO(), "%v DeltaStream closed[%v]", l.prefix, sid)
+	noteStreamClosed("delta")
 }
 
 // OnStreamDeltaRequest implements ecp_v3_server.Callbacks.
 func (l logAdapterV3) OnStreamDeltaRequest(sid int64, req *v3discovery.DeltaDiscoveryRequest) error {
 	dlog.Debugf(context.TODO(), "V3 Stream DeltaRequest[%v] for type %s: subscribing for %d resources", sid, req.TypeUrl, len(req.ResourceNamesSubscribe))
 	dlog.Debugf(context.TODO(), "V3 Stream DeltaRequest[%v] dump: %v", sid, req)
+	noteDeltaStreamRequest(req)
 	return nil
 }
 
@@ -632,11 +665,28 @@ func (l logAdapterV3) OnFetchResponse(req *v3discovery.DiscoveryRequest, res *v3
 	dlog.Debugf(context.TODO(), "V3 Fetch response: %v -> %v", req, res)
 }
 
+// startUpdateSpan starts the span for a call to update. A SIGHUP means that diagd has written new
+// configuration, so that continues the trace that the watcher left with the baton.
+func startUpdateSpan(ctx context.Context, trigger string) (context.Context, trace.Span) {
+	attrs := []attribute.KeyValue{attribute.String("ambassador.ambex.trigger", trigger)}
+	if trigger == "sighup" {
+		var batonAttrs []attribute.KeyValue
+		ctx, batonAttrs = tracing.BatonFromContext(ctx).Take(ctx)
+		attrs = append(attrs, batonAttrs...)
+	}
+	return tracing.Start(ctx, "ambex.update", trace.WithAttributes(attrs...))
+}
+
+// Main runs ambex: it serves Envoy's ADS, and feeds it the configuration in the files in the
+// directories named by rawArgs, and the fastpath snapshots from fastpathCh. Updates are throttled
+// when getUsage reports that memory is tight. Every snapshot that's handed to Envoy is passed to
+// observer, if it isn't nil.
 func Main(
 	ctx context.Context,
 	Version string,
 	getUsage MemoryGetter,
 	fastpathCh <-chan *FastpathSnapshot,
+	observer SnapshotObserver,
 	rawArgs ...string,
 ) error {
 	args, err := parseArgs(ctx, rawArgs...)
@@ -704,8 +754,9 @@ func Main(
 		//
 		// XXX This seems questionable: why do we do this? Envoy isn't currently started until
 		// we have a real configuration...
+		uctx, span := startUpdateSpan(ctx, "initial")
 		err = update(
-			ctx,
+			uctx,
 			args.snapdirPath,
 			args.numsnaps,
 			args.edsBypass,
@@ -715,7 +766,9 @@ func Main(
 			edsEndpointsV3,
 			fastpathSnapshot,
 			updates,
+			observer,
 		)
+		span.End()
 		if err != nil {
 			return err
 		}
@@ -725,8 +778,9 @@ func Main(
 
 			select {
 			case <-sigCh:
+				uctx, span := startUpdateSpan(ctx, "sighup")
 				err := update(
-					ctx,
+					uctx,
 					args.snapdirPath,
 					args.numsnaps,
 					args.edsBypass,
@@ -736,7 +790,9 @@ func Main(
 					edsEndpointsV3,
 					fastpathSnapshot,
 					updates,
+					observer,
 				)
+				span.End()
 				if err != nil {
 					return err
 				}
@@ -746,8 +802,9 @@ func Main(
 					edsEndpointsV3 = fpSnap.Endpoints.ToMap_v3()
 				}
 				fastpathSnapshot = fpSnap
+				uctx, span := startUpdateSpan(ctx, "fastpath")
 				err := update(
-					ctx,
+					uctx,
 					args.snapdirPath,
 					args.numsnaps,
 					args.edsBypass,
@@ -757,14 +814,17 @@ func Main(
 					edsEndpointsV3,
 					fastpathSnapshot,
 					updates,
+					observer,
 				)
+				span.End()
 				if err != nil {
 					return err
 				}
 			case <-watcher.Events:
 				// Non-fastpath update. Just update.
+				uctx, span := startUpdateSpan(ctx, "watch")
 				err := update(
-					ctx,
+					uctx,
 					args.snapdirPath,
 					args.numsnaps,
 					args.edsBypass,
@@ -774,7 +834,9 @@ func Main(
 					edsEndpointsV3,
 					fastpathSnapshot,
 					updates,
+					observer,
 				)
+				span.End()
 				if err != nil {
 					return err
 				}
@@ -0,0 +1,87 @@
+package ambex
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+
+	v3discovery "github.com/emissary-ingress/emissary/v3/pkg/api/envoy/service/discovery/v3"
+)
+
+// Prometheus metrics for ambex. These are package-level, like ambex's own state is in practice:
+// there's only ever one ambex per process. Use RegisterMetrics to make them scrapeable.
+var (
+	snapshotsPushed = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "ambassador_ambex_snapshots_pushed_total",
+		Help: "Envoy configuration snapshots that ambex has pushed to Envoy.",
+	})
+	snapshotsThrottled = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "ambassador_ambex_snapshots_throttled_total",
+		Help: "Envoy configuration snapshots that ambex has held back because memory is constrained.",
+	})
+	staleReconfigsGauge = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "ambassador_ambex_stale_reconfigs",
+		Help: "Envoy configurations pushed within the drain time, which Envoy may still be holding in memory.",
+	})
+
+	xdsStreams = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "ambassador_ambex_xds_streams",
+		Help: "xDS streams currently open to ambex.",
+	}, []string{"kind"}) // "sotw" or "delta"
+	xdsStreamsOpened = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "ambassador_ambex_xds_streams_opened_total",
+		Help: "xDS streams that have been opened to ambex.",
+	}, []string{"kind"})
+	xdsAcks = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "ambassador_ambex_xds_acks_total",
+		Help: "xDS responses that Envoy has accepted.",
+	}, []string{"type_url"})
+	xdsNacks = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "ambassador_ambex_xds_nacks_total",
+		Help: "xDS responses that Envoy has rejected.",
+	}, []string{"type_url"})
+)
+
+// RegisterMetrics registers ambex's Prometheus metrics with the supplied registerer.
+func RegisterMetrics(registerer prometheus.Registerer) error {
+	for _, collector := range []prometheus.Collector{
+		snapshotsPushed,
+		snapshotsThrottled,
+		staleReconfigsGauge,
+		xdsStreams,
+		xdsStreamsOpened,
+		xdsAcks,
+		xdsNacks,
+	} {
+		if err := registerer.Register(collector); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func noteStreamOpen(kind string) {
+	xdsStreams.WithLabelValues(kind).Inc()
+	xdsStreamsOpened.WithLabelValues(kind).Inc()
+}
+
+func noteStreamClosed(kind string) {
+	xdsStreams.WithLabelValues(kind).Dec()
+}
+
+// noteAck counts a request from Envoy as an ACK or a NACK of the previous response. A request
+// without a response nonce isn't responding to anything, so it's neither.
+func noteAck(typeURL, responseNonce string, nack bool) {
+	switch {
+	case nack:
+		xdsNacks.WithLabelValues(typeURL).Inc()
+	case responseNonce != "":
+		xdsAcks.WithLabelValues(typeURL).Inc()
+	}
+}
+
+func noteStreamRequest(req *v3discovery.DiscoveryRequest) {
+	noteAck(req.GetTypeUrl(), req.GetResponseNonce(), req.GetErrorDetail() != nil)
+}
+
+func noteDeltaStreamRequest(req *v3discovery.DeltaDiscoveryRequest) {
+	noteAck(req.GetTypeUrl(), req.GetResponseNonce(), req.GetErrorDetail() != nil)
+}
@@ -0,0 +1,70 @@
+package ambex
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/genproto/googleapis/rpc/status"
+
+	v3discovery "github.com/emissary-ingress/emissary/v3/pkg/api/envoy/service/discovery/v3"
+)
+
+func TestRegisterMetrics(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	require.NoError(t, RegisterMetrics(registry))
+	// Registering twice is a mistake.
+	assert.Error(t, RegisterMetrics(registry))
+}
+
+func TestSnapshotMetrics(t *testing.T) {
+	pushed := testutil.ToFloat64(snapshotsPushed)
+	throttled := testutil.ToFloat64(snapshotsThrottled)
+
+	// At 90% memory usage only one stale config is allowed, so the first update gets pushed and
+	// the rest get throttled.
+	h := newHarness(t)
+	h.setUsage(90)
+	for i := 0; i < 10; i++ {
+		h.update(0)
+	}
+	h.expectUntil(1)
+
+	assert.Eventually(t, func() bool {
+		return testutil.ToFloat64(snapshotsPushed) == pushed+1 &&
+			testutil.ToFloat64(snapshotsThrottled) == throttled+9
+	}, time.Second, 10*time.Millisecond)
+	assert.Equal(t, 1.0, testutil.ToFloat64(staleReconfigsGauge))
+}
+
+func TestXDSMetrics(t *testing.T) {
+	const typeURL = "type.googleapis.com/envoy.config.cluster.v3.Cluster"
+	acks := testutil.ToFloat64(xdsAcks.WithLabelValues(typeURL))
+	nacks := testutil.ToFloat64(xdsNacks.WithLabelValues(typeURL))
+	streams := testutil.ToFloat64(xdsStreams.WithLabelValues("sotw"))
+
+	l := logAdapterV3{logAdapterBase{"V3"}}
+	require.NoError(t, l.OnStreamOpen(context.Background(), 1, typeURL))
+	assert.Equal(t, streams+1, testutil.ToFloat64(xdsStreams.WithLabelValues("sotw")))
+
+	// The first request isn't responding to anything...
+	require.NoError(t, l.OnStreamRequest(1, &v3discovery.DiscoveryRequest{TypeUrl: typeURL}))
+	// ...then Envoy accepts one response...
+	require.NoError(t, l.OnStreamRequest(1, &v3discovery.DiscoveryRequest{TypeUrl: typeURL, ResponseNonce: "1"}))
+	// ...and rejects the next.
+	require.NoError(t, l.OnStreamRequest(1, &v3discovery.DiscoveryRequest{
+		TypeUrl:       typeURL,
+		ResponseNonce: "2",
+		ErrorDetail:   &status.Status{Message: "bad cluster"},
+	}))
+
+	assert.Equal(t, acks+1, testutil.ToFloat64(xdsAcks.WithLabelValues(typeURL)))
+	assert.Equal(t, nacks+1, testutil.ToFloat64(xdsNacks.WithLabelValues(typeURL)))
+
+	l.OnStreamClosed(1, nil)
+	assert.Equal(t, streams, testutil.ToFloat64(xdsStreams.WithLabelValues("sotw")))
+}
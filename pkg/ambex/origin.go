@@ -0,0 +1,186 @@
+package ambex
+
+import (
+	// standard library
+	"sort"
+	"sync"
+
+	// envoy api v3
+	v3cluster "github.com/emissary-ingress/emissary/v3/pkg/api/envoy/config/cluster/v3"
+	v3listener "github.com/emissary-ingress/emissary/v3/pkg/api/envoy/config/listener/v3"
+	v3route "github.com/emissary-ingress/emissary/v3/pkg/api/envoy/config/route/v3"
+
+	// envoy control plane
+	ecp_cache_types "github.com/emissary-ingress/emissary/v3/pkg/envoy-control-plane/cache/types"
+	ecp_v3_cache "github.com/emissary-ingress/emissary/v3/pkg/envoy-control-plane/cache/v3"
+
+	// first-party libraries
+	"github.com/emissary-ingress/emissary/v3/pkg/origin"
+)
+
+// An Explanation describes where one listener, cluster, or route in a snapshot came from.
+type Explanation struct {
+	Type string `json:"type"` // "listener", "cluster", or "route"
+	Name string `json:"name"` // the Envoy name; routes often don't have one
+
+	// For routes, where in the snapshot the route lives, what it matches, and where it sends
+	// traffic.
+	RouteConfig string   `json:"route_config,omitempty"`
+	VirtualHost string   `json:"virtual_host,omitempty"`
+	Match       string   `json:"match,omitempty"`
+	Clusters    []string `json:"clusters,omitempty"`
+
+	Origins []origin.Origin `json:"origins"`
+}
+
+// matches returns whether the explanation is relevant to the given Envoy name: either it's the
+// name of the thing being explained, or the name of something that the thing is part of or
+// refers to.
+func (e Explanation) matches(name string) bool {
+	if e.Name == name || e.RouteConfig == name || e.VirtualHost == name {
+		return true
+	}
+	for _, cluster := range e.Clusters {
+		if cluster == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Explain describes where every listener, cluster, and route in the snapshot came from.
+func Explain(snapshot *ecp_v3_cache.Snapshot) []Explanation {
+	if snapshot == nil {
+		return nil
+	}
+
+	var ret []Explanation
+	for _, res := range sortedResources(snapshot, ecp_cache_types.Listener) {
+		if lst, ok := res.(*v3listener.Listener); ok {
+			ret = append(ret, Explanation{
+				Type:    "listener",
+				Name:    lst.GetName(),
+				Origins: origin.FromMetadata(lst.GetMetadata()),
+			})
+		}
+	}
+	for _, res := range sortedResources(snapshot, ecp_cache_types.Cluster) {
+		if cluster, ok := res.(*v3cluster.Cluster); ok {
+			ret = append(ret, Explanation{
+				Type:    "cluster",
+				Name:    cluster.GetName(),
+				Origins: origin.FromMetadata(cluster.GetMetadata()),
+			})
+		}
+	}
+	for _, res := range sortedResources(snapshot, ecp_cache_types.Route) {
+		rc, ok := res.(*v3route.RouteConfiguration)
+		if !ok {
+			continue
+		}
+		for _, vhost := range rc.GetVirtualHosts() {
+			for _, route := range vhost.GetRoutes() {
+				ret = append(ret, Explanation{
+					Type:        "route",
+					Name:        route.GetName(),
+					RouteConfig: rc.GetName(),
+					VirtualHost: vhost.GetName(),
+					Match:       routeMatchString(route.GetMatch()),
+					Clusters:    routeClusters(route),
+					Origins:     origin.FromMetadata(route.GetMetadata()),
+				})
+			}
+		}
+	}
+	return ret
+}
+
+func sortedResources(snapshot *ecp_v3_cache.Snapshot, typ ecp_cache_types.ResponseType) []ecp_cache_types.Resource {
+	items := snapshot.Resources[typ].Items
+	names := make([]string, 0, len(items))
+	for name := range items {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	ret := make([]ecp_cache_types.Resource, 0, len(names))
+	for _, name := range names {
+		ret = append(ret, items[name].Resource)
+	}
+	return ret
+}
+
+func routeMatchString(match *v3route.RouteMatch) string {
+	switch {
+	case match == nil:
+		return ""
+	case match.GetPrefix() != "":
+		return "prefix " + match.GetPrefix()
+	case match.GetPath() != "":
+		return "path " + match.GetPath()
+	case match.GetSafeRegex() != nil:
+		return "regex " + match.GetSafeRegex().GetRegex()
+	case match.GetConnectMatcher() != nil:
+		return "connect"
+	default:
+		return ""
+	}
+}
+
+func routeClusters(route *v3route.Route) []string {
+	action := route.GetRoute()
+	if action == nil {
+		return nil
+	}
+	if cluster := action.GetCluster(); cluster != "" {
+		return []string{cluster}
+	}
+	var ret []string
+	for _, cluster := range action.GetWeightedClusters().GetClusters() {
+		ret = append(ret, cluster.GetName())
+	}
+	return ret
+}
+
+// An Explainer keeps hold of the snapshot that was most recently handed to Envoy, so that it can
+// be explained. The zero value is ready to use, and a nil *Explainer ignores snapshots and
+// explains nothing.
+type Explainer struct {
+	mu       sync.Mutex
+	version  string
+	snapshot *ecp_v3_cache.Snapshot
+}
+
+// Observe makes the supplied snapshot the one to explain. It is a SnapshotObserver.
+func (e *Explainer) Observe(version string, snapshot *ecp_v3_cache.Snapshot) {
+	if e == nil {
+		return
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.version = version
+	e.snapshot = snapshot
+}
+
+// Explain returns the version of the current snapshot, along with the explanations of everything
+// in it that matches the supplied Envoy name (see Explanation.matches), or of everything in it if
+// the name is empty.
+func (e *Explainer) Explain(name string) (string, []Explanation) {
+	if e == nil {
+		return "", nil
+	}
+	e.mu.Lock()
+	version, snapshot := e.version, e.snapshot
+	e.mu.Unlock()
+
+	explanations := Explain(snapshot)
+	if name == "" {
+		return version, explanations
+	}
+	var ret []Explanation
+	for _, explanation := range explanations {
+		if explanation.matches(name) {
+			ret = append(ret, explanation)
+		}
+	}
+	return version, ret
+}
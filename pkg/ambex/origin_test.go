@@ -0,0 +1,82 @@
+package ambex
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	v3cluster "github.com/emissary-ingress/emissary/v3/pkg/api/envoy/config/cluster/v3"
+	v3listener "github.com/emissary-ingress/emissary/v3/pkg/api/envoy/config/listener/v3"
+	v3route "github.com/emissary-ingress/emissary/v3/pkg/api/envoy/config/route/v3"
+	ecp_cache_types "github.com/emissary-ingress/emissary/v3/pkg/envoy-control-plane/cache/types"
+	ecp_v3_cache "github.com/emissary-ingress/emissary/v3/pkg/envoy-control-plane/cache/v3"
+	ecp_v3_resource "github.com/emissary-ingress/emissary/v3/pkg/envoy-control-plane/resource/v3"
+	"github.com/emissary-ingress/emissary/v3/pkg/origin"
+)
+
+func TestExplain(t *testing.T) {
+	quote := origin.Origin{Kind: "Mapping", Namespace: "default", Name: "quote", Generation: 2}
+	listener := origin.Origin{Kind: "Listener", Namespace: "ambassador", Name: "http"}
+
+	snapshot, err := ecp_v3_cache.NewSnapshot("v1", map[ecp_v3_resource.Type][]ecp_cache_types.Resource{
+		ecp_v3_resource.ListenerType: {
+			&v3listener.Listener{Name: "ambassador-listener-8080", Metadata: origin.Metadata(listener)},
+		},
+		ecp_v3_resource.ClusterType: {
+			&v3cluster.Cluster{Name: "cluster_quote_default", Metadata: origin.Metadata(quote)},
+			&v3cluster.Cluster{Name: "cluster_internal"},
+		},
+		ecp_v3_resource.RouteType: {
+			&v3route.RouteConfiguration{
+				Name: "ambassador-listener-8080-routeconfig-0",
+				VirtualHosts: []*v3route.VirtualHost{{
+					Name: "ambassador-listener-8080-*",
+					Routes: []*v3route.Route{{
+						Match: &v3route.RouteMatch{PathSpecifier: &v3route.RouteMatch_Prefix{Prefix: "/quote/"}},
+						Action: &v3route.Route_Route{Route: &v3route.RouteAction{
+							ClusterSpecifier: &v3route.RouteAction_Cluster{Cluster: "cluster_quote_default"},
+						}},
+						Metadata: origin.Metadata(quote),
+					}},
+				}},
+			},
+		},
+	})
+	require.NoError(t, err)
+
+	explainer := &Explainer{}
+	explainer.Observe("v1", snapshot)
+
+	version, explanations := explainer.Explain("")
+	assert.Equal(t, "v1", version)
+	assert.Equal(t, []Explanation{
+		{Type: "listener", Name: "ambassador-listener-8080", Origins: []origin.Origin{listener}},
+		{Type: "cluster", Name: "cluster_internal"},
+		{Type: "cluster", Name: "cluster_quote_default", Origins: []origin.Origin{quote}},
+		{
+			Type:        "route",
+			RouteConfig: "ambassador-listener-8080-routeconfig-0",
+			VirtualHost: "ambassador-listener-8080-*",
+			Match:       "prefix /quote/",
+			Clusters:    []string{"cluster_quote_default"},
+			Origins:     []origin.Origin{quote},
+		},
+	}, explanations)
+
+	// Asking about a cluster explains the cluster and the routes that use it.
+	_, explanations = explainer.Explain("cluster_quote_default")
+	require.Len(t, explanations, 2)
+	assert.Equal(t, "cluster", explanations[0].Type)
+	assert.Equal(t, "route", explanations[1].Type)
+
+	_, explanations = explainer.Explain("no-such-thing")
+	assert.Empty(t, explanations)
+
+	// A nil Explainer explains nothing.
+	var nilExplainer *Explainer
+	nilExplainer.Observe("v2", snapshot)
+	version, explanations = nilExplainer.Explain("")
+	assert.Equal(t, "", version)
+	assert.Nil(t, explanations)
+}
@@ -131,6 +131,7 @@ func updaterWithTicker(ctx context.Context, updates <-chan Update, getUsage Memo
 		}
 
 		staleReconfigs := len(updateTimes)
+		staleReconfigsGauge.Set(float64(staleReconfigs))
 
 		info.Store(debugInfo{updateTimes, staleReconfigs, maxStaleReconfigs, pushed, disableRatelimiter})
 
@@ -139,6 +140,7 @@ func updaterWithTicker(ctx context.Context, updates <-chan Update, getUsage Memo
 			if !tick {
 				dlog.Warnf(ctx, "Memory Usage: throttling reconfig %+v due to constrained memory with %d stale reconfigs (%d max)",
 					latest.Version, staleReconfigs, maxStaleReconfigs)
+				snapshotsThrottled.Inc()
 			}
 			continue
 		}
@@ -158,6 +160,8 @@ func updaterWithTicker(ctx context.Context, updates <-chan Update, getUsage Memo
 		updateTimes = append(updateTimes, now)
 		dlog.Infof(ctx, "Pushing snapshot %+v", latest.Version)
 		pushed = true
+		snapshotsPushed.Inc()
+		staleReconfigsGauge.Set(float64(len(updateTimes)))
 
 		info.Store(debugInfo{updateTimes, staleReconfigs, maxStaleReconfigs, pushed, disableRatelimiter})
 	}
@@ -35,7 +35,8 @@ func BuildScheme() *runtime.Scheme {
 var crdYAML string
 
 func NewValidator() *kates.Validator {
-	crdObjs, err := kates.ParseManifests(crdYAML)
+	// Parse to Unstructured, so that the Validator sees any CEL rules.
+	crdObjs, err := kates.ParseManifestsToUnstructured(crdYAML)
 	runtimeutil.Must(err)
 	validator, err := kates.NewValidator(nil, crdObjs)
 	runtimeutil.Must(err)
@@ -104,8 +104,152 @@ type ConsulResolverList struct {
 	Items           []ConsulResolver `json:"items"`
 }
 
+// DNSRecordType is the kind of DNS record that a DNSResolver looks up.
+// +kubebuilder:validation:Enum=SRV;A;AAAA
+type DNSRecordType string
+
+const (
+	// SRVRecordType looks up SRV records, which supply the port and weight of
+	// each endpoint, and then the A/AAAA records for each target.
+	SRVRecordType DNSRecordType = "SRV"
+
+	// ARecordType looks up IPv4 addresses.
+	ARecordType DNSRecordType = "A"
+
+	// AAAARecordType looks up IPv6 addresses.
+	AAAARecordType DNSRecordType = "AAAA"
+)
+
+// DNSResolver tells Ambassador to resolve services by polling DNS itself and
+// feeding the results to Envoy as endpoints, rather than having Envoy resolve
+// the service name with a STRICT_DNS cluster. In addition to the AmbassadorID,
+// it needs to know which kind of record to look up, and may be told which
+// nameservers to ask and how often to ask them.
+type DNSResolverSpec struct {
+	AmbassadorID AmbassadorID `json:"ambassador_id,omitempty"`
+
+	// RecordType is the kind of DNS record to look up for each service. If not
+	// set, SRV records are used.
+	RecordType DNSRecordType `json:"recordType,omitempty"`
+
+	// Nameservers is a list of "host:port" nameservers to query. If not set,
+	// the nameservers from the pod's /etc/resolv.conf are used.
+	Nameservers []string `json:"nameservers,omitempty"`
+
+	// DefaultPort is the port to use for A and AAAA records when the service
+	// doesn't name one.
+	//
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:validation:Maximum=65535
+	DefaultPort int32 `json:"defaultPort,omitempty"`
+
+	// MinRefreshInterval is a lower bound on how often records are re-queried,
+	// regardless of their TTL. Defaults to 5s; anything under 1s is treated as 1s.
+	MinRefreshInterval *metav1.Duration `json:"minRefreshInterval,omitempty"`
+
+	// MaxRefreshInterval is an upper bound on how long records are trusted,
+	// regardless of their TTL. Defaults to 5m.
+	MaxRefreshInterval *metav1.Duration `json:"maxRefreshInterval,omitempty"`
+}
+
+// DNSResolver is the Schema for the DNSResolver API
+//
+// +kubebuilder:object:root=true
+// +kubebuilder:printcolumn:name="RecordType",type=string,JSONPath=`.spec.recordType`
+// +kubebuilder:storageversion
+type DNSResolver struct {
+	metav1.TypeMeta   `json:""`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec DNSResolverSpec `json:"spec,omitempty"`
+}
+
+// DNSResolverList contains a list of DNSResolvers.
+//
+// +kubebuilder:object:root=true
+type DNSResolverList struct {
+	metav1.TypeMeta `json:""`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []DNSResolver `json:"items"`
+}
+
+// StaticEndpointHealth is the health that a StaticEndpointResolver reports to
+// Envoy for an endpoint.
+// +kubebuilder:validation:Enum=Healthy;Unhealthy;Draining;Unknown
+type StaticEndpointHealth string
+
+const (
+	StaticEndpointHealthy   StaticEndpointHealth = "Healthy"
+	StaticEndpointUnhealthy StaticEndpointHealth = "Unhealthy"
+	StaticEndpointDraining  StaticEndpointHealth = "Draining"
+	StaticEndpointUnknown   StaticEndpointHealth = "Unknown"
+)
+
+// StaticEndpoint is a single upstream address of a StaticEndpointService.
+type StaticEndpoint struct {
+	// Address is the IP address of the endpoint.
+	Address string `json:"address"`
+
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:validation:Maximum=65535
+	Port int32 `json:"port"`
+
+	// Weight is the load-balancing weight of the endpoint relative to the other
+	// endpoints of the service. If not set, all endpoints are weighted equally.
+	//
+	// +kubebuilder:validation:Minimum=1
+	Weight int32 `json:"weight,omitempty"`
+
+	// Zone is the locality zone of the endpoint, for zone-aware routing.
+	Zone string `json:"zone,omitempty"`
+
+	// Health lets an endpoint be taken out of rotation (Unhealthy) or drained
+	// (Draining) without deleting it. If not set, Envoy's own health checking
+	// decides.
+	Health StaticEndpointHealth `json:"health,omitempty"`
+}
+
+// StaticEndpointService is a named set of endpoints. Mappings refer to it by
+// using the name as their service.
+type StaticEndpointService struct {
+	Name      string           `json:"name"`
+	Endpoints []StaticEndpoint `json:"endpoints,omitempty"`
+}
+
+// StaticEndpointResolver tells Ambassador to resolve services using the
+// endpoints listed right in the resolver, for upstreams that live outside the
+// cluster. In addition to the AmbassadorID, it has a list of services and
+// their endpoints.
+type StaticEndpointResolverSpec struct {
+	AmbassadorID AmbassadorID `json:"ambassador_id,omitempty"`
+
+	Services []StaticEndpointService `json:"services,omitempty"`
+}
+
+// StaticEndpointResolver is the Schema for the StaticEndpointResolver API
+//
+// +kubebuilder:object:root=true
+// +kubebuilder:storageversion
+type StaticEndpointResolver struct {
+	metav1.TypeMeta   `json:""`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec StaticEndpointResolverSpec `json:"spec,omitempty"`
+}
+
+// StaticEndpointResolverList contains a list of StaticEndpointResolvers.
+//
+// +kubebuilder:object:root=true
+type StaticEndpointResolverList struct {
+	metav1.TypeMeta `json:""`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []StaticEndpointResolver `json:"items"`
+}
+
 func init() {
 	SchemeBuilder.Register(&KubernetesServiceResolver{}, &KubernetesServiceResolverList{})
 	SchemeBuilder.Register(&KubernetesEndpointResolver{}, &KubernetesEndpointResolverList{})
 	SchemeBuilder.Register(&ConsulResolver{}, &ConsulResolverList{})
+	SchemeBuilder.Register(&DNSResolver{}, &DNSResolverList{})
+	SchemeBuilder.Register(&StaticEndpointResolver{}, &StaticEndpointResolverList{})
 }
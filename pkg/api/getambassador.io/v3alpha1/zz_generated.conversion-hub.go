@@ -11,6 +11,8 @@ func (*RateLimitService) Hub()           {}
 func (*KubernetesServiceResolver) Hub()  {}
 func (*KubernetesEndpointResolver) Hub() {}
 func (*ConsulResolver) Hub()             {}
+func (*DNSResolver) Hub()                {}
+func (*StaticEndpointResolver) Hub()     {}
 func (*TCPMapping) Hub()                 {}
 func (*TLSContext) Hub()                 {}
 func (*TracingService) Hub()             {}
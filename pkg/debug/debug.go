@@ -8,6 +8,8 @@ import (
 	"sync"
 	"sync/atomic"
 	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
 )
 
 // This struct serves as the root of all runtime debug info for the process. This consists of timers
@@ -19,6 +21,9 @@ type Debug struct {
 	values map[string]*Value // holds the debug values.
 
 	clock ClockFunc // clock function to pass to all the timers
+
+	// Every timer also feeds this histogram, so that the timers can be scraped by Prometheus.
+	durations *prometheus.HistogramVec
 }
 
 // An atomic.Value with custom json marshalling.
@@ -57,7 +62,18 @@ func NewDebug() *Debug {
 
 // Create a new set of debug info with the specified clock function.
 func NewDebugWithClock(clock ClockFunc) *Debug {
-	return &Debug{clock: clock, timers: map[string]*Timer{}, values: map[string]*Value{}}
+	return &Debug{
+		clock:  clock,
+		timers: map[string]*Timer{},
+		values: map[string]*Value{},
+		durations: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name: "ambassador_debug_timer_duration_seconds",
+			Help: "How long the actions timed by each debug timer took.",
+			// Our timers cover everything from microsecond-long updates to reconfigurations
+			// that take several seconds, so these go from 100µs to about 26s.
+			Buckets: prometheus.ExponentialBuckets(0.0001, 4, 10),
+		}, []string{"timer"}),
+	}
 }
 
 // Access the contexts of the debug info while holding the mutex.
@@ -74,6 +90,7 @@ func (d *Debug) Timer(name string) (result *Timer) {
 		result, ok = d.timers[name]
 		if !ok {
 			result = NewTimerWithClock(d.clock)
+			result.observer = d.durations.WithLabelValues(name)
 			d.timers[name] = result
 		}
 	})
@@ -93,6 +110,17 @@ func (d *Debug) Value(name string) (result *atomic.Value) {
 	return
 }
 
+// The Describe() method implements prometheus.Collector, so that the timers can be scraped.
+func (d *Debug) Describe(ch chan<- *prometheus.Desc) {
+	d.durations.Describe(ch)
+}
+
+// The Collect() method implements prometheus.Collector. Every timer is reported as a histogram
+// of durations, labeled with the timer's name.
+func (d *Debug) Collect(ch chan<- prometheus.Metric) {
+	d.durations.Collect(ch)
+}
+
 // The ServeHTTP() method will serve a json representation of the contents of the debug root.
 func (d *Debug) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	d.withMutex(func() {
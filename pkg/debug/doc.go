@@ -77,6 +77,10 @@
 //	  ...
 //	}
 //
+// Timers that come from a Debug root are also reported to Prometheus, as the histogram
+// `ambassador_debug_timer_duration_seconds` labeled with the timer's name: the Debug root is a
+// prometheus.Collector, and the entrypoint serves it at `localhost:8877/metrics`.
+//
 // 2. Atomic Values
 //
 // Another tool in the toolkit for externalizing relevant state is atomic values. Anywhere in the
@@ -6,6 +6,8 @@ import (
 	"net/http"
 	"sync"
 	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
 )
 
 // The Timer struct can be used to time discrete actions. It tracks min, max, average, and total
@@ -44,6 +46,8 @@ type Timer struct {
 	max   time.Duration // the min elapsed time for an action
 
 	clock func() time.Time // The clock function used by the timer.
+
+	observer prometheus.Observer // If set, also gets every elapsed time, in seconds.
 }
 
 // The type of the clock function to use for timing.
@@ -74,9 +78,12 @@ func (t *Timer) Start() StopFunc {
 
 // Records the timing info for an action.
 func (t *Timer) record(start time.Time, stop time.Time) {
-	t.withMutex(func() {
-		delta := stop.Sub(start)
+	delta := stop.Sub(start)
+	if t.observer != nil {
+		t.observer.Observe(delta.Seconds())
+	}
 
+	t.withMutex(func() {
 		if t.count == 0 {
 			// Initialize min and max if this is the first event.
 			t.min = delta
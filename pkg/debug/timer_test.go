@@ -1,9 +1,11 @@
 package debug_test
 
 import (
+	"strings"
 	"testing"
 	"time"
 
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/stretchr/testify/assert"
 
 	"github.com/emissary-ingress/emissary/v3/pkg/debug"
@@ -64,3 +66,53 @@ func TestConcurrentTiming(t *testing.T) {
 func TestAverageZero(t *testing.T) {
 	assert.Equal(t, 0*time.Second, debug.NewTimer().Average())
 }
+
+func TestTimerMetrics(t *testing.T) {
+	clock := time.Now()
+	dbg := debug.NewDebugWithClock(func() time.Time {
+		return clock
+	})
+
+	dbg.Timer("fast").Time(func() {
+		clock = clock.Add(time.Millisecond)
+	})
+	dbg.Timer("slow").Time(func() {
+		clock = clock.Add(2 * time.Second)
+	})
+	dbg.Timer("slow").Time(func() {
+		clock = clock.Add(3 * time.Second)
+	})
+
+	// Each timer is a histogram labeled with its name.
+	assert.Equal(t, 2, testutil.CollectAndCount(dbg, "ambassador_debug_timer_duration_seconds"))
+	assert.NoError(t, testutil.CollectAndCompare(dbg, strings.NewReader(`
+# HELP ambassador_debug_timer_duration_seconds How long the actions timed by each debug timer took.
+# TYPE ambassador_debug_timer_duration_seconds histogram
+ambassador_debug_timer_duration_seconds_bucket{timer="fast",le="0.0001"} 0
+ambassador_debug_timer_duration_seconds_bucket{timer="fast",le="0.0004"} 0
+ambassador_debug_timer_duration_seconds_bucket{timer="fast",le="0.0016"} 1
+ambassador_debug_timer_duration_seconds_bucket{timer="fast",le="0.0064"} 1
+ambassador_debug_timer_duration_seconds_bucket{timer="fast",le="0.0256"} 1
+ambassador_debug_timer_duration_seconds_bucket{timer="fast",le="0.1024"} 1
+ambassador_debug_timer_duration_seconds_bucket{timer="fast",le="0.4096"} 1
+ambassador_debug_timer_duration_seconds_bucket{timer="fast",le="1.6384"} 1
+ambassador_debug_timer_duration_seconds_bucket{timer="fast",le="6.5536"} 1
+ambassador_debug_timer_duration_seconds_bucket{timer="fast",le="26.2144"} 1
+ambassador_debug_timer_duration_seconds_bucket{timer="fast",le="+Inf"} 1
+ambassador_debug_timer_duration_seconds_sum{timer="fast"} 0.001
+ambassador_debug_timer_duration_seconds_count{timer="fast"} 1
+ambassador_debug_timer_duration_seconds_bucket{timer="slow",le="0.0001"} 0
+ambassador_debug_timer_duration_seconds_bucket{timer="slow",le="0.0004"} 0
+ambassador_debug_timer_duration_seconds_bucket{timer="slow",le="0.0016"} 0
+ambassador_debug_timer_duration_seconds_bucket{timer="slow",le="0.0064"} 0
+ambassador_debug_timer_duration_seconds_bucket{timer="slow",le="0.0256"} 0
+ambassador_debug_timer_duration_seconds_bucket{timer="slow",le="0.1024"} 0
+ambassador_debug_timer_duration_seconds_bucket{timer="slow",le="0.4096"} 0
+ambassador_debug_timer_duration_seconds_bucket{timer="slow",le="1.6384"} 0
+ambassador_debug_timer_duration_seconds_bucket{timer="slow",le="6.5536"} 2
+ambassador_debug_timer_duration_seconds_bucket{timer="slow",le="26.2144"} 2
+ambassador_debug_timer_duration_seconds_bucket{timer="slow",le="+Inf"} 2
+ambassador_debug_timer_duration_seconds_sum{timer="slow"} 5
+ambassador_debug_timer_duration_seconds_count{timer="slow"} 2
+`)))
+}
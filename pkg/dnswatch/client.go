@@ -0,0 +1,362 @@
+package dnswatch
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// SRV is a single SRV record.
+type SRV struct {
+	Target   string
+	Port     int
+	Priority int
+	Weight   int
+}
+
+// Lookuper is what a ServiceWatcher needs from DNS. Every lookup hands back the TTL of the answer
+// along with the answer itself, since the point of watching DNS is to re-query when the zone owner
+// says the answer may have changed.
+type Lookuper interface {
+	LookupSRV(ctx context.Context, name string) ([]SRV, time.Duration, error)
+	LookupAddrs(ctx context.Context, name string, family string) ([]string, time.Duration, error)
+}
+
+// NotFoundError is returned when the nameserver says that a name does not exist at all.
+type NotFoundError struct {
+	Name string
+}
+
+func (e *NotFoundError) Error() string {
+	return fmt.Sprintf("%s: no such host", e.Name)
+}
+
+// IsNotFound returns true if err means that the name being looked up doesn't exist.
+func IsNotFound(err error) bool {
+	var nf *NotFoundError
+	return errors.As(err, &nf)
+}
+
+// Client is a minimal stub resolver. We can't use net.Resolver because it doesn't tell us the TTLs
+// of the records it finds.
+type Client struct {
+	Nameservers []string
+	Timeout     time.Duration
+
+	// Search is the list of domains to try relative names in, and Ndots is how many dots a name
+	// needs to be tried as-is before the search domains, just as in resolv.conf(5). Names that end
+	// in a dot are never searched.
+	Search []string
+	Ndots  int
+}
+
+// NewClient returns a Client that queries the given "host:port" nameservers in order. If no
+// nameservers are given, the ones in /etc/resolv.conf are used, along with its search domains and
+// ndots, so that short in-cluster names resolve just as they do for everything else in the pod.
+func NewClient(nameservers []string) (*Client, error) {
+	var search []string
+	ndots := 1
+	if len(nameservers) == 0 {
+		conf, err := ReadResolvConf("/etc/resolv.conf")
+		if err != nil {
+			return nil, err
+		}
+		if len(conf.Nameservers) == 0 {
+			return nil, fmt.Errorf("no nameservers found in /etc/resolv.conf")
+		}
+		nameservers = conf.Nameservers
+		search = conf.Search
+		ndots = conf.Ndots
+	}
+
+	servers := make([]string, 0, len(nameservers))
+	for _, ns := range nameservers {
+		if _, _, err := net.SplitHostPort(ns); err != nil {
+			ns = net.JoinHostPort(strings.Trim(ns, "[]"), "53")
+		}
+		servers = append(servers, ns)
+	}
+
+	return &Client{Nameservers: servers, Timeout: 5 * time.Second, Search: search, Ndots: ndots}, nil
+}
+
+// ResolvConf is the part of a resolv.conf(5) file that a Client cares about.
+type ResolvConf struct {
+	// The nameservers, as "host:port" strings.
+	Nameservers []string
+	Search      []string
+	Ndots       int
+}
+
+// ReadResolvConf reads a resolv.conf(5) file. As with the C library, "search" and "domain" replace
+// each other, whichever comes last, and ndots defaults to 1 and is capped at 15.
+func ReadResolvConf(path string) (*ResolvConf, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	conf := &ResolvConf{Ndots: 1}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 {
+			continue
+		}
+		switch fields[0] {
+		case "nameserver":
+			if net.ParseIP(fields[1]) == nil {
+				continue
+			}
+			conf.Nameservers = append(conf.Nameservers, net.JoinHostPort(fields[1], "53"))
+		case "search":
+			conf.Search = append([]string(nil), fields[1:]...)
+		case "domain":
+			conf.Search = []string{fields[1]}
+		case "options":
+			for _, opt := range fields[1:] {
+				if v, ok := strings.CutPrefix(opt, "ndots:"); ok {
+					if n, err := strconv.Atoi(v); err == nil && n >= 0 {
+						if n > 15 {
+							n = 15
+						}
+						conf.Ndots = n
+					}
+				}
+			}
+		}
+	}
+	return conf, scanner.Err()
+}
+
+func (c *Client) LookupSRV(ctx context.Context, name string) ([]SRV, time.Duration, error) {
+	answers, ttl, err := c.query(ctx, name, dnsmessage.TypeSRV)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var result []SRV
+	for _, rr := range answers {
+		if srv, ok := rr.Body.(*dnsmessage.SRVResource); ok {
+			result = append(result, SRV{
+				Target:   strings.TrimSuffix(srv.Target.String(), "."),
+				Port:     int(srv.Port),
+				Priority: int(srv.Priority),
+				Weight:   int(srv.Weight),
+			})
+		}
+	}
+	return result, ttl, nil
+}
+
+// LookupAddrs looks up the A (if family is "A") or AAAA (if family is "AAAA") records for name.
+func (c *Client) LookupAddrs(ctx context.Context, name string, family string) ([]string, time.Duration, error) {
+	qtype := dnsmessage.TypeA
+	if family == "AAAA" {
+		qtype = dnsmessage.TypeAAAA
+	}
+
+	answers, ttl, err := c.query(ctx, name, qtype)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var result []string
+	for _, rr := range answers {
+		switch body := rr.Body.(type) {
+		case *dnsmessage.AResource:
+			result = append(result, net.IP(body.A[:]).String())
+		case *dnsmessage.AAAAResource:
+			result = append(result, net.IP(body.AAAA[:]).String())
+		}
+	}
+	return result, ttl, nil
+}
+
+// query looks up the records of type qtype for name, trying each of the names that name might
+// mean (see candidates) until one of them has some. If none of them exist, that's a NotFoundError.
+func (c *Client) query(ctx context.Context, name string, qtype dnsmessage.Type) ([]dnsmessage.Resource, time.Duration, error) {
+	var lastErr error
+	found := false
+	for _, fqdn := range c.candidates(name) {
+		answers, ttl, err := c.queryName(ctx, name, fqdn, qtype)
+		switch {
+		case err == nil && len(answers) > 0:
+			return answers, ttl, nil
+		case err == nil:
+			found = true
+		case !IsNotFound(err):
+			lastErr = err
+		}
+	}
+	switch {
+	case lastErr != nil:
+		return nil, 0, lastErr
+	case found:
+		return nil, 0, nil
+	default:
+		return nil, 0, &NotFoundError{Name: name}
+	}
+}
+
+// candidates returns the fully qualified names to try for name, in order.
+func (c *Client) candidates(name string) []string {
+	if strings.HasSuffix(name, ".") || len(c.Search) == 0 {
+		return []string{strings.TrimSuffix(name, ".") + "."}
+	}
+
+	asIs := strings.Count(name, ".") >= c.Ndots
+	var names []string
+	if asIs {
+		names = append(names, name+".")
+	}
+	for _, domain := range c.Search {
+		names = append(names, name+"."+strings.TrimSuffix(domain, ".")+".")
+	}
+	if !asIs {
+		names = append(names, name+".")
+	}
+	return names
+}
+
+// queryName asks each nameserver in turn for the records of type qtype for the fully qualified
+// fqdn, returning the matching answers and the smallest TTL among them. Errors are about name.
+func (c *Client) queryName(ctx context.Context, name, fqdn string, qtype dnsmessage.Type) ([]dnsmessage.Resource, time.Duration, error) {
+	qname, err := dnsmessage.NewName(fqdn)
+	if err != nil {
+		return nil, 0, fmt.Errorf("%s: %w", name, err)
+	}
+
+	id := uint16(rand.Uint32())
+	b := dnsmessage.NewBuilder(nil, dnsmessage.Header{ID: id, RecursionDesired: true})
+	b.EnableCompression()
+	if err := b.StartQuestions(); err != nil {
+		return nil, 0, err
+	}
+	if err := b.Question(dnsmessage.Question{Name: qname, Type: qtype, Class: dnsmessage.ClassINET}); err != nil {
+		return nil, 0, err
+	}
+	// Advertise a larger UDP buffer so that big SRV answers don't have to fall back to TCP.
+	if err := b.StartAdditionals(); err != nil {
+		return nil, 0, err
+	}
+	var opt dnsmessage.ResourceHeader
+	if err := opt.SetEDNS0(4096, dnsmessage.RCodeSuccess, false); err != nil {
+		return nil, 0, err
+	}
+	if err := b.OPTResource(opt, dnsmessage.OPTResource{}); err != nil {
+		return nil, 0, err
+	}
+	req, err := b.Finish()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	lastErr := fmt.Errorf("%s: no nameservers configured", name)
+	for _, server := range c.Nameservers {
+		resp, err := c.exchange(ctx, "udp", server, id, req)
+		if err == nil && resp.Truncated {
+			resp, err = c.exchange(ctx, "tcp", server, id, req)
+		}
+		if err != nil {
+			lastErr = fmt.Errorf("%s: %w", name, err)
+			continue
+		}
+
+		switch resp.RCode {
+		case dnsmessage.RCodeSuccess:
+		case dnsmessage.RCodeNameError:
+			return nil, 0, &NotFoundError{Name: name}
+		default:
+			lastErr = fmt.Errorf("%s: %v from %s", name, resp.RCode, server)
+			continue
+		}
+
+		var answers []dnsmessage.Resource
+		var ttl time.Duration
+		for _, rr := range resp.Answers {
+			if rr.Header.Type != qtype {
+				// Most likely a CNAME on the way to the records we asked for.
+				continue
+			}
+			rrTTL := time.Duration(rr.Header.TTL) * time.Second
+			if len(answers) == 0 || rrTTL < ttl {
+				ttl = rrTTL
+			}
+			answers = append(answers, rr)
+		}
+		return answers, ttl, nil
+	}
+	return nil, 0, lastErr
+}
+
+func (c *Client) exchange(ctx context.Context, network, server string, id uint16, req []byte) (*dnsmessage.Message, error) {
+	timeout := c.Timeout
+	if timeout == 0 {
+		timeout = 5 * time.Second
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, network, server)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		if err := conn.SetDeadline(deadline); err != nil {
+			return nil, err
+		}
+	}
+
+	var buf []byte
+	if network == "tcp" {
+		// DNS over TCP prefixes each message with its length.
+		msg := make([]byte, 2+len(req))
+		binary.BigEndian.PutUint16(msg, uint16(len(req)))
+		copy(msg[2:], req)
+		if _, err := conn.Write(msg); err != nil {
+			return nil, err
+		}
+		var length [2]byte
+		if _, err := io.ReadFull(conn, length[:]); err != nil {
+			return nil, err
+		}
+		buf = make([]byte, binary.BigEndian.Uint16(length[:]))
+		if _, err := io.ReadFull(conn, buf); err != nil {
+			return nil, err
+		}
+	} else {
+		if _, err := conn.Write(req); err != nil {
+			return nil, err
+		}
+		buf = make([]byte, 65535)
+		n, err := conn.Read(buf)
+		if err != nil {
+			return nil, err
+		}
+		buf = buf[:n]
+	}
+
+	var resp dnsmessage.Message
+	if err := resp.Unpack(buf); err != nil {
+		return nil, err
+	}
+	if !resp.Response || resp.ID != id {
+		return nil, fmt.Errorf("mismatched response from %s", server)
+	}
+	return &resp, nil
+}
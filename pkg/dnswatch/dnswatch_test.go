@@ -0,0 +1,340 @@
+package dnswatch
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// serveDNS runs a tiny UDP nameserver on localhost that answers from records, and returns its
+// address.
+func serveDNS(t *testing.T, records map[dnsmessage.Question][]dnsmessage.Resource) string {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+
+	go func() {
+		buf := make([]byte, 65535)
+		for {
+			n, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			var req dnsmessage.Message
+			if err := req.Unpack(buf[:n]); err != nil {
+				continue
+			}
+			q := req.Questions[0]
+			resp := dnsmessage.Message{
+				Header:    dnsmessage.Header{ID: req.ID, Response: true},
+				Questions: req.Questions,
+			}
+			answers, ok := records[q]
+			if ok {
+				resp.Answers = answers
+			} else {
+				resp.RCode = dnsmessage.RCodeNameError
+			}
+			out, err := resp.Pack()
+			if err != nil {
+				continue
+			}
+			_, _ = conn.WriteTo(out, addr)
+		}
+	}()
+
+	return conn.LocalAddr().String()
+}
+
+func TestClient(t *testing.T) {
+	name := dnsmessage.MustNewName("_http._tcp.example.com.")
+	target := dnsmessage.MustNewName("a.example.com.")
+
+	server := serveDNS(t, map[dnsmessage.Question][]dnsmessage.Resource{
+		{Name: name, Type: dnsmessage.TypeSRV, Class: dnsmessage.ClassINET}: {
+			{
+				Header: dnsmessage.ResourceHeader{Name: name, Type: dnsmessage.TypeSRV, Class: dnsmessage.ClassINET, TTL: 60},
+				Body:   &dnsmessage.SRVResource{Target: target, Port: 8080, Priority: 1, Weight: 10},
+			},
+		},
+		{Name: target, Type: dnsmessage.TypeA, Class: dnsmessage.ClassINET}: {
+			{
+				Header: dnsmessage.ResourceHeader{Name: target, Type: dnsmessage.TypeA, Class: dnsmessage.ClassINET, TTL: 30},
+				Body:   &dnsmessage.AResource{A: [4]byte{10, 0, 0, 2}},
+			},
+			{
+				Header: dnsmessage.ResourceHeader{Name: target, Type: dnsmessage.TypeA, Class: dnsmessage.ClassINET, TTL: 20},
+				Body:   &dnsmessage.AResource{A: [4]byte{10, 0, 0, 1}},
+			},
+		},
+	})
+
+	client, err := NewClient([]string{server})
+	require.NoError(t, err)
+	ctx := context.Background()
+
+	srvs, ttl, err := client.LookupSRV(ctx, "_http._tcp.example.com")
+	require.NoError(t, err)
+	assert.Equal(t, []SRV{{Target: "a.example.com", Port: 8080, Priority: 1, Weight: 10}}, srvs)
+	assert.Equal(t, 60*time.Second, ttl)
+
+	addrs, ttl, err := client.LookupAddrs(ctx, "a.example.com", "A")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"10.0.0.2", "10.0.0.1"}, addrs)
+	assert.Equal(t, 20*time.Second, ttl)
+
+	_, _, err = client.LookupAddrs(ctx, "missing.example.com", "A")
+	assert.True(t, IsNotFound(err))
+}
+
+func TestReadResolvConf(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "resolv.conf")
+	require.NoError(t, os.WriteFile(path, []byte(`
+# comment
+search default.svc.cluster.local svc.cluster.local
+nameserver 10.96.0.10
+nameserver fd00::10
+nameserver bogus
+options ndots:5
+`), 0644))
+
+	conf, err := ReadResolvConf(path)
+	require.NoError(t, err)
+	assert.Equal(t, &ResolvConf{
+		Nameservers: []string{"10.96.0.10:53", "[fd00::10]:53"},
+		Search:      []string{"default.svc.cluster.local", "svc.cluster.local"},
+		Ndots:       5,
+	}, conf)
+
+	// "domain" and "search" replace each other, and ndots has a sensible default.
+	require.NoError(t, os.WriteFile(path, []byte("search a.example\ndomain b.example\nnameserver 10.0.0.1\n"), 0644))
+	conf, err = ReadResolvConf(path)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"b.example"}, conf.Search)
+	assert.Equal(t, 1, conf.Ndots)
+}
+
+func TestClientSearch(t *testing.T) {
+	short := dnsmessage.MustNewName("db.default.svc.cluster.local.")
+	dotted := dnsmessage.MustNewName("db.other.")
+	a := func(name dnsmessage.Name, ip byte) []dnsmessage.Resource {
+		return []dnsmessage.Resource{{
+			Header: dnsmessage.ResourceHeader{Name: name, Type: dnsmessage.TypeA, Class: dnsmessage.ClassINET, TTL: 30},
+			Body:   &dnsmessage.AResource{A: [4]byte{10, 0, 0, ip}},
+		}}
+	}
+	server := serveDNS(t, map[dnsmessage.Question][]dnsmessage.Resource{
+		{Name: short, Type: dnsmessage.TypeA, Class: dnsmessage.ClassINET}:  a(short, 1),
+		{Name: dotted, Type: dnsmessage.TypeA, Class: dnsmessage.ClassINET}: a(dotted, 2),
+	})
+
+	client, err := NewClient([]string{server})
+	require.NoError(t, err)
+	client.Search = []string{"default.svc.cluster.local", "svc.cluster.local"}
+	client.Ndots = 5
+	ctx := context.Background()
+
+	// Short names are found in the search domains...
+	addrs, _, err := client.LookupAddrs(ctx, "db", "A")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"10.0.0.1"}, addrs)
+
+	// ...and names without enough dots fall back to being tried as-is...
+	addrs, _, err = client.LookupAddrs(ctx, "db.other", "A")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"10.0.0.2"}, addrs)
+
+	// ...but fully qualified names are never searched.
+	_, _, err = client.LookupAddrs(ctx, "db.", "A")
+	assert.True(t, IsNotFound(err))
+
+	assert.Equal(t, []string{"db.other."}, (&Client{Search: client.Search, Ndots: 1}).candidates("db.other")[:1])
+}
+
+type fakeLookuper struct {
+	mutex sync.Mutex
+	srv   map[string][]SRV
+	addrs map[string][]string
+	ttl   time.Duration
+	err   error
+}
+
+func (f *fakeLookuper) LookupSRV(_ context.Context, name string) ([]SRV, time.Duration, error) {
+	name = strings.TrimSuffix(name, ".")
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if f.err != nil {
+		return nil, 0, f.err
+	}
+	srvs, ok := f.srv[name]
+	if !ok {
+		return nil, 0, &NotFoundError{Name: name}
+	}
+	return srvs, f.ttl, nil
+}
+
+func (f *fakeLookuper) LookupAddrs(_ context.Context, name string, family string) ([]string, time.Duration, error) {
+	name = strings.TrimSuffix(name, ".")
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	addrs, ok := f.addrs[family+" "+name]
+	if !ok {
+		return nil, 0, &NotFoundError{Name: name}
+	}
+	return addrs, f.ttl, nil
+}
+
+func TestNew(t *testing.T) {
+	client := &fakeLookuper{}
+
+	w, err := New(client, "dns", "http://foo.example.com:8080", "A", 80, time.Second, time.Minute)
+	require.NoError(t, err)
+	assert.Equal(t, "foo.example.com:8080", w.ServiceName)
+	assert.Equal(t, "foo.example.com", w.host)
+	assert.Equal(t, 8080, w.port)
+
+	w, err = New(client, "dns", "foo.example.com", "AAAA", 80, time.Second, time.Minute)
+	require.NoError(t, err)
+	assert.Equal(t, 80, w.port)
+
+	_, err = New(client, "dns", "foo.example.com", "A", 0, time.Second, time.Minute)
+	assert.Error(t, err)
+
+	_, err = New(client, "dns", "_http._tcp.example.com:80", "SRV", 0, time.Second, time.Minute)
+	assert.Error(t, err)
+
+	w, err = New(client, "dns", "foo.example.com", "A", 80, time.Minute, time.Second)
+	require.NoError(t, err)
+	assert.Equal(t, time.Minute, w.interval(0))
+	assert.Equal(t, time.Minute, w.interval(time.Hour))
+
+	w, err = New(client, "dns", "foo.example.com", "A", 80, 0, 0)
+	require.NoError(t, err)
+	assert.Equal(t, time.Second, w.interval(0))
+	assert.Equal(t, time.Second, w.interval(time.Hour))
+}
+
+func setRefreshFloor(t *testing.T, floor time.Duration) {
+	old := refreshFloor
+	refreshFloor = floor
+	t.Cleanup(func() { refreshFloor = old })
+}
+
+func TestServiceWatcher(t *testing.T) {
+	client := &fakeLookuper{
+		srv: map[string][]SRV{
+			"_http._tcp.example.com": {
+				{Target: "b.example.com", Port: 8080, Priority: 1, Weight: 10},
+				{Target: "a.example.com", Port: 8080, Priority: 1, Weight: 20},
+				{Target: "gone.example.com", Port: 8080, Priority: 1, Weight: 20},
+			},
+		},
+		addrs: map[string][]string{
+			"A a.example.com":    {"10.0.0.1"},
+			"AAAA b.example.com": {"2001:db8::1"},
+		},
+		ttl: time.Millisecond,
+	}
+
+	setRefreshFloor(t, time.Millisecond)
+	w, err := New(client, "dns", "_http._tcp.example.com", "SRV", 0, time.Millisecond, time.Millisecond)
+	require.NoError(t, err)
+
+	updates := make(chan Endpoints, 10)
+	w.Watch(func(endpoints Endpoints, err error) {
+		require.NoError(t, err)
+		updates <- endpoints
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	done := make(chan error)
+	go func() { done <- w.Start(ctx) }()
+
+	endpoints := <-updates
+	assert.Equal(t, "dns", endpoints.Resolver)
+	assert.Equal(t, "_http._tcp.example.com", endpoints.Service)
+	assert.Equal(t, []Endpoint{
+		{Address: "10.0.0.1", Port: 8080, Priority: 1, Weight: 20},
+		{Address: "2001:db8::1", Port: 8080, Priority: 1, Weight: 10},
+	}, endpoints.Endpoints)
+
+	// Nothing changes, so we shouldn't hear about it again until something does.
+	time.Sleep(20 * time.Millisecond)
+	assert.Len(t, updates, 0)
+
+	client.mutex.Lock()
+	client.addrs["A b.example.com"] = []string{"10.0.0.2"}
+	client.mutex.Unlock()
+
+	endpoints = <-updates
+	assert.Equal(t, []Endpoint{
+		{Address: "10.0.0.1", Port: 8080, Priority: 1, Weight: 20},
+		{Address: "10.0.0.2", Port: 8080, Priority: 1, Weight: 10},
+	}, endpoints.Endpoints)
+
+	w.Stop()
+	require.NoError(t, <-done)
+}
+
+func TestServiceWatcherErrors(t *testing.T) {
+	client := &fakeLookuper{
+		srv: map[string][]SRV{
+			"_http._tcp.example.com": {{Target: "a.example.com", Port: 8080}},
+		},
+		addrs: map[string][]string{
+			"A a.example.com": {"10.0.0.1"},
+		},
+		ttl: time.Millisecond,
+		err: errors.New("server misbehaving"),
+	}
+
+	setRefreshFloor(t, time.Millisecond)
+	w, err := New(client, "dns", "_http._tcp.example.com", "SRV", 0, time.Millisecond, time.Millisecond)
+	require.NoError(t, err)
+
+	errs := make(chan error, 10)
+	updates := make(chan Endpoints, 10)
+	w.Watch(func(endpoints Endpoints, err error) {
+		if err != nil {
+			errs <- err
+			return
+		}
+		updates <- endpoints
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	done := make(chan error)
+	go func() { done <- w.Start(ctx) }()
+
+	// The same failure over and over again is only worth reporting once...
+	assert.EqualError(t, <-errs, "server misbehaving")
+	time.Sleep(20 * time.Millisecond)
+	assert.Len(t, errs, 0)
+
+	// ...but recovering, and failing again, is news.
+	client.mutex.Lock()
+	client.err = nil
+	client.mutex.Unlock()
+	endpoints := <-updates
+	assert.Equal(t, []Endpoint{{Address: "10.0.0.1", Port: 8080}}, endpoints.Endpoints)
+
+	client.mutex.Lock()
+	client.err = errors.New("server misbehaving")
+	client.mutex.Unlock()
+	assert.EqualError(t, <-errs, "server misbehaving")
+
+	w.Stop()
+	require.NoError(t, <-done)
+}
@@ -0,0 +1,230 @@
+package dnswatch
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// refreshFloor is the shortest interval a ServiceWatcher will ever wait between lookups, however
+// it's configured, so that a zero (or tiny) refresh interval can't turn into a busy loop against
+// the nameserver.
+var refreshFloor = time.Second
+
+type ServiceWatcher struct {
+	Resolver    string
+	ServiceName string
+
+	client      Lookuper
+	recordType  string
+	host        string
+	port        int
+	minInterval time.Duration
+	maxInterval time.Duration
+
+	handler  func(endpoints Endpoints, err error)
+	stop     chan struct{}
+	stopOnce sync.Once
+}
+
+// New returns a ServiceWatcher that looks up the records of type recordType ("SRV", "A", or
+// "AAAA") for service, re-querying whenever the TTL of the answer runs out, but never more often
+// than minInterval or less often than maxInterval. Both intervals are raised to at least one second.
+//
+// For SRV lookups, service is the full SRV name (e.g. "_http._tcp.example.com"), since SRV
+// records carry their own ports. For A and AAAA lookups, service is a hostname with an optional
+// port; if it doesn't have one, defaultPort is used.
+func New(client Lookuper, resolver, service, recordType string, defaultPort int, minInterval, maxInterval time.Duration) (*ServiceWatcher, error) {
+	// Mappings are allowed to put a scheme on the service, but it means nothing to DNS. We drop
+	// it from the ServiceName too, since that's what the endpoints get named by.
+	host := service
+	if i := strings.Index(host, "://"); i >= 0 {
+		host = host[i+3:]
+	}
+
+	w := &ServiceWatcher{
+		Resolver:    resolver,
+		ServiceName: host,
+		client:      client,
+		recordType:  recordType,
+		minInterval: minInterval,
+		maxInterval: maxInterval,
+		stop:        make(chan struct{}),
+	}
+
+	switch recordType {
+	case "SRV":
+		if _, _, err := net.SplitHostPort(host); err == nil {
+			return nil, fmt.Errorf("%s: SRV lookups get their ports from DNS, so the service must not have one", service)
+		}
+		w.host = host
+	case "A", "AAAA":
+		w.host = host
+		w.port = defaultPort
+		if h, p, err := net.SplitHostPort(host); err == nil {
+			port, err := strconv.Atoi(p)
+			if err != nil {
+				return nil, fmt.Errorf("%s: invalid port: %w", service, err)
+			}
+			w.host = h
+			w.port = port
+		}
+		if w.port == 0 {
+			return nil, fmt.Errorf("%s: no port given, and the resolver has no defaultPort", service)
+		}
+	default:
+		return nil, fmt.Errorf("%s: unsupported record type %q", service, recordType)
+	}
+
+	if w.minInterval < refreshFloor {
+		w.minInterval = refreshFloor
+	}
+	if w.maxInterval < w.minInterval {
+		w.maxInterval = w.minInterval
+	}
+
+	return w, nil
+}
+
+// Watch sets the handler that is called with the endpoints for the service. It is called after
+// the first lookup, and then again whenever the endpoints change or a lookup fails. When a lookup
+// fails, the handler gets an error and the endpoints should be ignored; a lookup that keeps failing
+// with the same error is only reported once.
+func (w *ServiceWatcher) Watch(handler func(endpoints Endpoints, err error)) {
+	w.handler = handler
+}
+
+// Start polls DNS until Stop is called or the context is cancelled.
+func (w *ServiceWatcher) Start(ctx context.Context) error {
+	var last *Endpoints
+	var lastErr string
+	for {
+		endpoints, ttl, err := w.lookup(ctx)
+		if IsNotFound(err) {
+			// The name is really gone, so the honest answer is that there are no endpoints.
+			err = nil
+		}
+
+		wait := w.minInterval
+		if err != nil {
+			if err.Error() != lastErr {
+				w.handler(endpoints, err)
+				lastErr = err.Error()
+			}
+		} else {
+			lastErr = ""
+			if last == nil || !reflect.DeepEqual(last.Endpoints, endpoints.Endpoints) {
+				w.handler(endpoints, nil)
+				last = &endpoints
+			}
+			wait = w.interval(ttl)
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-timer.C:
+		case <-w.stop:
+			timer.Stop()
+			return nil
+		case <-ctx.Done():
+			timer.Stop()
+			return nil
+		}
+	}
+}
+
+func (w *ServiceWatcher) Stop() {
+	w.stopOnce.Do(func() { close(w.stop) })
+}
+
+// interval clamps ttl to [minInterval, maxInterval].
+func (w *ServiceWatcher) interval(ttl time.Duration) time.Duration {
+	if ttl < w.minInterval {
+		return w.minInterval
+	}
+	if ttl > w.maxInterval {
+		return w.maxInterval
+	}
+	return ttl
+}
+
+// lookup does a single round of lookups, returning the endpoints found and the smallest TTL of any
+// record involved.
+func (w *ServiceWatcher) lookup(ctx context.Context) (Endpoints, time.Duration, error) {
+	endpoints := Endpoints{Resolver: w.Resolver, Service: w.ServiceName, Endpoints: []Endpoint{}}
+
+	if w.recordType != "SRV" {
+		addrs, ttl, err := w.client.LookupAddrs(ctx, w.host, w.recordType)
+		if err != nil {
+			return endpoints, 0, err
+		}
+		for _, addr := range addrs {
+			endpoints.Endpoints = append(endpoints.Endpoints, Endpoint{Address: addr, Port: w.port})
+		}
+		sortEndpoints(endpoints.Endpoints)
+		return endpoints, ttl, nil
+	}
+
+	srvs, ttl, err := w.client.LookupSRV(ctx, w.host)
+	if err != nil {
+		return endpoints, 0, err
+	}
+	for _, srv := range srvs {
+		addrs, addrTTL, err := w.lookupTarget(ctx, srv.Target)
+		if err != nil {
+			if IsNotFound(err) {
+				// One dangling target shouldn't take out all the others.
+				continue
+			}
+			return endpoints, 0, err
+		}
+		if addrTTL < ttl {
+			ttl = addrTTL
+		}
+		for _, addr := range addrs {
+			endpoints.Endpoints = append(endpoints.Endpoints, Endpoint{
+				Address:  addr,
+				Port:     srv.Port,
+				Priority: srv.Priority,
+				Weight:   srv.Weight,
+			})
+		}
+	}
+	sortEndpoints(endpoints.Endpoints)
+	return endpoints, ttl, nil
+}
+
+// lookupTarget resolves the target of an SRV record, preferring IPv4 addresses but falling back to
+// IPv6 if there aren't any.
+func (w *ServiceWatcher) lookupTarget(ctx context.Context, target string) ([]string, time.Duration, error) {
+	if ip := net.ParseIP(target); ip != nil {
+		return []string{ip.String()}, w.maxInterval, nil
+	}
+	// SRV targets are always fully qualified, so they mustn't go through the search domains.
+	target += "."
+	addrs, ttl, err := w.client.LookupAddrs(ctx, target, "A")
+	if err != nil && !IsNotFound(err) {
+		return nil, 0, err
+	}
+	if len(addrs) > 0 {
+		return addrs, ttl, nil
+	}
+	return w.client.LookupAddrs(ctx, target, "AAAA")
+}
+
+// sortEndpoints puts endpoints in a stable order, so that DNS servers that rotate their answers
+// don't look like they're changing them.
+func sortEndpoints(endpoints []Endpoint) {
+	sort.Slice(endpoints, func(i, j int) bool {
+		if endpoints[i].Address != endpoints[j].Address {
+			return endpoints[i].Address < endpoints[j].Address
+		}
+		return endpoints[i].Port < endpoints[j].Port
+	})
+}
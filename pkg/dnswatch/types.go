@@ -0,0 +1,16 @@
+package dnswatch
+
+// Endpoints contains an Array of Endpoint structs and meta information about the DNS name that the
+// contained endpoints were found under.
+type Endpoints struct {
+	Resolver  string     `json:""`
+	Service   string     `json:""`
+	Endpoints []Endpoint `json:""`
+}
+
+type Endpoint struct {
+	Address  string `json:""`
+	Port     int    `json:""`
+	Priority int    `json:",omitempty"`
+	Weight   int    `json:",omitempty"`
+}
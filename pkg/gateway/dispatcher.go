@@ -24,6 +24,7 @@ import (
 
 	"github.com/datawire/dlib/dlog"
 	"github.com/emissary-ingress/emissary/v3/pkg/kates"
+	"github.com/emissary-ingress/emissary/v3/pkg/origin"
 )
 
 // The Dispatcher struct allows transforms to be registered for different kinds of kubernetes
@@ -248,13 +249,15 @@ func (d *Dispatcher) IsWatched(namespace, name string) bool {
 	return ok
 }
 
-func (d *Dispatcher) buildClusterMap() (map[string]string, map[string]bool) {
+func (d *Dispatcher) buildClusterMap() (map[string]string, map[string]bool, map[string][]origin.Origin) {
 	refs := map[string]string{}
 	watches := map[string]bool{}
+	origins := map[string][]origin.Origin{}
 	for _, config := range d.configs {
 		for _, route := range config.Routes {
 			for _, ref := range route.ClusterRefs {
 				refs[ref.Name] = ref.EndpointPath
+				origins[ref.Name] = append(origins[ref.Name], sourceOrigins(ref.Source)...)
 				if route.Namespace != "" {
 					key := fmt.Sprintf("%s:%s", route.Namespace, ref.Name)
 					watches[key] = true
@@ -262,7 +265,7 @@ func (d *Dispatcher) buildClusterMap() (map[string]string, map[string]bool) {
 			}
 		}
 	}
-	return refs, watches
+	return refs, watches, origins
 }
 
 func (d *Dispatcher) buildEndpointMap() map[string]*v3endpoint.ClusterLoadAssignment {
@@ -343,12 +346,14 @@ func (d *Dispatcher) buildSnapshot(ctx context.Context) {
 	d.version = fmt.Sprintf("v%d", d.changeCount)
 
 	endpointMap := d.buildEndpointMap()
-	clusterMap, endpointWatches := d.buildClusterMap()
+	clusterMap, endpointWatches, clusterOrigins := d.buildClusterMap()
 
 	clusters := []ecp_cache_types.Resource{}
 	endpoints := []ecp_cache_types.Resource{}
 	for name, path := range clusterMap {
-		clusters = append(clusters, makeCluster(name, path))
+		cluster := makeCluster(name, path)
+		cluster.Metadata = origin.Metadata(clusterOrigins[name]...)
+		clusters = append(clusters, cluster)
 		key := path
 		if key == "" {
 			key = name
@@ -12,6 +12,7 @@ import (
 	"google.golang.org/protobuf/types/known/anypb"
 
 	// envoy api v3
+	v3cluster "github.com/emissary-ingress/emissary/v3/pkg/api/envoy/config/cluster/v3"
 	v3core "github.com/emissary-ingress/emissary/v3/pkg/api/envoy/config/core/v3"
 	v3endpoint "github.com/emissary-ingress/emissary/v3/pkg/api/envoy/config/endpoint/v3"
 	v3listener "github.com/emissary-ingress/emissary/v3/pkg/api/envoy/config/listener/v3"
@@ -25,6 +26,7 @@ import (
 	"github.com/datawire/dlib/dlog"
 	"github.com/emissary-ingress/emissary/v3/pkg/gateway"
 	"github.com/emissary-ingress/emissary/v3/pkg/kates"
+	"github.com/emissary-ingress/emissary/v3/pkg/origin"
 )
 
 func assertErrorContains(t *testing.T, err error, msg string) {
@@ -466,3 +468,37 @@ func compile_FooEndpointWatches(f *Foo) (*gateway.CompiledConfig, error) {
 		}},
 	}, nil
 }
+
+func TestDispatcherAssemblyClusterOrigins(t *testing.T) {
+	t.Parallel()
+	ctx := dlog.NewTestContext(t, false)
+	disp := gateway.NewDispatcher()
+	err := disp.Register("Foo", wrapFooCompiler(compile_FooWithClusterOrigin))
+	require.NoError(t, err)
+	foo := makeFoo("default", "foo", "bar")
+	err = disp.Upsert(foo)
+	require.NoError(t, err)
+
+	_, snapshot := disp.GetSnapshot(ctx)
+	require.NotNil(t, snapshot)
+
+	clusters := snapshot.Resources[ecp_cache_types.Cluster].Items
+	require.Len(t, clusters, 1)
+	cluster, ok := clusters["foo"].Resource.(*v3cluster.Cluster)
+	require.True(t, ok)
+	assert.Equal(t, []origin.Origin{{Kind: "Foo", Namespace: "default", Name: "foo"}},
+		origin.FromMetadata(cluster.GetMetadata()))
+}
+
+func compile_FooWithClusterOrigin(f *Foo) (*gateway.CompiledConfig, error) {
+	src := gateway.SourceFromResource(f)
+	return &gateway.CompiledConfig{
+		CompiledItem: gateway.NewCompiledItem(src),
+		Routes: []*gateway.CompiledRoute{{
+			ClusterRefs: []*gateway.ClusterRef{{
+				CompiledItem: gateway.NewCompiledItem(gateway.Sourcef("forwardTo 0 in %s", src)),
+				Name:         "foo",
+			}},
+		}},
+	}, nil
+}
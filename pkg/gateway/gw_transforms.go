@@ -22,6 +22,7 @@ import (
 
 	// first-party libraries
 	"github.com/emissary-ingress/emissary/v3/pkg/kates"
+	"github.com/emissary-ingress/emissary/v3/pkg/origin"
 )
 
 func Compile_Gateway(gateway *gw.Gateway) (*CompiledConfig, error) {
@@ -67,7 +68,8 @@ func Compile_Listener(parent Source, lst gw.Listener, name string) (*CompiledLis
 	return &CompiledListener{
 		CompiledItem: NewCompiledItem(Sourcef("listener %s", lst.Hostname)),
 		Listener: &v3listener.Listener{
-			Name: name,
+			Name:     name,
+			Metadata: origin.Metadata(sourceOrigins(parent)...),
 			Address: &v3core.Address{Address: &v3core.Address_SocketAddress{SocketAddress: &v3core.SocketAddress{
 				Address:       "0.0.0.0",
 				PortSpecifier: &v3core.SocketAddress_PortValue{PortValue: uint32(lst.Port)},
@@ -103,6 +105,9 @@ func Compile_HTTPRoute(httpRoute *gw.HTTPRoute) (*CompiledConfig, error) {
 		}
 		routes = append(routes, _routes...)
 	}
+	for _, route := range routes {
+		route.Metadata = origin.Metadata(sourceOrigins(src)...)
+	}
 	return &CompiledConfig{
 		CompiledItem: NewCompiledItem(src),
 		Routes: []*CompiledRoute{
@@ -4,6 +4,7 @@ import (
 	"fmt"
 
 	"github.com/emissary-ingress/emissary/v3/pkg/kates"
+	"github.com/emissary-ingress/emissary/v3/pkg/origin"
 )
 
 type Source interface {
@@ -43,3 +44,26 @@ func (c *patternSource) Location() string {
 	}
 	return fmt.Sprintf(c.pattern, args...)
 }
+
+// sourceOrigins returns the Kubernetes resources that the Source ultimately refers to.
+func sourceOrigins(s Source) []origin.Origin {
+	switch s := s.(type) {
+	case *k8sSource:
+		return []origin.Origin{{
+			Kind:       s.resource.GetObjectKind().GroupVersionKind().Kind,
+			Namespace:  s.resource.GetNamespace(),
+			Name:       s.resource.GetName(),
+			Generation: s.resource.GetGeneration(),
+		}}
+	case *patternSource:
+		var ret []origin.Origin
+		for _, a := range s.args {
+			if src, ok := a.(Source); ok {
+				ret = append(ret, sourceOrigins(src)...)
+			}
+		}
+		return ret
+	default:
+		return nil
+	}
+}
@@ -9,6 +9,8 @@ import (
 	"time"
 
 	"k8s.io/apimachinery/pkg/api/meta"
+
+	"github.com/emissary-ingress/emissary/v3/pkg/debug"
 )
 
 // The Accumulator struct is used to efficiently maintain an in-memory copy of kubernetes resources
@@ -45,18 +47,28 @@ import (
 //  2. When multiple Kinds are needed by a controller, the Accumulator will not notify the
 //     controller until all the Kinds have been fully bootstrapped.
 //
-//  3. Graceful load shedding: When the rate of change of resources is very fast, the API and
+//  3. When a Kind is watched in more than one namespace (see Query.Namespaces and
+//     Query.NamespaceSelector), the Accumulator will not consider that Kind bootstrapped until
+//     every one of those namespaces has been. This includes namespaces that start matching a
+//     NamespaceSelector later on: notifications are held back until they have been loaded too.
+//
+//  4. Graceful load shedding: When the rate of change of resources is very fast, the API and
 //     implementation are structured so that individual object deltas get coalesced into a single
 //     snapshot update. This prevents excessively triggering business logic to process an entire
-//     snapshot for each individual object change that occurs.
+//     snapshot for each individual object change that occurs. How long to wait for changes to
+//     coalesce adapts to how fast they are arriving; see CoalescePolicy.
 type Accumulator struct {
+	ctx    context.Context
 	client *Client
 	fields map[string]*field
 	// keyed by unKey(*Unstructured), tracks excluded resources for filtered updates
 	excluded map[string]bool
-	synced   int
-	changed  chan struct{}
-	mutex    sync.Mutex
+	// keyed by NamespaceSelector, tracks whether the initial list of matching Namespaces is done
+	namespaceSelectors map[string]bool
+	rawUpdateCh        chan rawUpdate
+	namespaceCh        chan rawUpdate
+	changed            chan struct{}
+	mutex              sync.Mutex
 }
 
 type field struct {
@@ -69,10 +81,46 @@ type field struct {
 	// The values map has a true for a new or update object, false for a deleted object.
 	deltas map[string]*Delta
 
-	synced      bool
+	// The watches map is keyed by namespace. It holds a single NamespaceAll entry unless the
+	// query names particular namespaces.
+	watches map[string]*namespaceWatch
+
 	firstUpdate bool
 }
 
+// A namespaceWatch is the watch of a single namespace for a field.
+type namespaceWatch struct {
+	namespace string
+	cancel    context.CancelFunc
+	synced    bool
+}
+
+// namespaced returns whether the field is for a namespaced Kind, i.e. whether the namespaces in
+// the query mean anything.
+func (f *field) namespaced() bool {
+	return f.mapping.Scope.Name() == meta.RESTScopeNameNamespace
+}
+
+// transform returns the resource as the field's Transform would have it. The resource itself is
+// left alone, since it may well be shared.
+func (f *field) transform(un *Unstructured) *Unstructured {
+	if f.query.Transform == nil {
+		return un
+	}
+	un = un.DeepCopy()
+	f.query.Transform(un)
+	return un
+}
+
+// watching returns whether the field is watching the given namespace.
+func (f *field) watching(namespace string) bool {
+	if _, ok := f.watches[NamespaceAll]; ok {
+		return true
+	}
+	_, ok := f.watches[namespace]
+	return ok
+}
+
 type DeltaType int
 
 const (
@@ -81,13 +129,6 @@ const (
 	ObjectDelete
 )
 
-type changeStatus int
-
-const (
-	awaitingDispatch changeStatus = iota
-	dispatched
-)
-
 func (dt DeltaType) MarshalJSON() ([]byte, error) {
 	switch dt {
 	case ObjectAdd:
@@ -159,32 +200,100 @@ func newDelta(deltaType DeltaType, obj *Unstructured) *Delta {
 }
 
 func newAccumulator(ctx context.Context, client *Client, queries ...Query) (*Accumulator, error) {
-	changed := make(chan struct{})
-
-	fields := make(map[string]*field)
-	rawUpdateCh := make(chan rawUpdate)
+	acc := &Accumulator{
+		ctx:                ctx,
+		client:             client,
+		fields:             map[string]*field{},
+		excluded:           map[string]bool{},
+		namespaceSelectors: map[string]bool{},
+		rawUpdateCh:        make(chan rawUpdate),
+		namespaceCh:        make(chan rawUpdate),
+		changed:            make(chan struct{}),
+		mutex:              sync.Mutex{},
+	}
 
 	for _, q := range queries {
 		field, err := client.newField(q)
 		if err != nil {
 			return nil, err
 		}
-		fields[q.Name] = field
-		client.watchRaw(ctx, q, rawUpdateCh, client.cliFor(field.mapping, q.Namespace))
+		acc.fields[q.Name] = field
+
+		switch {
+		case !field.namespaced():
+			acc.startWatch(field, NamespaceAll)
+		case q.NamespaceSelector != "":
+			// The watches for this field get started as the Namespaces show up. All the fields
+			// with the same selector share a single watch of the Namespaces.
+			if _, ok := acc.namespaceSelectors[q.NamespaceSelector]; !ok {
+				if err := acc.watchNamespaces(q.NamespaceSelector); err != nil {
+					return nil, err
+				}
+			}
+		case len(q.Namespaces) > 0:
+			for _, ns := range q.Namespaces {
+				acc.startWatch(field, ns)
+			}
+		default:
+			acc.startWatch(field, q.Namespace)
+		}
 	}
 
-	acc := &Accumulator{
-		client:   client,
-		fields:   fields,
-		excluded: map[string]bool{},
-		synced:   0,
-		changed:  changed,
-		mutex:    sync.Mutex{},
+	go acc.Listen(ctx, acc.rawUpdateCh, client.coalescePolicy)
+
+	return acc, nil
+}
+
+// startWatch starts watching the namespace for the field. The caller must hold the mutex (or be
+// newAccumulator).
+func (a *Accumulator) startWatch(field *field, namespace string) {
+	if _, ok := field.watches[namespace]; ok {
+		return
 	}
+	ctx, cancel := context.WithCancel(a.ctx)
+	watch := &namespaceWatch{namespace: namespace, cancel: cancel}
+	field.watches[namespace] = watch
+
+	q := field.query
+	q.Namespace = namespace
+	var cli listWatchClient
+	if q.MetadataOnly {
+		cli = a.client.metadataCliFor(field.mapping, namespace)
+	} else {
+		cli = a.client.cliFor(field.mapping, namespace)
+	}
+	a.client.watchRaw(ctx, q, watch, a.rawUpdateCh, cli)
+}
 
-	go acc.Listen(ctx, rawUpdateCh, client.maxAccumulatorInterval)
+// stopWatch stops watching the namespace for the field, and forgets all of the field's resources in
+// that namespace. The caller must hold the mutex.
+func (a *Accumulator) stopWatch(field *field, namespace string) {
+	watch, ok := field.watches[namespace]
+	if !ok {
+		return
+	}
+	watch.cancel()
+	delete(field.watches, namespace)
 
-	return acc, nil
+	for key, un := range field.values {
+		if un.GetNamespace() == namespace {
+			delete(field.values, key)
+			field.deltas[key] = newDelta(ObjectDelete, un)
+		}
+	}
+}
+
+// watchNamespaces starts watching the Namespaces that match the selector.
+func (a *Accumulator) watchNamespaces(selector string) error {
+	mapping, err := a.client.mappingFor("namespaces")
+	if err != nil {
+		return err
+	}
+	a.namespaceSelectors[selector] = false
+
+	q := Query{Name: selector, Kind: "namespaces", LabelSelector: selector}
+	a.client.watchRaw(a.ctx, q, nil, a.namespaceCh, a.client.cliFor(mapping, NamespaceAll))
+	return nil
 }
 
 // Listen for updates from rawUpdateCh and sends notifications, coalescing reads as neccessary.
@@ -192,40 +301,59 @@ func newAccumulator(ctx context.Context, client *Client, queries ...Query) (*Acc
 // the documentation for the Accumulator struct, i.e. Ensuring all Kinds are bootstrapped before any
 // notification occurs, as well as ensuring that we continue to coalesce updates in the background while
 // business logic is executing in order to ensure graceful load shedding.
-func (a *Accumulator) Listen(ctx context.Context, rawUpdateCh <-chan rawUpdate, interval time.Duration) {
-	ticker := time.NewTicker(interval)
-	defer ticker.Stop()
-	var changeStatus changeStatus
-	var lastChangeSent time.Time
+func (a *Accumulator) Listen(ctx context.Context, rawUpdateCh <-chan rawUpdate, policy CoalescePolicy) {
+	coalescer := newCoalescer(policy, time.Now)
+	stats := debug.FromContext(ctx).Value("kubernetesCoalescing")
+	stats.Store(coalescer.stats)
+
+	var timer *time.Timer
+	var timerCh <-chan time.Time
 	var synced bool
 
 	sendUpdate := func() {
+		// Publish the stats first, so that whoever we notify sees them.
+		coalescer.notified()
+		stats.Store(coalescer.stats)
 		a.changed <- struct{}{}
-		changeStatus = dispatched
-		lastChangeSent = time.Now()
+	}
+
+	maybeSendUpdate := func() {
+		if timer != nil {
+			timer.Stop()
+			timerCh = nil
+		}
+		switch {
+		case !synced:
+			// Nothing to do until we're synced, and we only get synced with an update, which
+			// will bring us back here.
+		case coalescer.due():
+			sendUpdate()
+		default:
+			timer = time.NewTimer(time.Until(coalescer.deadline()))
+			timerCh = timer.C
+		}
 	}
 
 	for {
 		select {
-		// We have two paths here:
-		// 1. If we get new data and it has been past our set interval since we last updated anything,
-		//    we go ahead and immediately send that.
-		//
-		// 2. If we get new data but we just recently sent a change within our interval, we'll
-		//    wait until we get our next Tick before sending a change.
+		// Every update counts as a change for the coalescer, which tells us when to send a
+		// change: either once the updates have gone quiet for a while, or once the oldest
+		// update we haven't sent has been waiting as long as the policy allows. In the
+		// meantime, the timer waits for whichever of those comes first.
 		case rawUp := <-rawUpdateCh:
 			synced = a.storeUpdate(rawUp)
-			since := rawUp.ts.Sub(lastChangeSent)
-			if synced && since >= interval {
-				sendUpdate()
-			} else {
-				changeStatus = awaitingDispatch
-			}
-		case <-ticker.C:
-			if synced && changeStatus == awaitingDispatch {
-				sendUpdate()
-			}
+			coalescer.change()
+			maybeSendUpdate()
+		case nsUp := <-a.namespaceCh:
+			synced = a.storeNamespaceUpdate(nsUp)
+			coalescer.change()
+			maybeSendUpdate()
+		case <-timerCh:
+			maybeSendUpdate()
 		case <-ctx.Done():
+			if timer != nil {
+				timer.Stop()
+			}
 			return
 		}
 	}
@@ -256,6 +384,10 @@ func (a *Accumulator) storeUpdate(update rawUpdate) bool {
 	a.mutex.Lock()
 	defer a.mutex.Unlock()
 	field := a.fields[update.name]
+	if field.watches[update.watch.namespace] != update.watch {
+		// This is a straggler from a namespace that we've since stopped watching.
+		return a.isSynced()
+	}
 	if update.new != nil {
 		key := unKey(update.new)
 		oldValue, oldExists := field.values[key]
@@ -283,11 +415,49 @@ func (a *Accumulator) storeUpdate(update rawUpdate) bool {
 			field.deltas[key] = newDelta(ObjectDelete, update.old)
 		}
 	}
-	if update.synced && !field.synced {
-		field.synced = true
-		a.synced += 1
+	if update.synced {
+		update.watch.synced = true
+	}
+	return a.isSynced()
+}
+
+// storeNamespaceUpdate starts or stops the watches of a namespace for every field with the
+// update's NamespaceSelector, as the namespace starts or stops matching it.
+func (a *Accumulator) storeNamespaceUpdate(update rawUpdate) bool {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	for _, field := range a.fields {
+		if field.query.NamespaceSelector != update.name || !field.namespaced() {
+			continue
+		}
+		if update.new != nil {
+			a.startWatch(field, update.new.GetName())
+		} else if update.old != nil {
+			a.stopWatch(field, update.old.GetName())
+		}
+	}
+	if update.synced {
+		a.namespaceSelectors[update.name] = true
+	}
+	return a.isSynced()
+}
+
+// isSynced returns whether every watch of every field has been bootstrapped. The caller must hold
+// the mutex.
+func (a *Accumulator) isSynced() bool {
+	for _, synced := range a.namespaceSelectors {
+		if !synced {
+			return false
+		}
+	}
+	for _, field := range a.fields {
+		for _, watch := range field.watches {
+			if !watch.synced {
+				return false
+			}
+		}
 	}
-	return a.synced >= len(a.fields)
+	return true
 }
 
 func (a *Accumulator) updateField(
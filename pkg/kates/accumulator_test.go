@@ -317,3 +317,240 @@ func TestNotifyOnUpdate(t *testing.T) {
 		}
 	})
 }
+
+// testNamespaces creates a namespace for each of the names, each with a single ConfigMap in it.
+func testNamespaces(ctx context.Context, t *testing.T, cli *Client, labels map[string]string, names ...string) []*Namespace {
+	var namespaces []*Namespace
+	for _, name := range names {
+		ns := &Namespace{
+			TypeMeta: TypeMeta{
+				Kind: "Namespace",
+			},
+			ObjectMeta: ObjectMeta{
+				Name:   name,
+				Labels: labels,
+			},
+		}
+		err := cli.Upsert(ctx, ns, ns, &ns)
+		require.NoError(t, err)
+		namespaces = append(namespaces, ns)
+
+		cm := &ConfigMap{
+			TypeMeta: TypeMeta{
+				Kind: "ConfigMap",
+			},
+			ObjectMeta: ObjectMeta{
+				Name:      "test-namespaces",
+				Namespace: name,
+				Labels: map[string]string{
+					"test": "test-namespaces",
+				},
+			},
+		}
+		err = cli.Upsert(ctx, cm, cm, &cm)
+		require.NoError(t, err)
+	}
+
+	t.Cleanup(func() {
+		for _, ns := range namespaces {
+			if err := cli.Delete(ctx, ns, nil); err != nil && !IsNotFound(err) {
+				t.Error(err)
+			}
+		}
+	})
+	return namespaces
+}
+
+// Make sure that a watch of a list of namespaces sees every one of those namespaces in its first
+// notification, and none of the others.
+func TestBootstrapMultipleNamespaces(t *testing.T) {
+	ctx, cli := testClient(t, nil)
+	testNamespaces(ctx, t, cli, nil, "test-namespaces-a", "test-namespaces-b", "test-namespaces-c")
+
+	// Use a separate client for watching so we can bypass any caching, and slow down the add
+	// events so that the namespaces bootstrap at different times.
+	_, cli2 := testClient(t, nil)
+	cli2.watchAdded = func(old *Unstructured, new *Unstructured) {
+		time.Sleep(1 * time.Second)
+	}
+	acc, err := cli2.Watch(ctx, Query{
+		Name:          "ConfigMaps",
+		Kind:          "ConfigMap",
+		LabelSelector: "test=test-namespaces",
+		Namespaces:    []string{"test-namespaces-a", "test-namespaces-b"},
+	})
+	require.NoError(t, err)
+
+	snap := &Snap{}
+	for {
+		<-acc.Changed()
+		updated, err := acc.Update(ctx, snap)
+		require.NoError(t, err)
+		if updated {
+			break
+		}
+	}
+
+	require.Equal(t, 2, len(snap.ConfigMaps))
+	seen := map[string]bool{}
+	for _, cm := range snap.ConfigMaps {
+		seen[cm.GetNamespace()] = true
+	}
+	assert.Equal(t, map[string]bool{"test-namespaces-a": true, "test-namespaces-b": true}, seen)
+}
+
+// Make sure that a watch with a NamespaceSelector follows namespaces as they start and stop
+// matching the selector.
+func TestNamespaceSelector(t *testing.T) {
+	ctx, cli := testClient(t, nil)
+	labels := map[string]string{"test": "test-namespace-selector"}
+	namespaces := testNamespaces(ctx, t, cli, labels, "test-namespace-selector-a")
+	testNamespaces(ctx, t, cli, nil, "test-namespace-selector-b")
+
+	acc, err := cli.Watch(ctx, Query{
+		Name:              "ConfigMaps",
+		Kind:              "ConfigMap",
+		LabelSelector:     "test=test-namespaces",
+		NamespaceSelector: "test=test-namespace-selector",
+	})
+	require.NoError(t, err)
+
+	snap := &Snap{}
+	waitForConfigMaps := func(count int) {
+		for {
+			<-acc.Changed()
+			_, err := acc.Update(ctx, snap)
+			require.NoError(t, err)
+			if len(snap.ConfigMaps) == count {
+				return
+			}
+		}
+	}
+
+	waitForConfigMaps(1)
+	assert.Equal(t, "test-namespace-selector-a", snap.ConfigMaps[0].GetNamespace())
+
+	// Label the second namespace, and its ConfigMap should show up.
+	nsB := &Namespace{
+		TypeMeta:   TypeMeta{Kind: "Namespace"},
+		ObjectMeta: ObjectMeta{Name: "test-namespace-selector-b", Labels: labels},
+	}
+	err = cli.Upsert(ctx, nsB, nsB, &nsB)
+	require.NoError(t, err)
+	waitForConfigMaps(2)
+
+	// Unlabel the first namespace, and its ConfigMap should go away.
+	nsA := namespaces[0]
+	nsA.SetLabels(nil)
+	err = cli.Update(ctx, nsA, &nsA)
+	require.NoError(t, err)
+	waitForConfigMaps(1)
+	assert.Equal(t, "test-namespace-selector-b", snap.ConfigMaps[0].GetNamespace())
+}
+
+// Make sure that a MetadataOnly watch gets the metadata of resources, and nothing else.
+func TestMetadataOnly(t *testing.T) {
+	ctx, cli := testClient(t, nil)
+	cm := &ConfigMap{
+		TypeMeta: TypeMeta{
+			Kind: "ConfigMap",
+		},
+		ObjectMeta: ObjectMeta{
+			Name: "test-metadata-only",
+			Labels: map[string]string{
+				"test": "test-metadata-only",
+			},
+		},
+		Data: map[string]string{
+			"big": "data",
+		},
+	}
+	err := cli.Upsert(ctx, cm, cm, &cm)
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		if err := cli.Delete(ctx, cm, nil); err != nil && !IsNotFound(err) {
+			t.Error(err)
+		}
+	})
+
+	// Use a separate client for watching so we can bypass any caching.
+	_, cli2 := testClient(t, nil)
+	acc, err := cli2.Watch(ctx, Query{
+		Name:          "ConfigMaps",
+		Kind:          "ConfigMap",
+		LabelSelector: "test=test-metadata-only",
+		MetadataOnly:  true,
+	})
+	require.NoError(t, err)
+
+	snap := &Snap{}
+	for {
+		<-acc.Changed()
+		updated, err := acc.Update(ctx, snap)
+		require.NoError(t, err)
+		if updated {
+			break
+		}
+	}
+
+	require.Equal(t, 1, len(snap.ConfigMaps))
+	assert.Equal(t, "test-metadata-only", snap.ConfigMaps[0].GetName())
+	assert.Equal(t, cm.GetResourceVersion(), snap.ConfigMaps[0].GetResourceVersion())
+	assert.Empty(t, snap.ConfigMaps[0].Data)
+}
+
+// Make sure that the Transform of a Query gets to see (and change) every resource before the
+// snapshot does.
+func TestTransform(t *testing.T) {
+	ctx, cli := testClient(t, nil)
+	cm := &ConfigMap{
+		TypeMeta: TypeMeta{
+			Kind: "ConfigMap",
+		},
+		ObjectMeta: ObjectMeta{
+			Name: "test-transform",
+			Labels: map[string]string{
+				"test": "test-transform",
+			},
+		},
+		Data: map[string]string{
+			"keep": "this",
+			"drop": "that",
+		},
+	}
+	err := cli.Upsert(ctx, cm, cm, &cm)
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		if err := cli.Delete(ctx, cm, nil); err != nil && !IsNotFound(err) {
+			t.Error(err)
+		}
+	})
+
+	_, cli2 := testClient(t, nil)
+	acc, err := cli2.Watch(ctx, Query{
+		Name:          "ConfigMaps",
+		Kind:          "ConfigMap",
+		LabelSelector: "test=test-transform",
+		Transform: func(un *Unstructured) {
+			un.SetManagedFields(nil)
+			if data, ok := un.Object["data"].(map[string]interface{}); ok {
+				delete(data, "drop")
+			}
+		},
+	})
+	require.NoError(t, err)
+
+	snap := &Snap{}
+	for {
+		<-acc.Changed()
+		updated, err := acc.Update(ctx, snap)
+		require.NoError(t, err)
+		if updated {
+			break
+		}
+	}
+
+	require.Equal(t, 1, len(snap.ConfigMaps))
+	assert.Equal(t, map[string]string{"keep": "this"}, snap.ConfigMaps[0].Data)
+	assert.Empty(t, snap.ConfigMaps[0].GetManagedFields())
+}
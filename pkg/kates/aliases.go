@@ -45,6 +45,7 @@ import (
 
 type TypeMeta = metav1.TypeMeta
 type ObjectMeta = metav1.ObjectMeta
+type PartialObjectMetadata = metav1.PartialObjectMetadata
 type APIResource = metav1.APIResource
 
 type Namespace = corev1.Namespace
@@ -141,6 +142,7 @@ var MustParseQuantity = resource.MustParse
 type Quantity = resource.Quantity
 type IntOrString = intstr.IntOrString
 type Time = metav1.Time
+type Duration = metav1.Duration
 
 var Int = intstr.Int
 
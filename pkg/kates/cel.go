@@ -0,0 +1,261 @@
+package kates
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/google/cel-go/cel"
+	"github.com/google/cel-go/checker/decls"
+	"github.com/google/cel-go/common/types"
+	"github.com/google/cel-go/ext"
+	"google.golang.org/protobuf/proto"
+	utiljson "k8s.io/apimachinery/pkg/util/json"
+	fieldpath "k8s.io/apimachinery/pkg/util/validation/field"
+)
+
+// The Kubernetes 1.21 apiextensions types that we build against don't know about
+// x-kubernetes-validations, so we dig the CEL rules out of the raw (unstructured) schema ourselves
+// rather than out of the typed JSONSchemaProps.
+
+// A celRule is a single compiled x-kubernetes-validations rule.
+type celRule struct {
+	rule    string
+	message string
+	program cel.Program
+	// A transition rule refers to oldSelf, and so may only be evaluated when there is an old
+	// value to compare against.
+	transition bool
+}
+
+// A celSchema is the part of an OpenAPI schema that has CEL rules in it: the rules that apply to
+// this node, plus the nodes beneath it that have rules of their own. Nodes with no rules anywhere
+// beneath them are pruned, so walking an object only visits the parts of it that have rules.
+type celSchema struct {
+	typ        string
+	rules      []celRule
+	properties map[string]*celSchema
+	items      *celSchema
+	additional *celSchema
+}
+
+// celEnv is the standard CEL environment plus the string extensions. The Kubernetes CEL libraries
+// (lists, regex, url, ...) aren't in the apiserver we build against, so rules that use them fail to
+// compile.
+var celEnv = func() *cel.Env {
+	env, err := cel.NewEnv(
+		cel.Declarations(
+			decls.NewVar("self", decls.Dyn),
+			decls.NewVar("oldSelf", decls.Dyn),
+		),
+		ext.Strings(),
+	)
+	if err != nil {
+		panic(err)
+	}
+	return env
+}()
+
+// celCostLimit and celInterruptCheckFrequency bound how much work a single rule may do, using the
+// same per-call limit as the apiserver, so that an expensive rule (or a huge object) can't wedge
+// whoever is validating.
+const (
+	celCostLimit               = 1000000
+	celInterruptCheckFrequency = 100
+)
+
+// compileCELSchema compiles all the CEL rules in the supplied raw OpenAPI v3 schema. It returns
+// nil if there are no rules at all. Rules that fail to compile are left out, and returned as
+// errors alongside the rules that did compile.
+func compileCELSchema(schema map[string]interface{}) (*celSchema, fieldpath.ErrorList) {
+	var errs fieldpath.ErrorList
+	node := compileCELNode(schema, nil, &errs)
+	return node, errs
+}
+
+func compileCELNode(schema map[string]interface{}, fldPath *fieldpath.Path, errs *fieldpath.ErrorList) *celSchema {
+	if schema == nil {
+		return nil
+	}
+
+	node := &celSchema{}
+	node.typ, _ = schema["type"].(string)
+	empty := true
+
+	rules, _ := schema["x-kubernetes-validations"].([]interface{})
+	for i, untypedRule := range rules {
+		rule, _ := untypedRule.(map[string]interface{})
+		compiled, err := compileCELRule(rule)
+		if err != nil {
+			*errs = append(*errs, fieldpath.Invalid(fldPath.Child("x-kubernetes-validations").Index(i), rule["rule"], err.Error()))
+			continue
+		}
+		node.rules = append(node.rules, compiled)
+		empty = false
+	}
+
+	properties, _ := schema["properties"].(map[string]interface{})
+	for name, untypedProp := range properties {
+		prop, _ := untypedProp.(map[string]interface{})
+		child := compileCELNode(prop, fldPath.Child("properties").Key(name), errs)
+		if child != nil {
+			if node.properties == nil {
+				node.properties = make(map[string]*celSchema)
+			}
+			node.properties[name] = child
+			empty = false
+		}
+	}
+
+	items, _ := schema["items"].(map[string]interface{})
+	child := compileCELNode(items, fldPath.Child("items"), errs)
+	if child != nil {
+		node.items = child
+		empty = false
+	}
+
+	// additionalProperties may also be a bool, in which case there's no schema (and no rules)
+	// for the values.
+	additional, _ := schema["additionalProperties"].(map[string]interface{})
+	child = compileCELNode(additional, fldPath.Child("additionalProperties"), errs)
+	if child != nil {
+		node.additional = child
+		empty = false
+	}
+
+	if empty {
+		return nil
+	}
+	return node
+}
+
+func compileCELRule(rule map[string]interface{}) (celRule, error) {
+	text, _ := rule["rule"].(string)
+	if text == "" {
+		return celRule{}, fmt.Errorf("rule is required")
+	}
+	message, _ := rule["message"].(string)
+
+	ast, issues := celEnv.Compile(text)
+	if issues != nil && issues.Err() != nil {
+		return celRule{}, fmt.Errorf("compilation failed: %w", issues.Err())
+	}
+	if !proto.Equal(ast.ResultType(), decls.Bool) && !proto.Equal(ast.ResultType(), decls.Dyn) {
+		return celRule{}, fmt.Errorf("rule must evaluate to a bool")
+	}
+	checked, err := cel.AstToCheckedExpr(ast)
+	if err != nil {
+		return celRule{}, err
+	}
+	transition := false
+	for _, ref := range checked.ReferenceMap {
+		if ref.Name == "oldSelf" {
+			transition = true
+		}
+	}
+
+	program, err := celEnv.Program(ast,
+		cel.CostLimit(celCostLimit),
+		cel.InterruptCheckFrequency(celInterruptCheckFrequency),
+	)
+	if err != nil {
+		return celRule{}, err
+	}
+
+	return celRule{
+		rule:       text,
+		message:    message,
+		program:    program,
+		transition: transition,
+	}, nil
+}
+
+// validate evaluates the rules against the supplied object (which must already have been through
+// celValue). The old object may be nil, in which case transition rules are skipped. Evaluation
+// stops early if the context is cancelled.
+func (s *celSchema) validate(ctx context.Context, fldPath *fieldpath.Path, obj, old interface{}) fieldpath.ErrorList {
+	if s == nil || obj == nil {
+		return nil
+	}
+
+	var errs fieldpath.ErrorList
+
+	for _, rule := range s.rules {
+		vars := map[string]interface{}{"self": obj}
+		if rule.transition {
+			if old == nil {
+				continue
+			}
+			vars["oldSelf"] = old
+		}
+
+		val, _, err := rule.program.ContextEval(ctx, vars)
+		if err != nil {
+			errs = append(errs, fieldpath.Invalid(fldPath, s.typ, fmt.Sprintf("rule evaluation error: %v", err)))
+			continue
+		}
+		if val != types.True {
+			message := rule.message
+			if message == "" {
+				message = fmt.Sprintf("failed rule: %s", strings.TrimSpace(rule.rule))
+			}
+			errs = append(errs, fieldpath.Invalid(fldPath, s.typ, message))
+		}
+	}
+
+	switch obj := obj.(type) {
+	case map[string]interface{}:
+		oldMap, _ := old.(map[string]interface{})
+		keys := make([]string, 0, len(obj))
+		for key := range obj {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			value := obj[key]
+			var child *celSchema
+			var childPath *fieldpath.Path
+			if prop, ok := s.properties[key]; ok {
+				child, childPath = prop, fldPath.Child(key)
+			} else if s.additional != nil {
+				child, childPath = s.additional, fldPath.Key(key)
+			}
+			if child == nil {
+				continue
+			}
+			var oldValue interface{}
+			if oldMap != nil {
+				oldValue = oldMap[key]
+			}
+			errs = append(errs, child.validate(ctx, childPath, value, oldValue)...)
+		}
+	case []interface{}:
+		// We don't try to correlate list items with the old list, so transition rules on
+		// items never fire.
+		for i, item := range obj {
+			errs = append(errs, s.items.validate(ctx, fldPath.Index(i), item, nil)...)
+		}
+	}
+
+	return errs
+}
+
+// celValue turns an arbitrary jsonish value into the plain maps, slices, and scalars that CEL
+// knows how to deal with, with whole numbers as int64 (as the apiserver would have them) rather
+// than float64.
+func celValue(in interface{}) (interface{}, error) {
+	if in == nil {
+		return nil, nil
+	}
+	jsonBytes, err := json.Marshal(in)
+	if err != nil {
+		return nil, err
+	}
+	var out interface{}
+	if err := utiljson.Unmarshal(jsonBytes, &out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
@@ -26,6 +26,7 @@ import (
 	"k8s.io/client-go/discovery/cached/disk"
 	"k8s.io/client-go/discovery/cached/memory"
 	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/metadata"
 	"k8s.io/client-go/rest"
 	"k8s.io/client-go/restmapper"
 	"k8s.io/client-go/tools/cache"
@@ -77,13 +78,18 @@ import (
 //  2. The Accumulator API is guaranteed to bootstrap (i.e. perform an initial List operation) on
 //     all watches prior to notifying the user that resources are available to process.
 type Client struct {
-	config                 *ConfigFlags
-	cli                    dynamic.Interface
-	mapper                 meta.RESTMapper
-	disco                  discovery.CachedDiscoveryInterface
-	mutex                  sync.Mutex
-	canonical              map[string]*Unstructured
-	maxAccumulatorInterval time.Duration
+	config         *ConfigFlags
+	cli            dynamic.Interface
+	metacli        metadata.Interface
+	mapper         meta.RESTMapper
+	disco          discovery.CachedDiscoveryInterface
+	mutex          sync.Mutex
+	canonical      map[string]*Unstructured
+	coalescePolicy CoalescePolicy
+
+	// The statsMutex protects the stats map, which is keyed by Query name.
+	statsMutex sync.Mutex
+	stats      map[string]*WatchStats
 
 	// This is an internal interface for testing, it lets us deliberately introduce delays into the
 	// implementation, e.g. effectively increasing the latency to the api server in a controllable
@@ -144,21 +150,28 @@ func NewClientFromConfigFlags(config *ConfigFlags) (*Client, error) {
 		return nil, err
 	}
 
+	metacli, err := metadata.NewForConfig(restconfig)
+	if err != nil {
+		return nil, err
+	}
+
 	mapper, disco, err := NewRESTMapper(config)
 	if err != nil {
 		return nil, err
 	}
 
 	return &Client{
-		config:                 config,
-		cli:                    cli,
-		mapper:                 mapper,
-		disco:                  disco,
-		canonical:              make(map[string]*Unstructured),
-		maxAccumulatorInterval: 1 * time.Second,
-		watchAdded:             func(oldObj, newObj *Unstructured) {},
-		watchUpdated:           func(oldObj, newObj *Unstructured) {},
-		watchDeleted:           func(oldObj, newObj *Unstructured) {},
+		config:         config,
+		cli:            cli,
+		metacli:        metacli,
+		mapper:         mapper,
+		disco:          disco,
+		canonical:      make(map[string]*Unstructured),
+		stats:          make(map[string]*WatchStats),
+		coalescePolicy: DefaultCoalescePolicy,
+		watchAdded:     func(oldObj, newObj *Unstructured) {},
+		watchUpdated:   func(oldObj, newObj *Unstructured) {},
+		watchDeleted:   func(oldObj, newObj *Unstructured) {},
 	}, nil
 }
 
@@ -215,16 +228,84 @@ func InCluster() bool {
 
 // Sets the max interval to wait before sending changes for snapshot updates. The interval must
 // be non-negative, otherwise it will return an error.
+//
+// This is both the MaxLatency and the MaxInterval of the Client's CoalescePolicy, so that under
+// sustained churn the Accumulator notifies about once per interval, as it always has; the
+// MinInterval is reduced to fit underneath it if need be. Isolated changes are still noticed
+// after just the MinInterval.
 func (c *Client) MaxAccumulatorInterval(interval time.Duration) error {
 	c.mutex.Lock()
 	defer c.mutex.Unlock()
 	if interval <= 0 {
 		return fmt.Errorf("interval must be positive")
 	}
-	c.maxAccumulatorInterval = interval
+	c.coalescePolicy.MaxLatency = interval
+	c.coalescePolicy.MaxInterval = interval
+	if c.coalescePolicy.MinInterval > interval {
+		c.coalescePolicy.MinInterval = interval
+	}
+	return nil
+}
+
+// Sets the CoalescePolicy used by Accumulators created after this call.
+func (c *Client) AccumulatorCoalescePolicy(policy CoalescePolicy) error {
+	if err := policy.validate(); err != nil {
+		return err
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.coalescePolicy = policy
 	return nil
 }
 
+// The WatchStats struct counts the trouble that the watches for a single Query have run in to. When
+// a Query covers several namespaces, the counts are for all of them together.
+type WatchStats struct {
+	// The Relists field counts how many times the resources had to be listed again after the
+	// first time, because the watch couldn't pick up where it left off.
+	Relists int `json:"relists"`
+	// The Expired field counts how many times the watch was closed because the resourceVersion
+	// it was watching from was too old.
+	Expired int `json:"expired"`
+	// The Forbidden field counts how many times listing the resources was forbidden.
+	Forbidden int `json:"forbidden"`
+	// The Errors field counts every other failure to list or watch the resources.
+	Errors int `json:"errors"`
+	// The LastError and LastErrorTime fields describe the most recent failure of any kind.
+	LastError     string    `json:"lastError,omitempty"`
+	LastErrorTime time.Time `json:"lastErrorTime,omitempty"`
+}
+
+// The WatchStats method returns the WatchStats of every Query that the Client has watched, keyed by
+// Query name.
+func (c *Client) WatchStats() map[string]WatchStats {
+	c.statsMutex.Lock()
+	defer c.statsMutex.Unlock()
+	result := make(map[string]WatchStats, len(c.stats))
+	for name, stats := range c.stats {
+		result[name] = *stats
+	}
+	return result
+}
+
+// recordWatch updates the WatchStats of the named Query.
+func (c *Client) recordWatch(name string, f func(*WatchStats)) {
+	c.statsMutex.Lock()
+	defer c.statsMutex.Unlock()
+	stats, ok := c.stats[name]
+	if !ok {
+		stats = &WatchStats{}
+		c.stats[name] = stats
+	}
+	f(stats)
+}
+
+// failed remembers the error as the most recent one.
+func (stats *WatchStats) failed(err error) {
+	stats.LastError = err.Error()
+	stats.LastErrorTime = time.Now()
+}
+
 // DynamicInterface is an accessor method to the k8s dynamic client
 func (c *Client) DynamicInterface() dynamic.Interface {
 	return c.cli
@@ -361,6 +442,17 @@ type Query struct {
 	Kind string
 	// The Namespace field holds the namespace to Query.
 	Namespace string
+	// The Namespaces field holds a list of namespaces to Query. If it
+	// is set, it overrides the Namespace field, and Watch will watch
+	// each of the namespaces separately and merge the results. This is
+	// ignored for List, and for cluster-scoped Kinds.
+	Namespaces []string
+	// The NamespaceSelector field holds a string in selector syntax
+	// that is used to pick the namespaces to Query by their labels. If
+	// it is set, it overrides both Namespace and Namespaces, and Watch
+	// will start and stop watching namespaces as they come and go. This
+	// is ignored for List, and for cluster-scoped Kinds.
+	NamespaceSelector string
 	// The FieldSelector field holds a string in selector syntax
 	// that is used to filter results based on field values. The
 	// only field values supported are metadata.name and
@@ -369,6 +461,19 @@ type Query struct {
 	// The LabelSelector field holds a string in selector syntax
 	// that is used to filter results based on label values.
 	LabelSelector string
+	// The MetadataOnly field, if set, makes Watch fetch only the
+	// metadata of each resource, leaving everything else (the spec,
+	// the status, the data of a Secret, ...) empty. This saves a lot
+	// of memory on Kinds with many big resources that are mostly
+	// uninteresting. This is ignored for List.
+	MetadataOnly bool
+	// The Transform field, if set, is called on every resource as
+	// it comes in from the apiserver, before it gets stored anywhere,
+	// so that it can strip out the parts of resources that nobody
+	// needs. It may modify the resource in place, but it must leave
+	// the apiVersion, kind, name, namespace, and resourceVersion
+	// alone. This is ignored for List.
+	Transform func(*Unstructured)
 }
 
 func (c *Client) Watch(ctx context.Context, queries ...Query) (*Accumulator, error) {
@@ -377,7 +482,7 @@ func (c *Client) Watch(ctx context.Context, queries ...Query) (*Accumulator, err
 
 // ==
 
-func (c *Client) watchRaw(ctx context.Context, query Query, target chan rawUpdate, cli dynamic.ResourceInterface) {
+func (c *Client) watchRaw(ctx context.Context, query Query, watch *namespaceWatch, target chan rawUpdate, cli listWatchClient) {
 	var informer cache.SharedInformer
 
 	// we override Watch to let us signal when our initial List is
@@ -385,36 +490,46 @@ func (c *Client) watchRaw(ctx context.Context, query Query, target chan rawUpdat
 	// resource instances of the kind being watched
 	lw := newListWatcher(ctx, cli, query, func(lw *lw) {
 		if lw.hasSynced() {
-			target <- rawUpdate{query.Name, true, nil, nil, time.Now()}
+			target <- rawUpdate{query.Name, watch, true, nil, nil}
 		}
 	})
+	lw.record = func(f func(*WatchStats)) { c.recordWatch(query.Name, f) }
 	informer = cache.NewSharedInformer(lw, &Unstructured{}, 5*time.Minute)
-	// TODO: uncomment this when we get to kubernetes 1.19. Right now errors will get logged by
-	// klog. With this error handler in place we will log them to our own logger and provide a
-	// more useful error message:
-	/*
-		informer.SetWatchErrorHandler(func(r *cache.Reflector, err error) {
-			// This is from client-go/tools/cache/reflector.go:563
-			isExpiredError := func(err error) bool {
-				// In Kubernetes 1.17 and earlier, the api server returns both apierrors.StatusReasonExpired and
-				// apierrors.StatusReasonGone for HTTP 410 (Gone) status code responses. In 1.18 the kube server is more consistent
-				// and always returns apierrors.StatusReasonExpired. For backward compatibility we can only remove the apierrors.IsGone
-				// check when we fully drop support for Kubernetes 1.17 servers from reflectors.
-				return apierrors.IsResourceExpired(err) || apierrors.IsGone(err)
-			}
+	err := informer.SetWatchErrorHandler(func(r *cache.Reflector, err error) {
+		// This is from client-go/tools/cache/reflector.go:563
+		isExpiredError := func(err error) bool {
+			// In Kubernetes 1.17 and earlier, the api server returns both apierrors.StatusReasonExpired and
+			// apierrors.StatusReasonGone for HTTP 410 (Gone) status code responses. In 1.18 the kube server is more consistent
+			// and always returns apierrors.StatusReasonExpired. For backward compatibility we can only remove the apierrors.IsGone
+			// check when we fully drop support for Kubernetes 1.17 servers from reflectors.
+			return apierrors.IsResourceExpired(err) || apierrors.IsGone(err)
+		}
 
-			switch {
-			case isExpiredError(err):
-				log.Printf("Watch of %s closed with: %v", query.Kind, err)
-			case err == io.EOF:
-				// watch closed normally
-			case err == io.ErrUnexpectedEOF:
-				log.Printf("Watch for %s closed with unexpected EOF: %v", query.Kind, err)
-			default:
-				log.Printf("Failed to watch %s: %v", query.Kind, err)
-			}
-		})
-	*/
+		var forbidden bool
+		lw.withMutex(func() { forbidden = lw.listForbidden })
+
+		switch {
+		case isExpiredError(err):
+			dlog.Debugf(ctx, "watch of %s (%s) closed with: %v", query.Name, query.Kind, err)
+			lw.record(func(stats *WatchStats) { stats.Expired++ })
+		case err == io.EOF:
+			// watch closed normally
+		case forbidden:
+			// We already counted this (and pretended to be synced) when the list was
+			// forbidden, so there's nothing more to say.
+			dlog.Debugf(ctx, "unable to watch %s (%s): %v", query.Name, query.Kind, err)
+		case err == io.ErrUnexpectedEOF:
+			dlog.Infof(ctx, "watch of %s (%s) closed with unexpected EOF: %v", query.Name, query.Kind, err)
+			lw.record(func(stats *WatchStats) { stats.Errors++; stats.failed(err) })
+		default:
+			dlog.Warnf(ctx, "failed to watch %s (%s): %v", query.Name, query.Kind, err)
+			lw.record(func(stats *WatchStats) { stats.Errors++; stats.failed(err) })
+		}
+	})
+	if err != nil {
+		// This can only happen if the informer is already running, which it isn't.
+		dlog.Errorf(ctx, "unable to handle watch errors for %s (%s): %v", query.Name, query.Kind, err)
+	}
 	informer.AddEventHandler(
 		cache.ResourceEventHandlerFuncs{
 			AddFunc: func(obj interface{}) {
@@ -424,7 +539,7 @@ func (c *Client) watchRaw(ctx context.Context, query Query, target chan rawUpdat
 				// better/faster tests.
 				c.watchAdded(nil, obj.(*Unstructured))
 				lw.countAddEvent()
-				target <- rawUpdate{query.Name, lw.hasSynced(), nil, obj.(*Unstructured), time.Now()}
+				target <- rawUpdate{query.Name, watch, lw.hasSynced(), nil, obj.(*Unstructured)}
 			},
 			UpdateFunc: func(oldObj, newObj interface{}) {
 				old := oldObj.(*Unstructured)
@@ -435,7 +550,7 @@ func (c *Client) watchRaw(ctx context.Context, query Query, target chan rawUpdat
 				// nicer prettier set of hooks, but for now all we need is this hack for
 				// better/faster tests.
 				c.watchUpdated(old, new)
-				target <- rawUpdate{query.Name, lw.hasSynced(), old, new, time.Now()}
+				target <- rawUpdate{query.Name, watch, lw.hasSynced(), old, new}
 			},
 			DeleteFunc: func(obj interface{}) {
 				var old *Unstructured
@@ -458,7 +573,7 @@ func (c *Client) watchRaw(ctx context.Context, query Query, target chan rawUpdat
 				c.mutex.Lock()
 				delete(c.canonical, key)
 				c.mutex.Unlock()
-				target <- rawUpdate{query.Name, lw.hasSynced(), old, nil, time.Now()}
+				target <- rawUpdate{query.Name, watch, lw.hasSynced(), old, nil}
 			},
 		},
 	)
@@ -467,20 +582,24 @@ func (c *Client) watchRaw(ctx context.Context, query Query, target chan rawUpdat
 }
 
 type rawUpdate struct {
-	name   string
+	name string
+	// The watch field identifies which of the (possibly many) watches for the named Query the
+	// update came from. It is nil for the watches of Namespaces behind a NamespaceSelector.
+	watch  *namespaceWatch
 	synced bool
 	old    *unstructured.Unstructured
 	new    *unstructured.Unstructured
-	ts     time.Time
 }
 
 type lw struct {
 	// All these fields are read-only and initialized on construction.
 	ctx    context.Context
-	client dynamic.ResourceInterface
+	client listWatchClient
 	query  Query
 	synced func(*lw)
 	once   sync.Once
+	// The record function updates the WatchStats of the query.
+	record func(func(*WatchStats))
 
 	// The mutex protects all the read-write fields.
 	mutex            sync.Mutex
@@ -490,8 +609,8 @@ type lw struct {
 	listForbidden    bool
 }
 
-func newListWatcher(ctx context.Context, client dynamic.ResourceInterface, query Query, synced func(*lw)) *lw {
-	return &lw{ctx: ctx, client: client, query: query, synced: synced}
+func newListWatcher(ctx context.Context, client listWatchClient, query Query, synced func(*lw)) *lw {
+	return &lw{ctx: ctx, client: client, query: query, synced: synced, record: func(func(*WatchStats)) {}}
 }
 
 func (lw *lw) withMutex(f func()) {
@@ -532,9 +651,17 @@ func (lw *lw) List(opts ListOptions) (runtime.Object, error) {
 	synced := false
 	forbidden := false
 
+	// If we've listed successfully before, then the watch couldn't carry on from where it was,
+	// which is worth knowing about.
+	relist := false
+	lw.withMutex(func() {
+		relist = lw.initialListDone
+	})
+
 	opts.FieldSelector = lw.query.FieldSelector
 	opts.LabelSelector = lw.query.LabelSelector
 	result, err := lw.client.List(lw.ctx, opts)
+	listErr := err
 
 	if err == nil {
 		// No error, the list worked out fine. We can be synced now...
@@ -569,6 +696,26 @@ func (lw *lw) List(opts ListOptions) (runtime.Object, error) {
 		lw.listForbidden = forbidden
 	})
 
+	lw.record(func(stats *WatchStats) {
+		switch {
+		case forbidden:
+			stats.Forbidden++
+			stats.failed(listErr)
+		case listErr != nil:
+			stats.Errors++
+			stats.failed(listErr)
+		}
+		if relist {
+			stats.Relists++
+		}
+	})
+
+	if err == nil && lw.query.Transform != nil {
+		for i := range result.Items {
+			lw.query.Transform(&result.Items[i])
+		}
+	}
+
 	return result, err
 }
 
@@ -592,6 +739,15 @@ func (lw *lw) Watch(opts ListOptions) (watch.Interface, error) {
 		}
 	}
 
+	if err == nil && lw.query.Transform != nil {
+		iface = watch.Filter(iface, func(event watch.Event) (watch.Event, bool) {
+			if un, ok := event.Object.(*Unstructured); ok {
+				lw.query.Transform(un)
+			}
+			return event, true
+		})
+	}
+
 	return iface, err
 }
 
@@ -606,6 +762,16 @@ func (c *Client) cliFor(mapping *meta.RESTMapping, namespace string) dynamic.Res
 	}
 }
 
+// metadataCliFor is like cliFor, but for watching just the metadata of resources.
+func (c *Client) metadataCliFor(mapping *meta.RESTMapping, namespace string) listWatchClient {
+	cli := c.metacli.Resource(mapping.Resource)
+	if mapping.Scope.Name() == meta.RESTScopeNameNamespace && namespace != NamespaceAll {
+		return &metadataClient{cli.Namespace(namespace), mapping.GroupVersionKind}
+	} else {
+		return &metadataClient{cli, mapping.GroupVersionKind}
+	}
+}
+
 func (c *Client) cliForResource(resource *Unstructured) (dynamic.ResourceInterface, error) {
 	mapping, err := c.mappingFor(resource.GroupVersionKind().GroupKind().String())
 	if err != nil {
@@ -639,6 +805,7 @@ func (c *Client) newField(q Query) (*field, error) {
 		selector: sel,
 		values:   make(map[string]*Unstructured),
 		deltas:   make(map[string]*Delta),
+		watches:  make(map[string]*namespaceWatch),
 	}, nil
 }
 
@@ -1048,14 +1215,14 @@ func (c *Client) patchWatch(ctx context.Context, field *field) error {
 				// The object in the watch result is stale, so we update it with the canonical
 				// version and track it as a delta.
 				dlog.Println(ctx, "Patching update", field.mapping.GroupVersionKind.Kind, key)
-				field.values[key] = can
+				field.values[key] = field.transform(can)
 				field.deltas[key] = newDelta(ObjectUpdate, can)
 			}
 		} else if can != nil && can.GroupVersionKind() == field.mapping.GroupVersionKind &&
-			field.selector.Matches(LabelSet(can.GetLabels())) {
+			field.selector.Matches(LabelSet(can.GetLabels())) && field.watching(can.GetNamespace()) {
 			// An object that was created locally is not yet present in the watch result, so we add it.
 			dlog.Println(ctx, "Patching add", field.mapping.GroupVersionKind.Kind, key)
-			field.values[key] = can
+			field.values[key] = field.transform(can)
 			field.deltas[key] = newDelta(ObjectAdd, can)
 		}
 	}
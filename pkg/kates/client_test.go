@@ -12,7 +12,11 @@ import (
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
 	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/watch"
 
 	"github.com/datawire/dlib/dlog"
 	dtest_k3s "github.com/datawire/dtest"
@@ -646,3 +650,55 @@ func TestPatchWatch(t *testing.T) {
 	require.NoError(err)
 	assert.NotContains(field.values, p1Key)
 }
+
+// The scriptedList is a listWatchClient whose List calls return the given errors in turn (and then
+// succeed forever).
+type scriptedList struct {
+	errs []error
+}
+
+func (s *scriptedList) List(_ context.Context, _ ListOptions) (*unstructured.UnstructuredList, error) {
+	if len(s.errs) > 0 {
+		err := s.errs[0]
+		s.errs = s.errs[1:]
+		if err != nil {
+			return nil, err
+		}
+	}
+	return &unstructured.UnstructuredList{}, nil
+}
+
+func (s *scriptedList) Watch(_ context.Context, _ ListOptions) (watch.Interface, error) {
+	return watch.NewEmptyWatch(), nil
+}
+
+func TestWatchStats(t *testing.T) {
+	ctx := dlog.NewTestContext(t, false)
+	cli := &Client{stats: map[string]*WatchStats{}}
+
+	forbidden := apierrors.NewForbidden(schema.GroupResource{Resource: "secrets"}, "", fmt.Errorf("nope"))
+	transient := fmt.Errorf("connection refused")
+	lw := newListWatcher(ctx, &scriptedList{errs: []error{transient, forbidden, nil, nil}}, Query{Name: "secrets", Kind: "Secret"}, func(*lw) {})
+	lw.record = func(f func(*WatchStats)) { cli.recordWatch("secrets", f) }
+
+	for i := 0; i < 4; i++ {
+		_, err := lw.List(ListOptions{})
+		if i == 0 {
+			require.Error(t, err)
+		} else {
+			require.NoError(t, err)
+		}
+	}
+
+	stats := cli.WatchStats()["secrets"]
+	assert.Equal(t, 1, stats.Errors)
+	assert.Equal(t, 1, stats.Forbidden)
+	// The forbidden list counts as the initial one, so the two after it are relists.
+	assert.Equal(t, 2, stats.Relists)
+	assert.Equal(t, forbidden.Error(), stats.LastError)
+	assert.False(t, stats.LastErrorTime.IsZero())
+
+	// The stats handed out are a copy.
+	cli.WatchStats()["secrets"] = WatchStats{}
+	assert.Equal(t, 2, cli.WatchStats()["secrets"].Relists)
+}
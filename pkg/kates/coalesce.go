@@ -0,0 +1,171 @@
+package kates
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/emissary-ingress/emissary/v3/pkg/debug"
+)
+
+// The CoalescePolicy struct controls how an Accumulator coalesces bursts of changes into a single
+// notification.
+//
+// The Accumulator waits for things to go quiet before notifying: once a change arrives, it waits
+// until no further change has arrived for the current interval. The interval starts out at
+// MinInterval, so that an isolated change is noticed quickly. Whenever a notification has to
+// coalesce more than ChurnThreshold changes, the interval doubles (up to MaxInterval), and
+// whenever a notification covers just a single change it halves again, so that during sustained
+// churn (e.g. a large rollout) we notify much less often. However busy things are, no change waits
+// more than MaxLatency before a notification goes out.
+type CoalescePolicy struct {
+	MinInterval    time.Duration
+	MaxInterval    time.Duration
+	MaxLatency     time.Duration
+	ChurnThreshold int
+}
+
+// The DefaultCoalescePolicy is the policy a Client starts out with.
+var DefaultCoalescePolicy = CoalescePolicy{
+	MinInterval:    100 * time.Millisecond,
+	MaxInterval:    500 * time.Millisecond,
+	MaxLatency:     1 * time.Second,
+	ChurnThreshold: 10,
+}
+
+func (p CoalescePolicy) validate() error {
+	if p.MinInterval <= 0 {
+		return fmt.Errorf("MinInterval must be positive")
+	}
+	if p.MaxInterval < p.MinInterval {
+		return fmt.Errorf("MaxInterval must be at least MinInterval")
+	}
+	if p.MaxLatency < p.MaxInterval {
+		return fmt.Errorf("MaxLatency must be at least MaxInterval")
+	}
+	if p.ChurnThreshold < 1 {
+		return fmt.Errorf("ChurnThreshold must be at least 1")
+	}
+	return nil
+}
+
+// The CoalesceStats struct describes what an Accumulator's coalescing has been up to.
+type CoalesceStats struct {
+	// The Interval field is the quiet period currently being waited for.
+	Interval time.Duration
+	// The Changes field counts all the changes seen, and the Notifications field counts the
+	// notifications they were coalesced into.
+	Changes       int
+	Notifications int
+	// The LastCoalesced and MaxCoalesced fields are the number of changes in the most recent
+	// notification, and in the biggest one.
+	LastCoalesced int
+	MaxCoalesced  int
+	// The LatencyBound field counts the notifications that went out because of MaxLatency rather
+	// than because things went quiet.
+	LatencyBound int
+}
+
+func (s CoalesceStats) MarshalJSON() ([]byte, error) {
+	type plain CoalesceStats
+	return json.Marshal(struct {
+		plain
+		Interval string
+	}{plain(s), s.Interval.String()})
+}
+
+// A coalescer implements a CoalescePolicy. It doesn't do any waiting itself, it just decides
+// (according to its clock) when a notification is due; the Accumulator does the waiting. It is
+// not safe for concurrent use.
+type coalescer struct {
+	policy CoalescePolicy
+	clock  debug.ClockFunc
+
+	interval     time.Duration
+	pending      int       // changes since the last notification
+	firstPending time.Time // when the first of those arrived
+	lastChange   time.Time
+	lastNotify   time.Time
+
+	stats CoalesceStats
+}
+
+func newCoalescer(policy CoalescePolicy, clock debug.ClockFunc) *coalescer {
+	c := &coalescer{
+		policy:     policy,
+		clock:      clock,
+		interval:   policy.MinInterval,
+		lastNotify: clock(),
+	}
+	c.stats.Interval = c.interval
+	return c
+}
+
+// The change method records that a change has arrived.
+func (c *coalescer) change() {
+	now := c.clock()
+	if c.pending == 0 {
+		c.firstPending = now
+		// If it's been quiet for a good while, then whatever churn we were adapting to is over.
+		if now.Sub(c.lastNotify) >= c.policy.MaxLatency {
+			c.interval = c.policy.MinInterval
+			c.stats.Interval = c.interval
+		}
+	}
+	c.pending++
+	c.lastChange = now
+	c.stats.Changes++
+}
+
+// The due method returns whether a notification should go out now.
+func (c *coalescer) due() bool {
+	if c.pending == 0 {
+		return false
+	}
+	return !c.clock().Before(c.deadline())
+}
+
+// The deadline method returns when the pending changes will be due, assuming no more arrive. It
+// is only meaningful if there are changes pending.
+func (c *coalescer) deadline() time.Time {
+	quiet := c.lastChange.Add(c.interval)
+	bound := c.firstPending.Add(c.policy.MaxLatency)
+	if bound.Before(quiet) {
+		return bound
+	}
+	return quiet
+}
+
+// The notified method records that a notification has gone out for all the pending changes, and
+// adapts the interval to how many of them there were.
+func (c *coalescer) notified() {
+	now := c.clock()
+	if now.Before(c.lastChange.Add(c.interval)) {
+		c.stats.LatencyBound++
+	}
+
+	// The first notification is the bootstrap, which says nothing about churn.
+	switch {
+	case c.stats.Notifications == 0:
+	case c.pending > c.policy.ChurnThreshold:
+		c.interval *= 2
+		if c.interval > c.policy.MaxInterval {
+			c.interval = c.policy.MaxInterval
+		}
+	case c.pending <= 1:
+		c.interval /= 2
+		if c.interval < c.policy.MinInterval {
+			c.interval = c.policy.MinInterval
+		}
+	}
+
+	c.stats.Interval = c.interval
+	c.stats.Notifications++
+	c.stats.LastCoalesced = c.pending
+	if c.pending > c.stats.MaxCoalesced {
+		c.stats.MaxCoalesced = c.pending
+	}
+
+	c.pending = 0
+	c.lastNotify = now
+}
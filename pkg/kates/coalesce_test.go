@@ -0,0 +1,220 @@
+package kates
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/datawire/dlib/dlog"
+	"github.com/emissary-ingress/emissary/v3/pkg/debug"
+)
+
+var testPolicy = CoalescePolicy{
+	MinInterval:    100 * time.Millisecond,
+	MaxInterval:    400 * time.Millisecond,
+	MaxLatency:     time.Second,
+	ChurnThreshold: 3,
+}
+
+// testCoalescer returns a coalescer whose clock only moves when the returned function is called.
+func testCoalescer(policy CoalescePolicy) (*coalescer, func(time.Duration)) {
+	now := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+	c := newCoalescer(policy, func() time.Time { return now })
+	return c, func(d time.Duration) { now = now.Add(d) }
+}
+
+// burst feeds the coalescer changes every gap until one is due, and returns how many it took.
+func burst(c *coalescer, advance func(time.Duration), gap time.Duration) int {
+	for n := 1; ; n++ {
+		c.change()
+		advance(gap)
+		if c.due() {
+			c.notified()
+			return n
+		}
+	}
+}
+
+func TestCoalesceDebounce(t *testing.T) {
+	c, advance := testCoalescer(testPolicy)
+	assert.False(t, c.due())
+
+	// Bootstrap.
+	c.change()
+	assert.False(t, c.due())
+	advance(99 * time.Millisecond)
+	assert.False(t, c.due())
+	advance(time.Millisecond)
+	assert.True(t, c.due())
+	c.notified()
+	assert.False(t, c.due())
+
+	// An isolated change waits for MinInterval of quiet.
+	advance(5 * time.Second)
+	c.change()
+	advance(50 * time.Millisecond)
+	assert.False(t, c.due())
+	// ...which starts over with each change.
+	c.change()
+	advance(50 * time.Millisecond)
+	assert.False(t, c.due())
+	advance(50 * time.Millisecond)
+	assert.True(t, c.due())
+	c.notified()
+
+	assert.Equal(t, CoalesceStats{
+		Interval:      100 * time.Millisecond,
+		Changes:       3,
+		Notifications: 2,
+		LastCoalesced: 2,
+		MaxCoalesced:  2,
+	}, c.stats)
+}
+
+func TestCoalesceChurn(t *testing.T) {
+	c, advance := testCoalescer(testPolicy)
+	c.change()
+	advance(100 * time.Millisecond)
+	require.True(t, c.due())
+	c.notified()
+
+	// Changes every 50ms never go quiet for 100ms, so each notification is held off by
+	// MaxLatency, coalescing more than ChurnThreshold changes and doubling the interval, up to
+	// MaxInterval.
+	for _, interval := range []time.Duration{200, 400, 400} {
+		assert.Equal(t, 20, burst(c, advance, 50*time.Millisecond))
+		assert.Equal(t, interval*time.Millisecond, c.stats.Interval)
+	}
+	assert.Equal(t, 3, c.stats.LatencyBound)
+
+	// Now that the interval is longer, changes every 300ms get coalesced too.
+	assert.Equal(t, 4, burst(c, advance, 300*time.Millisecond))
+	assert.Equal(t, 400*time.Millisecond, c.stats.Interval)
+	assert.Equal(t, 4, c.stats.LatencyBound)
+
+	// As the churn dies down, the interval shrinks again.
+	advance(400 * time.Millisecond)
+	assert.Equal(t, 1, burst(c, advance, 400*time.Millisecond))
+	assert.Equal(t, 200*time.Millisecond, c.stats.Interval)
+	assert.Equal(t, 1, burst(c, advance, 200*time.Millisecond))
+	assert.Equal(t, 100*time.Millisecond, c.stats.Interval)
+	assert.Equal(t, 1, burst(c, advance, 100*time.Millisecond))
+	assert.Equal(t, 100*time.Millisecond, c.stats.Interval)
+
+	assert.Equal(t, 8, c.stats.Notifications)
+	assert.Equal(t, 20, c.stats.MaxCoalesced)
+	assert.Equal(t, 1, c.stats.LastCoalesced)
+	assert.Equal(t, 1+60+4+3, c.stats.Changes)
+}
+
+func TestCoalesceQuietResets(t *testing.T) {
+	c, advance := testCoalescer(testPolicy)
+	c.change()
+	advance(100 * time.Millisecond)
+	c.notified()
+	burst(c, advance, 50*time.Millisecond)
+	burst(c, advance, 50*time.Millisecond)
+	require.Equal(t, 400*time.Millisecond, c.stats.Interval)
+
+	// After MaxLatency without any changes, we're back to MinInterval straight away.
+	advance(time.Second)
+	c.change()
+	assert.Equal(t, 100*time.Millisecond, c.stats.Interval)
+	advance(100 * time.Millisecond)
+	assert.True(t, c.due())
+}
+
+func TestCoalesceDeadline(t *testing.T) {
+	c, advance := testCoalescer(testPolicy)
+	start := c.clock()
+
+	c.change()
+	assert.Equal(t, start.Add(100*time.Millisecond), c.deadline())
+	for i := 0; i < 12; i++ {
+		advance(80 * time.Millisecond)
+		c.change()
+	}
+	// The quiet period would end at 1.06s, but MaxLatency comes first.
+	assert.Equal(t, start.Add(time.Second), c.deadline())
+	advance(40 * time.Millisecond)
+	assert.True(t, c.due())
+}
+
+func TestCoalescePolicy(t *testing.T) {
+	assert.NoError(t, DefaultCoalescePolicy.validate())
+	assert.NoError(t, testPolicy.validate())
+
+	bad := testPolicy
+	bad.MinInterval = 0
+	assert.Error(t, bad.validate())
+
+	bad = testPolicy
+	bad.MaxInterval = 50 * time.Millisecond
+	assert.Error(t, bad.validate())
+
+	bad = testPolicy
+	bad.MaxLatency = 300 * time.Millisecond
+	assert.Error(t, bad.validate())
+
+	bad = testPolicy
+	bad.ChurnThreshold = 0
+	assert.Error(t, bad.validate())
+
+	cli := &Client{coalescePolicy: DefaultCoalescePolicy}
+	assert.Error(t, cli.AccumulatorCoalescePolicy(bad))
+	require.NoError(t, cli.MaxAccumulatorInterval(300*time.Millisecond))
+	assert.Equal(t, CoalescePolicy{
+		MinInterval:    100 * time.Millisecond,
+		MaxInterval:    300 * time.Millisecond,
+		MaxLatency:     300 * time.Millisecond,
+		ChurnThreshold: 10,
+	}, cli.coalescePolicy)
+	assert.NoError(t, cli.coalescePolicy.validate())
+
+	// Under sustained churn, notifications are spaced out by the full interval.
+	require.NoError(t, cli.MaxAccumulatorInterval(5*time.Second))
+	assert.Equal(t, 5*time.Second, cli.coalescePolicy.MaxInterval)
+	assert.Equal(t, 5*time.Second, cli.coalescePolicy.MaxLatency)
+	assert.Equal(t, 100*time.Millisecond, cli.coalescePolicy.MinInterval)
+}
+
+func TestCoalesceStatsBeforeNotify(t *testing.T) {
+	dbg := debug.NewDebug()
+	ctx, cancel := context.WithCancel(debug.NewContext(dlog.NewTestContext(t, false), dbg))
+	defer cancel()
+
+	acc := &Accumulator{
+		namespaceSelectors: map[string]bool{},
+		namespaceCh:        make(chan rawUpdate),
+		changed:            make(chan struct{}),
+	}
+	go acc.Listen(ctx, nil, testPolicy)
+
+	// Whoever is notified of a change can already see it in the stats.
+	acc.namespaceCh <- rawUpdate{name: "selector", synced: true}
+	select {
+	case <-acc.changed:
+	case <-time.After(10 * time.Second):
+		t.Fatal("no notification")
+	}
+	stats := dbg.Value("kubernetesCoalescing").Load().(CoalesceStats)
+	assert.Equal(t, 1, stats.Changes)
+	assert.Equal(t, 1, stats.Notifications)
+}
+
+func TestCoalesceStatsJSON(t *testing.T) {
+	bytes, err := json.Marshal(CoalesceStats{Interval: 250 * time.Millisecond, Changes: 3, Notifications: 1})
+	require.NoError(t, err)
+	assert.JSONEq(t, `{
+		"Interval": "250ms",
+		"Changes": 3,
+		"Notifications": 1,
+		"LastCoalesced": 0,
+		"MaxCoalesced": 0,
+		"LatencyBound": 0
+	}`, string(bytes))
+}
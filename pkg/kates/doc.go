@@ -123,6 +123,11 @@
 //	        slowReconcile(&snapshot)
 //	    }
 //	}
+//
+// How long the Accumulator waits for events to coalesce before notifying adapts to how fast they
+// are arriving, within the bounds set by the Client's CoalescePolicy (see
+// Client.AccumulatorCoalescePolicy). What it has been up to is published as the
+// "kubernetesCoalescing" value of pkg/debug.
 package kates
 
 // TODO:
@@ -0,0 +1,77 @@
+package kates
+
+import (
+	"context"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/metadata"
+)
+
+// A listWatchClient is the part of a dynamic.ResourceInterface that the watch machinery uses.
+type listWatchClient interface {
+	List(ctx context.Context, opts ListOptions) (*unstructured.UnstructuredList, error)
+	Watch(ctx context.Context, opts ListOptions) (watch.Interface, error)
+}
+
+// The metadataClient is a listWatchClient that asks the apiserver for PartialObjectMetadata rather
+// than for whole resources, and dresses the results back up as Unstructureds of the right Kind (with
+// nothing but metadata), so the rest of the watch machinery doesn't need to know the difference.
+type metadataClient struct {
+	cli metadata.ResourceInterface
+	gvk schema.GroupVersionKind
+}
+
+func (m *metadataClient) List(ctx context.Context, opts ListOptions) (*unstructured.UnstructuredList, error) {
+	list, err := m.cli.List(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &unstructured.UnstructuredList{Object: map[string]interface{}{}}
+	result.SetResourceVersion(list.GetResourceVersion())
+	result.SetContinue(list.GetContinue())
+	for i := range list.Items {
+		un, err := m.unstructured(&list.Items[i])
+		if err != nil {
+			return nil, err
+		}
+		result.Items = append(result.Items, *un)
+	}
+	return result, nil
+}
+
+func (m *metadataClient) Watch(ctx context.Context, opts ListOptions) (watch.Interface, error) {
+	w, err := m.cli.Watch(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	return watch.Filter(w, func(event watch.Event) (watch.Event, bool) {
+		pom, ok := event.Object.(*PartialObjectMetadata)
+		if !ok {
+			// Errors come through as a *Status, which is fine as it is.
+			return event, true
+		}
+		un, err := m.unstructured(pom)
+		if err != nil {
+			status := apierrors.NewInternalError(err).ErrStatus
+			return watch.Event{Type: watch.Error, Object: &status}, true
+		}
+		event.Object = un
+		return event, true
+	}), nil
+}
+
+func (m *metadataClient) unstructured(pom *PartialObjectMetadata) (*Unstructured, error) {
+	objectMeta, err := runtime.DefaultUnstructuredConverter.ToUnstructured(&pom.ObjectMeta)
+	if err != nil {
+		return nil, err
+	}
+	un := &Unstructured{Object: map[string]interface{}{"metadata": objectMeta}}
+	un.SetGroupVersionKind(m.gvk)
+	return un, nil
+}
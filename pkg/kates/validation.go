@@ -11,19 +11,36 @@ import (
 	apiextV1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
 	apiextV1beta1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1beta1"
 	"k8s.io/apiextensions-apiserver/pkg/apiserver/validation"
+	fieldpath "k8s.io/apimachinery/pkg/util/validation/field"
 	"k8s.io/kube-openapi/pkg/validation/validate"
 
 	"github.com/datawire/dlib/derror"
+	"github.com/datawire/dlib/dlog"
 )
 
 // A Validator may be used in concert with a Client to perform
 // validate of freeform jsonish data structures as kubernetes CRDs.
 type Validator struct {
 	client *Client
-	static map[TypeMeta]*apiextVInternal.CustomResourceDefinition
+	static map[TypeMeta]*crdDefinition
 
 	mutex      sync.Mutex
-	validators map[TypeMeta]*validate.SchemaValidator
+	validators map[TypeMeta]*crdValidator
+}
+
+// A crdDefinition is a CRD both as the internal apiextensions type (for the OpenAPI schema) and
+// raw (for the x-kubernetes-validations CEL rules, which the apiextensions types we build against
+// don't know about).
+type crdDefinition struct {
+	typed *apiextVInternal.CustomResourceDefinition
+	raw   map[string]interface{}
+}
+
+// A crdValidator is everything needed to validate one version of one CRD. Either field may be
+// nil.
+type crdValidator struct {
+	schema *validate.SchemaValidator
+	rules  *celSchema
 }
 
 // The NewValidator constructor returns a *Validator that uses the
@@ -35,9 +52,15 @@ func NewValidator(client *Client, staticCRDs []Object) (*Validator, error) {
 		return nil, errors.New("at least 1 client or static CRD must be provided")
 	}
 
-	static := make(map[TypeMeta]*apiextVInternal.CustomResourceDefinition, len(staticCRDs))
+	static := make(map[TypeMeta]*crdDefinition, len(staticCRDs))
 	for i, untypedCRD := range staticCRDs {
 		var crd apiextVInternal.CustomResourceDefinition
+		// N.B.: If the CRD was parsed into a typed object, any CEL rules are already gone; pass
+		// it as Unstructured to keep them.
+		var raw map[string]interface{}
+		if err := convert(untypedCRD, &raw); err != nil {
+			return nil, fmt.Errorf("staticCRDs[%d]: %w", i, err)
+		}
 		switch untypedCRD.GetObjectKind().GroupVersionKind() {
 		case apiextV1beta1.SchemeGroupVersion.WithKind("CustomResourceDefinition"):
 			var crdV1beta1 apiextV1beta1.CustomResourceDefinition
@@ -65,7 +88,7 @@ func NewValidator(client *Client, staticCRDs []Object) (*Validator, error) {
 			static[TypeMeta{
 				APIVersion: crd.Spec.Group + "/" + version.Name,
 				Kind:       crd.Spec.Names.Kind,
-			}] = &crd
+			}] = &crdDefinition{typed: &crd, raw: raw}
 		}
 	}
 
@@ -73,11 +96,11 @@ func NewValidator(client *Client, staticCRDs []Object) (*Validator, error) {
 		client: client,
 		static: static,
 
-		validators: make(map[TypeMeta]*validate.SchemaValidator),
+		validators: make(map[TypeMeta]*crdValidator),
 	}, nil
 }
 
-func (v *Validator) getCRD(ctx context.Context, tm TypeMeta) (*apiextVInternal.CustomResourceDefinition, error) {
+func (v *Validator) getCRD(ctx context.Context, tm TypeMeta) (*crdDefinition, error) {
 	if crd, ok := v.static[tm]; ok {
 		return crd, nil
 	}
@@ -88,15 +111,11 @@ func (v *Validator) getCRD(ctx context.Context, tm TypeMeta) (*apiextVInternal.C
 		}
 		crd := mapping.Resource.GroupResource().String()
 
-		obj := &apiextV1.CustomResourceDefinition{
-			TypeMeta: TypeMeta{
-				Kind: "CustomResourceDefinition",
-			},
-			ObjectMeta: ObjectMeta{
-				Name: crd,
-			},
-		}
-		err = v.client.Get(ctx, obj, obj)
+		// Fetch it unstructured, so as not to lose the CEL rules.
+		un := &Unstructured{}
+		un.SetKind("CustomResourceDefinition")
+		un.SetName(crd)
+		err = v.client.Get(ctx, un, un)
 		if err != nil {
 			if IsNotFound(err) {
 				return nil, nil
@@ -105,17 +124,22 @@ func (v *Validator) getCRD(ctx context.Context, tm TypeMeta) (*apiextVInternal.C
 			return nil, err
 		}
 
+		var obj apiextV1.CustomResourceDefinition
+		if err := convert(un, &obj); err != nil {
+			return nil, err
+		}
+
 		var ret apiextVInternal.CustomResourceDefinition
-		err = apiextV1.Convert_v1_CustomResourceDefinition_To_apiextensions_CustomResourceDefinition(obj, &ret, nil)
+		err = apiextV1.Convert_v1_CustomResourceDefinition_To_apiextensions_CustomResourceDefinition(&obj, &ret, nil)
 		if err != nil {
 			return nil, err
 		}
-		return &ret, nil
+		return &crdDefinition{typed: &ret, raw: un.Object}, nil
 	}
 	return nil, nil
 }
 
-func (v *Validator) getValidator(ctx context.Context, tm TypeMeta) (*validate.SchemaValidator, error) {
+func (v *Validator) getValidator(ctx context.Context, tm TypeMeta) (*crdValidator, error) {
 	v.mutex.Lock()
 	defer v.mutex.Unlock()
 
@@ -126,17 +150,18 @@ func (v *Validator) getValidator(ctx context.Context, tm TypeMeta) (*validate.Sc
 			return nil, err
 		}
 
+		validator = &crdValidator{}
 		if crd != nil {
-			if crd.Spec.Validation != nil {
-				validator, _, err = validation.NewSchemaValidator(crd.Spec.Validation)
+			tmVersion := path.Base(tm.APIVersion)
+			if crd.typed.Spec.Validation != nil {
+				validator.schema, _, err = validation.NewSchemaValidator(crd.typed.Spec.Validation)
 				if err != nil {
 					return nil, err
 				}
 			} else {
-				tmVersion := path.Base(tm.APIVersion)
-				for _, version := range crd.Spec.Versions {
+				for _, version := range crd.typed.Spec.Versions {
 					if version.Name == tmVersion {
-						validator, _, err = validation.NewSchemaValidator(version.Schema)
+						validator.schema, _, err = validation.NewSchemaValidator(version.Schema)
 						if err != nil {
 							return nil, err
 						}
@@ -144,13 +169,43 @@ func (v *Validator) getValidator(ctx context.Context, tm TypeMeta) (*validate.Sc
 					}
 				}
 			}
+
+			// A rule that we can't compile (most likely because it uses one of the Kubernetes
+			// CEL libraries that we don't have) shouldn't stop us from checking everything
+			// else, so we leave it out, and complain about it just this once.
+			var ruleErrs fieldpath.ErrorList
+			validator.rules, ruleErrs = compileCELSchema(rawSchema(crd.raw, tmVersion))
+			for _, ruleErr := range ruleErrs {
+				dlog.Errorf(ctx, "%s: ignoring x-kubernetes-validations rule: %v", crd.typed.Name, ruleErr)
+			}
 		}
 
-		v.validators[tm] = validator // even if validator is nil; cache negative responses
+		v.validators[tm] = validator // even if there is no schema; cache negative responses
 	}
 	return validator, nil
 }
 
+// rawSchema digs the OpenAPI v3 schema for the named version out of a raw CRD, the same way
+// getValidator picks the typed one: a v1beta1 CRD's top-level schema wins over the per-version
+// ones.
+func rawSchema(crd map[string]interface{}, version string) map[string]interface{} {
+	spec, _ := crd["spec"].(map[string]interface{})
+	if validation, ok := spec["validation"].(map[string]interface{}); ok {
+		schema, _ := validation["openAPIV3Schema"].(map[string]interface{})
+		return schema
+	}
+	versions, _ := spec["versions"].([]interface{})
+	for _, untypedVersion := range versions {
+		v, _ := untypedVersion.(map[string]interface{})
+		if v["name"] == version {
+			schema, _ := v["schema"].(map[string]interface{})
+			openAPIV3Schema, _ := schema["openAPIV3Schema"].(map[string]interface{})
+			return openAPIV3Schema
+		}
+	}
+	return nil
+}
+
 // The Validate method validates the supplied jsonish object as a
 // kubernetes CRD instance.
 //
@@ -166,7 +221,19 @@ func (v *Validator) getValidator(ctx context.Context, tm TypeMeta) (*validate.Sc
 // Validator needs to query the cluster to figure out if it is a CRD
 // and if so to fetch the schema needed to perform validation. All
 // subsequent Validate() calls for that Kind will be local.
+//
+// Along with the OpenAPI schema, the Validate method checks any CEL
+// rules (x-kubernetes-validations) in the CRD, except for transition
+// rules (those that refer to oldSelf); use ValidateUpdate to check
+// those as well.
 func (v *Validator) Validate(ctx context.Context, resource interface{}) error {
+	return v.ValidateUpdate(ctx, nil, resource)
+}
+
+// The ValidateUpdate method is like Validate, but also evaluates CEL
+// transition rules against the supplied old version of the
+// resource. If old is nil, it is exactly the same as Validate.
+func (v *Validator) ValidateUpdate(ctx context.Context, old, resource interface{}) error {
 	var tm TypeMeta
 	err := convert(resource, &tm)
 	if err != nil {
@@ -178,7 +245,7 @@ func (v *Validator) Validate(ctx context.Context, resource interface{}) error {
 		return err
 	}
 
-	result := validator.Validate(resource)
+	result := validator.schema.Validate(resource)
 
 	var errs derror.MultiError
 	for _, e := range result.Errors {
@@ -189,6 +256,20 @@ func (v *Validator) Validate(ctx context.Context, resource interface{}) error {
 		errs = append(errs, w)
 	}
 
+	if validator.rules != nil {
+		obj, err := celValue(resource)
+		if err != nil {
+			return err
+		}
+		oldObj, err := celValue(old)
+		if err != nil {
+			return err
+		}
+		for _, e := range validator.rules.validate(ctx, nil, obj, oldObj) {
+			errs = append(errs, e)
+		}
+	}
+
 	if len(errs) > 0 {
 		return errs
 	}
@@ -1,10 +1,14 @@
 package kates
 
 import (
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+
+	"github.com/datawire/dlib/derror"
+	"github.com/datawire/dlib/dlog"
 )
 
 func TestValidation(t *testing.T) {
@@ -72,6 +76,174 @@ func TestValidation(t *testing.T) {
 	assert.NoError(t, err)
 }
 
+func TestValidationCEL(t *testing.T) {
+	ctx := dlog.NewTestContext(t, false)
+
+	objs, err := ParseManifestsToUnstructured(CELCRD)
+	require.NoError(t, err)
+	validator, err := NewValidator(nil, objs)
+	require.NoError(t, err)
+
+	resource := func(spec map[string]interface{}) map[string]interface{} {
+		return map[string]interface{}{
+			"apiVersion": "test.io/v1",
+			"kind":       "TestCEL",
+			"spec":       spec,
+		}
+	}
+
+	// Satisfies every rule.
+	assert.NoError(t, validator.Validate(ctx, resource(map[string]interface{}{
+		"replicas":    2,
+		"maxReplicas": 3,
+		"mode":        "fixed",
+		"ports":       []interface{}{map[string]interface{}{"port": 80}},
+		"labels":      map[string]interface{}{"app": "foo"},
+	})))
+
+	err = validator.Validate(ctx, resource(map[string]interface{}{
+		"replicas":    4,
+		"maxReplicas": 3,
+		"mode":        "fixed",
+		"ports":       []interface{}{map[string]interface{}{"port": 80}, map[string]interface{}{"port": 0}},
+		"labels":      map[string]interface{}{"app": ""},
+	}))
+	require.Error(t, err)
+	assert.Equal(t, []string{
+		`spec: Invalid value: "object": replicas must not exceed maxReplicas`,
+		`spec.labels[app]: Invalid value: "string": failed rule: size(self) > 0`,
+		`spec.ports[1].port: Invalid value: "integer": failed rule: self > 0 && self < 65536`,
+	}, multiErrorStrings(err))
+
+	// The transition rule only kicks in when there's an old object.
+	changed := resource(map[string]interface{}{"mode": "auto"})
+	assert.NoError(t, validator.Validate(ctx, changed))
+	assert.NoError(t, validator.ValidateUpdate(ctx, resource(map[string]interface{}{"mode": "auto"}), changed))
+	err = validator.ValidateUpdate(ctx, resource(map[string]interface{}{"mode": "fixed"}), changed)
+	require.Error(t, err)
+	assert.Equal(t, []string{
+		`spec.mode: Invalid value: "string": mode is immutable`,
+	}, multiErrorStrings(err))
+}
+
+func TestValidationCELCompileError(t *testing.T) {
+	ctx := dlog.NewTestContext(t, false)
+
+	objs, err := ParseManifestsToUnstructured(strings.Replace(CELCRD, "size(self) > 0", "size(self) >", 1))
+	require.NoError(t, err)
+	validator, err := NewValidator(nil, objs)
+	require.NoError(t, err)
+
+	// The rule that doesn't compile is skipped, but the others are still checked.
+	err = validator.Validate(ctx, map[string]interface{}{
+		"apiVersion": "test.io/v1",
+		"kind":       "TestCEL",
+		"spec": map[string]interface{}{
+			"replicas":    4,
+			"maxReplicas": 3,
+			"labels":      map[string]interface{}{"app": ""},
+		},
+	})
+	require.Error(t, err)
+	assert.Equal(t, []string{
+		`spec: Invalid value: "object": replicas must not exceed maxReplicas`,
+	}, multiErrorStrings(err))
+
+	// And the validator is cached, rather than compiled (and complained about) every time.
+	assert.Len(t, validator.validators, 1)
+	assert.NoError(t, validator.Validate(ctx, map[string]interface{}{
+		"apiVersion": "test.io/v1",
+		"kind":       "TestCEL",
+	}))
+	assert.Len(t, validator.validators, 1)
+}
+
+func TestValidationCELCostLimit(t *testing.T) {
+	ctx := dlog.NewTestContext(t, false)
+
+	rules, errs := compileCELSchema(map[string]interface{}{
+		"type": "array",
+		"x-kubernetes-validations": []interface{}{
+			map[string]interface{}{"rule": "self.all(x, self.all(y, self.all(z, x + y + z >= 0)))"},
+		},
+	})
+	require.Empty(t, errs)
+
+	list := make([]interface{}, 500)
+	for i := range list {
+		list[i] = int64(i)
+	}
+	verrs := rules.validate(ctx, nil, list, nil)
+	require.Len(t, verrs, 1)
+	assert.Contains(t, verrs[0].Error(), "cost limit exceeded")
+}
+
+func multiErrorStrings(err error) []string {
+	var ret []string
+	for _, e := range err.(derror.MultiError) {
+		ret = append(ret, e.Error())
+	}
+	return ret
+}
+
+var CELCRD = `
+apiVersion: apiextensions.k8s.io/v1
+kind: CustomResourceDefinition
+metadata:
+  name: testcels.test.io
+spec:
+  group: test.io
+  names:
+    kind: TestCEL
+    plural: testcels
+    singular: testcel
+  scope: Namespaced
+  versions:
+  - name: v1
+    served: true
+    storage: true
+    schema:
+      openAPIV3Schema:
+        type: object
+        properties:
+          apiVersion:
+            type: string
+          kind:
+            type: string
+          metadata:
+            type: object
+          spec:
+            type: object
+            x-kubernetes-validations:
+            - rule: "!has(self.replicas) || !has(self.maxReplicas) || self.replicas <= self.maxReplicas"
+              message: replicas must not exceed maxReplicas
+            properties:
+              replicas:
+                type: integer
+              maxReplicas:
+                type: integer
+              mode:
+                type: string
+                x-kubernetes-validations:
+                - rule: self == oldSelf
+                  message: mode is immutable
+              ports:
+                type: array
+                items:
+                  type: object
+                  properties:
+                    port:
+                      type: integer
+                      x-kubernetes-validations:
+                      - rule: self > 0 && self < 65536
+              labels:
+                type: object
+                additionalProperties:
+                  type: string
+                  x-kubernetes-validations:
+                  - rule: size(self) > 0
+`
+
 var CRD = `
 apiVersion: apiextensions.k8s.io/v1
 kind: CustomResourceDefinition
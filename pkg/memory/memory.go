@@ -7,6 +7,7 @@ import (
 	"io/ioutil"
 	"math"
 	"os"
+	"path/filepath"
 	"sort"
 	"strconv"
 	"strings"
@@ -19,9 +20,10 @@ import (
 )
 
 // The Watch method will check memory usage every 10 seconds and log it if it jumps more than 10Gi
-// up or down. Additionally if memory usage exceeds 50% of the cgroup limit, it will log usage every
-// minute. Usage is also unconditionally logged before returning. This function only returns if the
-// context is canceled.
+// up or down. Additionally if memory usage exceeds 50% of the cgroup limit, or the cgroup's tasks
+// have been stalled waiting for memory more than 10% of the time, it will log usage every minute.
+// Usage is also unconditionally logged before returning. This function only returns if the context
+// is canceled.
 func (usage *MemoryUsage) Watch(ctx context.Context) {
 	dbg := debug.FromContext(ctx)
 	memory := dbg.Value("memory")
@@ -49,12 +51,12 @@ func (usage *MemoryUsage) Watch(ctx context.Context) {
 func (m *MemoryUsage) ShortString() string {
 	m.mutex.Lock()
 	defer m.mutex.Unlock()
-	return fmt.Sprintf("%s of %s (%d%%)", m.usage.String(), m.limit.String(), m.percentUsed())
+	return fmt.Sprintf("%s of %s (%d%%)%s", m.usage.String(), m.limit.String(), m.percentUsed(), m.pressure)
 }
 
 // Return true if conditions for action are satisifed. We take action if memory has changed more
 // than 10Gi since our previous action. We also take action once per minute if usage is greather
-// than 50% of our limit.
+// than 50% of our limit, or if we're under memory pressure.
 func (m *MemoryUsage) shouldDo(now time.Time) bool {
 	const jump = 10 * 1024 * 1024
 	delta := m.previous - m.usage
@@ -62,7 +64,8 @@ func (m *MemoryUsage) shouldDo(now time.Time) bool {
 		return true
 	}
 
-	if m.percentUsed() > 50 && now.Sub(m.lastAction) >= 60*time.Second {
+	underPressure := m.pressure != nil && m.pressure.Some.Avg10 >= 10
+	if (m.percentUsed() > 50 || underPressure) && now.Sub(m.lastAction) >= 60*time.Second {
 		return true
 	}
 
@@ -84,13 +87,19 @@ func (m *MemoryUsage) maybeDo(now time.Time, f func()) {
 
 // The GetMemoryUsage function returns MemoryUsage info for the entire cgroup.
 func GetMemoryUsage(ctx context.Context) *MemoryUsage {
-	usage, limit := readUsage(ctx)
+	return getMemoryUsage(ctx, cgroup{root: "/sys/fs/cgroup"})
+}
+
+func getMemoryUsage(ctx context.Context, cg cgroup) *MemoryUsage {
+	usage, limit := cg.readUsage(ctx)
 	return &MemoryUsage{
 		usage:      usage,
 		limit:      limit,
+		pressure:   cg.readPressure(ctx),
 		perProcess: readPerProcess(ctx),
 
-		readUsage:      readUsage,
+		readUsage:      cg.readUsage,
+		readPressure:   cg.readPressure,
 		readPerProcess: readPerProcess,
 	}
 }
@@ -99,12 +108,14 @@ func GetMemoryUsage(ctx context.Context) *MemoryUsage {
 type MemoryUsage struct {
 	usage      memory
 	limit      memory
+	pressure   *Pressure // nil if it isn't available
 	perProcess map[int]*ProcessUsage
 	previous   memory
 	lastAction time.Time
 
 	// these allow mocking for tests
 	readUsage      func(context.Context) (memory, memory)
+	readPressure   func(context.Context) *Pressure
 	readPerProcess func(context.Context) map[int]*ProcessUsage
 
 	// Protects the whole structure
@@ -142,6 +153,9 @@ func (m *MemoryUsage) Refresh(ctx context.Context) {
 	usage, limit := m.readUsage(ctx)
 	m.usage = usage
 	m.limit = limit
+	if m.readPressure != nil {
+		m.pressure = m.readPressure(ctx)
+	}
 
 	// GC process memory info that has been around for more than 10 refreshes.
 	for pid, usage := range m.perProcess {
@@ -166,6 +180,7 @@ func (m *MemoryUsage) Refresh(ctx context.Context) {
 // If there is no cgroups memory limit then the value in
 // /sys/fs/cgroup/memory/memory.limit_in_bytes will be math.MaxInt64 rounded down to
 // the nearest pagesize. We calculate this number so we can detect if there is no memory limit.
+// (With cgroup v2, memory.max just says "max", which readMemory turns into this.)
 var unlimited memory = (memory(math.MaxInt64) / memory(os.Getpagesize())) * memory(os.Getpagesize())
 
 // Pretty print a summary of memory usage suitable for logging.
@@ -179,6 +194,7 @@ func (m *MemoryUsage) String() string {
 	} else {
 		msg.WriteString(fmt.Sprintf("Memory Usage %s (%d%%)", m.usage.String(), m.percentUsed()))
 	}
+	msg.WriteString(m.pressure.String())
 
 	pids := make([]int, 0, len(m.perProcess))
 	for pid := range m.perProcess {
@@ -212,6 +228,41 @@ func (m *MemoryUsage) PercentUsed() int {
 	return m.percentUsed()
 }
 
+// The MemoryUsage.ThrottlePercent method returns the memory usage percentage that the Envoy
+// reconfiguration throttle should go by. That's PercentUsed, unless the container has been under
+// sustained memory pressure, which can happen well short of the limit (or without one): then it's
+// at least as high as the pressure warrants.
+func (m *MemoryUsage) ThrottlePercent() int {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	percent := m.percentUsed()
+	if m.pressure == nil {
+		return percent
+	}
+	// Going by the 60s averages means that a short burst of pressure doesn't count.
+	for _, level := range pressureThrottle {
+		if m.pressure.Some.Avg60 >= level.some || m.pressure.Full.Avg60 >= level.full {
+			if level.percent > percent {
+				percent = level.percent
+			}
+			break
+		}
+	}
+	return percent
+}
+
+// pressureThrottle maps how much of the time tasks have been stalled waiting for memory to the
+// usage percentage that it's treated like, from the worst pressure down.
+var pressureThrottle = []struct {
+	some, full float64
+	percent    int
+}{
+	{some: 40, full: 10, percent: 90},
+	{some: 20, full: 5, percent: 80},
+	{some: 10, full: 2, percent: 70},
+}
+
 // This the same as PercentUsed() but not protected by a lock so we can use it form places where we
 // already have the lock.
 func (m *MemoryUsage) percentUsed() int {
@@ -233,50 +284,119 @@ func GetCmdline(ctx context.Context, pid int) []string {
 	return strings.Split(strings.TrimSuffix(string(bytes), "\n"), "\x00")
 }
 
+// A cgroup reads memory usage and pressure from a cgroup filesystem, which is mounted at
+// /sys/fs/cgroup (this is a field so that tests can point it at a fixture). Both cgroup v1, where
+// the memory controller has its own hierarchy under memory/, and cgroup v2, where every controller
+// shares the one, are supported.
+type cgroup struct {
+	root string
+}
+
+// isV2 returns whether the cgroup filesystem is cgroup v2. Every cgroup v2 cgroup has a
+// cgroup.controllers file; with cgroup v1, /sys/fs/cgroup just holds a directory per controller.
+func (cg cgroup) isV2() bool {
+	_, err := os.Stat(filepath.Join(cg.root, "cgroup.controllers"))
+	return err == nil
+}
+
 // Helper to read the usage and limit for the cgroup.
-func readUsage(ctx context.Context) (memory, memory) {
-	limit, err := readMemory("/sys/fs/cgroup/memory/memory.limit_in_bytes")
+//
+// We calculate usage the same way the kubelet does, since that's what decides whether to evict us,
+// and it's what container_memory_working_set_bytes reports[1]: the working set is the total usage,
+// less the inactive page cache, which the kernel can reclaim before it needs to invoke the
+// OOMKiller[2]. For cgroup v1, total usage is memory.usage_in_bytes and the inactive page cache is
+// total_inactive_file in memory.stat[3]; for cgroup v2, they're memory.current and inactive_file[4].
+//
+// [1]: https://github.com/google/cadvisor/blob/master/container/libcontainer/handler.go (setMemoryStats)
+// [2]: https://faun.pub/how-much-is-too-much-the-linux-oomkiller-and-used-memory-d32186f29c9d
+// [3]: https://www.kernel.org/doc/Documentation/cgroup-v1/memory.txt
+// [4]: https://www.kernel.org/doc/Documentation/admin-guide/cgroup-v2.rst
+func (cg cgroup) readUsage(ctx context.Context) (memory, memory) {
+	limitFile := filepath.Join(cg.root, "memory", "memory.limit_in_bytes")
+	usageFile := filepath.Join(cg.root, "memory", "memory.usage_in_bytes")
+	statFile := filepath.Join(cg.root, "memory", "memory.stat")
+	if cg.isV2() {
+		limitFile = filepath.Join(cg.root, "memory.max")
+		usageFile = filepath.Join(cg.root, "memory.current")
+		statFile = filepath.Join(cg.root, "memory.stat")
+	}
+
+	limit, err := readMemory(limitFile)
 	if err != nil {
-		if errors.Is(err, os.ErrPermission) || errors.Is(err, os.ErrNotExist) {
-			// Don't complain if we don't have permission or the info doesn't exist.
-			return 0, unlimited
-		}
-		dlog.Errorf(ctx, "couldn't access memory limit: %v", err)
+		logReadError(ctx, "memory limit", err)
 		return 0, unlimited
 	}
 
-	stats, err := readMemoryStat("/sys/fs/cgroup/memory/memory.stat")
+	usage, err := readMemory(usageFile)
 	if err != nil {
-		if errors.Is(err, os.ErrPermission) || errors.Is(err, os.ErrNotExist) {
-			// Don't complain if we don't have permission or the info doesn't exist.
-			return 0, limit
-		}
-		dlog.Errorf(ctx, "couldn't access memory usage: %v", err)
+		logReadError(ctx, "memory usage", err)
+		return 0, limit
+	}
+
+	stats, err := readMemoryStat(statFile)
+	if err != nil {
+		logReadError(ctx, "memory usage", err)
 		return 0, limit
 	}
 
-	// We calculate memory usage according to the OOMKiller as (rss + cache + swap) - inactive_file.
-	// This is substantiated by this article[1] which claims we need to track container_memory_working_set_bytes.
-	// According to this stack overflow[2], container_memory_working_set_bytes is "total usage" - "inactive file".
-	// Best as I can tell from the cgroup docs[3], "total usage" is computed from memory.stat by
-	// adding (rss + cache + swap), and "inactive file" is just the inactive_file field.
-	//
-	// [1]: https://faun.pub/how-much-is-too-much-the-linux-oomkiller-and-used-memory-d32186f29c9d
-	// [2]: https://stackoverflow.com/questions/65428558/what-is-the-difference-between-container-memory-working-set-bytes-and-contain
-	// [3]: https://www.kernel.org/doc/Documentation/cgroup-v1/memory.txt
+	inactiveFile := stats.InactiveFile
+	if !cg.isV2() {
+		inactiveFile = stats.TotalInactiveFile
+	}
+	return workingSet(usage, inactiveFile), limit
+}
 
-	totalUsage := stats.Rss + stats.Cache + stats.Swap
-	OOMUsage := totalUsage - stats.InactiveFile
-	return memory(OOMUsage), limit
+// workingSet returns usage less the inactive page cache, or zero if the page cache is somehow
+// bigger.
+func workingSet(usage memory, inactiveFile uint64) memory {
+	if memory(inactiveFile) >= usage {
+		return 0
+	}
+	return usage - memory(inactiveFile)
 }
 
-// Read an int64 from a file and convert it to memory.
+// Helper to read the cgroup's memory pressure. This returns nil if the pressure isn't available,
+// which it isn't with cgroup v1, or if the kernel wasn't built with PSI.
+func (cg cgroup) readPressure(ctx context.Context) *Pressure {
+	if !cg.isV2() {
+		return nil
+	}
+	bytes, err := ioutil.ReadFile(filepath.Join(cg.root, "memory.pressure"))
+	if err != nil {
+		// A kernel without PSI support gives us EOPNOTSUPP, not ENOENT.
+		if !errors.Is(err, syscall.EOPNOTSUPP) {
+			logReadError(ctx, "memory pressure", err)
+		}
+		return nil
+	}
+	pressure, err := parsePressure(string(bytes))
+	if err != nil {
+		dlog.Errorf(ctx, "couldn't parse memory pressure: %v", err)
+		return nil
+	}
+	return &pressure
+}
+
+// Log an error reading from the cgroup filesystem, unless it's just that we don't have
+// permission, or the info doesn't exist, which isn't worth complaining about.
+func logReadError(ctx context.Context, what string, err error) {
+	if errors.Is(err, os.ErrPermission) || errors.Is(err, os.ErrNotExist) {
+		return
+	}
+	dlog.Errorf(ctx, "couldn't access %s: %v", what, err)
+}
+
+// Read an int64 from a file and convert it to memory. cgroup v2 says "max" rather than giving a
+// number when there's no limit.
 func readMemory(fpath string) (memory, error) {
 	contentAsB, err := ioutil.ReadFile(fpath)
 	if err != nil {
 		return 0, err
 	}
 	contentAsStr := strings.TrimSuffix(string(contentAsB), "\n")
+	if contentAsStr == "max" {
+		return unlimited, nil
+	}
 	m, err := strconv.ParseInt(contentAsStr, 10, 64)
 	return memory(m), err
 }
@@ -334,10 +454,11 @@ func readPerProcess(ctx context.Context) map[int]*ProcessUsage {
 }
 
 type memoryStat struct {
-	Rss          uint64 // rss field
-	Cache        uint64 // cache field
-	Swap         uint64 // swap field
-	InactiveFile uint64 // inactive_file field
+	Rss               uint64 // rss field (cgroup v1 only)
+	Cache             uint64 // cache field (cgroup v1 only)
+	Swap              uint64 // swap field (cgroup v1 only)
+	InactiveFile      uint64 // inactive_file field
+	TotalInactiveFile uint64 // total_inactive_file field (cgroup v1 only)
 }
 
 func readMemoryStat(fpath string) (memoryStat, error) {
@@ -373,6 +494,82 @@ func parseMemoryStat(content string) (memoryStat, error) {
 			result.Cache = n
 		case "inactive_file":
 			result.InactiveFile = n
+		case "total_inactive_file":
+			result.TotalInactiveFile = n
+		}
+	}
+	return result, nil
+}
+
+// Pressure is a cgroup's memory pressure stall information (PSI): how much of the time some, or
+// all, of its tasks were stalled waiting for memory. Unlike usage, this says whether we're actually
+// short of memory, rather than just using a lot of it.
+type Pressure struct {
+	Some PressureStats
+	Full PressureStats
+}
+
+// Pretty print the short-term pressure, suitable for appending to a summary of usage. This is
+// empty if there's no pressure information.
+func (p *Pressure) String() string {
+	if p == nil {
+		return ""
+	}
+	return fmt.Sprintf(", stalled on memory %.2f%% (some) %.2f%% (full) of the last 10s", p.Some.Avg10, p.Full.Avg10)
+}
+
+// PressureStats are the averages, as percentages, over the last 10, 60 and 300 seconds, along with
+// the total time stalled.
+type PressureStats struct {
+	Avg10  float64
+	Avg60  float64
+	Avg300 float64
+	Total  time.Duration
+}
+
+// parsePressure parses a PSI file, which looks like this:
+//
+//	some avg10=0.00 avg60=0.00 avg300=0.00 total=0
+//	full avg10=0.00 avg60=0.00 avg300=0.00 total=0
+func parsePressure(content string) (Pressure, error) {
+	result := Pressure{}
+	for _, line := range strings.Split(content, "\n") {
+		parts := strings.Fields(line)
+		if len(parts) == 0 {
+			continue
+		}
+
+		var stats *PressureStats
+		switch parts[0] {
+		case "some":
+			stats = &result.Some
+		case "full":
+			stats = &result.Full
+		default:
+			continue
+		}
+
+		for _, field := range parts[1:] {
+			key, value, ok := strings.Cut(field, "=")
+			if !ok {
+				return result, fmt.Errorf("malformed field %q", field)
+			}
+			var err error
+			switch key {
+			case "avg10":
+				stats.Avg10, err = strconv.ParseFloat(value, 64)
+			case "avg60":
+				stats.Avg60, err = strconv.ParseFloat(value, 64)
+			case "avg300":
+				stats.Avg300, err = strconv.ParseFloat(value, 64)
+			case "total":
+				var us uint64
+				us, err = strconv.ParseUint(value, 10, 64)
+				stats.Total = time.Duration(us) * time.Microsecond
+			}
+			if err != nil {
+				return result, err
+			}
 		}
 	}
 	return result, nil
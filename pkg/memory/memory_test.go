@@ -2,10 +2,14 @@ package memory
 
 import (
 	"context"
+	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 
 	"github.com/datawire/dlib/dlog"
 )
@@ -199,4 +203,180 @@ total_unevictable 0
 	assert.Equal(uint64(175247360), result.Cache)
 	assert.Equal(uint64(1), result.Swap)
 	assert.Equal(uint64(222568448), result.InactiveFile)
+	assert.Equal(uint64(222568448), result.TotalInactiveFile)
+}
+
+func TestMemoryMetrics(t *testing.T) {
+	usage := &MemoryUsage{
+		usage: 512 * 1024 * 1024,
+		limit: unlimited,
+		perProcess: map[int]*ProcessUsage{
+			1:  {Pid: 1, Cmdline: []string{"/usr/bin/busyambassador", "entrypoint"}, Usage: 256 * 1024 * 1024},
+			7:  {Pid: 7, Cmdline: []string{"python3", "diagd"}, Usage: 64 * 1024 * 1024},
+			8:  {Pid: 8, Cmdline: []string{"/usr/bin/python3", "diagd"}, Usage: 32 * 1024 * 1024},
+			42: {Pid: 42, Cmdline: []string{"envoy"}, Usage: 1024, RefreshesSinceExit: 1},
+		},
+	}
+
+	// Without a limit there's no limit or percentage to report, exited processes don't count, and
+	// processes running the same command are added up.
+	assert.NoError(t, testutil.CollectAndCompare(usage, strings.NewReader(`
+# HELP ambassador_memory_usage_bytes Memory used by the container, as of the last refresh.
+# TYPE ambassador_memory_usage_bytes gauge
+ambassador_memory_usage_bytes 5.36870912e+08
+# HELP ambassador_memory_process_usage_bytes Memory used by the processes in the container running each command, as of the last refresh.
+# TYPE ambassador_memory_process_usage_bytes gauge
+ambassador_memory_process_usage_bytes{command="busyambassador"} 2.68435456e+08
+ambassador_memory_process_usage_bytes{command="python3"} 1.00663296e+08
+`)))
+
+	usage.limit = 1024 * 1024 * 1024
+	assert.NoError(t, testutil.CollectAndCompare(usage, strings.NewReader(`
+# HELP ambassador_memory_limit_bytes The container's memory limit. Not reported if there is no limit.
+# TYPE ambassador_memory_limit_bytes gauge
+ambassador_memory_limit_bytes 1.073741824e+09
+# HELP ambassador_memory_usage_percent Memory used by the container, as a percentage of its limit.
+# TYPE ambassador_memory_usage_percent gauge
+ambassador_memory_usage_percent 50
+`), "ambassador_memory_limit_bytes", "ambassador_memory_usage_percent"))
+}
+
+// Read usage from fixture cgroup filesystems. The working set is total usage less inactive_file
+// (or total_inactive_file for cgroup v1), the same as the kubelet.
+func TestCgroupReadUsage(t *testing.T) {
+	ctx := dlog.NewTestContext(t, false)
+
+	for name, tc := range map[string]struct {
+		usage    memory
+		limit    memory
+		pressure *Pressure
+	}{
+		"cgroup-v1": {
+			usage: 600000000 - 222568448,
+			limit: 2097152000,
+		},
+		"cgroup-v2": {
+			usage: 536870912 - 134217728,
+			limit: 1024 * 1024 * 1024,
+			pressure: &Pressure{
+				Some: PressureStats{Avg10: 12.5, Avg60: 4, Avg300: 1.25, Total: 1500 * time.Millisecond},
+				Full: PressureStats{Avg10: 2, Avg60: 0.5, Avg300: 0.1, Total: 250 * time.Millisecond},
+			},
+		},
+		// memory.max is "max", the page cache is bigger than memory.current, and there's no PSI.
+		"cgroup-v2-unlimited": {
+			usage: 0,
+			limit: unlimited,
+		},
+		"nonexistent": {
+			usage: 0,
+			limit: unlimited,
+		},
+	} {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			cg := cgroup{root: filepath.Join("testdata", name)}
+			usage, limit := cg.readUsage(ctx)
+			assert.Equal(t, tc.usage, usage)
+			assert.Equal(t, tc.limit, limit)
+			assert.Equal(t, tc.pressure, cg.readPressure(ctx))
+		})
+	}
+}
+
+func TestMemoryUsageCgroupV2(t *testing.T) {
+	ctx := dlog.NewTestContext(t, false)
+	usage := getMemoryUsage(ctx, cgroup{root: filepath.Join("testdata", "cgroup-v2")})
+
+	assert.Equal(t, 37, usage.PercentUsed())
+	assert.Equal(t, 12.5, usage.pressure.Some.Avg10)
+	assert.Equal(t, "0.38Gi of 1.00Gi (37%), stalled on memory 12.50% (some) 2.00% (full) of the last 10s",
+		usage.ShortString())
+
+	// Being under pressure is interesting even though we're well under 50% of our limit.
+	start := time.Now()
+	usage.maybeDo(start, func() {})
+	did := false
+	usage.maybeDo(start.Add(60*time.Second), func() {
+		did = true
+	})
+	assert.True(t, did)
+
+	usage.Refresh(ctx)
+	assert.Equal(t, 37, usage.PercentUsed())
+	assert.NotNil(t, usage.pressure)
+}
+
+func TestParsePressure(t *testing.T) {
+	result, err := parsePressure("some avg10=0.31 avg60=0.12 avg300=0.03 total=4200\n")
+	require.NoError(t, err)
+	assert.Equal(t, Pressure{
+		Some: PressureStats{Avg10: 0.31, Avg60: 0.12, Avg300: 0.03, Total: 4200 * time.Microsecond},
+	}, result)
+
+	_, err = parsePressure("some avg10=0.31 avg60\n")
+	assert.Error(t, err)
+	_, err = parsePressure("full avg10=lots\n")
+	assert.Error(t, err)
+}
+
+func TestMemoryPressureMetrics(t *testing.T) {
+	usage := &MemoryUsage{
+		limit: unlimited,
+		pressure: &Pressure{
+			Some: PressureStats{Avg10: 12.5, Avg60: 4, Avg300: 1.25, Total: 1500 * time.Millisecond},
+			Full: PressureStats{Avg10: 2, Avg60: 0.5, Avg300: 0.1, Total: 250 * time.Millisecond},
+		},
+	}
+
+	assert.NoError(t, testutil.CollectAndCompare(usage, strings.NewReader(`
+# HELP ambassador_memory_pressure_percent How much of the time some (or all) of the container's tasks were stalled waiting for memory, averaged over the window. Not reported without cgroup v2 PSI.
+# TYPE ambassador_memory_pressure_percent gauge
+ambassador_memory_pressure_percent{kind="full",window="10s"} 2
+ambassador_memory_pressure_percent{kind="full",window="300s"} 0.1
+ambassador_memory_pressure_percent{kind="full",window="60s"} 0.5
+ambassador_memory_pressure_percent{kind="some",window="10s"} 12.5
+ambassador_memory_pressure_percent{kind="some",window="300s"} 1.25
+ambassador_memory_pressure_percent{kind="some",window="60s"} 4
+# HELP ambassador_memory_pressure_stalled_seconds_total How long some (or all) of the container's tasks have been stalled waiting for memory. Not reported without cgroup v2 PSI.
+# TYPE ambassador_memory_pressure_stalled_seconds_total counter
+ambassador_memory_pressure_stalled_seconds_total{kind="full"} 0.25
+ambassador_memory_pressure_stalled_seconds_total{kind="some"} 1.5
+`), "ambassador_memory_pressure_percent", "ambassador_memory_pressure_stalled_seconds_total"))
+
+	// Without PSI, there's nothing to report.
+	usage.pressure = nil
+	assert.Equal(t, 0, testutil.CollectAndCount(usage, "ambassador_memory_pressure_percent"))
+}
+
+func TestThrottlePercent(t *testing.T) {
+	usage := &MemoryUsage{usage: 256 * 1024 * 1024, limit: 1024 * 1024 * 1024}
+
+	// Without PSI, it's just the usage.
+	assert.Equal(t, 25, usage.ThrottlePercent())
+
+	for _, tc := range []struct {
+		some, full PressureStats
+		percent    int
+	}{
+		// A short burst of pressure doesn't count...
+		{some: PressureStats{Avg10: 80, Avg60: 5}, percent: 25},
+		// ...but sustained pressure does, however little memory is in use.
+		{some: PressureStats{Avg60: 12}, percent: 70},
+		{some: PressureStats{Avg60: 25}, full: PressureStats{Avg60: 1}, percent: 80},
+		{some: PressureStats{Avg60: 25}, full: PressureStats{Avg60: 10}, percent: 90},
+	} {
+		usage.pressure = &Pressure{Some: tc.some, Full: tc.full}
+		assert.Equal(t, tc.percent, usage.ThrottlePercent(), "%+v", tc)
+	}
+
+	// Pressure never makes it lower than the usage.
+	usage.usage = 950 * 1024 * 1024
+	usage.pressure = &Pressure{Some: PressureStats{Avg60: 12}}
+	assert.Equal(t, 92, usage.ThrottlePercent())
+
+	// Nor does it need a limit.
+	usage.limit = unlimited
+	usage.pressure = &Pressure{Some: PressureStats{Avg60: 45}}
+	assert.Equal(t, 90, usage.ThrottlePercent())
 }
@@ -0,0 +1,72 @@
+package memory
+
+import (
+	"path/filepath"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	usageDesc = prometheus.NewDesc("ambassador_memory_usage_bytes",
+		"Memory used by the container, as of the last refresh.", nil, nil)
+	limitDesc = prometheus.NewDesc("ambassador_memory_limit_bytes",
+		"The container's memory limit. Not reported if there is no limit.", nil, nil)
+	percentDesc = prometheus.NewDesc("ambassador_memory_usage_percent",
+		"Memory used by the container, as a percentage of its limit.", nil, nil)
+	processDesc = prometheus.NewDesc("ambassador_memory_process_usage_bytes",
+		"Memory used by the processes in the container running each command, as of the last refresh.", []string{"command"}, nil)
+	pressureDesc = prometheus.NewDesc("ambassador_memory_pressure_percent",
+		"How much of the time some (or all) of the container's tasks were stalled waiting for memory, averaged over the window. Not reported without cgroup v2 PSI.",
+		[]string{"kind", "window"}, nil)
+	pressureTotalDesc = prometheus.NewDesc("ambassador_memory_pressure_stalled_seconds_total",
+		"How long some (or all) of the container's tasks have been stalled waiting for memory. Not reported without cgroup v2 PSI.",
+		[]string{"kind"}, nil)
+)
+
+// The Describe method implements prometheus.Collector.
+func (m *MemoryUsage) Describe(ch chan<- *prometheus.Desc) {
+	ch <- usageDesc
+	ch <- limitDesc
+	ch <- percentDesc
+	ch <- processDesc
+	ch <- pressureDesc
+	ch <- pressureTotalDesc
+}
+
+// The Collect method implements prometheus.Collector. It reports the figures from the last
+// Refresh, rather than reading them again.
+func (m *MemoryUsage) Collect(ch chan<- prometheus.Metric) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	ch <- prometheus.MustNewConstMetric(usageDesc, prometheus.GaugeValue, float64(m.usage))
+	if m.limit != unlimited {
+		ch <- prometheus.MustNewConstMetric(limitDesc, prometheus.GaugeValue, float64(m.limit))
+		ch <- prometheus.MustNewConstMetric(percentDesc, prometheus.GaugeValue, float64(m.percentUsed()))
+	}
+	if m.pressure != nil {
+		for kind, stats := range map[string]PressureStats{"some": m.pressure.Some, "full": m.pressure.Full} {
+			ch <- prometheus.MustNewConstMetric(pressureDesc, prometheus.GaugeValue, stats.Avg10, kind, "10s")
+			ch <- prometheus.MustNewConstMetric(pressureDesc, prometheus.GaugeValue, stats.Avg60, kind, "60s")
+			ch <- prometheus.MustNewConstMetric(pressureDesc, prometheus.GaugeValue, stats.Avg300, kind, "300s")
+			ch <- prometheus.MustNewConstMetric(pressureTotalDesc, prometheus.CounterValue, stats.Total.Seconds(), kind)
+		}
+	}
+	// Pids change every time something restarts, so sum by command rather than making a new series
+	// for every pid.
+	byCommand := map[string]memory{}
+	for _, usage := range m.perProcess {
+		// Processes that have exited hang around for a while, but they aren't using anything.
+		if usage.RefreshesSinceExit > 0 {
+			continue
+		}
+		command := ""
+		if len(usage.Cmdline) > 0 {
+			command = filepath.Base(usage.Cmdline[0])
+		}
+		byCommand[command] += usage.Usage
+	}
+	for command, usage := range byCommand {
+		ch <- prometheus.MustNewConstMetric(processDesc, prometheus.GaugeValue, float64(usage), command)
+	}
+}
@@ -0,0 +1,229 @@
+package metricssink
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"time"
+
+	dto "github.com/prometheus/client_model/go"
+	collectorpb "go.opentelemetry.io/proto/otlp/collector/metrics/v1"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	metricspb "go.opentelemetry.io/proto/otlp/metrics/v1"
+	resourcepb "go.opentelemetry.io/proto/otlp/resource/v1"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/datawire/dlib/dlog"
+)
+
+const scopeName = "github.com/emissary-ingress/emissary/v3/pkg/metricssink"
+
+// An OTLPExporter periodically pushes everything in a Sink to an OTLP/HTTP metrics endpoint.
+// Each Envoy becomes a resource, identified by the "envoy.node.id" and "envoy.node.cluster"
+// attributes, and its stats keep their Envoy names.
+type OTLPExporter struct {
+	// Endpoint is the URL to POST to, e.g. "http://otel-collector:4318/v1/metrics".
+	Endpoint string
+	// Interval is how often to export. Zero means every 30 seconds.
+	Interval time.Duration
+	// Headers are added to every request, e.g. for authentication.
+	Headers http.Header
+	// Client is the HTTP client to use. Nil means http.DefaultClient.
+	Client *http.Client
+}
+
+// Run exports the contents of sink every Interval until ctx is cancelled. Failed exports are
+// logged, and the next export tries again with whatever is current by then.
+func (e *OTLPExporter) Run(ctx context.Context, sink *Sink) error {
+	interval := e.Interval
+	if interval <= 0 {
+		interval = 30 * time.Second
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			if err := e.Export(ctx, sink); err != nil {
+				dlog.Errorf(ctx, "OTLP export to %s failed: %v", e.Endpoint, err)
+			}
+		}
+	}
+}
+
+// Export pushes the current contents of sink to the endpoint once.
+func (e *OTLPExporter) Export(ctx context.Context, sink *Sink) error {
+	req := sink.otlpRequest()
+	if len(req.ResourceMetrics) == 0 {
+		return nil
+	}
+	body, err := proto.Marshal(req)
+	if err != nil {
+		return err
+	}
+
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, e.Endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	for name, values := range e.Headers {
+		httpReq.Header[name] = values
+	}
+	httpReq.Header.Set("Content-Type", "application/x-protobuf")
+
+	client := e.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(httpReq)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("%s: %s", resp.Status, bytes.TrimSpace(msg))
+	}
+	return nil
+}
+
+// otlpRequest converts the contents of the sink into an OTLP export request.
+func (s *Sink) otlpRequest() *collectorpb.ExportMetricsServiceRequest {
+	req := &collectorpb.ExportMetricsServiceRequest{}
+	scopes := map[Node]*metricspb.ScopeMetrics{}
+	metrics := map[Node]map[string]*metricspb.Metric{}
+
+	for _, entry := range s.snapshot() {
+		scope := scopes[entry.node]
+		if scope == nil {
+			scope = &metricspb.ScopeMetrics{Scope: &commonpb.InstrumentationScope{Name: scopeName}}
+			scopes[entry.node] = scope
+			metrics[entry.node] = map[string]*metricspb.Metric{}
+			req.ResourceMetrics = append(req.ResourceMetrics, &metricspb.ResourceMetrics{
+				Resource: &resourcepb.Resource{Attributes: []*commonpb.KeyValue{
+					stringAttribute("envoy.node.id", entry.node.ID),
+					stringAttribute("envoy.node.cluster", entry.node.Cluster),
+				}},
+				ScopeMetrics: []*metricspb.ScopeMetrics{scope},
+			})
+		}
+
+		metric := metrics[entry.node][entry.family]
+		if metric == nil {
+			metric = newOTLPMetric(entry)
+			if metric == nil {
+				continue
+			}
+			metrics[entry.node][entry.family] = metric
+			scope.Metrics = append(scope.Metrics, metric)
+		}
+		addOTLPDataPoint(metric, entry)
+	}
+	return req
+}
+
+func newOTLPMetric(entry *series) *metricspb.Metric {
+	metric := &metricspb.Metric{Name: entry.family, Description: entry.help}
+	switch entry.typ {
+	case dto.MetricType_COUNTER:
+		metric.Data = &metricspb.Metric_Sum{Sum: &metricspb.Sum{
+			AggregationTemporality: metricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_CUMULATIVE,
+			IsMonotonic:            true,
+		}}
+	case dto.MetricType_GAUGE, dto.MetricType_UNTYPED:
+		metric.Data = &metricspb.Metric_Gauge{Gauge: &metricspb.Gauge{}}
+	case dto.MetricType_HISTOGRAM:
+		metric.Data = &metricspb.Metric_Histogram{Histogram: &metricspb.Histogram{
+			AggregationTemporality: metricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_CUMULATIVE,
+		}}
+	case dto.MetricType_SUMMARY:
+		metric.Data = &metricspb.Metric_Summary{Summary: &metricspb.Summary{}}
+	default:
+		return nil
+	}
+	return metric
+}
+
+func addOTLPDataPoint(metric *metricspb.Metric, entry *series) {
+	var attrs []*commonpb.KeyValue
+	for _, label := range entry.metric.GetLabel() {
+		if label.GetName() == NodeIDLabel || label.GetName() == NodeClusterLabel {
+			// These are on the resource.
+			continue
+		}
+		attrs = append(attrs, stringAttribute(label.GetName(), label.GetValue()))
+	}
+	start := uint64(entry.start.UnixNano())
+	now := uint64(entry.updated.UnixNano())
+
+	switch data := metric.Data.(type) {
+	case *metricspb.Metric_Sum:
+		data.Sum.DataPoints = append(data.Sum.DataPoints, &metricspb.NumberDataPoint{
+			Attributes:        attrs,
+			StartTimeUnixNano: start,
+			TimeUnixNano:      now,
+			Value:             &metricspb.NumberDataPoint_AsDouble{AsDouble: entry.metric.GetCounter().GetValue()},
+		})
+	case *metricspb.Metric_Gauge:
+		value := entry.metric.GetGauge().GetValue()
+		if entry.typ == dto.MetricType_UNTYPED {
+			value = entry.metric.GetUntyped().GetValue()
+		}
+		data.Gauge.DataPoints = append(data.Gauge.DataPoints, &metricspb.NumberDataPoint{
+			Attributes:   attrs,
+			TimeUnixNano: now,
+			Value:        &metricspb.NumberDataPoint_AsDouble{AsDouble: value},
+		})
+	case *metricspb.Metric_Histogram:
+		hist := entry.metric.GetHistogram()
+		point := &metricspb.HistogramDataPoint{
+			Attributes:        attrs,
+			StartTimeUnixNano: start,
+			TimeUnixNano:      now,
+			Count:             hist.GetSampleCount(),
+			Sum:               proto.Float64(hist.GetSampleSum()),
+		}
+		// Prometheus buckets are cumulative, and OTLP buckets aren't. OTLP also always has an
+		// overflow bucket, where Prometheus only has one if there's a +Inf bucket.
+		var cumulative uint64
+		for _, bucket := range hist.GetBucket() {
+			if math.IsInf(bucket.GetUpperBound(), +1) {
+				continue
+			}
+			point.ExplicitBounds = append(point.ExplicitBounds, bucket.GetUpperBound())
+			point.BucketCounts = append(point.BucketCounts, bucket.GetCumulativeCount()-cumulative)
+			cumulative = bucket.GetCumulativeCount()
+		}
+		point.BucketCounts = append(point.BucketCounts, hist.GetSampleCount()-cumulative)
+		data.Histogram.DataPoints = append(data.Histogram.DataPoints, point)
+	case *metricspb.Metric_Summary:
+		summary := entry.metric.GetSummary()
+		point := &metricspb.SummaryDataPoint{
+			Attributes:        attrs,
+			StartTimeUnixNano: start,
+			TimeUnixNano:      now,
+			Count:             summary.GetSampleCount(),
+			Sum:               summary.GetSampleSum(),
+		}
+		for _, quantile := range summary.GetQuantile() {
+			point.QuantileValues = append(point.QuantileValues, &metricspb.SummaryDataPoint_ValueAtQuantile{
+				Quantile: quantile.GetQuantile(),
+				Value:    quantile.GetValue(),
+			})
+		}
+		data.Summary.DataPoints = append(data.Summary.DataPoints, point)
+	}
+}
+
+func stringAttribute(key, value string) *commonpb.KeyValue {
+	return &commonpb.KeyValue{
+		Key:   key,
+		Value: &commonpb.AnyValue{Value: &commonpb.AnyValue_StringValue{StringValue: value}},
+	}
+}
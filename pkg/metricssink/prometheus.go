@@ -0,0 +1,83 @@
+package metricssink
+
+import (
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"google.golang.org/protobuf/proto"
+)
+
+var _ prometheus.Gatherer = (*Sink)(nil)
+
+// Gather implements prometheus.Gatherer, returning every series that hasn't expired. Names are
+// mangled the same way as Envoy's own /stats/prometheus endpoint does it, so dashboards built for
+// scraping Envoy directly keep working: "cluster.foo.upstream_rq_total" becomes
+// "envoy_cluster_foo_upstream_rq_total".
+func (s *Sink) Gather() ([]*dto.MetricFamily, error) {
+	var families []*dto.MetricFamily
+	byName := map[string]*dto.MetricFamily{}
+	for _, entry := range s.snapshot() {
+		name := prometheusName(entry.family)
+		family := byName[name]
+		if family == nil {
+			family = &dto.MetricFamily{
+				Name: proto.String(name),
+				Help: proto.String(entry.help),
+				Type: entry.typ.Enum(),
+			}
+			if entry.help == "" {
+				family.Help = proto.String("Envoy stat " + entry.family)
+			}
+			byName[name] = family
+			families = append(families, family)
+		}
+		if entry.typ != family.GetType() {
+			// Two Envoys disagree about what type this is. Prometheus won't take both, so
+			// go with whichever sorted first.
+			continue
+		}
+		family.Metric = append(family.Metric, prometheusMetric(entry.metric))
+	}
+	return families, nil
+}
+
+func prometheusName(name string) string {
+	name = sanitize(name)
+	if !strings.HasPrefix(name, "envoy_") {
+		name = "envoy_" + name
+	}
+	return name
+}
+
+func prometheusMetric(metric *dto.Metric) *dto.Metric {
+	if !needsSanitizing(metric.GetLabel()) {
+		return metric
+	}
+	labels := make([]*dto.LabelPair, 0, len(metric.GetLabel()))
+	for _, label := range metric.GetLabel() {
+		labels = append(labels, &dto.LabelPair{Name: proto.String(sanitize(label.GetName())), Value: label.Value})
+	}
+	return withLabels(metric, labels)
+}
+
+func needsSanitizing(labels []*dto.LabelPair) bool {
+	for _, label := range labels {
+		if sanitize(label.GetName()) != label.GetName() {
+			return true
+		}
+	}
+	return false
+}
+
+// sanitize replaces everything that isn't allowed in a Prometheus name with an underscore.
+func sanitize(name string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '_', r == ':':
+			return r
+		default:
+			return '_'
+		}
+	}, name)
+}
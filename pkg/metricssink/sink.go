@@ -0,0 +1,221 @@
+// Package metricssink is a receiver for Envoy's metrics service (envoy.service.metrics.v3). Envoys
+// stream their stats to a Sink, which keeps the latest value of every series from every Envoy,
+// labelled with the Envoy's node ID and cluster, and re-exports them: as a prometheus.Gatherer, so
+// they can be scraped from one place instead of from every pod's admin port, and over OTLP.
+//
+// Series that an Envoy stops reporting (because the Envoy went away, or because the stat did) are
+// forgotten once they're older than the Sink's retention.
+package metricssink
+
+import (
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	dto "github.com/prometheus/client_model/go"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/datawire/dlib/dlog"
+	v3metrics "github.com/emissary-ingress/emissary/v3/pkg/api/envoy/service/metrics/v3"
+)
+
+// DefaultRetention is how long a series is kept after it was last reported, if Options doesn't
+// say otherwise.
+const DefaultRetention = 5 * time.Minute
+
+// The labels that a Sink adds to every series to say which Envoy it came from.
+const (
+	NodeIDLabel      = "envoy_node_id"
+	NodeClusterLabel = "envoy_node_cluster"
+)
+
+// Options configures a Sink.
+type Options struct {
+	// Retention is how long a series is kept after it was last reported. Zero means
+	// DefaultRetention.
+	Retention time.Duration
+
+	// CountersAsDeltas must match the report_counters_as_deltas setting of the Envoys' metrics
+	// service config: if it's set, each counter that an Envoy reports is added to the previous
+	// value, rather than replacing it.
+	CountersAsDeltas bool
+}
+
+// A Node identifies the Envoy that a series came from.
+type Node struct {
+	ID      string
+	Cluster string
+}
+
+type seriesKey struct {
+	name   string
+	node   Node
+	labels string // the Envoy's own labels, canonicalized by labelsKey
+}
+
+type series struct {
+	family  string // the name that Envoy reported, e.g. "cluster.foo.upstream_rq_total"
+	help    string
+	typ     dto.MetricType
+	metric  *dto.Metric // with the node labels added; never modified once stored
+	node    Node
+	start   time.Time // when the series was first reported
+	updated time.Time // when the series was last reported
+}
+
+// A Sink aggregates the metrics streamed to it by any number of Envoys. Use New to create one.
+type Sink struct {
+	v3metrics.UnimplementedMetricsServiceServer
+
+	opts  Options
+	clock func() time.Time
+
+	mu     sync.Mutex
+	series map[seriesKey]*series
+}
+
+var _ v3metrics.MetricsServiceServer = (*Sink)(nil)
+
+// New returns a Sink with nothing in it.
+func New(opts Options) *Sink {
+	if opts.Retention <= 0 {
+		opts.Retention = DefaultRetention
+	}
+	return &Sink{
+		opts:   opts,
+		clock:  time.Now,
+		series: make(map[seriesKey]*series),
+	}
+}
+
+// StreamMetrics implements v3metrics.MetricsServiceServer. Envoy only identifies itself in the
+// first message on a stream, so that's remembered for the rest of them.
+func (s *Sink) StreamMetrics(stream v3metrics.MetricsService_StreamMetricsServer) error {
+	ctx := stream.Context()
+	var node Node
+	for {
+		msg, err := stream.Recv()
+		if err == io.EOF {
+			return stream.SendAndClose(&v3metrics.StreamMetricsResponse{})
+		}
+		if err != nil {
+			return err
+		}
+		if id := msg.GetIdentifier(); id != nil {
+			node = Node{ID: id.GetNode().GetId(), Cluster: id.GetNode().GetCluster()}
+			dlog.Debugf(ctx, "metrics stream from node %q (cluster %q)", node.ID, node.Cluster)
+		}
+		s.Record(node, msg.GetEnvoyMetrics())
+	}
+}
+
+// Record stores the metrics that a node has just reported.
+func (s *Sink) Record(node Node, families []*dto.MetricFamily) {
+	now := s.clock()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, family := range families {
+		for _, metric := range family.GetMetric() {
+			key := seriesKey{name: family.GetName(), node: node, labels: labelsKey(metric.GetLabel())}
+			prev := s.series[key]
+			if prev != nil && prev.typ != family.GetType() {
+				// The stat changed type, which means it's really a different stat now.
+				prev = nil
+			}
+
+			stored := withLabels(metric, withNodeLabels(metric.GetLabel(), node))
+			if s.opts.CountersAsDeltas && family.GetType() == dto.MetricType_COUNTER && prev != nil {
+				stored.Counter = &dto.Counter{
+					Value: proto.Float64(prev.metric.GetCounter().GetValue() + metric.GetCounter().GetValue()),
+				}
+			}
+
+			entry := &series{
+				family:  family.GetName(),
+				help:    family.GetHelp(),
+				typ:     family.GetType(),
+				metric:  stored,
+				node:    node,
+				start:   now,
+				updated: now,
+			}
+			if prev != nil {
+				entry.start = prev.start
+			}
+			s.series[key] = entry
+		}
+	}
+}
+
+// snapshot expires the series that are past their retention, and returns the rest, sorted by
+// name and then by labels.
+func (s *Sink) snapshot() []*series {
+	now := s.clock()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ret := make([]*series, 0, len(s.series))
+	for key, entry := range s.series {
+		if now.Sub(entry.updated) > s.opts.Retention {
+			delete(s.series, key)
+			continue
+		}
+		ret = append(ret, entry)
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		if ret[i].family != ret[j].family {
+			return ret[i].family < ret[j].family
+		}
+		return labelsKey(ret[i].metric.GetLabel()) < labelsKey(ret[j].metric.GetLabel())
+	})
+	return ret
+}
+
+// labelsKey turns a set of labels into a string that's the same regardless of their order.
+func labelsKey(labels []*dto.LabelPair) string {
+	pairs := make([]string, 0, len(labels))
+	for _, label := range labels {
+		pairs = append(pairs, label.GetName()+"="+label.GetValue())
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, "\xff")
+}
+
+// withNodeLabels returns a sorted copy of labels with the node labels added. If the Envoy already
+// has labels with those names, ours win.
+func withNodeLabels(labels []*dto.LabelPair, node Node) []*dto.LabelPair {
+	ret := make([]*dto.LabelPair, 0, len(labels)+2)
+	for _, label := range labels {
+		if label.GetName() == NodeIDLabel || label.GetName() == NodeClusterLabel {
+			continue
+		}
+		ret = append(ret, &dto.LabelPair{Name: proto.String(label.GetName()), Value: proto.String(label.GetValue())})
+	}
+	ret = append(ret,
+		&dto.LabelPair{Name: proto.String(NodeIDLabel), Value: proto.String(node.ID)},
+		&dto.LabelPair{Name: proto.String(NodeClusterLabel), Value: proto.String(node.Cluster)},
+	)
+	sort.Slice(ret, func(i, j int) bool { return ret[i].GetName() < ret[j].GetName() })
+	return ret
+}
+
+// withLabels returns a copy of metric with different labels and without a timestamp. The values
+// are shared with the original, so neither of them may be modified afterwards.
+//
+// (client_model's messages are still the old github.com/golang/protobuf kind, which
+// google.golang.org/protobuf/proto can't Clone.)
+func withLabels(metric *dto.Metric, labels []*dto.LabelPair) *dto.Metric {
+	return &dto.Metric{
+		Label:     labels,
+		Gauge:     metric.Gauge,
+		Counter:   metric.Counter,
+		Summary:   metric.Summary,
+		Untyped:   metric.Untyped,
+		Histogram: metric.Histogram,
+	}
+}
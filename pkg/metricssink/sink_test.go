@@ -0,0 +1,249 @@
+package metricssink
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	collectorpb "go.opentelemetry.io/proto/otlp/collector/metrics/v1"
+	metricspb "go.opentelemetry.io/proto/otlp/metrics/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/datawire/dlib/dlog"
+	v3core "github.com/emissary-ingress/emissary/v3/pkg/api/envoy/config/core/v3"
+	v3metrics "github.com/emissary-ingress/emissary/v3/pkg/api/envoy/service/metrics/v3"
+)
+
+func newTestSink(opts Options) (*Sink, *time.Time) {
+	s := New(opts)
+	now := time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC)
+	s.clock = func() time.Time { return now }
+	return s, &now
+}
+
+func counter(name string, value float64, labels ...string) *dto.MetricFamily {
+	metric := &dto.Metric{Counter: &dto.Counter{Value: proto.Float64(value)}}
+	for i := 0; i+1 < len(labels); i += 2 {
+		metric.Label = append(metric.Label, &dto.LabelPair{Name: proto.String(labels[i]), Value: proto.String(labels[i+1])})
+	}
+	return &dto.MetricFamily{
+		Name:   proto.String(name),
+		Type:   dto.MetricType_COUNTER.Enum(),
+		Metric: []*dto.Metric{metric},
+	}
+}
+
+func gauge(name string, value float64) *dto.MetricFamily {
+	return &dto.MetricFamily{
+		Name:   proto.String(name),
+		Type:   dto.MetricType_GAUGE.Enum(),
+		Metric: []*dto.Metric{{Gauge: &dto.Gauge{Value: proto.Float64(value)}}},
+	}
+}
+
+func histogram(name string) *dto.MetricFamily {
+	return &dto.MetricFamily{
+		Name: proto.String(name),
+		Type: dto.MetricType_HISTOGRAM.Enum(),
+		Metric: []*dto.Metric{{Histogram: &dto.Histogram{
+			SampleCount: proto.Uint64(5),
+			SampleSum:   proto.Float64(42),
+			Bucket: []*dto.Bucket{
+				{UpperBound: proto.Float64(1), CumulativeCount: proto.Uint64(1)},
+				{UpperBound: proto.Float64(10), CumulativeCount: proto.Uint64(4)},
+				{UpperBound: proto.Float64(math.Inf(+1)), CumulativeCount: proto.Uint64(5)},
+			},
+		}}},
+	}
+}
+
+var (
+	nodeA = Node{ID: "ambassador-a", Cluster: "ambassador-default"}
+	nodeB = Node{ID: "ambassador-b", Cluster: "ambassador-default"}
+)
+
+func TestGather(t *testing.T) {
+	s, _ := newTestSink(Options{})
+	s.Record(nodeA, []*dto.MetricFamily{
+		counter("cluster.upstream_rq_total", 10, "envoy_cluster_name", "quote"),
+		gauge("server.live", 1),
+		histogram("cluster.upstream_rq_time"),
+	})
+	s.Record(nodeB, []*dto.MetricFamily{
+		counter("cluster.upstream_rq_total", 3, "envoy_cluster_name", "quote"),
+	})
+
+	expected := `
+# HELP envoy_cluster_upstream_rq_time Envoy stat cluster.upstream_rq_time
+# TYPE envoy_cluster_upstream_rq_time histogram
+envoy_cluster_upstream_rq_time_bucket{envoy_node_cluster="ambassador-default",envoy_node_id="ambassador-a",le="1"} 1
+envoy_cluster_upstream_rq_time_bucket{envoy_node_cluster="ambassador-default",envoy_node_id="ambassador-a",le="10"} 4
+envoy_cluster_upstream_rq_time_bucket{envoy_node_cluster="ambassador-default",envoy_node_id="ambassador-a",le="+Inf"} 5
+envoy_cluster_upstream_rq_time_sum{envoy_node_cluster="ambassador-default",envoy_node_id="ambassador-a"} 42
+envoy_cluster_upstream_rq_time_count{envoy_node_cluster="ambassador-default",envoy_node_id="ambassador-a"} 5
+# HELP envoy_cluster_upstream_rq_total Envoy stat cluster.upstream_rq_total
+# TYPE envoy_cluster_upstream_rq_total counter
+envoy_cluster_upstream_rq_total{envoy_cluster_name="quote",envoy_node_cluster="ambassador-default",envoy_node_id="ambassador-a"} 10
+envoy_cluster_upstream_rq_total{envoy_cluster_name="quote",envoy_node_cluster="ambassador-default",envoy_node_id="ambassador-b"} 3
+# HELP envoy_server_live Envoy stat server.live
+# TYPE envoy_server_live gauge
+envoy_server_live{envoy_node_cluster="ambassador-default",envoy_node_id="ambassador-a"} 1
+`
+	assert.NoError(t, testutil.GatherAndCompare(s, strings.NewReader(expected)))
+}
+
+func TestRetention(t *testing.T) {
+	s, now := newTestSink(Options{Retention: time.Minute})
+	s.Record(nodeA, []*dto.MetricFamily{gauge("server.live", 1)})
+	*now = now.Add(30 * time.Second)
+	s.Record(nodeB, []*dto.MetricFamily{gauge("server.live", 1)})
+
+	count, err := testutil.GatherAndCount(s)
+	require.NoError(t, err)
+	assert.Equal(t, 2, count)
+
+	// Node A hasn't said anything for long enough that it's gone.
+	*now = now.Add(45 * time.Second)
+	count, err = testutil.GatherAndCount(s)
+	require.NoError(t, err)
+	assert.Equal(t, 1, count)
+	assert.Len(t, s.series, 1)
+}
+
+func TestCountersAsDeltas(t *testing.T) {
+	for _, asDeltas := range []bool{false, true} {
+		s, _ := newTestSink(Options{CountersAsDeltas: asDeltas})
+		s.Record(nodeA, []*dto.MetricFamily{counter("http.rq_total", 5)})
+		s.Record(nodeA, []*dto.MetricFamily{counter("http.rq_total", 2)})
+
+		families, err := s.Gather()
+		require.NoError(t, err)
+		require.Len(t, families, 1)
+		value := families[0].GetMetric()[0].GetCounter().GetValue()
+		if asDeltas {
+			assert.Equal(t, 7.0, value)
+		} else {
+			assert.Equal(t, 2.0, value)
+		}
+	}
+}
+
+type fakeStream struct {
+	grpc.ServerStream
+	ctx      context.Context
+	messages []*v3metrics.StreamMetricsMessage
+	closed   bool
+}
+
+func (f *fakeStream) Context() context.Context { return f.ctx }
+
+func (f *fakeStream) Recv() (*v3metrics.StreamMetricsMessage, error) {
+	if len(f.messages) == 0 {
+		return nil, io.EOF
+	}
+	msg := f.messages[0]
+	f.messages = f.messages[1:]
+	return msg, nil
+}
+
+func (f *fakeStream) SendAndClose(*v3metrics.StreamMetricsResponse) error {
+	f.closed = true
+	return nil
+}
+
+func TestStreamMetrics(t *testing.T) {
+	s, _ := newTestSink(Options{})
+
+	// Only the first message says who it's from.
+	stream := &fakeStream{
+		ctx: dlog.NewTestContext(t, false),
+		messages: []*v3metrics.StreamMetricsMessage{
+			{
+				Identifier: &v3metrics.StreamMetricsMessage_Identifier{
+					Node: &v3core.Node{Id: nodeA.ID, Cluster: nodeA.Cluster},
+				},
+				EnvoyMetrics: []*dto.MetricFamily{gauge("server.live", 1)},
+			},
+			{
+				EnvoyMetrics: []*dto.MetricFamily{gauge("server.live", 0)},
+			},
+		},
+	}
+	require.NoError(t, s.StreamMetrics(stream))
+	assert.True(t, stream.closed)
+
+	expected := `
+# HELP envoy_server_live Envoy stat server.live
+# TYPE envoy_server_live gauge
+envoy_server_live{envoy_node_cluster="ambassador-default",envoy_node_id="ambassador-a"} 0
+`
+	assert.NoError(t, testutil.GatherAndCompare(s, strings.NewReader(expected)))
+}
+
+func TestOTLPExport(t *testing.T) {
+	s, _ := newTestSink(Options{})
+	s.Record(nodeA, []*dto.MetricFamily{
+		counter("cluster.upstream_rq_total", 10, "envoy_cluster_name", "quote"),
+		histogram("cluster.upstream_rq_time"),
+	})
+	s.Record(nodeB, []*dto.MetricFamily{gauge("server.live", 1)})
+
+	var received collectorpb.ExportMetricsServiceRequest
+	var header http.Header
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header = r.Header
+		body, err := ioutil.ReadAll(r.Body)
+		require.NoError(t, err)
+		require.NoError(t, proto.Unmarshal(body, &received))
+	}))
+	defer srv.Close()
+
+	exporter := &OTLPExporter{Endpoint: srv.URL, Headers: http.Header{"Authorization": {"Bearer xyzzy"}}}
+	require.NoError(t, exporter.Export(dlog.NewTestContext(t, false), s))
+	assert.Equal(t, "application/x-protobuf", header.Get("Content-Type"))
+	assert.Equal(t, "Bearer xyzzy", header.Get("Authorization"))
+
+	// One resource per node.
+	require.Len(t, received.ResourceMetrics, 2)
+	nodeAMetrics := received.ResourceMetrics[0]
+	assert.Equal(t, "envoy.node.id", nodeAMetrics.GetResource().GetAttributes()[0].GetKey())
+	assert.Equal(t, nodeA.ID, nodeAMetrics.GetResource().GetAttributes()[0].GetValue().GetStringValue())
+
+	metrics := nodeAMetrics.GetScopeMetrics()[0].GetMetrics()
+	require.Len(t, metrics, 2)
+
+	hist := metrics[0]
+	assert.Equal(t, "cluster.upstream_rq_time", hist.GetName())
+	point := hist.GetHistogram().GetDataPoints()[0]
+	assert.Equal(t, []float64{1, 10}, point.GetExplicitBounds())
+	assert.Equal(t, []uint64{1, 3, 1}, point.GetBucketCounts())
+	assert.Equal(t, uint64(5), point.GetCount())
+
+	sum := metrics[1]
+	assert.Equal(t, "cluster.upstream_rq_total", sum.GetName())
+	assert.True(t, sum.GetSum().GetIsMonotonic())
+	assert.Equal(t, metricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_CUMULATIVE, sum.GetSum().GetAggregationTemporality())
+	sumPoint := sum.GetSum().GetDataPoints()[0]
+	assert.Equal(t, 10.0, sumPoint.GetAsDouble())
+	require.Len(t, sumPoint.GetAttributes(), 1)
+	assert.Equal(t, "envoy_cluster_name", sumPoint.GetAttributes()[0].GetKey())
+
+	// Errors from the endpoint are reported.
+	srv.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "nope", http.StatusBadRequest)
+	})
+	err := exporter.Export(dlog.NewTestContext(t, false), s)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "nope")
+}
@@ -0,0 +1,94 @@
+// Package origin records, in Envoy metadata, the Kubernetes resources that a piece of Envoy
+// configuration came from. It is shared by everything that builds Envoy configuration (the Gateway
+// API dispatcher; the Python side of the world does the same in
+// python/ambassador/envoy/v3/v3origin.py) and by ambex, which explains it.
+package origin
+
+import (
+	// standard library
+	"sort"
+
+	// third-party libraries
+	"google.golang.org/protobuf/types/known/structpb"
+
+	// envoy api v3
+	v3core "github.com/emissary-ingress/emissary/v3/pkg/api/envoy/config/core/v3"
+)
+
+// MetadataKey is the filter_metadata namespace under which routes, clusters, and listeners record
+// the Kubernetes resources that they came from.
+const MetadataKey = "getambassador.io"
+
+// An Origin identifies a Kubernetes resource that contributed to a piece of Envoy configuration.
+type Origin struct {
+	Kind       string `json:"kind"`
+	Namespace  string `json:"namespace"`
+	Name       string `json:"name"`
+	Generation int64  `json:"generation,omitempty"`
+}
+
+func (o Origin) less(other Origin) bool {
+	if o.Kind != other.Kind {
+		return o.Kind < other.Kind
+	}
+	if o.Namespace != other.Namespace {
+		return o.Namespace < other.Namespace
+	}
+	return o.Name < other.Name
+}
+
+// Metadata returns Envoy metadata recording the supplied origins, or nil if there aren't any.
+func Metadata(origins ...Origin) *v3core.Metadata {
+	if len(origins) == 0 {
+		return nil
+	}
+	origins = append([]Origin(nil), origins...)
+	sort.SliceStable(origins, func(i, j int) bool { return origins[i].less(origins[j]) })
+
+	var values []*structpb.Value
+	for i, origin := range origins {
+		if i > 0 && origin == origins[i-1] {
+			continue
+		}
+		fields := map[string]*structpb.Value{
+			"kind":      structpb.NewStringValue(origin.Kind),
+			"namespace": structpb.NewStringValue(origin.Namespace),
+			"name":      structpb.NewStringValue(origin.Name),
+		}
+		if origin.Generation != 0 {
+			fields["generation"] = structpb.NewNumberValue(float64(origin.Generation))
+		}
+		values = append(values, structpb.NewStructValue(&structpb.Struct{Fields: fields}))
+	}
+
+	return &v3core.Metadata{
+		FilterMetadata: map[string]*structpb.Struct{
+			MetadataKey: {
+				Fields: map[string]*structpb.Value{
+					"origins": structpb.NewListValue(&structpb.ListValue{Values: values}),
+				},
+			},
+		},
+	}
+}
+
+// FromMetadata returns the origins recorded in the supplied Envoy metadata. Anything that doesn't
+// look like an origin is ignored.
+func FromMetadata(md *v3core.Metadata) []Origin {
+	list := md.GetFilterMetadata()[MetadataKey].GetFields()["origins"].GetListValue()
+
+	var origins []Origin
+	for _, value := range list.GetValues() {
+		fields := value.GetStructValue().GetFields()
+		if fields == nil {
+			continue
+		}
+		origins = append(origins, Origin{
+			Kind:       fields["kind"].GetStringValue(),
+			Namespace:  fields["namespace"].GetStringValue(),
+			Name:       fields["name"].GetStringValue(),
+			Generation: int64(fields["generation"].GetNumberValue()),
+		})
+	}
+	return origins
+}
@@ -0,0 +1,21 @@
+package origin_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/emissary-ingress/emissary/v3/pkg/origin"
+)
+
+func TestMetadata(t *testing.T) {
+	assert.Nil(t, origin.Metadata())
+	assert.Nil(t, origin.FromMetadata(nil))
+
+	quote := origin.Origin{Kind: "Mapping", Namespace: "default", Name: "quote", Generation: 3}
+	backend := origin.Origin{Kind: "Mapping", Namespace: "default", Name: "backend"}
+
+	// Origins come back sorted, without duplicates.
+	md := origin.Metadata(quote, backend, quote)
+	assert.Equal(t, []origin.Origin{backend, quote}, origin.FromMetadata(md))
+}
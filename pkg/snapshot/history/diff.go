@@ -0,0 +1,160 @@
+package history
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+)
+
+// A Change is a single difference between two snapshots.
+//
+// The Path identifies where in the snapshot the change is: object fields are separated by dots,
+// and list elements are in brackets. Lists of Kubernetes resources are matched up by
+// "namespace/name", and lists of other named things (e.g. Envoy resources) by name, so the
+// path to the prefix of the "quote" Mapping is "Kubernetes.Mappings[default/quote].spec.prefix"
+// no matter where in the list it is. Other lists are compared element by element.
+type Change struct {
+	Op   string      `json:"op"` // "add", "remove", or "change"
+	Path string      `json:"path"`
+	From interface{} `json:"from,omitempty"`
+	To   interface{} `json:"to,omitempty"`
+}
+
+// diffValues appends to changes the differences between two decoded JSON values.
+func diffValues(path string, from, to interface{}, changes []Change) []Change {
+	switch fromTyped := from.(type) {
+	case map[string]interface{}:
+		if toTyped, ok := to.(map[string]interface{}); ok {
+			return diffMaps(path, fromTyped, toTyped, changes)
+		}
+	case []interface{}:
+		if toTyped, ok := to.([]interface{}); ok {
+			return diffLists(path, fromTyped, toTyped, changes)
+		}
+	}
+	if !reflect.DeepEqual(from, to) {
+		changes = append(changes, Change{Op: "change", Path: path, From: from, To: to})
+	}
+	return changes
+}
+
+func diffMaps(path string, from, to map[string]interface{}, changes []Change) []Change {
+	keys := make([]string, 0, len(from)+len(to))
+	for key := range from {
+		keys = append(keys, key)
+	}
+	for key := range to {
+		if _, ok := from[key]; !ok {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		childPath := key
+		if path != "" {
+			childPath = path + "." + key
+		}
+		fromValue, inFrom := from[key]
+		toValue, inTo := to[key]
+		switch {
+		case !inFrom:
+			changes = appendAdd(childPath, toValue, changes)
+		case !inTo:
+			changes = appendRemove(childPath, fromValue, changes)
+		default:
+			changes = diffValues(childPath, fromValue, toValue, changes)
+		}
+	}
+	return changes
+}
+
+func diffLists(path string, from, to []interface{}, changes []Change) []Change {
+	fromKeys, fromOK := listKeys(from)
+	toKeys, toOK := listKeys(to)
+	if fromOK && toOK {
+		fromByKey := make(map[string]interface{}, len(from))
+		for i, key := range fromKeys {
+			fromByKey[key] = from[i]
+		}
+		toByKey := make(map[string]interface{}, len(to))
+		for i, key := range toKeys {
+			toByKey[key] = to[i]
+		}
+
+		// Removals in the old order, then everything else in the new order.
+		for i, key := range fromKeys {
+			if _, ok := toByKey[key]; !ok {
+				changes = appendRemove(fmt.Sprintf("%s[%s]", path, key), from[i], changes)
+			}
+		}
+		for i, key := range toKeys {
+			elemPath := fmt.Sprintf("%s[%s]", path, key)
+			if fromValue, ok := fromByKey[key]; ok {
+				changes = diffValues(elemPath, fromValue, to[i], changes)
+			} else {
+				changes = appendAdd(elemPath, to[i], changes)
+			}
+		}
+		return changes
+	}
+
+	for i := 0; i < len(from) || i < len(to); i++ {
+		elemPath := path + "[" + strconv.Itoa(i) + "]"
+		switch {
+		case i >= len(from):
+			changes = appendAdd(elemPath, to[i], changes)
+		case i >= len(to):
+			changes = appendRemove(elemPath, from[i], changes)
+		default:
+			changes = diffValues(elemPath, from[i], to[i], changes)
+		}
+	}
+	return changes
+}
+
+func appendAdd(path string, value interface{}, changes []Change) []Change {
+	return append(changes, Change{Op: "add", Path: path, To: value})
+}
+
+func appendRemove(path string, value interface{}, changes []Change) []Change {
+	return append(changes, Change{Op: "remove", Path: path, From: value})
+}
+
+// listKeys returns the identity of each element of a list, if every element has one and they are
+// all different.
+func listKeys(list []interface{}) ([]string, bool) {
+	if len(list) == 0 {
+		return nil, true
+	}
+	keys := make([]string, 0, len(list))
+	seen := make(map[string]bool, len(list))
+	for _, elem := range list {
+		key, ok := elemKey(elem)
+		if !ok || seen[key] {
+			return nil, false
+		}
+		seen[key] = true
+		keys = append(keys, key)
+	}
+	return keys, true
+}
+
+func elemKey(elem interface{}) (string, bool) {
+	obj, ok := elem.(map[string]interface{})
+	if !ok {
+		return "", false
+	}
+	if metadata, ok := obj["metadata"].(map[string]interface{}); ok {
+		name, _ := metadata["name"].(string)
+		if name != "" {
+			namespace, _ := metadata["namespace"].(string)
+			return namespace + "/" + name, true
+		}
+	}
+	if name, ok := obj["name"].(string); ok && name != "" {
+		return name, true
+	}
+	return "", false
+}
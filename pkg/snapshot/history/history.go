@@ -0,0 +1,321 @@
+// Package history keeps a short history of the snapshots that flow through Emissary: the input
+// snapshots that the watcher hands to diagd, and the output snapshots that ambex hands to Envoy.
+// It can serve that history over HTTP, including structured diffs between any two snapshots of
+// the same kind, so that it's possible to answer "what changed right before things went wrong?"
+package history
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/emissary-ingress/emissary/v3/pkg/kates"
+)
+
+// A Kind says which part of the pipeline a snapshot came from.
+type Kind string
+
+const (
+	// Input snapshots are the ones the watcher produces from Kubernetes and Consul.
+	Input Kind = "input"
+	// Output snapshots are the Envoy configurations that ambex produces.
+	Output Kind = "output"
+)
+
+// An Entry is a single snapshot in the history.
+type Entry struct {
+	// Generation numbers are shared between inputs and outputs, so ordering entries by
+	// generation puts them in the order they happened.
+	Generation int       `json:"generation"`
+	Kind       Kind      `json:"kind"`
+	Version    string    `json:"version,omitempty"` // the ambex version, for outputs
+	Time       time.Time `json:"time"`
+	// The size of the snapshot, in bytes. Output snapshots are only marshalled when they're
+	// asked for, so until then this is only known for inputs.
+	Size int `json:"size,omitempty"`
+
+	// The Deltas field holds the Kubernetes changes that led to an input snapshot.
+	Deltas []*kates.Delta `json:"deltas,omitempty"`
+
+	Snapshot json.RawMessage `json:"snapshot,omitempty"`
+}
+
+// A History is a bounded, concurrency-safe record of recent snapshots.
+//
+// Recording a snapshot is cheap: inputs are kept as the JSON they arrived as, and outputs aren't
+// marshalled at all until someone asks for them. Secrets in input snapshots are redacted whenever
+// they're handed out.
+type History struct {
+	clock func() time.Time
+	limit int
+
+	mu         sync.Mutex
+	generation int
+	entries    map[Kind][]record
+}
+
+// A record is an Entry as it's kept in the History: without its Snapshot, which comes from either
+// input or output when it's asked for.
+type record struct {
+	Entry
+	input  []byte      // the raw input snapshot, Secrets and all
+	output interface{} // the output snapshot, to be marshalled
+}
+
+// New returns a History that keeps the most recent limit snapshots of each kind. A limit of zero
+// or less keeps nothing.
+func New(limit int) *History {
+	return &History{
+		clock:   time.Now,
+		limit:   limit,
+		entries: map[Kind][]record{},
+	}
+}
+
+// AddInput records an input snapshot, as JSON. The deltas that caused it are taken from the
+// snapshot's own Deltas field. It returns the generation of the new entry.
+func (h *History) AddInput(snapshot []byte) int {
+	if h == nil {
+		return 0
+	}
+	var withDeltas struct {
+		Deltas []*kates.Delta
+	}
+	if h.limit > 0 {
+		// If the snapshot won't parse then we just don't have any deltas for it; the diff
+		// will still show what changed.
+		_ = json.Unmarshal(snapshot, &withDeltas)
+	}
+
+	return h.add(record{
+		Entry: Entry{
+			Kind:   Input,
+			Deltas: withDeltas.Deltas,
+			Size:   len(snapshot),
+		},
+		input: snapshot,
+	})
+}
+
+// AddOutput records an ambex output snapshot, which will be marshalled to JSON if and when it's
+// asked for; it mustn't be changed afterwards. It returns the generation of the new entry.
+func (h *History) AddOutput(version string, snapshot interface{}) int {
+	return h.add(record{
+		Entry: Entry{
+			Kind:    Output,
+			Version: version,
+		},
+		output: snapshot,
+	})
+}
+
+func (h *History) add(rec record) int {
+	if h == nil {
+		return 0
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.generation++
+	if h.limit <= 0 {
+		return h.generation
+	}
+
+	rec.Generation = h.generation
+	rec.Time = h.clock()
+
+	records := append(h.entries[rec.Kind], rec)
+	if len(records) > h.limit {
+		records = append([]record(nil), records[len(records)-h.limit:]...)
+	}
+	h.entries[rec.Kind] = records
+	return rec.Generation
+}
+
+// List returns every snapshot in the history, oldest first, without the snapshots themselves.
+func (h *History) List() []Entry {
+	if h == nil {
+		return nil
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	ret := []Entry{}
+	for _, records := range h.entries {
+		for _, rec := range records {
+			ret = append(ret, rec.Entry)
+		}
+	}
+	sort.Slice(ret, func(i, j int) bool { return ret[i].Generation < ret[j].Generation })
+	return ret
+}
+
+// Get returns the snapshot with the given generation, if it's still in the history.
+func (h *History) Get(generation int) (Entry, error) {
+	rec, value, err := h.load(generation)
+	if err != nil {
+		return Entry{}, err
+	}
+	entry := rec.Entry
+	entry.Snapshot, err = json.Marshal(value)
+	if err != nil {
+		return Entry{}, fmt.Errorf("snapshot %d: %w", generation, err)
+	}
+	entry.Size = len(entry.Snapshot)
+	return entry, nil
+}
+
+// load finds the snapshot with the given generation, and returns it as a generic JSON value, with
+// any Secrets redacted.
+func (h *History) load(generation int) (record, interface{}, error) {
+	rec, ok := h.find(generation)
+	if !ok {
+		return record{}, nil, fmt.Errorf("snapshot %d is not in the history", generation)
+	}
+
+	bs := rec.input
+	if rec.Kind == Output {
+		var err error
+		if bs, err = json.Marshal(rec.output); err != nil {
+			return record{}, nil, fmt.Errorf("snapshot %d: %w", generation, err)
+		}
+	}
+	var value interface{}
+	if err := json.Unmarshal(bs, &value); err != nil {
+		return record{}, nil, fmt.Errorf("snapshot %d: %w", generation, err)
+	}
+	if rec.Kind == Input {
+		redactInput(value)
+	}
+	return rec, value, nil
+}
+
+func (h *History) find(generation int) (record, bool) {
+	if h == nil {
+		return record{}, false
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for _, records := range h.entries {
+		for _, rec := range records {
+			if rec.Generation == generation {
+				return rec, true
+			}
+		}
+	}
+	return record{}, false
+}
+
+// redactInput scrubs an input snapshot the way that snapshot.Snapshot.Sanitize does: the values
+// of Secrets are replaced with "<REDACTED>", and invalid resources (which we know nothing about,
+// so which might have anything in them) are cut down to their kind, name, namespace, and errors.
+func redactInput(value interface{}) {
+	snapshot, _ := value.(map[string]interface{})
+
+	kubernetes, _ := snapshot["Kubernetes"].(map[string]interface{})
+	secrets, _ := kubernetes["secret"].([]interface{})
+	for _, secret := range secrets {
+		secret, _ := secret.(map[string]interface{})
+		for _, field := range []string{"data", "stringData"} {
+			data, _ := secret[field].(map[string]interface{})
+			for key := range data {
+				data[key] = "<REDACTED>"
+			}
+		}
+		if metadata, ok := secret["metadata"].(map[string]interface{}); ok {
+			// The last-applied-configuration annotation has the data in it too.
+			delete(metadata, "annotations")
+		}
+	}
+
+	invalid, _ := snapshot["Invalid"].([]interface{})
+	for i, obj := range invalid {
+		obj, _ := obj.(map[string]interface{})
+		metadata, _ := obj["metadata"].(map[string]interface{})
+		scrubbed := map[string]interface{}{
+			"apiVersion": obj["apiVersion"],
+			"kind":       obj["kind"],
+			"metadata": map[string]interface{}{
+				"name":      metadata["name"],
+				"namespace": metadata["namespace"],
+			},
+		}
+		if errs, ok := obj["errors"]; ok {
+			scrubbed["errors"] = errs
+		}
+		invalid[i] = scrubbed
+	}
+}
+
+// A Diff describes how one snapshot differs from an earlier one of the same kind.
+type Diff struct {
+	Kind     Kind      `json:"kind"`
+	From     int       `json:"from"`
+	To       int       `json:"to"`
+	FromTime time.Time `json:"fromTime"`
+	ToTime   time.Time `json:"toTime"`
+
+	// For inputs, the Deltas field holds all the Kubernetes changes reported in between the two
+	// snapshots (that is, for every input after From, up to and including To).
+	Deltas []*kates.Delta `json:"deltas,omitempty"`
+
+	Changes []Change `json:"changes"`
+}
+
+// Diff compares the snapshots with the two given generations.
+func (h *History) Diff(from, to int) (*Diff, error) {
+	fromEntry, ok := h.find(from)
+	if !ok {
+		return nil, fmt.Errorf("snapshot %d is not in the history", from)
+	}
+	toEntry, ok := h.find(to)
+	if !ok {
+		return nil, fmt.Errorf("snapshot %d is not in the history", to)
+	}
+	if fromEntry.Kind != toEntry.Kind {
+		return nil, fmt.Errorf("cannot compare an %s snapshot (%d) with an %s snapshot (%d)",
+			fromEntry.Kind, from, toEntry.Kind, to)
+	}
+
+	_, fromValue, err := h.load(from)
+	if err != nil {
+		return nil, err
+	}
+	_, toValue, err := h.load(to)
+	if err != nil {
+		return nil, err
+	}
+
+	if fromEntry.Kind == Input {
+		// The deltas are reported separately, below.
+		for _, value := range []interface{}{fromValue, toValue} {
+			if m, ok := value.(map[string]interface{}); ok {
+				delete(m, "Deltas")
+			}
+		}
+	}
+
+	diff := &Diff{
+		Kind:     fromEntry.Kind,
+		From:     from,
+		To:       to,
+		FromTime: fromEntry.Time,
+		ToTime:   toEntry.Time,
+		Changes:  diffValues("", fromValue, toValue, nil),
+	}
+
+	if fromEntry.Kind == Input && from < to {
+		h.mu.Lock()
+		for _, rec := range h.entries[Input] {
+			if rec.Generation > from && rec.Generation <= to {
+				diff.Deltas = append(diff.Deltas, rec.Deltas...)
+			}
+		}
+		h.mu.Unlock()
+	}
+
+	return diff, nil
+}
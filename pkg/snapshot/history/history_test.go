@@ -0,0 +1,251 @@
+package history
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestHistory(limit int) *History {
+	h := New(limit)
+	now := time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC)
+	h.clock = func() time.Time {
+		now = now.Add(time.Second)
+		return now
+	}
+	return h
+}
+
+func TestHistoryLimit(t *testing.T) {
+	h := newTestHistory(2)
+
+	assert.Equal(t, 1, h.AddInput([]byte(`{"Kubernetes":{}}`)))
+	assert.Equal(t, 2, h.AddOutput("v1", json.RawMessage(`{}`)))
+	assert.Equal(t, 3, h.AddInput([]byte(`{"Kubernetes":{}}`)))
+	assert.Equal(t, 4, h.AddInput([]byte(`{"Kubernetes":{}}`)))
+	assert.Equal(t, 5, h.AddOutput("v2", json.RawMessage(`{}`)))
+
+	// Generation 1 has aged out of the inputs, but both outputs are still there.
+	var generations []int
+	for _, entry := range h.List() {
+		generations = append(generations, entry.Generation)
+		assert.Nil(t, entry.Snapshot)
+	}
+	assert.Equal(t, []int{2, 3, 4, 5}, generations)
+
+	_, err := h.Get(1)
+	assert.Error(t, err)
+	entry, err := h.Get(5)
+	require.NoError(t, err)
+	assert.Equal(t, Output, entry.Kind)
+	assert.Equal(t, "v2", entry.Version)
+	assert.Equal(t, 2, entry.Size)
+
+	// A limit of zero keeps nothing, and a nil History ignores everything.
+	h = New(0)
+	h.AddInput([]byte(`{}`))
+	assert.Empty(t, h.List())
+
+	var nilHistory *History
+	assert.Equal(t, 0, nilHistory.AddInput([]byte(`{}`)))
+	assert.Nil(t, nilHistory.List())
+}
+
+// A marshalCounter counts how many times it's been marshalled.
+type marshalCounter struct {
+	count *int
+}
+
+func (m marshalCounter) MarshalJSON() ([]byte, error) {
+	*m.count++
+	return []byte(`{"clusters": []}`), nil
+}
+
+func TestHistoryLazyOutput(t *testing.T) {
+	h := newTestHistory(2)
+
+	// Outputs are only marshalled when they're asked for.
+	var count int
+	h.AddOutput("v1", marshalCounter{&count})
+	h.AddOutput("v2", marshalCounter{&count})
+	assert.Len(t, h.List(), 2)
+	assert.Equal(t, 0, count)
+
+	entry, err := h.Get(2)
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"clusters": []}`, string(entry.Snapshot))
+	assert.Equal(t, 1, count)
+
+	// Nor are they marshalled at all if the history is off.
+	h = New(0)
+	h.AddOutput("v1", marshalCounter{&count})
+	assert.Equal(t, 1, count)
+}
+
+func TestHistoryRedactsSecrets(t *testing.T) {
+	h := newTestHistory(10)
+	h.AddInput([]byte(`{
+		"Kubernetes": {"secret": [{
+			"metadata": {"namespace": "default", "name": "tls", "annotations": {"last-applied": "tls.key: c2VjcmV0"}},
+			"data": {"tls.crt": "Y2VydA==", "tls.key": "c2VjcmV0"}
+		}]},
+		"Invalid": [{
+			"apiVersion": "getambassador.io/v3alpha1",
+			"kind": "Mapping",
+			"metadata": {"namespace": "default", "name": "bad", "labels": {"a": "b"}},
+			"spec": {"headers": {"authorization": "Bearer hunter2"}},
+			"errors": "spec.prefix in body is required"
+		}]
+	}`))
+
+	entry, err := h.Get(1)
+	require.NoError(t, err)
+	assert.JSONEq(t, `{
+		"Kubernetes": {"secret": [{
+			"metadata": {"namespace": "default", "name": "tls"},
+			"data": {"tls.crt": "<REDACTED>", "tls.key": "<REDACTED>"}
+		}]},
+		"Invalid": [{
+			"apiVersion": "getambassador.io/v3alpha1",
+			"kind": "Mapping",
+			"metadata": {"namespace": "default", "name": "bad"},
+			"errors": "spec.prefix in body is required"
+		}]
+	}`, string(entry.Snapshot))
+}
+
+func TestHistoryDiff(t *testing.T) {
+	h := newTestHistory(10)
+
+	h.AddInput([]byte(`{
+		"Kubernetes": {"Mappings": [
+			{"metadata": {"namespace": "default", "name": "quote"}, "spec": {"prefix": "/quote/"}},
+			{"metadata": {"namespace": "default", "name": "old"}, "spec": {"prefix": "/old/"}}
+		]},
+		"Deltas": []
+	}`))
+	h.AddOutput("v1", json.RawMessage(`{}`))
+	h.AddInput([]byte(`{
+		"Kubernetes": {"Mappings": [
+			{"metadata": {"namespace": "default", "name": "new"}, "spec": {"prefix": "/new/"}},
+			{"metadata": {"namespace": "default", "name": "quote"}, "spec": {"prefix": "/quote/"}}
+		]},
+		"Deltas": [{"kind": "Mapping", "metadata": {"namespace": "default", "name": "new"}, "deltaType": 0}]
+	}`))
+	h.AddInput([]byte(`{
+		"Kubernetes": {"Mappings": [
+			{"metadata": {"namespace": "default", "name": "new"}, "spec": {"prefix": "/new/"}},
+			{"metadata": {"namespace": "default", "name": "quote"}, "spec": {"prefix": "/backend/"}}
+		]},
+		"Deltas": [{"kind": "Mapping", "metadata": {"namespace": "default", "name": "quote"}, "deltaType": 1}]
+	}`))
+
+	diff, err := h.Diff(1, 4)
+	require.NoError(t, err)
+	assert.Equal(t, Input, diff.Kind)
+	assert.Len(t, diff.Deltas, 2)
+
+	// Mappings are matched up by name, so reordering them isn't a change.
+	assert.Equal(t, []Change{
+		{
+			Op:   "remove",
+			Path: "Kubernetes.Mappings[default/old]",
+			From: map[string]interface{}{
+				"metadata": map[string]interface{}{"namespace": "default", "name": "old"},
+				"spec":     map[string]interface{}{"prefix": "/old/"},
+			},
+		},
+		{
+			Op:   "add",
+			Path: "Kubernetes.Mappings[default/new]",
+			To: map[string]interface{}{
+				"metadata": map[string]interface{}{"namespace": "default", "name": "new"},
+				"spec":     map[string]interface{}{"prefix": "/new/"},
+			},
+		},
+		{
+			Op:   "change",
+			Path: "Kubernetes.Mappings[default/quote].spec.prefix",
+			From: "/quote/",
+			To:   "/backend/",
+		},
+	}, diff.Changes)
+
+	_, err = h.Diff(1, 2)
+	assert.Error(t, err)
+	_, err = h.Diff(1, 42)
+	assert.Error(t, err)
+}
+
+func TestDiffLists(t *testing.T) {
+	// Lists without names are compared element by element.
+	changes := diffValues("", []interface{}{"a", "b"}, []interface{}{"a", "c", "d"}, nil)
+	assert.Equal(t, []Change{
+		{Op: "change", Path: "[1]", From: "b", To: "c"},
+		{Op: "add", Path: "[2]", To: "d"},
+	}, changes)
+
+	// So are lists with duplicate names.
+	dup := []interface{}{
+		map[string]interface{}{"name": "x", "value": 1.0},
+		map[string]interface{}{"name": "x", "value": 2.0},
+	}
+	dupChanged := []interface{}{
+		map[string]interface{}{"name": "x", "value": 1.0},
+		map[string]interface{}{"name": "x", "value": 3.0},
+	}
+	assert.Equal(t, []Change{
+		{Op: "change", Path: "clusters[1].value", From: 2.0, To: 3.0},
+	}, diffValues("clusters", dup, dupChanged, nil))
+
+	// Envoy resources are matched up by name.
+	assert.Equal(t, []Change{
+		{Op: "change", Path: "clusters[cluster_a].value", From: 1.0, To: 2.0},
+	}, diffValues("clusters",
+		[]interface{}{map[string]interface{}{"name": "cluster_a", "value": 1.0}},
+		[]interface{}{map[string]interface{}{"name": "cluster_a", "value": 2.0}},
+		nil))
+}
+
+func TestServeHTTP(t *testing.T) {
+	h := newTestHistory(10)
+	h.AddInput([]byte(`{"Kubernetes": {"a": 1}}`))
+	h.AddInput([]byte(`{"Kubernetes": {"a": 2}}`))
+
+	get := func(url string) (int, map[string]interface{}, []interface{}) {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, url, nil))
+		assert.Equal(t, "application/json", w.Header().Get("Content-Type"))
+		var value interface{}
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &value))
+		obj, _ := value.(map[string]interface{})
+		list, _ := value.([]interface{})
+		return w.Code, obj, list
+	}
+
+	code, _, list := get("/snapshots")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Len(t, list, 2)
+
+	code, obj, _ := get("/snapshots/2")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, map[string]interface{}{"Kubernetes": map[string]interface{}{"a": 2.0}}, obj["snapshot"])
+
+	code, obj, _ = get("/snapshots/diff?from=1&to=2")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, []interface{}{
+		map[string]interface{}{"op": "change", "path": "Kubernetes.a", "from": 1.0, "to": 2.0},
+	}, obj["changes"])
+
+	code, obj, _ = get("/snapshots/diff?from=1&to=banana")
+	assert.Equal(t, http.StatusBadRequest, code)
+	assert.Contains(t, obj["error"], "banana")
+
+	code, _, _ = get("/snapshots/3")
+	assert.Equal(t, http.StatusNotFound, code)
+}
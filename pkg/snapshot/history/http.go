@@ -0,0 +1,80 @@
+package history
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// ServeHTTP serves the history as JSON. It expects to be mounted at both "/snapshots" and
+// "/snapshots/", and answers:
+//
+//	/snapshots                    the list of entries, without the snapshots themselves
+//	/snapshots/{gen}              a single entry, including its snapshot
+//	/snapshots/diff?from=&to=     the Diff between two entries
+func (h *History) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	rest := strings.Trim(strings.TrimPrefix(r.URL.Path, "/snapshots"), "/")
+
+	switch rest {
+	case "":
+		writeJSON(w, http.StatusOK, h.List())
+	case "diff":
+		from, err := generationParam(r, "from")
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		to, err := generationParam(r, "to")
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		diff, err := h.Diff(from, to)
+		if err != nil {
+			writeError(w, http.StatusNotFound, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, diff)
+	default:
+		generation, err := strconv.Atoi(rest)
+		if err != nil {
+			writeError(w, http.StatusNotFound, fmt.Errorf("no such snapshot: %q", rest))
+			return
+		}
+		entry, err := h.Get(generation)
+		if err != nil {
+			writeError(w, http.StatusNotFound, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, entry)
+	}
+}
+
+func generationParam(r *http.Request, name string) (int, error) {
+	value := r.URL.Query().Get(name)
+	if value == "" {
+		return 0, fmt.Errorf("missing %q parameter", name)
+	}
+	generation, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, fmt.Errorf("bad %q parameter: %q is not a generation", name, value)
+	}
+	return generation, nil
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
+
+func writeJSON(w http.ResponseWriter, status int, value interface{}) {
+	bs, err := json.MarshalIndent(value, "", "  ")
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_, _ = w.Write(bs)
+}
@@ -3,6 +3,7 @@ package snapshot
 import (
 	"context"
 	"fmt"
+	"sort"
 	"strings"
 
 	"github.com/datawire/dlib/derror"
@@ -23,7 +24,32 @@ var (
 	validator = crdAll.NewValidator()
 )
 
+// AnnotationConfigKey is the annotation that holds the YAML of the resources to unfold.
+const AnnotationConfigKey = "getambassador.io/config"
+
+// AnnotationOriginKey is the annotation that PopulateAnnotations puts on every resource that it
+// unfolds out of a getambassador.io/config annotation. Its value is the "Kind/name.namespace" of
+// the object that the annotation is on.
+const AnnotationOriginKey = "getambassador.io/config-origin"
+
+// AnnotationOrigin returns the "Kind/name.namespace" of the object whose getambassador.io/config
+// annotation the resource was unfolded from, or "" if the resource didn't come from an annotation.
+func AnnotationOrigin(obj kates.Object) string {
+	return obj.GetAnnotations()[AnnotationOriginKey]
+}
+
+// PopulateAnnotations unfolds the getambassador.io/config annotations on Services, Ingresses,
+// Deployments, and Pods into the same typed slices as the CRDs (Mappings into Mappings, and so on),
+// marking each of them with AnnotationOriginKey. Resources that fail validation go into
+// InvalidAnnotations instead. A resource that's in the annotations of several Pods (as it is for
+// every replica of a workload) is only unfolded once, from the first of them by name.
+//
+// The typed slices are only rewritten when the CRDs in them change, so PopulateAnnotations first
+// removes whatever it unfolded the last time it was called.
 func (s *KubernetesSnapshot) PopulateAnnotations(ctx context.Context) error {
+	s.removeAnnotationResources()
+	s.InvalidAnnotations = nil
+
 	var annotatable []kates.Object
 	for _, svc := range s.Services {
 		annotatable = append(annotatable, svc)
@@ -31,8 +57,23 @@ func (s *KubernetesSnapshot) PopulateAnnotations(ctx context.Context) error {
 	for _, ing := range s.Ingresses {
 		annotatable = append(annotatable, ing)
 	}
+	for _, deployment := range s.Deployments {
+		annotatable = append(annotatable, deployment)
+	}
+	// Every replica of a workload has the same annotations, so sort the Pods, to always unfold the
+	// same replica's resources; see below.
+	pods := append([]*kates.Pod(nil), s.Pods...)
+	sort.Slice(pods, func(i, j int) bool {
+		return annotationKey(pods[i]) < annotationKey(pods[j])
+	})
+	for _, pod := range pods {
+		annotatable = append(annotatable, pod)
+	}
+
+	// The kind/name.namespace of every resource unfolded from a Pod, so that each of them is only
+	// unfolded once, rather than once per replica.
+	fromPods := map[string]bool{}
 
-	s.Annotations = make(map[string]AnnotationList)
 	var errs derror.MultiError
 	for _, r := range annotatable {
 		key := annotationKey(r)
@@ -41,19 +82,31 @@ func (s *KubernetesSnapshot) PopulateAnnotations(ctx context.Context) error {
 			errs = append(errs, fmt.Errorf("%s: %w", key, err))
 			continue
 		}
-		annotations := make(AnnotationList, len(objs))
-		for i, untypedObj := range objs {
+		for _, untypedObj := range objs {
+			if _, isPod := r.(*kates.Pod); isPod {
+				objKey := fmt.Sprintf("%s/%s.%s", untypedObj.GetKind(), untypedObj.GetName(), untypedObj.GetNamespace())
+				if fromPods[objKey] {
+					continue
+				}
+				fromPods[objKey] = true
+			}
+
+			annotations := untypedObj.GetAnnotations()
+			if annotations == nil {
+				annotations = map[string]string{}
+			}
+			annotations[AnnotationOriginKey] = key
+			untypedObj.SetAnnotations(annotations)
+
 			typedObj, err := ValidateAndConvertObject(ctx, untypedObj)
+			if err == nil && !s.addAnnotationResource(typedObj) {
+				err = fmt.Errorf("%s resources are not supported in annotations", untypedObj.GetKind())
+			}
 			if err != nil {
 				untypedObj.Object["errors"] = err.Error()
-				annotations[i] = untypedObj
-			} else {
-				annotations[i] = typedObj
+				s.InvalidAnnotations = append(s.InvalidAnnotations, untypedObj)
 			}
 		}
-		if len(annotations) > 0 {
-			s.Annotations[key] = annotations
-		}
 	}
 
 	if len(errs) > 0 {
@@ -62,6 +115,78 @@ func (s *KubernetesSnapshot) PopulateAnnotations(ctx context.Context) error {
 	return nil
 }
 
+// addAnnotationResource adds a resource unfolded from an annotation to the appropriate typed
+// slice, returning false if there isn't one.
+func (s *KubernetesSnapshot) addAnnotationResource(obj kates.Object) bool {
+	switch obj := obj.(type) {
+	case *crdCurrent.Listener:
+		s.Listeners = append(s.Listeners, obj)
+	case *crdCurrent.Host:
+		s.Hosts = append(s.Hosts, obj)
+	case *crdCurrent.Mapping:
+		s.Mappings = append(s.Mappings, obj)
+	case *crdCurrent.TCPMapping:
+		s.TCPMappings = append(s.TCPMappings, obj)
+	case *crdCurrent.Module:
+		s.Modules = append(s.Modules, obj)
+	case *crdCurrent.TLSContext:
+		s.TLSContexts = append(s.TLSContexts, obj)
+	case *crdCurrent.AuthService:
+		s.AuthServices = append(s.AuthServices, obj)
+	case *crdCurrent.RateLimitService:
+		s.RateLimitServices = append(s.RateLimitServices, obj)
+	case *crdCurrent.LogService:
+		s.LogServices = append(s.LogServices, obj)
+	case *crdCurrent.TracingService:
+		s.TracingServices = append(s.TracingServices, obj)
+	case *crdCurrent.DevPortal:
+		s.DevPortals = append(s.DevPortals, obj)
+	case *crdCurrent.ConsulResolver:
+		s.ConsulResolvers = append(s.ConsulResolvers, obj)
+	case *crdCurrent.DNSResolver:
+		s.DNSResolvers = append(s.DNSResolvers, obj)
+	case *crdCurrent.StaticEndpointResolver:
+		s.StaticEndpointResolvers = append(s.StaticEndpointResolvers, obj)
+	case *crdCurrent.KubernetesEndpointResolver:
+		s.KubernetesEndpointResolvers = append(s.KubernetesEndpointResolvers, obj)
+	case *crdCurrent.KubernetesServiceResolver:
+		s.KubernetesServiceResolvers = append(s.KubernetesServiceResolvers, obj)
+	default:
+		return false
+	}
+	return true
+}
+
+// removeAnnotationResources removes everything that addAnnotationResource added.
+func (s *KubernetesSnapshot) removeAnnotationResources() {
+	s.Listeners = withoutAnnotationResources(s.Listeners)
+	s.Hosts = withoutAnnotationResources(s.Hosts)
+	s.Mappings = withoutAnnotationResources(s.Mappings)
+	s.TCPMappings = withoutAnnotationResources(s.TCPMappings)
+	s.Modules = withoutAnnotationResources(s.Modules)
+	s.TLSContexts = withoutAnnotationResources(s.TLSContexts)
+	s.AuthServices = withoutAnnotationResources(s.AuthServices)
+	s.RateLimitServices = withoutAnnotationResources(s.RateLimitServices)
+	s.LogServices = withoutAnnotationResources(s.LogServices)
+	s.TracingServices = withoutAnnotationResources(s.TracingServices)
+	s.DevPortals = withoutAnnotationResources(s.DevPortals)
+	s.ConsulResolvers = withoutAnnotationResources(s.ConsulResolvers)
+	s.DNSResolvers = withoutAnnotationResources(s.DNSResolvers)
+	s.StaticEndpointResolvers = withoutAnnotationResources(s.StaticEndpointResolvers)
+	s.KubernetesEndpointResolvers = withoutAnnotationResources(s.KubernetesEndpointResolvers)
+	s.KubernetesServiceResolvers = withoutAnnotationResources(s.KubernetesServiceResolvers)
+}
+
+func withoutAnnotationResources[T kates.Object](objs []T) []T {
+	ret := objs[:0:0]
+	for _, obj := range objs {
+		if AnnotationOrigin(obj) == "" {
+			ret = append(ret, obj)
+		}
+	}
+	return ret
+}
+
 // ValidateAndConvertObject validates an apiGroup=getambassador.io resource, and converts it to the
 // preferred version.
 //
@@ -138,7 +263,7 @@ func convertAnnotationObject(srcUnstruct *kates.Unstructured) (kates.Object, err
 // You should probably not be calling this directly; the only reason it's public is for use by
 // tests.
 func ParseAnnotationResources(resource kates.Object) ([]*kates.Unstructured, error) {
-	annotationStr, annotationStrOK := resource.GetAnnotations()["getambassador.io/config"]
+	annotationStr, annotationStrOK := resource.GetAnnotations()[AnnotationConfigKey]
 	if !annotationStrOK {
 		return nil, nil
 	}
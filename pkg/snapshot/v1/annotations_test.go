@@ -137,52 +137,100 @@ prefix: /blah/`
 		},
 	}
 
+	deploymentAnnotation := `
+---
+apiVersion: getambassador.io/v3alpha1
+kind: Mapping
+name: deployment-mapping
+prefix: /deploy/
+service: deploy:80`
+
+	deployment := &kates.Deployment{
+		TypeMeta: metav1.TypeMeta{
+			Kind: "Deployment",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "deploy",
+			Namespace: "somens",
+			Annotations: map[string]string{
+				"getambassador.io/config": deploymentAnnotation,
+			},
+		},
+	}
+
+	crdMapping := &amb.Mapping{
+		TypeMeta: metav1.TypeMeta{
+			Kind:       "Mapping",
+			APIVersion: "getambassador.io/v3alpha1",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "crd-mapping",
+			Namespace: "ambassador",
+		},
+		Spec: amb.MappingSpec{
+			Prefix:  "/crd/",
+			Service: "crd:80",
+		},
+	}
+
 	ks := &snapshotTypes.KubernetesSnapshot{
-		Services:  []*kates.Service{svc, ambSvc, svcWithEmptyAnnotation, svcWithMissingAnnotation},
-		Ingresses: []*snapshotTypes.Ingress{{Ingress: *ingress}},
-		Hosts:     []*amb.Host{ignoredHost},
+		Services:    []*kates.Service{svc, ambSvc, svcWithEmptyAnnotation, svcWithMissingAnnotation},
+		Ingresses:   []*snapshotTypes.Ingress{{Ingress: *ingress}},
+		Deployments: []*kates.Deployment{deployment},
+		Hosts:       []*amb.Host{ignoredHost},
+		Mappings:    []*amb.Mapping{crdMapping},
 	}
 
 	ctx := dlog.NewTestContext(t, false)
 
-	err := ks.PopulateAnnotations(ctx)
-	assert.NoError(t, err)
-	assert.Equal(t, len(ks.Services), 4)
-	assert.Equal(t, map[string]snapshotTypes.AnnotationList{
-		"Service/svc.ambassador": {
-			&amb.Mapping{
-				TypeMeta: metav1.TypeMeta{
-					Kind:       "Mapping",
-					APIVersion: "getambassador.io/v3alpha1",
-				},
-				ObjectMeta: metav1.ObjectMeta{
-					Name:      "quote-backend",
-					Namespace: "ambassador",
-				},
-				Spec: amb.MappingSpec{
-					Prefix:  "/backend/",
-					Service: "quote:80",
+	expectedMappings := []*amb.Mapping{
+		crdMapping,
+		{
+			TypeMeta: metav1.TypeMeta{
+				Kind:       "Mapping",
+				APIVersion: "getambassador.io/v3alpha1",
+			},
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "quote-backend",
+				Namespace: "ambassador",
+				Annotations: map[string]string{
+					snapshotTypes.AnnotationOriginKey: "Service/svc.ambassador",
 				},
 			},
+			Spec: amb.MappingSpec{
+				Prefix:  "/backend/",
+				Service: "quote:80",
+			},
 		},
-		"Ingress/ingress.somens": {
-			&kates.Unstructured{
-				Object: map[string]interface{}{
-					"apiVersion": "getambassador.io/v3alpha1",
-					"kind":       "Mapping",
-					"metadata": map[string]interface{}{
-						"name":      "cool-mapping",
-						"namespace": "somens",
-					},
-					"spec": map[string]interface{}{
-						"prefix": "/blah/",
-					},
-					"errors": "spec.service in body is required",
+		{
+			TypeMeta: metav1.TypeMeta{
+				Kind:       "Mapping",
+				APIVersion: "getambassador.io/v3alpha1",
+			},
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "deployment-mapping",
+				Namespace: "somens",
+				Annotations: map[string]string{
+					snapshotTypes.AnnotationOriginKey: "Deployment/deploy.somens",
 				},
 			},
+			Spec: amb.MappingSpec{
+				Prefix:  "/deploy/",
+				Service: "deploy:80",
+			},
 		},
-		"Service/ambassador.ambassador": {
-			&amb.Module{
+	}
+
+	// Populating twice must give the same result as populating once, since the snapshot's typed
+	// slices are reused from one update to the next.
+	for i := 0; i < 2; i++ {
+		err := ks.PopulateAnnotations(ctx)
+		assert.NoError(t, err)
+		assert.Equal(t, len(ks.Services), 4)
+		assert.Equal(t, []*amb.Host{ignoredHost}, ks.Hosts)
+		assert.Equal(t, expectedMappings, ks.Mappings)
+		assert.Equal(t, []*amb.Module{
+			{
 				TypeMeta: metav1.TypeMeta{
 					Kind:       "Module",
 					APIVersion: "getambassador.io/v3alpha1",
@@ -190,12 +238,17 @@ prefix: /blah/`
 				ObjectMeta: metav1.ObjectMeta{
 					Name:      "ambassador",
 					Namespace: "ambassador",
+					Annotations: map[string]string{
+						snapshotTypes.AnnotationOriginKey: "Service/ambassador.ambassador",
+					},
 				},
 				Spec: amb.ModuleSpec{
 					Config: getModuleSpec(t, `{"diagnostics":{"enabled":true}}`),
 				},
 			},
-			&amb.KubernetesEndpointResolver{
+		}, ks.Modules)
+		assert.Equal(t, []*amb.KubernetesEndpointResolver{
+			{
 				TypeMeta: metav1.TypeMeta{
 					Kind:       "KubernetesEndpointResolver",
 					APIVersion: "getambassador.io/v3alpha1",
@@ -203,10 +256,68 @@ prefix: /blah/`
 				ObjectMeta: metav1.ObjectMeta{
 					Name:      "endpoint",
 					Namespace: "ambassador",
+					Annotations: map[string]string{
+						snapshotTypes.AnnotationOriginKey: "Service/ambassador.ambassador",
+					},
 				},
 			},
-		},
-	}, ks.Annotations)
+		}, ks.KubernetesEndpointResolvers)
+		assert.Equal(t, []*kates.Unstructured{
+			{
+				Object: map[string]interface{}{
+					"apiVersion": "getambassador.io/v3alpha1",
+					"kind":       "Mapping",
+					"metadata": map[string]interface{}{
+						"name":      "cool-mapping",
+						"namespace": "somens",
+						"annotations": map[string]interface{}{
+							snapshotTypes.AnnotationOriginKey: "Ingress/ingress.somens",
+						},
+					},
+					"spec": map[string]interface{}{
+						"prefix": "/blah/",
+					},
+					"errors": "spec.service in body is required",
+				},
+			},
+		}, ks.InvalidAnnotations)
+	}
+}
+
+func TestParseAnnotationsPodReplicas(t *testing.T) {
+	pod := func(name, prefix string) *kates.Pod {
+		return &kates.Pod{
+			TypeMeta: metav1.TypeMeta{
+				Kind: "Pod",
+			},
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      name,
+				Namespace: "somens",
+				Annotations: map[string]string{
+					"getambassador.io/config": `
+---
+apiVersion: getambassador.io/v3alpha1
+kind: Mapping
+name: pod-mapping
+prefix: ` + prefix + `
+service: pod:80`,
+				},
+			},
+		}
+	}
+
+	// Mid-rollout, the replicas needn't all agree.
+	ks := &snapshotTypes.KubernetesSnapshot{
+		Pods: []*kates.Pod{pod("web-c", "/new/"), pod("web-a", "/old/"), pod("web-b", "/old/")},
+	}
+
+	ctx := dlog.NewTestContext(t, false)
+	require.NoError(t, ks.PopulateAnnotations(ctx))
+	require.Len(t, ks.Mappings, 1)
+	assert.Equal(t, "pod-mapping", ks.Mappings[0].GetName())
+	assert.Equal(t, "/old/", ks.Mappings[0].Spec.Prefix)
+	assert.Equal(t, "Pod/web-a.somens", snapshotTypes.AnnotationOrigin(ks.Mappings[0]))
+	assert.Empty(t, ks.InvalidAnnotations)
 }
 
 func TestConvertAnnotation(t *testing.T) {
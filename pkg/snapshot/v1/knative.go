@@ -0,0 +1,123 @@
+package snapshot
+
+import (
+	"github.com/emissary-ingress/emissary/v3/pkg/kates"
+)
+
+// The KNative* types mirror the parts of Knative Serving's
+// networking.internal.knative.dev/v1alpha1 Ingress that Ambassador uses. We don't import
+// knative.dev/networking for these: it would drag in most of Knative to get at a handful of
+// structs.
+
+const (
+	// KNativeIngressClassAnnotation is the annotation that Knative uses to say which ingress
+	// implementation should handle an Ingress.
+	KNativeIngressClassAnnotation = "networking.knative.dev/ingress.class"
+	// KNativeIngressClassAnnotationAlt is the spelling of KNativeIngressClassAnnotation used by
+	// newer versions of Knative.
+	KNativeIngressClassAnnotationAlt = "networking.knative.dev/ingress-class"
+	// KNativeIngressClass is the ingress class that selects Ambassador.
+	KNativeIngressClass = "ambassador.ingress.networking.knative.dev"
+)
+
+type KNativeIngress struct {
+	kates.TypeMeta   `json:",inline"`
+	kates.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   KNativeIngressSpec   `json:"spec,omitempty"`
+	Status KNativeIngressStatus `json:"status,omitempty"`
+}
+
+type KNativeIngressSpec struct {
+	TLS        []KNativeIngressTLS  `json:"tls,omitempty"`
+	Rules      []KNativeIngressRule `json:"rules,omitempty"`
+	HTTPOption string               `json:"httpOption,omitempty"`
+	// Visibility was moved in to the individual rules in Knative Serving 0.8.0.
+	Visibility string `json:"visibility,omitempty"`
+}
+
+type KNativeIngressTLS struct {
+	Hosts           []string `json:"hosts,omitempty"`
+	SecretName      string   `json:"secretName,omitempty"`
+	SecretNamespace string   `json:"secretNamespace,omitempty"`
+}
+
+type KNativeIngressRule struct {
+	Hosts      []string                     `json:"hosts,omitempty"`
+	Visibility string                       `json:"visibility,omitempty"`
+	HTTP       *KNativeHTTPIngressRuleValue `json:"http,omitempty"`
+}
+
+type KNativeHTTPIngressRuleValue struct {
+	Paths []KNativeHTTPIngressPath `json:"paths"`
+}
+
+type KNativeHTTPIngressPath struct {
+	Path          string                        `json:"path,omitempty"`
+	RewriteHost   string                        `json:"rewriteHost,omitempty"`
+	Headers       map[string]KNativeHeaderMatch `json:"headers,omitempty"`
+	Splits        []KNativeIngressBackendSplit  `json:"splits"`
+	AppendHeaders map[string]string             `json:"appendHeaders,omitempty"`
+	// Timeout and Retries were dropped in Knative Serving 0.25.0, but older versions still send
+	// them, and we still honor Timeout.
+	Timeout *kates.Duration   `json:"timeout,omitempty"`
+	Retries *KNativeHTTPRetry `json:"retries,omitempty"`
+}
+
+type KNativeHeaderMatch struct {
+	Exact string `json:"exact,omitempty"`
+}
+
+type KNativeHTTPRetry struct {
+	Attempts      int             `json:"attempts"`
+	PerTryTimeout *kates.Duration `json:"perTryTimeout,omitempty"`
+}
+
+type KNativeIngressBackendSplit struct {
+	ServiceNamespace string            `json:"serviceNamespace"`
+	ServiceName      string            `json:"serviceName"`
+	ServicePort      kates.IntOrString `json:"servicePort"`
+	Percent          int               `json:"percent,omitempty"`
+	AppendHeaders    map[string]string `json:"appendHeaders,omitempty"`
+}
+
+type KNativeIngressStatus struct {
+	ObservedGeneration int64              `json:"observedGeneration,omitempty"`
+	Conditions         []KNativeCondition `json:"conditions,omitempty"`
+	Annotations        map[string]string  `json:"annotations,omitempty"`
+
+	// LoadBalancer is the only load balancer that Knative Serving before 0.8.0 looks at;
+	// everything newer uses PublicLoadBalancer and PrivateLoadBalancer.
+	LoadBalancer        *KNativeLoadBalancerStatus `json:"loadBalancer,omitempty"`
+	PublicLoadBalancer  *KNativeLoadBalancerStatus `json:"publicLoadBalancer,omitempty"`
+	PrivateLoadBalancer *KNativeLoadBalancerStatus `json:"privateLoadBalancer,omitempty"`
+}
+
+type KNativeCondition struct {
+	Type               string     `json:"type"`
+	Status             string     `json:"status"`
+	Severity           string     `json:"severity,omitempty"`
+	LastTransitionTime kates.Time `json:"lastTransitionTime,omitempty"`
+	Reason             string     `json:"reason,omitempty"`
+	Message            string     `json:"message,omitempty"`
+}
+
+type KNativeLoadBalancerStatus struct {
+	Ingress []KNativeLoadBalancerIngressStatus `json:"ingress,omitempty"`
+}
+
+type KNativeLoadBalancerIngressStatus struct {
+	IP             string `json:"ip,omitempty"`
+	Domain         string `json:"domain,omitempty"`
+	DomainInternal string `json:"domainInternal,omitempty"`
+	MeshOnly       bool   `json:"meshOnly,omitempty"`
+}
+
+// IngressClass returns the Knative ingress class of the Ingress, or the empty string if it
+// doesn't specify one.
+func (ing *KNativeIngress) IngressClass() string {
+	if class, ok := ing.GetAnnotations()[KNativeIngressClassAnnotation]; ok {
+		return class
+	}
+	return ing.GetAnnotations()[KNativeIngressClassAnnotationAlt]
+}
@@ -9,9 +9,9 @@ import (
 // anything that's not object metadata from Invalid objects. (since we couldn't parse the things in
 // "invalid", we actually don't know what they are so they could contain secrets.)
 //
-// TODO:(@acookin) Could also remove server generated bits from here, e.g. the last applied configuration
-// annotation that the kube server applies. The benefit of that would be to reduce bits sent across
-// the wire.
+// Server generated bits, e.g. managedFields and the last applied configuration annotation that the
+// kube server applies, never get this far: the entrypoint strips them as resources come in from the
+// apiserver.
 func (s *Snapshot) Sanitize() error {
 	var err error
 	if s.Kubernetes != nil {
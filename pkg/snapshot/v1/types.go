@@ -78,6 +78,8 @@ type KubernetesSnapshot struct {
 
 	// resolvers
 	ConsulResolvers             []*amb.ConsulResolver             `json:"ConsulResolver"`
+	DNSResolvers                []*amb.DNSResolver                `json:"DNSResolver"`
+	StaticEndpointResolvers     []*amb.StaticEndpointResolver     `json:"StaticEndpointResolver"`
 	KubernetesEndpointResolvers []*amb.KubernetesEndpointResolver `json:"KubernetesEndpointResolver"`
 	KubernetesServiceResolvers  []*amb.KubernetesServiceResolver  `json:"KubernetesServiceResolver"`
 
@@ -90,24 +92,26 @@ type KubernetesSnapshot struct {
 	// the operator.
 
 	KNativeClusterIngresses []*kates.Unstructured `json:"clusteringresses.networking.internal.knative.dev,omitempty"`
-	KNativeIngresses        []*kates.Unstructured `json:"ingresses.networking.internal.knative.dev,omitempty"`
+	KNativeIngresses        []*KNativeIngress     `json:"ingresses.networking.internal.knative.dev,omitempty"`
 
 	FilterPolicies []*kates.Unstructured `json:"filterpolicies.v3alpha1.getambassador.io,omitempty"`
 	Filters        []*kates.Unstructured `json:"filters.v3alpha1.getambassador.io,omitempty"`
 
-	K8sSecrets []*kates.Secret             `json:"-"`      // Secrets from Kubernetes
+	K8sSecrets []*kates.Secret             `json:"-"`      // Secrets from Kubernetes (metadata only)
 	FSSecrets  map[SecretRef]*kates.Secret `json:"-"`      // Secrets from the filesystem
 	Secrets    []*kates.Secret             `json:"secret"` // Secrets we'll feed to Ambassador
 
 	ConfigMaps []*kates.ConfigMap `json:"ConfigMaps,omitempty"`
 
-	// [kind/name.namespace][]kates.Object
-	Annotations map[string]AnnotationList `json:"annotations"`
+	// Resources from getambassador.io/config annotations that failed validation. (The valid ones
+	// are in the typed fields above, along with the CRDs; see PopulateAnnotations.)
+	InvalidAnnotations []*kates.Unstructured `json:"-"`
 
-	// Pods and Deployments were added to be used by Ambassador Agent so it can
-	// report to AgentCom in Ambassador Cloud.
-	Pods        []*kates.Pod        `json:"Pods,omitempty"`
-	Deployments []*kates.Deployment `json:"Deployments,omitempty"`
+	// Pods and Deployments (metadata only) are only watched for their getambassador.io/config
+	// annotations, which PopulateAnnotations unfolds into the fields above, so they aren't sent
+	// along themselves.
+	Pods        []*kates.Pod        `json:"-"`
+	Deployments []*kates.Deployment `json:"-"`
 
 	// ArgoRollouts represents the argo-rollout CRD state of the world that may or may not be present
 	// in the client's cluster. For this reason, Rollouts resources are fetched making use of the
@@ -124,45 +128,6 @@ type KubernetesSnapshot struct {
 	ArgoApplications []*kates.Unstructured `json:"ArgoApplications,omitempty"`
 }
 
-// AnnotationList is a []kates.Object that round-trips through JSON (kates.Object is an interface,
-// and you can't normally unmarshal in to an interface).
-//
-// The kates.Object will be the appropriate struct(-pointer) type for valid resources, and a
-// *kates.Unstructured for invalid resources.
-type AnnotationList []kates.Object
-
-// UnmarshalJSON implements json.Unmarshaler, and exists because unmarshalling directly in to an
-// interface (kates.Object) doesn't work.
-func (al *AnnotationList) UnmarshalJSON(bs []byte) error {
-	if string(bs) == "null" {
-		*al = AnnotationList{}
-		return nil
-	}
-	var untyped []*kates.Unstructured
-
-	// Unmarshal as unstructured
-	err := json.Unmarshal(bs, &untyped)
-	if err != nil {
-		return err
-	}
-
-	typed := make(AnnotationList, len(untyped))
-	for i, inObj := range untyped {
-		if _, isInvalid := inObj.Object["errors"]; isInvalid {
-			typed[i] = inObj
-		} else {
-			outObj, err := convertAnnotationObject(inObj)
-			if err != nil {
-				return err
-			}
-			typed[i] = outObj
-		}
-	}
-
-	*al = typed
-	return nil
-}
-
 // The APIDoc type is custom object built in the style of a Kubernetes resource (name, type, version)
 // which holds a reference to a Kubernetes object from which an OpenAPI document was scrapped (Data field)
 type APIDoc struct {
@@ -0,0 +1,199 @@
+// Package tracing sets up OpenTelemetry tracing for the reconfiguration pipeline, and has the
+// helpers that the stages of the pipeline use to make their spans:
+//
+//	kates → SnapshotHolder.K8sUpdate → SnapshotHolder.Notify → diagd webhook
+//	      → ambex update → ambex Updater → SetSnapshot
+//
+// The watcher and ambex stages don't share a call chain (the watcher hands off to diagd, and diagd
+// hands off to ambex), so the watcher leaves its span context with a Baton in the shared context
+// and ambex picks it up from there. That way one trace covers one reconfiguration from end to end.
+//
+// By default nothing is exported and every span is a no-op.
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.7.0"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/datawire/dlib/dlog"
+	"github.com/emissary-ingress/emissary/v3/pkg/kates"
+)
+
+const instrumentationName = "github.com/emissary-ingress/emissary/v3"
+
+// Attribute keys for the things that spans in the pipeline carry.
+const (
+	DeltaCountKey      = attribute.Key("ambassador.delta.count")
+	DeltaKindsKey      = attribute.Key("ambassador.delta.kinds")
+	DeltaNamesKey      = attribute.Key("ambassador.delta.names")
+	AmbexGenerationKey = attribute.Key("ambassador.ambex.generation")
+	AmbexVersionKey    = attribute.Key("ambassador.ambex.version")
+)
+
+// maxDeltaNames bounds how many delta names go on a span: a resync can touch thousands of objects,
+// and nobody wants to read a span that big.
+const maxDeltaNames = 50
+
+// Setup configures the global tracer provider and propagator. The exporter is one of:
+//
+//	"" or "none"   don't export anything (the default)
+//	"otlp"         export over OTLP/HTTP; configure it with the standard OTEL_EXPORTER_OTLP_*
+//	               environment variables (e.g. OTEL_EXPORTER_OTLP_ENDPOINT)
+//
+// The returned function flushes and shuts down the exporter.
+func Setup(ctx context.Context, exporter, serviceName, version string) (func(context.Context) error, error) {
+	// Always propagate W3C trace context, so that a trace started upstream of us (or by us) can
+	// be continued by whoever we call.
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+
+	switch strings.ToLower(strings.TrimSpace(exporter)) {
+	case "", "none":
+		return func(context.Context) error { return nil }, nil
+	case "otlp":
+	default:
+		return nil, fmt.Errorf("unknown tracing exporter %q (expected \"none\" or \"otlp\")", exporter)
+	}
+
+	exp, err := otlptracehttp.New(ctx)
+	if err != nil {
+		return nil, err
+	}
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exp),
+		sdktrace.WithResource(resource.NewWithAttributes(semconv.SchemaURL,
+			semconv.ServiceNameKey.String(serviceName),
+			semconv.ServiceVersionKey.String(version),
+		)),
+	)
+	otel.SetTracerProvider(provider)
+	dlog.Infof(ctx, "tracing: exporting spans over OTLP")
+
+	return provider.Shutdown, nil
+}
+
+// Start starts a span for a stage of the pipeline.
+func Start(ctx context.Context, name string, opts ...trace.SpanStartOption) (context.Context, trace.Span) {
+	return otel.Tracer(instrumentationName).Start(ctx, name, opts...)
+}
+
+// StartLinked starts a span for a stage of the pipeline that batches up the work of several
+// earlier spans: the first of them (that's actually being traced) is its parent, and the rest
+// are linked to it.
+func StartLinked(ctx context.Context, name string, parents []trace.SpanContext, opts ...trace.SpanStartOption) (context.Context, trace.Span) {
+	parented := trace.SpanContextFromContext(ctx).IsValid()
+	var links []trace.Link
+	for _, sc := range parents {
+		switch {
+		case !sc.IsValid():
+		case !parented:
+			ctx = trace.ContextWithRemoteSpanContext(ctx, sc)
+			parented = true
+		default:
+			links = append(links, trace.Link{SpanContext: sc})
+		}
+	}
+	if len(links) > 0 {
+		opts = append(opts, trace.WithLinks(links...))
+	}
+	return Start(ctx, name, opts...)
+}
+
+// DeltaAttributes describes the deltas that triggered a stage of the pipeline: how many there
+// were, which kinds of resources they were for, and (up to a point) which resources.
+func DeltaAttributes(deltas []*kates.Delta) []attribute.KeyValue {
+	if len(deltas) == 0 {
+		return nil
+	}
+
+	kindSet := map[string]struct{}{}
+	names := make([]string, 0, len(deltas))
+	for _, delta := range deltas {
+		kindSet[delta.Kind] = struct{}{}
+		if len(names) < maxDeltaNames {
+			names = append(names, fmt.Sprintf("%s %s/%s", delta.Kind, delta.Namespace, delta.Name))
+		}
+	}
+	kinds := make([]string, 0, len(kindSet))
+	for kind := range kindSet {
+		kinds = append(kinds, kind)
+	}
+	sort.Strings(kinds)
+
+	return []attribute.KeyValue{
+		DeltaCountKey.Int(len(deltas)),
+		DeltaKindsKey.StringSlice(kinds),
+		DeltaNamesKey.StringSlice(names),
+	}
+}
+
+// InjectHTTP adds the trace context in ctx to the headers of an outgoing request.
+func InjectHTTP(ctx context.Context, header http.Header) {
+	otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(header))
+}
+
+// A Baton carries a span context, and the attributes describing what triggered it, from one stage
+// of the pipeline to a later one that doesn't share its call chain. The zero value is ready to use,
+// and a nil *Baton carries nothing.
+type Baton struct {
+	mu    sync.Mutex
+	sc    trace.SpanContext
+	attrs []attribute.KeyValue
+}
+
+// Pass leaves the span context in ctx with the baton, along with the supplied attributes,
+// replacing whatever was there.
+func (b *Baton) Pass(ctx context.Context, attrs ...attribute.KeyValue) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.sc = trace.SpanContextFromContext(ctx)
+	b.attrs = attrs
+}
+
+// Take returns ctx with the span context that was last passed to the baton as its parent,
+// along with the attributes that came with it. Both are cleared, so that each hand-off is only
+// picked up once.
+func (b *Baton) Take(ctx context.Context) (context.Context, []attribute.KeyValue) {
+	if b == nil {
+		return ctx, nil
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	sc, attrs := b.sc, b.attrs
+	b.sc, b.attrs = trace.SpanContext{}, nil
+	if !sc.IsValid() {
+		return ctx, attrs
+	}
+	return trace.ContextWithRemoteSpanContext(ctx, sc), attrs
+}
+
+// batonKey is the context key for the Baton. It's a type of its own, since pointers to distinct
+// zero-size variables (like &struct{}{}) may be equal, and so would collide with other packages'
+// keys.
+type batonKey struct{}
+
+// WithBaton returns a child context that carries the supplied Baton.
+func WithBaton(parent context.Context, baton *Baton) context.Context {
+	return context.WithValue(parent, batonKey{}, baton)
+}
+
+// BatonFromContext returns the Baton carried by ctx, or nil if there isn't one.
+func BatonFromContext(ctx context.Context) *Baton {
+	baton, _ := ctx.Value(batonKey{}).(*Baton)
+	return baton
+}
@@ -0,0 +1,160 @@
+package tracing
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/emissary-ingress/emissary/v3/pkg/debug"
+	"github.com/emissary-ingress/emissary/v3/pkg/kates"
+)
+
+func setupRecorder(t *testing.T) *tracetest.SpanRecorder {
+	t.Helper()
+	recorder := tracetest.NewSpanRecorder()
+	prev := otel.GetTracerProvider()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	t.Cleanup(func() { otel.SetTracerProvider(prev) })
+	return recorder
+}
+
+func TestSetup(t *testing.T) {
+	ctx := context.Background()
+
+	shutdown, err := Setup(ctx, "", "test", "v0")
+	require.NoError(t, err)
+	assert.NoError(t, shutdown(ctx))
+
+	shutdown, err = Setup(ctx, " None ", "test", "v0")
+	require.NoError(t, err)
+	assert.NoError(t, shutdown(ctx))
+
+	_, err = Setup(ctx, "zipkin", "test", "v0")
+	assert.Error(t, err)
+}
+
+func TestDeltaAttributes(t *testing.T) {
+	assert.Nil(t, DeltaAttributes(nil))
+
+	var deltas []*kates.Delta
+	for _, name := range []string{"a", "b"} {
+		deltas = append(deltas, &kates.Delta{
+			TypeMeta:   kates.TypeMeta{Kind: "Mapping"},
+			ObjectMeta: kates.ObjectMeta{Namespace: "default", Name: name},
+		})
+	}
+	deltas = append(deltas, &kates.Delta{
+		TypeMeta:   kates.TypeMeta{Kind: "Host"},
+		ObjectMeta: kates.ObjectMeta{Namespace: "default", Name: "example"},
+	})
+	for i := 0; i < maxDeltaNames; i++ {
+		deltas = append(deltas, &kates.Delta{TypeMeta: kates.TypeMeta{Kind: "Endpoints"}})
+	}
+
+	attrs := attribute.NewSet(DeltaAttributes(deltas)...)
+	count, _ := attrs.Value(DeltaCountKey)
+	assert.Equal(t, int64(maxDeltaNames+3), count.AsInt64())
+	kinds, _ := attrs.Value(DeltaKindsKey)
+	assert.Equal(t, []string{"Endpoints", "Host", "Mapping"}, kinds.AsStringSlice())
+	names, _ := attrs.Value(DeltaNamesKey)
+	require.Len(t, names.AsStringSlice(), maxDeltaNames)
+	assert.Equal(t, []string{"Mapping default/a", "Mapping default/b", "Host default/example"}, names.AsStringSlice()[:3])
+}
+
+func TestStartLinked(t *testing.T) {
+	recorder := setupRecorder(t)
+	ctx := context.Background()
+
+	_, first := Start(ctx, "first")
+	first.End()
+	_, second := Start(ctx, "second")
+	second.End()
+
+	_, span := StartLinked(ctx, "linked", []trace.SpanContext{{}, first.SpanContext(), second.SpanContext()})
+	span.End()
+
+	spans := recorder.Ended()
+	require.Len(t, spans, 3)
+	linked := spans[2]
+	assert.Equal(t, first.SpanContext().TraceID(), linked.SpanContext().TraceID())
+	assert.Equal(t, first.SpanContext().SpanID(), linked.Parent().SpanID())
+	require.Len(t, linked.Links(), 1)
+	assert.Equal(t, second.SpanContext(), linked.Links()[0].SpanContext)
+
+	// Nothing to link to just makes a new trace.
+	_, span = StartLinked(ctx, "unlinked", nil)
+	span.End()
+	assert.False(t, recorder.Ended()[3].Parent().IsValid())
+}
+
+func TestBaton(t *testing.T) {
+	recorder := setupRecorder(t)
+	ctx := WithBaton(context.Background(), &Baton{})
+	baton := BatonFromContext(ctx)
+	require.NotNil(t, baton)
+
+	// Taking from an empty baton leaves the context alone.
+	taken, attrs := baton.Take(ctx)
+	assert.Equal(t, ctx, taken)
+	assert.Nil(t, attrs)
+
+	sctx, sender := Start(ctx, "sender")
+	baton.Pass(sctx, DeltaCountKey.Int(1))
+	sender.End()
+
+	taken, attrs = baton.Take(context.Background())
+	assert.Equal(t, []attribute.KeyValue{DeltaCountKey.Int(1)}, attrs)
+	_, receiver := Start(taken, "receiver")
+	receiver.End()
+
+	spans := recorder.Ended()
+	require.Len(t, spans, 2)
+	assert.Equal(t, spans[0].SpanContext().TraceID(), spans[1].SpanContext().TraceID())
+	assert.Equal(t, spans[0].SpanContext().SpanID(), spans[1].Parent().SpanID())
+
+	// Each hand-off is only picked up once.
+	_, attrs = baton.Take(ctx)
+	assert.Nil(t, attrs)
+
+	// And a missing baton is harmless.
+	var nilBaton *Baton
+	assert.Nil(t, BatonFromContext(context.Background()))
+	nilBaton.Pass(sctx)
+	taken, _ = nilBaton.Take(ctx)
+	assert.Equal(t, ctx, taken)
+}
+
+func TestBatonWithDebug(t *testing.T) {
+	// The baton and the debug root are both carried in the context, and neither may clobber the
+	// other, whichever order they're added in.
+	baton := &Baton{}
+	dbg := debug.NewDebug()
+	for _, ctx := range []context.Context{
+		debug.NewContext(WithBaton(context.Background(), baton), dbg),
+		WithBaton(debug.NewContext(context.Background(), dbg), baton),
+	} {
+		assert.Same(t, baton, BatonFromContext(ctx))
+		assert.Same(t, dbg, debug.FromContext(ctx))
+	}
+}
+
+func TestInjectHTTP(t *testing.T) {
+	setupRecorder(t)
+	_, err := Setup(context.Background(), "none", "test", "v0")
+	require.NoError(t, err)
+
+	ctx, span := Start(context.Background(), "request")
+	defer span.End()
+
+	header := http.Header{}
+	InjectHTTP(ctx, header)
+	assert.Contains(t, header.Get("traceparent"), span.SpanContext().TraceID().String())
+}
@@ -7,6 +7,8 @@ busyprograms=(
     watt
     agent
     apiext
+    envoy-metrics-sink
+    access-log-server
 )
 sudo install -D -t /opt/ambassador/bin/ /buildroot/bin/busyambassador
 for busyprogram in "${busyprograms[@]}"; do
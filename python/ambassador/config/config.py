@@ -57,12 +57,14 @@ class Config:
     StorageByKind: ClassVar[Dict[str, str]] = {
         "authservice": "auth_configs",
         "consulresolver": "resolvers",
+        "dnsresolver": "resolvers",
         "host": "hosts",
         "listener": "listeners",
         "mapping": "mappings",
         "kubernetesendpointresolver": "resolvers",
         "kubernetesserviceresolver": "resolvers",
         "ratelimitservice": "ratelimit_configs",
+        "staticendpointresolver": "resolvers",
         "devportal": "devportals",
         "tcpmapping": "tcpmappings",
         "tlscontext": "tls_contexts",
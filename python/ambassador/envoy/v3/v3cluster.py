@@ -12,11 +12,13 @@
 # See the License for the specific language governing permissions and
 # limitations under the License
 
+import copy
 import urllib
-from typing import TYPE_CHECKING, Dict, List, Union
+from typing import TYPE_CHECKING, Any, Dict, List, Union
 
 from ...cache import Cacheable
 from ...ir.ircluster import IRCluster
+from .v3origin import v3origin_metadata
 from .v3tls import V3TLSContext
 
 if TYPE_CHECKING:
@@ -273,6 +275,18 @@ class V3Cluster(Cacheable):
         config.clusters = []
         config.clustermap = {}
 
+        # Which Mappings refer to each cluster? We record them in the cluster's metadata
+        # so that it can be traced back later. Since this can change without the cluster
+        # itself changing, we do it for cached clusters too.
+        cluster_users: Dict[str, List[Any]] = {}
+
+        for group in config.ir.groups.values():
+            for mapping in list(group.get("mappings", [])) + list(group.get("shadows", [])):
+                ircluster = mapping.get("cluster", None)
+
+                if ircluster and getattr(ircluster, "envoy_name", None):
+                    cluster_users.setdefault(ircluster.envoy_name, []).append(mapping)
+
         # Sort by the envoy cluster name (x.envoy_name), not the symbolic IR cluster name (x.name)
         for ircluster in sorted(config.ir.clusters.values(), key=lambda x: x.envoy_name):
             # XXX This magic format is duplicated for now in ir.py.
@@ -301,5 +315,17 @@ class V3Cluster(Cacheable):
                 assert isinstance(cached_cluster, V3Cluster)
                 cluster = cached_cluster
 
+            # The cluster is in the cache, and might be used again by a later
+            # reconfiguration with different Mappings, so don't change it in place.
+            metadata = v3origin_metadata(cluster_users.get(ircluster.envoy_name, []))
+
+            if cluster.get("metadata") != metadata:
+                cluster = copy.copy(cluster)
+
+                if metadata:
+                    cluster["metadata"] = metadata
+                else:
+                    cluster.pop("metadata", None)
+
             config.clusters.append(cluster)
             config.clustermap[ircluster.envoy_name] = ircluster.clustermap_entry()
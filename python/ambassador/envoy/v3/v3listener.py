@@ -19,6 +19,7 @@ from ...ir.irhost import IRHost
 from ...ir.irlistener import IRListener
 from ...ir.irtcpmappinggroup import IRTCPMappingGroup
 from ...utils import parse_bool
+from .v3origin import v3origin_metadata
 from .v3route import DictifiedV3Route, V3Route, V3RouteVariants, hostglob_matches, v3prettyroute
 from .v3tls import V3TLSContext
 
@@ -1194,6 +1195,11 @@ class V3Listener:
         if self.listener_filters:
             listener["listener_filters"] = self.listener_filters
 
+        metadata = v3origin_metadata([self._irlistener])
+
+        if metadata:
+            listener["metadata"] = metadata
+
         return listener
 
     def __str__(self) -> str:
@@ -0,0 +1,60 @@
+from typing import Any, Dict, Iterable, List, Optional
+
+# This is the filter_metadata namespace under which we record which Kubernetes
+# resources an Envoy route, cluster, or listener came from. ambex reads it back out
+# (see pkg/origin/origin.go) to explain the Envoy configuration, so keep the two in sync.
+ORIGIN_METADATA_KEY = "getambassador.io"
+
+
+def v3origin(resource: Any) -> Optional[Dict[str, Any]]:
+    """
+    Return the origin of an IR resource -- its kind, namespace, name, and generation --
+    or None if it wasn't made from a Kubernetes resource (e.g. it was synthesized by
+    the IR, in which case its kind is one of our internal "IRWhatever" kinds).
+    """
+
+    if not resource:
+        return None
+
+    kind = resource.get("kind", None)
+
+    if not kind or kind.startswith("IR") or kind.startswith("ir."):
+        return None
+
+    if resource.get("location", None) == "--internal--":
+        return None
+
+    origin: Dict[str, Any] = {
+        "kind": kind,
+        "namespace": resource.get("namespace", None) or "",
+        "name": resource.get("name", None) or "",
+    }
+
+    generation = resource.get("generation", None)
+
+    if generation is not None:
+        origin["generation"] = int(generation)
+
+    return origin
+
+
+def v3origin_metadata(resources: Iterable[Any]) -> Optional[Dict[str, Any]]:
+    """
+    Return the Envoy metadata recording the origins of the given IR resources, or
+    None if none of them has an origin.
+    """
+
+    origins: List[Dict[str, Any]] = []
+
+    for resource in resources:
+        origin = v3origin(resource)
+
+        if origin and (origin not in origins):
+            origins.append(origin)
+
+    if not origins:
+        return None
+
+    origins.sort(key=lambda o: (o["kind"], o["namespace"], o["name"]))
+
+    return {"filter_metadata": {ORIGIN_METADATA_KEY: {"origins": origins}}}
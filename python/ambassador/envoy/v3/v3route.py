@@ -20,6 +20,7 @@ from ...ir.irbasemapping import IRBaseMapping
 from ...ir.irhttpmappinggroup import IRHTTPMappingGroup
 from ...ir.irutils import hostglob_matches
 from ..common import EnvoyRoute
+from .v3origin import v3origin_metadata
 from .v3ratelimitaction import V3RateLimitAction
 
 if TYPE_CHECKING:
@@ -341,6 +342,12 @@ class V3Route(Cacheable):
 
         self["match"] = match
 
+        # Record which Mapping this route came from, so that it can be traced back later.
+        metadata = v3origin_metadata([mapping])
+
+        if metadata:
+            self["metadata"] = metadata
+
         # `typed_per_filter_config` is used to pass typed configuration to Envoy filters
         typed_per_filter_config = {}
 
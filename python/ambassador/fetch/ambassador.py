@@ -15,6 +15,7 @@ class AmbassadorProcessor(ManagedKubernetesProcessor):
         kinds = [
             "AuthService",
             "ConsulResolver",
+            "DNSResolver",
             "Host",
             "KubernetesEndpointResolver",
             "KubernetesServiceResolver",
@@ -23,6 +24,7 @@ class AmbassadorProcessor(ManagedKubernetesProcessor):
             "Mapping",
             "Module",
             "RateLimitService",
+            "StaticEndpointResolver",
             "DevPortal",
             "TCPMapping",
             "TLSContext",
@@ -354,9 +354,6 @@ spec:
 
                 watt_list.append(obj)
 
-            # Remove annotations from the snapshot; we'll process them separately.
-            annotations = watt_k8s.pop("annotations", {})
-
             # These objects have to be processed first, in order, as they depend
             # on each other.
             watt_k8s_keys = list(self.manager.deps.sorted_watt_keys())
@@ -372,10 +369,6 @@ spec:
                     # self.logger.debug(f"Handling Kubernetes {key}...")
                     with self.manager.locations.push_reset():
                         self.handle_k8s(obj)
-                        if "errors" not in obj:
-                            ann_parent_key = f"{obj['kind']}/{obj['metadata']['name']}.{obj['metadata'].get('namespace')}"
-                            for ann_obj in annotations.get(ann_parent_key) or []:
-                                self.handle_annotation(ann_parent_key, ann_obj)
 
             watt_consul = watt_dict.get("Consul", {})
             consul_endpoints = watt_consul.get("Endpoints", {})
@@ -424,6 +417,16 @@ spec:
             # can't process it.
             return
 
+        # Resources that came from a getambassador.io/config annotation are in the
+        # snapshot alongside the CRDs, marked with the object that they came from.
+        # (entrypoint marks any real resource that sets this annotation itself as
+        # invalid, so only the ones that it unfolded get here with it.)
+        annotation_origin = obj.annotations.get("getambassador.io/config-origin")
+
+        if annotation_origin:
+            self.handle_annotation(annotation_origin, raw_obj)
+            return
+
         if not self.k8s_processor.try_process(obj):
             self.logger.debug(f"{self.location}: skipping K8s {obj.gvk}")
 
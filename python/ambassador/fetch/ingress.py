@@ -64,6 +64,10 @@ class IngressClassProcessor(ManagedKubernetesProcessor):
 
 
 class IngressProcessor(ManagedKubernetesProcessor):
+    """
+    A Kubernetes object processor that emits mappings from Ingresses. (entrypoint takes care of
+    copying our load balancer in to the status of each Ingress we handle.)
+    """
 
     service_dep: ServiceDependency
     ingress_classes_dep: IngressClassesDependency
@@ -84,26 +88,6 @@ class IngressProcessor(ManagedKubernetesProcessor):
             ]
         )
 
-    def _update_status(self, obj: KubernetesObject) -> None:
-        service_status = None
-
-        if not self.service_dep.ambassador_service or not self.service_dep.ambassador_service.name:
-            self.logger.error(
-                f"Unable to set Ingress {obj.name}'s load balancer, could not find Ambassador service"
-            )
-        else:
-            service_status = self.service_dep.ambassador_service.status
-
-        if obj.status != service_status:
-            if service_status:
-                status_update = (obj.gvk.kind, obj.namespace, service_status)
-                self.logger.debug(f"Updating Ingress {obj.name} status to {status_update}")
-                self.aconf.k8s_status_updates[f"{obj.name}.{obj.namespace}"] = status_update
-        else:
-            self.logger.debug(
-                f"Not reconciling Ingress {obj.name}: observed and current statuses are in sync"
-            )
-
     def _try_resolve_service_port_number(self, namespace, service_name, service_port):
         self.logger.debug(f"Resolving named port '{service_port}' in service '{service_name}'")
 
@@ -301,6 +285,3 @@ class IngressProcessor(ManagedKubernetesProcessor):
 
                 self.logger.debug(f"Generated Mapping from Ingress {obj.name}: {path_mapping}")
                 self.manager.emit(path_mapping)
-
-        # let's make arrangements to update Ingress' status now
-        self._update_status(obj)
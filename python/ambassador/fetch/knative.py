@@ -1,32 +1,26 @@
 from __future__ import annotations
 
-import datetime
 import itertools
-from typing import Any, ClassVar, Dict, FrozenSet, List, Optional
+from typing import Any, ClassVar, Dict, FrozenSet, List
 
 import durationpy
 
 from ..config import Config
-from .dependency import ServiceDependency
 from .k8sobject import KubernetesGVK, KubernetesObject
 from .k8sprocessor import ManagedKubernetesProcessor
-from .resource import NormalizedResource, ResourceManager
+from .resource import NormalizedResource
 
 
 class KnativeIngressProcessor(ManagedKubernetesProcessor):
     """
     A Kubernetes object processor that emits mappings from Knative Ingresses.
+
+    The status of each Knative Ingress (its readiness and load balancer) is written by
+    entrypoint, which also drops the Ingresses that aren't meant for us before they get here.
     """
 
     INGRESS_CLASS: ClassVar[str] = "ambassador.ingress.networking.knative.dev"
 
-    service_dep: ServiceDependency
-
-    def __init__(self, manager: ResourceManager):
-        super().__init__(manager)
-
-        self.service_dep = self.deps.want(ServiceDependency)
-
     def kinds(self) -> FrozenSet[KubernetesGVK]:
         return frozenset([KubernetesGVK.for_knative_networking("Ingress")])
 
@@ -110,74 +104,6 @@ class KnativeIngressProcessor(ManagedKubernetesProcessor):
             self.logger.debug(f"Generated Mapping from Knative {obj.kind}: {mapping}")
             self.manager.emit(mapping)
 
-    def _make_status(self, generation: int = 1, lb_domain: Optional[str] = None) -> Dict[str, Any]:
-        utcnow = datetime.datetime.utcnow().strftime("%Y-%m-%dT%H:%M:%SZ")
-        status = {
-            "observedGeneration": generation,
-            "conditions": [
-                {"lastTransitionTime": utcnow, "status": "True", "type": "LoadBalancerReady"},
-                {"lastTransitionTime": utcnow, "status": "True", "type": "NetworkConfigured"},
-                {"lastTransitionTime": utcnow, "status": "True", "type": "Ready"},
-            ],
-        }
-
-        if lb_domain:
-            load_balancer = {
-                "ingress": [
-                    {
-                        "domainInternal": lb_domain,
-                    }
-                ]
-            }
-
-            status["loadBalancer"] = load_balancer
-            status["privateLoadBalancer"] = load_balancer
-
-        return status
-
-    def _update_status(self, obj: KubernetesObject) -> None:
-        has_new_generation = obj.generation > obj.status.get("observedGeneration", 0)
-
-        # Knative expects the load balancer information on the ingress, which it
-        # then propagates to an ExternalName service for intra-cluster use. We
-        # pull that information here. Otherwise, it will continue to use the DNS
-        # name configured by the Knative service and go through an
-        # out-of-cluster ingress to access the service.
-        current_lb_domain = None
-
-        if not self.service_dep.ambassador_service or not self.service_dep.ambassador_service.name:
-            self.logger.warning(
-                f"Unable to set Knative {obj.kind} {obj.name}'s load balancer, could not find Ambassador service"
-            )
-        else:
-            # TODO: It is technically possible to use a domain other than
-            # cluster.local (common-ish on bare metal clusters). We can resolve
-            # the relevant domain by doing a DNS lookup on
-            # kubernetes.default.svc, but this problem appears elsewhere in the
-            # code as well and probably should just be fixed all at once.
-            current_lb_domain = f"{self.service_dep.ambassador_service.name}.{self.service_dep.ambassador_service.namespace}.svc.cluster.local"
-
-        observed_ingress: Dict[str, Any] = next(
-            iter(obj.status.get("privateLoadBalancer", {}).get("ingress", [])), {}
-        )
-        observed_lb_domain = observed_ingress.get("domainInternal")
-
-        has_new_lb_domain = current_lb_domain != observed_lb_domain
-
-        if has_new_generation or has_new_lb_domain:
-            status = self._make_status(generation=obj.generation, lb_domain=current_lb_domain)
-
-            if status:
-                status_update = (obj.gvk.domain, obj.namespace, status)
-                self.logger.info(
-                    f"Updating Knative {obj.kind} {obj.name} status to {status_update}"
-                )
-                self.aconf.k8s_status_updates[f"{obj.name}.{obj.namespace}"] = status_update
-        else:
-            self.logger.debug(
-                f"Not reconciling Knative {obj.kind} {obj.name}: observed and current generations are in sync"
-            )
-
     def _process(self, obj: KubernetesObject) -> None:
         if not self._has_required_annotations(obj):
             return
@@ -185,5 +111,3 @@ class KnativeIngressProcessor(ManagedKubernetesProcessor):
         rules = obj.spec.get("rules", [])
         for rule_count, rule in enumerate(rules):
             self._emit_mapping(obj, rule_count, rule)
-
-        self._update_status(obj)
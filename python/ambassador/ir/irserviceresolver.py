@@ -53,6 +53,10 @@ class IRServiceResolver(IRResource):
             if not self.get("datacenter"):
                 self.post_error("ConsulResolver is required to have a datacenter")
                 return False
+        elif self.kind == "DNSResolver":
+            self.resolve_with = "dns"
+        elif self.kind == "StaticEndpointResolver":
+            self.resolve_with = "static"
         elif self.kind == "KubernetesServiceResolver":
             self.resolve_with = "k8s"
         elif self.kind == "KubernetesEndpointResolver":
@@ -68,6 +72,8 @@ class IRServiceResolver(IRResource):
             "KubernetesServiceResolver": self._k8s_svc_valid_mapping,
             "KubernetesEndpointResolver": self._k8s_valid_mapping,
             "ConsulResolver": self._consul_valid_mapping,
+            "DNSResolver": self._dns_valid_mapping,
+            "StaticEndpointResolver": self._static_valid_mapping,
         }[self.kind]
 
         return fn(ir, mapping)
@@ -105,6 +111,34 @@ class IRServiceResolver(IRResource):
 
         return valid
 
+    def _dns_valid_mapping(self, ir: "IR", mapping: "IRBaseMapping"):
+        # SRV records carry their own ports, so a port on the service makes no sense there.
+        if (self.get("recordType") or "SRV") == "SRV" and ":" in mapping.service.split("://")[-1]:
+            mapping.post_error("The DNS resolver does not allow a service port with SRV records")
+            return False
+
+        return True
+
+    def _static_valid_mapping(self, ir: "IR", mapping: "IRBaseMapping"):
+        # The endpoints of a StaticEndpointResolver carry their own ports.
+        if ":" in mapping.service.split("://")[-1]:
+            ir.aconf.post_notice(
+                "The StaticEndpointResolver does not allow overriding service port; ignoring requested port",
+                resource=mapping,
+            )
+
+        if not any(svc.get("name") == self._static_name(mapping.service) for svc in self.get("services", [])):
+            mapping.post_error(
+                f"StaticEndpointResolver {self.name} has no service named {self._static_name(mapping.service)}"
+            )
+            return False
+
+        return True
+
+    @staticmethod
+    def _static_name(service: str) -> str:
+        return service.split("://")[-1].rsplit(":", 1)[0]
+
     def resolve(
         self, ir: "IR", cluster: "IRCluster", svc_name: str, svc_namespace: str, port: int
     ) -> Optional[SvcEndpointSet]:
@@ -112,6 +146,8 @@ class IRServiceResolver(IRResource):
             "KubernetesServiceResolver": self._k8s_svc_resolver,
             "KubernetesEndpointResolver": self._k8s_resolver,
             "ConsulResolver": self._consul_resolver,
+            "DNSResolver": self._dns_resolver,
+            "StaticEndpointResolver": self._static_resolver,
         }[self.kind]
 
         return fn(ir, cluster, svc_name, svc_namespace, port)
@@ -166,6 +202,19 @@ class IRServiceResolver(IRResource):
 
         return self.get_endpoints(ir, f"consul-{svc_name}-{self.datacenter}", None)
 
+    def _dns_resolver(
+        self, ir: "IR", cluster: "IRCluster", svc_name: str, svc_namespace: str, port: int
+    ) -> Optional[SvcEndpointSet]:
+        # DNS endpoints never go through the snapshot: entrypoint polls DNS itself and hands the
+        # results straight to Envoy over EDS.
+        return None
+
+    def _static_resolver(
+        self, ir: "IR", cluster: "IRCluster", svc_name: str, svc_namespace: str, port: int
+    ) -> Optional[SvcEndpointSet]:
+        # Likewise, entrypoint sends the endpoints of a StaticEndpointResolver over EDS itself.
+        return None
+
     def get_endpoints(self, ir: "IR", key: str, port: Optional[int]) -> Optional[SvcEndpointSet]:
         # OK. Do we have a Service by this key?
         service = ir.services.get(key)
@@ -212,6 +261,8 @@ class IRServiceResolver(IRResource):
             "KubernetesServiceResolver": self._k8s_svc_clustermap_entry,
             "KubernetesEndpointResolver": self._k8s_clustermap_entry,
             "ConsulResolver": self._consul_clustermap_entry,
+            "DNSResolver": self._dns_clustermap_entry,
+            "StaticEndpointResolver": self._static_clustermap_entry,
         }[self.kind]
 
         return fn(ir, cluster, svc_name, svc_namespace, port)
@@ -271,6 +322,48 @@ class IRServiceResolver(IRResource):
             "endpoint_path": "consul/%s/%s" % (self.datacenter, svc_name),
         }
 
+    def _dns_clustermap_entry(
+        self, ir: "IR", cluster: "IRCluster", svc_name: str, svc_namespace: str, port: int
+    ) -> ClustermapEntry:
+        # Fallback to the KubernetesServiceResolver for ip addresses.
+        if is_ip_address(svc_name):
+            return {
+                "service": svc_name,
+                "namespace": svc_namespace,
+                "port": port,
+                "kind": "KubernetesServiceResolver",
+            }
+
+        # entrypoint names DNS endpoints after the resolver and the service as written in the
+        # Mapping (minus any scheme), since for A and AAAA records the port is part of that.
+        return {
+            "service": svc_name,
+            "port": port,
+            "kind": self.kind,
+            "endpoint_path": "dns/%s/%s" % (self.name, cluster.service),
+        }
+
+    def _static_clustermap_entry(
+        self, ir: "IR", cluster: "IRCluster", svc_name: str, svc_namespace: str, port: int
+    ) -> ClustermapEntry:
+        # Fallback to the KubernetesServiceResolver for ip addresses.
+        if is_ip_address(svc_name):
+            return {
+                "service": svc_name,
+                "namespace": svc_namespace,
+                "port": port,
+                "kind": "KubernetesServiceResolver",
+            }
+
+        # entrypoint names static endpoints after the resolver and the service name, ignoring any
+        # port (we should've already posted a notice about the port being present). Hostnames are
+        # case-insensitive, and entrypoint lowercases the service names, so we do too.
+        return {
+            "service": svc_name,
+            "kind": self.kind,
+            "endpoint_path": "static/%s/%s" % (self.name, svc_name.lower()),
+        }
+
 
 class IRServiceResolverFactory:
     @classmethod